@@ -0,0 +1,256 @@
+// Package apikey handles API keys restrictions and usage counters
+package apikey
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrUnknownKey is returned for a key not present in the keys file
+	ErrUnknownKey = errors.New("unknown API key")
+	// ErrQuotaExceeded is returned when the monthly quota of a key is reached
+	ErrQuotaExceeded = errors.New("API key monthly quota exceeded")
+	// ErrZoomNotAllowed is returned when a key is not allowed to query a zoom level
+	ErrZoomNotAllowed = errors.New("zoom level not allowed for this API key")
+)
+
+var usageBucket = []byte("usage")
+
+// Key describes an API key and its restrictions
+type Key struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+	// MonthlyQuota is the number of requests allowed per calendar month, 0 for unlimited
+	MonthlyQuota uint64 `json:"monthly_quota,omitempty"`
+	MinZoom      int    `json:"min_zoom,omitempty"`
+	// MaxZoom is the maximum zoom level allowed, 0 for unlimited
+	MaxZoom int `json:"max_zoom,omitempty"`
+	// Trusted keys are served the attributes redacted from the public tiles,
+	// and can choose the redacted attributes
+	Trusted bool `json:"trusted,omitempty"`
+}
+
+// Usage reports the requests count for a key during a month
+type Usage struct {
+	ID           string `json:"id"`
+	Month        string `json:"month"`
+	Count        uint64 `json:"count"`
+	MonthlyQuota uint64 `json:"monthly_quota,omitempty"`
+}
+
+// Store validates keys and counts their usage, counters are persisted in a bbolt DB
+type Store struct {
+	keys map[string]*Key
+	db   *bbolt.DB
+
+	mu     sync.Mutex
+	month  string
+	counts map[string]uint64
+	now    func() time.Time
+}
+
+// Open loads the keys from the JSON file at keysPath (an array of Key),
+// and the usage counters from the DB at usagePath
+func Open(keysPath, usagePath string) (*Store, func() error, error) {
+	keys, err := readKeys(keysPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := bbolt.Open(usagePath, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open usage DB at %s: %w", usagePath, err)
+	}
+
+	s, err := newStore(keys, db, time.Now)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return s, func() error {
+		if err := s.Flush(); err != nil {
+			db.Close()
+			return err
+		}
+		return db.Close()
+	}, nil
+}
+
+// readKeys reads the JSON keys file at path
+func readKeys(path string) ([]*Key, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read keys file: %w", err)
+	}
+
+	var keys []*Key
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("can't decode keys file: %w", err)
+	}
+	return keys, nil
+}
+
+func keysByValue(keys []*Key) (map[string]*Key, error) {
+	m := make(map[string]*Key, len(keys))
+	for _, k := range keys {
+		if k.Key == "" || k.ID == "" {
+			return nil, errors.New("API keys require an id and a key")
+		}
+		m[k.Key] = k
+	}
+	return m, nil
+}
+
+func newStore(keys []*Key, db *bbolt.DB, now func() time.Time) (*Store, error) {
+	m, err := keysByValue(keys)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		keys: m,
+		db:   db,
+		now:  now,
+	}
+
+	if err := s.db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(usageBucket)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed writing to usage DB: %w", err)
+	}
+
+	if err := s.load(month(now())); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Authorize checks key is allowed to query zoom z and counts the request
+func (s *Store) Authorize(key string, z uint8) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.keys[key]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if int(z) < k.MinZoom || (k.MaxZoom > 0 && int(z) > k.MaxZoom) {
+		return k, ErrZoomNotAllowed
+	}
+
+	if m := month(s.now()); m != s.month {
+		if err := s.flush(); err != nil {
+			return k, err
+		}
+		if err := s.load(m); err != nil {
+			return k, err
+		}
+	}
+
+	if k.MonthlyQuota > 0 && s.counts[k.ID] >= k.MonthlyQuota {
+		return k, ErrQuotaExceeded
+	}
+	s.counts[k.ID]++
+
+	return k, nil
+}
+
+// Reload replaces the keys by the keys file at keysPath, the usage of the keys kept is preserved
+func (s *Store) Reload(keysPath string) error {
+	l, err := readKeys(keysPath)
+	if err != nil {
+		return err
+	}
+	keys, err := keysByValue(l)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+	s.keys = keys
+	return s.load(s.month)
+}
+
+// Usage returns the current month usage for every key
+func (s *Store) Usage() []Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usages := make([]Usage, 0, len(s.keys))
+	for _, k := range s.keys {
+		usages = append(usages, Usage{
+			ID:           k.ID,
+			Month:        s.month,
+			Count:        s.counts[k.ID],
+			MonthlyQuota: k.MonthlyQuota,
+		})
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].ID < usages[j].ID })
+
+	return usages
+}
+
+// Flush persists the counters
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush()
+}
+
+// flush must be called with the lock held
+func (s *Store) flush() error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(usageBucket)
+		for id, c := range s.counts {
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, c)
+			if err := b.Put(usageKey(s.month, id), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// load must be called with the lock held
+func (s *Store) load(m string) error {
+	s.month = m
+	s.counts = make(map[string]uint64, len(s.keys))
+
+	return s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(usageBucket)
+		for _, k := range s.keys {
+			v := b.Get(usageKey(m, k.ID))
+			if len(v) == 8 {
+				s.counts[k.ID] = binary.BigEndian.Uint64(v)
+			}
+		}
+		return nil
+	})
+}
+
+func usageKey(month, id string) []byte {
+	return []byte(month + "/" + id)
+}
+
+func month(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
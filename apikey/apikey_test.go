@@ -0,0 +1,78 @@
+package apikey
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestStore_Authorize(t *testing.T) {
+	tmpFile, err := ioutil.TempFile(os.TempDir(), "kvtiles-usage-test-")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	db, err := bbolt.Open(tmpFile.Name(), 0600, nil)
+	require.NoError(t, err)
+
+	now := time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC)
+	keys := []*Key{
+		{ID: "free", Key: "k1", MonthlyQuota: 2, MaxZoom: 12},
+		{ID: "paid", Key: "k2", MinZoom: 2},
+	}
+	s, err := newStore(keys, db, func() time.Time { return now })
+	require.NoError(t, err)
+
+	_, err = s.Authorize("nope", 1)
+	require.Equal(t, ErrUnknownKey, err)
+
+	_, err = s.Authorize("k1", 13)
+	require.Equal(t, ErrZoomNotAllowed, err)
+	_, err = s.Authorize("k2", 1)
+	require.Equal(t, ErrZoomNotAllowed, err)
+
+	k, err := s.Authorize("k1", 12)
+	require.NoError(t, err)
+	require.Equal(t, "free", k.ID)
+	_, err = s.Authorize("k1", 1)
+	require.NoError(t, err)
+	_, err = s.Authorize("k1", 1)
+	require.Equal(t, ErrQuotaExceeded, err)
+
+	_, err = s.Authorize("k2", 18)
+	require.NoError(t, err)
+
+	require.Equal(t, []Usage{
+		{ID: "free", Month: "2020-01", Count: 2, MonthlyQuota: 2},
+		{ID: "paid", Month: "2020-01", Count: 1},
+	}, s.Usage())
+
+	// counters are persisted and reloaded
+	require.NoError(t, s.Flush())
+	s, err = newStore(keys, db, func() time.Time { return now })
+	require.NoError(t, err)
+	_, err = s.Authorize("k1", 1)
+	require.Equal(t, ErrQuotaExceeded, err)
+
+	// reloading raises the quota and keeps the usage
+	keysFile := tmpFile.Name() + ".json"
+	defer os.Remove(keysFile)
+	require.NoError(t, ioutil.WriteFile(keysFile, []byte(`[{"id": "free", "key": "k1", "monthly_quota": 3}]`), 0600))
+	require.NoError(t, s.Reload(keysFile))
+	_, err = s.Authorize("k1", 1)
+	require.NoError(t, err)
+	_, err = s.Authorize("k1", 1)
+	require.Equal(t, ErrQuotaExceeded, err)
+	_, err = s.Authorize("k2", 18)
+	require.Equal(t, ErrUnknownKey, err)
+
+	// new month resets the quota
+	now = now.Add(24 * time.Hour)
+	_, err = s.Authorize("k1", 1)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Close())
+}
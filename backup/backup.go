@@ -0,0 +1,175 @@
+// Package backup uploads snapshots of the served DB to S3 on a schedule, keeping the last ones
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/akhenakh/kvtiles/internal/s3"
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// keyTimeFormat follows the prefix in the backups keys, the keys sort by time
+const keyTimeFormat = "20060102T150405Z"
+
+var (
+	backupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kvtiles",
+		Name:      "backups_total",
+		Help:      "Scheduled backups, result is success or failure.",
+	}, []string{"result"})
+
+	lastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kvtiles",
+		Name:      "backup_last_success_timestamp_seconds",
+		Help:      "Time of the last successful backup.",
+	})
+
+	lastSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kvtiles",
+		Name:      "backup_last_size_bytes",
+		Help:      "Size of the last successful backup.",
+	})
+)
+
+// Config configures the scheduled backups
+type Config struct {
+	Bucket s3.Bucket
+	// Prefix of the backups keys, e.g. backups/hawaii-, followed by the backup time and .db
+	Prefix   string
+	Interval time.Duration
+	// Keep is the count of backups kept, the older ones are deleted, 0 keeps them all
+	Keep int
+	// Dir holds the snapshot during the upload, the system temp dir if empty
+	Dir string
+}
+
+// Scheduler uploads a snapshot every interval
+type Scheduler struct {
+	snap   storage.Snapshotter
+	cfg    Config
+	logger log.Logger
+	now    func() time.Time
+}
+
+// NewScheduler returns a Scheduler backing up snap
+func NewScheduler(snap storage.Snapshotter, cfg Config, logger log.Logger) (*Scheduler, error) {
+	if cfg.Bucket.Name == "" {
+		return nil, errors.New("a backup bucket is required")
+	}
+	if cfg.Interval <= 0 {
+		return nil, errors.New("the backup interval must be positive")
+	}
+	if cfg.Bucket.Region == "" {
+		cfg.Bucket.Region = "us-east-1"
+	}
+	return &Scheduler{
+		snap:   snap,
+		cfg:    cfg,
+		logger: log.With(logger, "component", "backup", "bucket", cfg.Bucket.Name),
+		now:    time.Now,
+	}, nil
+}
+
+// Run backs up every interval until ctx is done, a failed backup is retried on the next one
+func (s *Scheduler) Run(ctx context.Context) error {
+	t := time.NewTicker(s.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		key, err := s.backup(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			backupsTotal.WithLabelValues("failure").Inc()
+			level.Error(s.logger).Log("msg", "backup failed", "error", err)
+			continue
+		}
+		backupsTotal.WithLabelValues("success").Inc()
+		level.Info(s.logger).Log("msg", "backup uploaded", "key", key)
+
+		if err := s.prune(ctx); err != nil && ctx.Err() == nil {
+			level.Warn(s.logger).Log("msg", "can't delete the old backups", "error", err)
+		}
+	}
+}
+
+// backup uploads a snapshot, returns its key
+func (s *Scheduler) backup(ctx context.Context) (string, error) {
+	// the snapshot is written first, the upload requires its size and sum
+	f, err := os.CreateTemp(s.cfg.Dir, ".backup-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := s.snap.WriteSnapshot(io.MultiWriter(f, h))
+	if err != nil {
+		return "", fmt.Errorf("can't write snapshot: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	now := s.now()
+	key := s.cfg.Prefix + now.UTC().Format(keyTimeFormat) + ".db"
+	if err := s.cfg.Bucket.Put(ctx, key, f, n, hex.EncodeToString(h.Sum(nil))); err != nil {
+		return "", fmt.Errorf("can't upload snapshot: %w", err)
+	}
+
+	lastSuccess.Set(float64(now.Unix()))
+	lastSize.Set(float64(n))
+	return key, nil
+}
+
+// prune deletes the backups older than the Keep last ones, the other keys under the prefix are left untouched
+func (s *Scheduler) prune(ctx context.Context) error {
+	if s.cfg.Keep <= 0 {
+		return nil
+	}
+	keys, err := s.cfg.Bucket.List(ctx, s.cfg.Prefix)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, k := range keys {
+		name := strings.TrimPrefix(k, s.cfg.Prefix)
+		if !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		if _, err := time.Parse(keyTimeFormat, strings.TrimSuffix(name, ".db")); err == nil {
+			backups = append(backups, k)
+		}
+	}
+
+	// listed in lexicographic order, the oldest first
+	for len(backups) > s.cfg.Keep {
+		if err := s.cfg.Bucket.Delete(ctx, backups[0]); err != nil {
+			return err
+		}
+		level.Info(s.logger).Log("msg", "old backup deleted", "key", backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
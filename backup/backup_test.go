@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/internal/s3"
+	"github.com/akhenakh/kvtiles/internal/sigv4"
+)
+
+type snapshot string
+
+func (s snapshot) WriteSnapshot(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, string(s))
+	return int64(n), err
+}
+
+// fakeS3 serves the objects of a single bucket
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch {
+	case r.Method == http.MethodPut:
+		b, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = string(b)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && key == "":
+		type content struct{ Key string }
+		var res struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []content
+		}
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				res.Contents = append(res.Contents, content{k})
+			}
+		}
+		sort.Slice(res.Contents, func(i, j int) bool { return res.Contents[i].Key < res.Contents[j].Key })
+		xml.NewEncoder(w).Encode(res)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestScheduler(t *testing.T) {
+	fs := &fakeS3{objects: map[string]string{"backups/notes.txt": "not a backup"}}
+	ts := httptest.NewServer(fs)
+	defer ts.Close()
+
+	s, err := NewScheduler(snapshot("bolt db"), Config{
+		Bucket: s3.Bucket{
+			Endpoint: ts.URL,
+			Name:     "bucket",
+			AWS:      sigv4.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		},
+		Prefix:   "backups/",
+		Interval: time.Hour,
+		Keep:     2,
+		Dir:      t.TempDir(),
+	}, log.NewNopLogger())
+	require.NoError(t, err)
+
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		key, err := s.backup(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "backups/"+now.Format(keyTimeFormat)+".db", key)
+		require.NoError(t, s.prune(ctx))
+		now = now.Add(time.Hour)
+	}
+
+	// the oldest backup is deleted, not the other objects
+	require.Equal(t, []string{
+		"backups/20201001T130000Z.db",
+		"backups/20201001T140000Z.db",
+		"backups/notes.txt",
+	}, fs.keys())
+	require.Equal(t, "bolt db", fs.objects["backups/20201001T140000Z.db"])
+
+	_, err = NewScheduler(snapshot(""), Config{Interval: time.Hour}, log.NewNopLogger())
+	require.Error(t, err)
+}
@@ -0,0 +1,301 @@
+// Package bundle packages the tiles of an area with the style, the glyphs and the sprites of the debug map
+// in a zip archive, for the offline use of the mobile clients
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/akhenakh/kvtiles/server"
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// ErrTooManyTiles is returned when the area holds more than Options.MaxTiles tiles
+var ErrTooManyTiles = errors.New("too many tiles in the bundle")
+
+// the files of the archive, the layout of the server URLs so the templates reference them as served
+const (
+	styleFile   = "style.json"
+	tileJSON    = "static/planet.json"
+	styleTpl    = "osm-liberty-gl.style"
+	tileJSONTpl = "planet.json"
+)
+
+// Options configures a bundle
+type Options struct {
+	// MinLat, MinLng, MaxLat, MaxLng is the area of the tiles
+	MinLat, MinLng, MaxLat, MaxLng float64
+	MinZoom, MaxZoom               uint8
+	// StaticDir holds the glyphs, its files override the embedded style and sprites as for server.WithStaticDir,
+	// ./static when empty
+	StaticDir string
+	// BaseURL is where the archive is extracted on the devices, e.g. asset://map, the style references the files from it
+	BaseURL string
+	// MaxTiles is the max count of tiles of the area, 0 for no limit
+	MaxTiles int
+}
+
+// Stats describes a written bundle
+type Stats struct {
+	// Tiles is the count of tiles written, the tiles missing from the DB are skipped
+	Tiles int
+	// Fonts are the font stacks of the style written with their glyphs
+	Fonts []string
+	// MissingFonts are the font stacks of the style missing from StaticDir
+	MissingFonts []string
+}
+
+// Write writes to w the zip archive of the tiles of store within opts, the style of the debug map as style.json,
+// its sprites and glyphs, the tiles are written uncompressed as tiles/{z}/{x}/{y}.{format} in the XYZ scheme
+func Write(ctx context.Context, w io.Writer, store storage.TileStore, opts Options) (Stats, error) {
+	var stats Stats
+	infos, ok, err := store.LoadMapInfos()
+	if err != nil {
+		return stats, fmt.Errorf("can't read map infos: %w", err)
+	}
+	if !ok {
+		return stats, errors.New("no map in DB")
+	}
+	if opts.MaxZoom > uint8(infos.MaxZoom) {
+		opts.MaxZoom = uint8(infos.MaxZoom)
+	}
+	if opts.MinZoom > opts.MaxZoom {
+		return stats, fmt.Errorf("invalid zoom range %d-%d", opts.MinZoom, opts.MaxZoom)
+	}
+
+	if opts.MaxTiles > 0 {
+		var count int
+		tilemath.CoverBBox(opts.MinLat, opts.MinLng, opts.MaxLat, opts.MaxLng, opts.MinZoom, opts.MaxZoom, func(tilemath.Tile) bool {
+			count++
+			return count <= opts.MaxTiles
+		})
+		if count > opts.MaxTiles {
+			return stats, fmt.Errorf("%w: more than %d", ErrTooManyTiles, opts.MaxTiles)
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	format := infos.Format
+	if format == "" {
+		format = "pbf"
+	}
+
+	var walkErr error
+	tilemath.CoverBBox(opts.MinLat, opts.MinLng, opts.MaxLat, opts.MaxLng, opts.MinZoom, opts.MaxZoom, func(t tilemath.Tile) bool {
+		if walkErr = ctx.Err(); walkErr != nil {
+			return false
+		}
+		// stored in the TMS scheme
+		data, err := store.ReadTileData(ctx, t.Z, t.X, 1<<t.Z-t.Y-1)
+		if err != nil {
+			walkErr = fmt.Errorf("can't read tile %d/%d/%d: %w", t.Z, t.X, t.Y, err)
+			return false
+		}
+		if len(data) == 0 {
+			return true
+		}
+		if data, err = gunzip(data); err != nil {
+			walkErr = fmt.Errorf("can't uncompress tile %d/%d/%d: %w", t.Z, t.X, t.Y, err)
+			return false
+		}
+		// the raster tiles are already compressed
+		method := zip.Deflate
+		if infos.Format != "" {
+			method = zip.Store
+		}
+		if walkErr = writeFile(zw, fmt.Sprintf("tiles/%d/%d/%d.%s", t.Z, t.X, t.Y, format), method, data); walkErr != nil {
+			return false
+		}
+		stats.Tiles++
+		return true
+	})
+	if walkErr != nil {
+		return stats, walkErr
+	}
+
+	// the map served offline is limited to the bundled zooms and centered on the area
+	bundled := *infos
+	bundled.MaxZoom = int(opts.MaxZoom)
+	bundled.CenterLat, bundled.CenterLng = (opts.MinLat+opts.MaxLat)/2, (opts.MinLng+opts.MaxLng)/2
+
+	if infos.Format != "" {
+		if err := writeRasterStyle(zw, opts, format); err != nil {
+			return stats, err
+		}
+		return stats, zw.Close()
+	}
+
+	if opts.StaticDir == "" {
+		opts.StaticDir = "./static"
+	}
+	files := server.StaticFS(opts.StaticDir)
+	params, err := server.TemplateParams(&bundled, opts.BaseURL, "")
+	if err != nil {
+		return stats, err
+	}
+	var style []byte
+	for _, f := range [][2]string{{styleFile, styleTpl}, {tileJSON, tileJSONTpl}} {
+		b, err := render(files, f[1], params)
+		if err != nil {
+			return stats, err
+		}
+		if err := writeFile(zw, f[0], zip.Deflate, b); err != nil {
+			return stats, err
+		}
+		if f[0] == styleFile {
+			style = b
+		}
+	}
+	if err := writeStyleFiles(zw, files, style, opts.BaseURL, &stats); err != nil {
+		return stats, err
+	}
+	return stats, zw.Close()
+}
+
+// render executes the template name of files
+func render(files fs.FS, name string, params map[string]interface{}) ([]byte, error) {
+	t, err := template.ParseFS(files, name)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, params); err != nil {
+		return nil, fmt.Errorf("can't execute template %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeStyleFiles writes the sprites and the glyphs of the font stacks referenced by style
+func writeStyleFiles(zw *zip.Writer, files fs.FS, style []byte, baseURL string, stats *Stats) error {
+	var st struct {
+		Sprite string `json:"sprite"`
+		Layers []struct {
+			Layout struct {
+				TextFont []interface{} `json:"text-font"`
+			} `json:"layout"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(style, &st); err != nil {
+		return fmt.Errorf("invalid style: %w", err)
+	}
+
+	// e.g. {baseURL}/static/osm-liberty, the sprites are served with their @2x variants
+	if sprite := strings.TrimPrefix(st.Sprite, baseURL+"/static/"); sprite != "" && sprite != st.Sprite {
+		for _, suffix := range []string{".json", ".png", "@2x.json", "@2x.png"} {
+			if err := copyFile(zw, files, sprite+suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, l := range st.Layers {
+		// expressions are skipped, only the literal font stacks are known
+		var fonts []string
+		for _, f := range l.Layout.TextFont {
+			if s, ok := f.(string); ok {
+				fonts = append(fonts, s)
+			}
+		}
+		if len(fonts) == 0 || len(fonts) != len(l.Layout.TextFont) {
+			continue
+		}
+		stack := strings.Join(fonts, ",")
+		if seen[stack] {
+			continue
+		}
+		seen[stack] = true
+
+		dir := path.Join("glyphs", stack)
+		ranges, err := fs.ReadDir(files, dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			stats.MissingFonts = append(stats.MissingFonts, stack)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("can't read glyphs of %s: %w", stack, err)
+		}
+		for _, r := range ranges {
+			if r.IsDir() || path.Ext(r.Name()) != ".pbf" {
+				continue
+			}
+			if err := copyFile(zw, files, path.Join(dir, r.Name())); err != nil {
+				return err
+			}
+		}
+		stats.Fonts = append(stats.Fonts, stack)
+	}
+	return nil
+}
+
+// writeRasterStyle writes a style showing the raster tiles
+func writeRasterStyle(zw *zip.Writer, opts Options, format string) error {
+	b, err := json.MarshalIndent(map[string]interface{}{
+		"version": 8,
+		"sources": map[string]interface{}{
+			"raster": map[string]interface{}{
+				"type":     "raster",
+				"tiles":    []string{opts.BaseURL + "/tiles/{z}/{x}/{y}." + format},
+				"tileSize": 256,
+				"minzoom":  opts.MinZoom,
+				"maxzoom":  opts.MaxZoom,
+				"bounds":   []float64{opts.MinLng, opts.MinLat, opts.MaxLng, opts.MaxLat},
+			},
+		},
+		"layers": []interface{}{
+			map[string]string{"id": "raster", "type": "raster", "source": "raster"},
+		},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(zw, styleFile, zip.Deflate, b)
+}
+
+// copyFile writes the file name of files to the archive under the same name
+func copyFile(zw *zip.Writer, files fs.FS, name string) error {
+	b, err := fs.ReadFile(files, name)
+	if err != nil {
+		return err
+	}
+	method := zip.Deflate
+	if path.Ext(name) == ".png" {
+		method = zip.Store
+	}
+	return writeFile(zw, "static/"+name, method, b)
+}
+
+func writeFile(zw *zip.Writer, name string, method uint16, data []byte) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+	if err != nil {
+		return fmt.Errorf("can't write %s to the bundle: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("can't write %s to the bundle: %w", name, err)
+	}
+	return nil
+}
+
+// gunzip uncompresses the gzipped tiles, other tiles are returned as is
+func gunzip(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,142 @@
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// memStore serves a gzipped tile holding its TMS coordinates up to zoom 2, except 2/3/0
+type memStore struct {
+	format string
+}
+
+func (s memStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	if z > 2 || (z == 2 && x == 3 && y == 0) {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte{z, byte(x), byte(y)})
+	zw.Close()
+	return buf.Bytes(), nil
+}
+
+func (s memStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 5, Format: s.format}, true, nil
+}
+
+func (memStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func readZip(t *testing.T, b []byte) map[string][]byte {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		files[f.Name], err = io.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+	}
+	return files
+}
+
+func TestWrite(t *testing.T) {
+	static := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(static, "glyphs", "Roboto Regular"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(static, "glyphs", "Roboto Regular", "0-255.pbf"), []byte("glyphs"), 0o600))
+
+	// the east half of the world
+	var buf bytes.Buffer
+	stats, err := Write(context.Background(), &buf, memStore{}, Options{
+		MinLat: -80, MinLng: 1, MaxLat: 80, MaxLng: 179,
+		MaxZoom:   10,
+		StaticDir: static,
+		BaseURL:   "asset://map",
+	})
+	require.NoError(t, err)
+	// 1 + 2 + 8 tiles at zooms 0 to 2, but 2/3/0 in the TMS scheme
+	require.Equal(t, 10, stats.Tiles)
+	require.Equal(t, []string{"Roboto Regular"}, stats.Fonts)
+	require.NotContains(t, stats.MissingFonts, "Roboto Regular")
+
+	files := readZip(t, buf.Bytes())
+	// uncompressed and in the XYZ scheme
+	require.Equal(t, []byte{1, 1, 0}, files["tiles/1/1/1.pbf"])
+	require.NotContains(t, files, "tiles/1/0/0.pbf")
+	require.NotContains(t, files, "tiles/2/3/3.pbf")
+	require.Equal(t, []byte("glyphs"), files["static/glyphs/Roboto Regular/0-255.pbf"])
+	require.Contains(t, files, "static/osm-liberty.png")
+	require.Contains(t, files, "static/osm-liberty@2x.json")
+
+	var style struct {
+		Sprite  string `json:"sprite"`
+		Glyphs  string `json:"glyphs"`
+		Sources map[string]struct {
+			URL string `json:"url"`
+		} `json:"sources"`
+	}
+	require.NoError(t, json.Unmarshal(files["style.json"], &style))
+	require.Equal(t, "asset://map/static/osm-liberty", style.Sprite)
+	require.Equal(t, "asset://map/static/glyphs/{fontstack}/{range}.pbf", style.Glyphs)
+	require.Equal(t, "asset://map/static/planet.json", style.Sources["openmaptiles"].URL)
+
+	var tj struct {
+		Tiles   []string `json:"tiles"`
+		MaxZoom int      `json:"maxzoom"`
+	}
+	require.NoError(t, json.Unmarshal(files["static/planet.json"], &tj))
+	require.Equal(t, []string{"asset://map/tiles/{z}/{x}/{y}.pbf"}, tj.Tiles)
+	// limited to the bundled zooms
+	require.Equal(t, 5, tj.MaxZoom)
+
+	// a static dir without glyphs
+	stats, err = Write(context.Background(), io.Discard, memStore{}, Options{
+		MinLat: -80, MinLng: 1, MaxLat: 80, MaxLng: 179, MaxZoom: 1, StaticDir: t.TempDir(),
+	})
+	require.NoError(t, err)
+	require.Empty(t, stats.Fonts)
+	require.Contains(t, stats.MissingFonts, "Roboto Regular")
+
+	_, err = Write(context.Background(), io.Discard, memStore{}, Options{
+		MinLat: -80, MinLng: -179, MaxLat: 80, MaxLng: 179, MaxZoom: 2, MaxTiles: 20,
+	})
+	require.ErrorIs(t, err, ErrTooManyTiles)
+}
+
+func TestWriteRaster(t *testing.T) {
+	var buf bytes.Buffer
+	stats, err := Write(context.Background(), &buf, memStore{format: "png"}, Options{
+		MinLat: -80, MinLng: -179, MaxLat: 80, MaxLng: 179, MaxZoom: 1, BaseURL: "file:///data/map",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, stats.Tiles)
+
+	files := readZip(t, buf.Bytes())
+	require.Contains(t, files, "tiles/1/0/1.png")
+	require.NotContains(t, files, "static/planet.json")
+
+	var style struct {
+		Sources map[string]struct {
+			Tiles   []string `json:"tiles"`
+			MaxZoom int      `json:"maxzoom"`
+		} `json:"sources"`
+	}
+	require.NoError(t, json.Unmarshal(files["style.json"], &style))
+	require.Equal(t, []string{"file:///data/map/tiles/{z}/{x}/{y}.png"}, style.Sources["raster"].Tiles)
+	require.Equal(t, 1, style.Sources["raster"].MaxZoom)
+}
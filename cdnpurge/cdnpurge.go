@@ -0,0 +1,293 @@
+// Package cdnpurge invalidates paths cached by CDNs when the served data changes
+package cdnpurge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/akhenakh/kvtiles/internal/sigv4"
+)
+
+// Purger invalidates paths cached by a CDN, a path ending with * invalidates the whole prefix
+type Purger interface {
+	Purge(ctx context.Context, paths []string) error
+}
+
+// DataPaths are the paths depending on the DB content
+var DataPaths = []string{"/tiles/*", "/static/*", "/version"}
+
+// Config configures the CDNs to purge, a CDN is enabled when its ID is set
+type Config struct {
+	// BaseURL is the public URL of the tiles server as seen through the CDN,
+	// used to purge single URLs, e.g. https://tiles.example.com
+	BaseURL string
+
+	FastlyServiceID string
+	FastlyToken     string
+
+	CloudflareZoneID string
+	CloudflareToken  string
+
+	CloudFrontDistributionID string
+	AWS                      sigv4.Credentials
+}
+
+// New returns a Purger for the CDNs enabled in cfg, nil if none is
+func New(cfg Config) (Purger, error) {
+	var m Multi
+	if cfg.FastlyServiceID != "" {
+		u, err := url.Parse(cfg.BaseURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("a valid base URL is required to purge Fastly: %q", cfg.BaseURL)
+		}
+		m = append(m, &Fastly{ServiceID: cfg.FastlyServiceID, Token: cfg.FastlyToken, Host: u.Host})
+	}
+	if cfg.CloudflareZoneID != "" {
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("a base URL is required to purge Cloudflare")
+		}
+		m = append(m, &Cloudflare{ZoneID: cfg.CloudflareZoneID, Token: cfg.CloudflareToken, BaseURL: cfg.BaseURL})
+	}
+	if cfg.CloudFrontDistributionID != "" {
+		if cfg.AWS.AccessKeyID == "" || cfg.AWS.SecretAccessKey == "" {
+			return nil, fmt.Errorf("AWS credentials are required to purge CloudFront")
+		}
+		m = append(m, &CloudFront{DistributionID: cfg.CloudFrontDistributionID, Credentials: cfg.AWS})
+	}
+
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// Multi purges several CDNs
+type Multi []Purger
+
+// Purge purges every CDN, returns the first error
+func (m Multi) Purge(ctx context.Context, paths []string) error {
+	var firstErr error
+	for _, p := range m {
+		if err := p.Purge(ctx, paths); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// Fastly purges by surrogate key, prefixes are mapped to the surrogate key named after
+// their first segment (/tiles/* to tiles), as set by the server, single URLs are purged by URL
+type Fastly struct {
+	ServiceID string
+	Token     string
+	// Host is the public host of the service
+	Host     string
+	Endpoint string
+}
+
+// Purge purges paths
+func (f *Fastly) Purge(ctx context.Context, paths []string) error {
+	endpoint := f.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.fastly.com"
+	}
+	headers := map[string]string{"Fastly-Key": f.Token}
+
+	for _, p := range paths {
+		var u string
+		if strings.HasSuffix(p, "*") {
+			key := strings.Trim(strings.TrimSuffix(p, "*"), "/")
+			if key == "" || strings.Contains(key, "/") {
+				u = fmt.Sprintf("%s/service/%s/purge_all", endpoint, f.ServiceID)
+			} else {
+				u = fmt.Sprintf("%s/service/%s/purge/%s", endpoint, f.ServiceID, url.PathEscape(key))
+			}
+		} else {
+			u = fmt.Sprintf("%s/purge/%s%s", endpoint, f.Host, p)
+		}
+
+		if err := do(ctx, http.MethodPost, u, nil, headers, nil); err != nil {
+			return fmt.Errorf("can't purge Fastly %s: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+// cloudflareMaxFiles is the max count of URLs or prefixes per purge request
+const cloudflareMaxFiles = 30
+
+// Cloudflare purges single URLs, and prefixes by prefix purge, which is not offered by every plan,
+// the purge then fails rather than purging the whole zone
+type Cloudflare struct {
+	ZoneID  string
+	Token   string
+	BaseURL string
+	// Endpoint defaults to https://api.cloudflare.com/client/v4
+	Endpoint string
+}
+
+// Purge purges paths
+func (c *Cloudflare) Purge(ctx context.Context, paths []string) error {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.cloudflare.com/client/v4"
+	}
+	u := fmt.Sprintf("%s/zones/%s/purge_cache", endpoint, c.ZoneID)
+	headers := map[string]string{
+		"Authorization": "Bearer " + c.Token,
+		"Content-Type":  "application/json",
+	}
+
+	base := strings.TrimSuffix(c.BaseURL, "/")
+	var files, prefixes []string
+	for _, p := range paths {
+		if strings.HasSuffix(p, "*") {
+			// the prefixes are given without scheme, e.g. tiles.example.com/tiles/
+			prefix := base + strings.TrimSuffix(p, "*")
+			if i := strings.Index(prefix, "://"); i >= 0 {
+				prefix = prefix[i+3:]
+			}
+			prefixes = append(prefixes, prefix)
+			continue
+		}
+		files = append(files, base+p)
+	}
+
+	for _, batch := range []struct {
+		field string
+		items []string
+	}{{"files", files}, {"prefixes", prefixes}} {
+		items := batch.items
+		for len(items) > 0 {
+			n := len(items)
+			if n > cloudflareMaxFiles {
+				n = cloudflareMaxFiles
+			}
+			body, _ := json.Marshal(map[string][]string{batch.field: items[:n]})
+			if err := c.post(ctx, u, body, headers); err != nil {
+				return err
+			}
+			items = items[n:]
+		}
+	}
+
+	return nil
+}
+
+func (c *Cloudflare) post(ctx context.Context, u string, body []byte, headers map[string]string) error {
+	var resp struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := do(ctx, http.MethodPost, u, body, headers, &resp); err != nil {
+		return fmt.Errorf("can't purge Cloudflare: %w", err)
+	}
+	if !resp.Success {
+		if len(resp.Errors) > 0 {
+			return fmt.Errorf("can't purge Cloudflare: %s", resp.Errors[0].Message)
+		}
+		return fmt.Errorf("can't purge Cloudflare")
+	}
+	return nil
+}
+
+// cloudFrontMaxPaths is the max count of paths per invalidation batch
+const cloudFrontMaxPaths = 3000
+
+// CloudFront creates invalidations, prefixes are supported natively
+type CloudFront struct {
+	DistributionID string
+	Credentials    sigv4.Credentials
+	// Endpoint defaults to https://cloudfront.amazonaws.com
+	Endpoint string
+}
+
+type invalidationBatch struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	CallerReference string   `xml:"CallerReference"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Paths           []string `xml:"Paths>Items>Path"`
+}
+
+// Purge purges paths
+func (c *CloudFront) Purge(ctx context.Context, paths []string) error {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://cloudfront.amazonaws.com"
+	}
+	u := fmt.Sprintf("%s/2020-05-31/distribution/%s/invalidation", endpoint, c.DistributionID)
+
+	for i := 0; len(paths) > 0; i++ {
+		n := len(paths)
+		if n > cloudFrontMaxPaths {
+			n = cloudFrontMaxPaths
+		}
+
+		body, err := xml.Marshal(invalidationBatch{
+			CallerReference: fmt.Sprintf("kvtiles-%d-%d", time.Now().UnixNano(), i),
+			Quantity:        n,
+			Paths:           paths[:n],
+		})
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/xml")
+		sigv4.Sign(req, body, c.Credentials, "us-east-1", "cloudfront", time.Now())
+
+		if err := send(req, nil); err != nil {
+			return fmt.Errorf("can't create CloudFront invalidation: %w", err)
+		}
+		paths = paths[n:]
+	}
+
+	return nil
+}
+
+// do sends a request, decoding the JSON response in v if not nil
+func do(ctx context.Context, method, u string, body []byte, headers map[string]string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return send(req, v)
+}
+
+func send(req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	if v != nil {
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package cdnpurge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFastly_Purge(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "secret", r.Header.Get("Fastly-Key"))
+		mu.Lock()
+		got = append(got, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	f := &Fastly{ServiceID: "svc", Token: "secret", Host: "tiles.example.com", Endpoint: ts.URL}
+	err := f.Purge(context.Background(), []string{"/tiles/*", "/*", "/version"})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/service/svc/purge/tiles",
+		"/service/svc/purge_all",
+		"/purge/tiles.example.com/version",
+	}, got)
+}
+
+func TestCloudflare_Purge(t *testing.T) {
+	var batches [][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/zones/zone/purge_cache", r.URL.Path)
+		var req struct {
+			Files []string `json:"files"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		batches = append(batches, req.Files)
+		fmt.Fprint(w, `{"success": true}`)
+	}))
+	defer ts.Close()
+
+	var paths []string
+	for i := 0; i < 45; i++ {
+		paths = append(paths, fmt.Sprintf("/tiles/10/%d/1.pbf", i))
+	}
+
+	c := &Cloudflare{ZoneID: "zone", Token: "secret", BaseURL: "https://tiles.example.com/", Endpoint: ts.URL}
+	require.NoError(t, c.Purge(context.Background(), paths))
+	require.Len(t, batches, 2)
+	require.Len(t, batches[0], cloudflareMaxFiles)
+	require.Equal(t, "https://tiles.example.com/tiles/10/0/1.pbf", batches[0][0])
+}
+
+func TestCloudflare_PurgePrefixes(t *testing.T) {
+	var bodies []map[string][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string][]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		bodies = append(bodies, req)
+		if _, ok := req["prefixes"]; ok {
+			fmt.Fprint(w, `{"success": false, "errors": [{"message": "Purge by prefix is only available on Enterprise plans"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"success": true}`)
+	}))
+	defer ts.Close()
+
+	c := &Cloudflare{ZoneID: "zone", Token: "secret", BaseURL: "https://tiles.example.com", Endpoint: ts.URL}
+	err := c.Purge(context.Background(), DataPaths)
+	require.EqualError(t, err, "can't purge Cloudflare: Purge by prefix is only available on Enterprise plans")
+	require.Equal(t, []map[string][]string{
+		{"files": {"https://tiles.example.com/version"}},
+		{"prefixes": {"tiles.example.com/tiles/", "tiles.example.com/static/"}},
+	}, bodies)
+}
@@ -0,0 +1,293 @@
+// Package kvtiles is a client for the kvtilesd HTTP API.
+package kvtiles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// ErrNotFound is returned when the tile does not exist
+var ErrNotFound = errors.New("kvtiles: not found")
+
+// StatusError is returned when the server answers with an unexpected status
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("kvtiles: unexpected status %s", e.Status)
+}
+
+// Client reads tiles from a kvtilesd server, it is safe for concurrent use
+type Client struct {
+	baseURL string
+	key     string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+
+	cacheDir string
+	cacheTTL time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithKey sets the tiles or API key sent with the requests
+func WithKey(key string) Option {
+	return func(c *Client) {
+		c.key = key
+	}
+}
+
+// WithHTTPClient replaces the default pooled HTTP client
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// WithRetries retries the failed requests up to n times, waiting backoff then twice as long each time
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = n
+		c.backoff = backoff
+	}
+}
+
+// WithDiskCache keeps the tiles read in dir for ttl, 0 to keep them forever
+func WithDiskCache(dir string, ttl time.Duration) Option {
+	return func(c *Client) {
+		c.cacheDir = dir
+		c.cacheTTL = ttl
+	}
+}
+
+// New returns a Client of the server at baseURL, e.g. http://localhost:8080
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("kvtiles: invalid base URL %q", baseURL)
+	}
+
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConns:        100,
+				MaxIdleConnsPerHost: 32,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+		retries: 2,
+		backoff: 100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.cacheDir != "" {
+		if err := os.MkdirAll(c.cacheDir, 0755); err != nil {
+			return nil, fmt.Errorf("kvtiles: can't create cache directory: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// GetTile returns the uncompressed vector tile at z/x/y in the XYZ scheme,
+// ErrNotFound if the tile does not exist
+func (c *Client) GetTile(ctx context.Context, z uint8, x, y uint64) ([]byte, error) {
+	path := fmt.Sprintf("/tiles/%d/%d/%d.pbf", z, x, y)
+
+	if data, ok := c.cached(path); ok {
+		return data, nil
+	}
+
+	data, err := c.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache(path, data)
+
+	return data, nil
+}
+
+// GetMapInfos returns the infos of the map served
+func (c *Client) GetMapInfos(ctx context.Context) (*storage.MapInfos, error) {
+	data, err := c.get(ctx, "/version")
+	if err != nil {
+		return nil, err
+	}
+
+	var v struct {
+		Infos *storage.MapInfos `json:"infos"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("kvtiles: invalid map infos: %w", err)
+	}
+	if v.Infos == nil {
+		return nil, ErrNotFound
+	}
+	return v.Infos, nil
+}
+
+// TileJSON describes the tileset, see https://github.com/mapbox/tilejson-spec
+type TileJSON struct {
+	TileJSON     string        `json:"tilejson"`
+	Name         string        `json:"name,omitempty"`
+	Description  string        `json:"description,omitempty"`
+	Attribution  string        `json:"attribution,omitempty"`
+	Scheme       string        `json:"scheme,omitempty"`
+	Format       string        `json:"format,omitempty"`
+	Tiles        []string      `json:"tiles"`
+	MinZoom      int           `json:"minzoom"`
+	MaxZoom      int           `json:"maxzoom"`
+	Bounds       []float64     `json:"bounds,omitempty"`
+	Center       []float64     `json:"center,omitempty"`
+	VectorLayers []VectorLayer `json:"vector_layers,omitempty"`
+}
+
+// VectorLayer describes a layer of the vector tiles
+type VectorLayer struct {
+	ID          string            `json:"id"`
+	Description string            `json:"description,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	MinZoom     int               `json:"minzoom"`
+	MaxZoom     int               `json:"maxzoom"`
+}
+
+// TileJSON returns the TileJSON of the tileset
+func (c *Client) TileJSON(ctx context.Context) (*TileJSON, error) {
+	data, err := c.get(ctx, "/static/planet.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var tj TileJSON
+	if err := json.Unmarshal(data, &tj); err != nil {
+		return nil, fmt.Errorf("kvtiles: invalid TileJSON: %w", err)
+	}
+	return &tj, nil
+}
+
+// get reads path, retrying on network errors, 429 and 5xx
+func (c *Client) get(ctx context.Context, path string) ([]byte, error) {
+	u := c.baseURL + path
+	if c.key != "" {
+		u += "?key=" + url.QueryEscape(c.key)
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		data, retry, err := c.do(ctx, u)
+		if err == nil || !retry || attempt >= c.retries {
+			return data, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) do(ctx context.Context, u string) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+		return nil, true, fmt.Errorf("kvtiles: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, true, fmt.Errorf("kvtiles: can't read response: %w", err)
+		}
+		return data, false, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, ErrNotFound
+	default:
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return nil, retry, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+}
+
+func (c *Client) cachePath(path string) string {
+	return filepath.Join(c.cacheDir, filepath.FromSlash(strings.TrimPrefix(path, "/tiles/")))
+}
+
+func (c *Client) cached(path string) ([]byte, bool) {
+	if c.cacheDir == "" {
+		return nil, false
+	}
+
+	p := c.cachePath(path)
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, false
+	}
+	if c.cacheTTL > 0 && time.Since(fi.ModTime()) > c.cacheTTL {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// cache writes the tile atomically, errors are ignored since the cache is optional
+func (c *Client) cache(path string, data []byte) {
+	if c.cacheDir == "" {
+		return
+	}
+
+	p := c.cachePath(path)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tile-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,111 @@
+package kvtiles
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, failures int32) (*httptest.Server, *int32) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if r.URL.Query().Get("key") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if n <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/tiles/1/0/0.pbf":
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			fmt.Fprint(gw, "tile 1/0/0")
+			gw.Close()
+		case "/version":
+			fmt.Fprint(w, `{"infos":{"MaxZoom":9,"Region":"hawaii"},"version":"test"}`)
+		case "/static/planet.json":
+			fmt.Fprint(w, `{"tilejson":"2.1.0","tiles":["http://localhost/tiles/{z}/{x}/{y}.pbf"],"minzoom":0,"maxzoom":9,
+				"vector_layers":[{"id":"water","fields":{"class":"String"},"minzoom":0,"maxzoom":9}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &requests
+}
+
+func TestClient_GetTile(t *testing.T) {
+	ts, requests := newTestServer(t, 2)
+	dir := t.TempDir()
+
+	c, err := New(ts.URL, WithKey("secret"), WithRetries(2, time.Millisecond), WithDiskCache(dir, time.Hour))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	data, err := c.GetTile(ctx, 1, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, "tile 1/0/0", string(data))
+	require.EqualValues(t, 3, atomic.LoadInt32(requests))
+
+	// served from the disk cache
+	data, err = c.GetTile(ctx, 1, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, "tile 1/0/0", string(data))
+	require.EqualValues(t, 3, atomic.LoadInt32(requests))
+
+	_, err = c.GetTile(ctx, 1, 1, 0)
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestClient_errors(t *testing.T) {
+	ts, requests := newTestServer(t, 10)
+
+	c, err := New(ts.URL, WithKey("secret"), WithRetries(1, time.Millisecond))
+	require.NoError(t, err)
+	_, err = c.GetTile(context.Background(), 1, 0, 0)
+	var se *StatusError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, http.StatusServiceUnavailable, se.Code)
+	require.EqualValues(t, 2, atomic.LoadInt32(requests))
+
+	// client errors are not retried
+	c, err = New(ts.URL, WithRetries(3, time.Millisecond))
+	require.NoError(t, err)
+	_, err = c.GetTile(context.Background(), 1, 0, 0)
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, http.StatusUnauthorized, se.Code)
+	require.EqualValues(t, 3, atomic.LoadInt32(requests))
+
+	_, err = New("localhost:8080")
+	require.Error(t, err)
+}
+
+func TestClient_infos(t *testing.T) {
+	ts, _ := newTestServer(t, 0)
+
+	c, err := New(ts.URL, WithKey("secret"))
+	require.NoError(t, err)
+
+	infos, err := c.GetMapInfos(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 9, infos.MaxZoom)
+	require.Equal(t, "hawaii", infos.Region)
+
+	tj, err := c.TileJSON(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 9, tj.MaxZoom)
+	require.Equal(t, "water", tj.VectorLayers[0].ID)
+	require.Len(t, tj.Tiles, 1)
+}
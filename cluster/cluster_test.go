@@ -0,0 +1,267 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+func TestRing(t *testing.T) {
+	r3, err := NewRing([]string{"a", "b", "c"})
+	require.NoError(t, err)
+	r4, err := NewRing([]string{"a", "b", "c", "d"})
+	require.NoError(t, err)
+
+	counts := make(map[string]int)
+	var moved, total int
+	for x := uint64(0); x < 128; x++ {
+		for y := uint64(0); y < 128; y++ {
+			tile := tilemath.Tile{Z: 7, X: x, Y: y}
+			s3, s4 := r3.Shard(tile), r4.Shard(tile)
+			counts[s3]++
+			total++
+			if s3 != s4 {
+				moved++
+				// tiles only move to the new shard
+				require.Equal(t, "d", s4)
+			}
+		}
+	}
+
+	for name, n := range counts {
+		require.InDelta(t, float64(total)/3, float64(n), float64(total)/6, "shard %s", name)
+	}
+	require.InDelta(t, float64(total)/4, float64(moved), float64(total)/8)
+
+	_, err = NewRing([]string{"a", "a"})
+	require.Error(t, err)
+}
+
+func TestParseShards(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{"a=http://a:8080, b=http://b:8080/", map[string][]string{"a": {"http://a:8080"}, "b": {"http://b:8080"}}, false},
+		{"a=http://a1,a=http://a2", map[string][]string{"a": {"http://a1", "http://a2"}}, false},
+		{"", nil, true},
+		{"a", nil, true},
+		{"=http://a", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			urls, err := ParseShards(tt.in)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, urls)
+		})
+	}
+}
+
+func TestGateway_ReadTileData(t *testing.T) {
+	urls := make(map[string][]string)
+	for _, name := range []string{"a", "b"} {
+		name := name
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/tiles/3/0/0.pbf" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			fmt.Fprintf(w, "%s%s", name, r.URL.Path)
+		}))
+		defer ts.Close()
+		urls[name] = []string{ts.URL}
+	}
+
+	g, err := NewGateway(urls)
+	require.NoError(t, err)
+
+	for x := uint64(1); x < 8; x++ {
+		// TMS y 7 is XYZ y 0
+		data, err := g.ReadTileData(context.Background(), 3, x, 7)
+		require.NoError(t, err)
+		shard := g.ring.Shard(tilemath.Tile{Z: 3, X: x, Y: 0})
+		require.Equal(t, fmt.Sprintf("%s/tiles/3/%d/0.pbf", shard, x), string(data))
+	}
+
+	data, err := g.ReadTileData(context.Background(), 3, 0, 7)
+	require.NoError(t, err)
+	require.Nil(t, data)
+}
+
+// gossipKey is the secret key of the test clusters
+var gossipKey = []byte("0123456789abcdef")
+
+func TestGossip(t *testing.T) {
+	logger := log.NewNopLogger()
+
+	g1, err := NewGossip(GossipConfig{
+		NodeName:  "n1",
+		BindAddr:  "127.0.0.1",
+		Meta:      Member{GroupcacheURL: "http://n1:8090"},
+		SecretKey: gossipKey,
+	}, logger)
+	require.NoError(t, err)
+	defer g1.Leave(time.Second)
+
+	changes := make(chan []Member, 10)
+	g1.Watch(func(members []Member) { changes <- members })
+	require.Len(t, <-changes, 1)
+
+	g2, err := NewGossip(GossipConfig{
+		NodeName:  "n2",
+		BindAddr:  "127.0.0.1",
+		Join:      []string{fmt.Sprintf("127.0.0.1:%d", g1.LocalPort())},
+		Meta:      Member{Shard: "a", ShardURL: "http://n2:8080"},
+		SecretKey: gossipKey,
+	}, logger)
+	require.NoError(t, err)
+	defer g2.Leave(time.Second)
+
+	var members []Member
+	require.Eventually(t, func() bool {
+		select {
+		case members = <-changes:
+		default:
+		}
+		return len(members) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, []string{"http://n1:8090"}, GroupcachePeers(members))
+	require.Equal(t, map[string][]string{"a": {"http://n2:8080"}}, ShardURLs(members))
+	require.Equal(t, "n2", members[1].Name)
+}
+
+func TestGossip_SecretKey(t *testing.T) {
+	logger := log.NewNopLogger()
+
+	_, err := NewGossip(GossipConfig{NodeName: "n1", BindAddr: "127.0.0.1"}, logger)
+	require.Error(t, err)
+
+	_, err = NewGossip(GossipConfig{NodeName: "n1", BindAddr: "127.0.0.1", SecretKey: []byte("short")}, logger)
+	require.Error(t, err)
+
+	g1, err := NewGossip(GossipConfig{NodeName: "n1", BindAddr: "127.0.0.1", SecretKey: gossipKey}, logger)
+	require.NoError(t, err)
+	defer g1.Leave(time.Second)
+
+	join := []string{fmt.Sprintf("127.0.0.1:%d", g1.LocalPort())}
+
+	// without the key or with another key
+	_, err = NewGossip(GossipConfig{NodeName: "n2", BindAddr: "127.0.0.1", Join: join, Insecure: true}, logger)
+	require.Error(t, err)
+	_, err = NewGossip(GossipConfig{NodeName: "n3", BindAddr: "127.0.0.1", Join: join, SecretKey: []byte("fedcba9876543210")}, logger)
+	require.Error(t, err)
+	require.Len(t, g1.Members(), 1)
+
+	key, err := ParseSecretKey("MDEyMzQ1Njc4OWFiY2RlZg==")
+	require.NoError(t, err)
+	require.Equal(t, gossipKey, key)
+}
+
+func TestGossip_Invalidate(t *testing.T) {
+	logger := log.NewNopLogger()
+
+	var nodes []*Gossip
+	for i, shard := range []string{"a", "", ""} {
+		cfg := GossipConfig{
+			NodeName:  fmt.Sprintf("n%d", i),
+			BindAddr:  "127.0.0.1",
+			Meta:      Member{Shard: shard},
+			SecretKey: gossipKey,
+		}
+		if i > 0 {
+			cfg.Join = []string{fmt.Sprintf("127.0.0.1:%d", nodes[0].LocalPort())}
+		}
+		g, err := NewGossip(cfg, logger)
+		require.NoError(t, err)
+		defer g.Leave(time.Second)
+		nodes = append(nodes, g)
+	}
+
+	received := make(chan Invalidation, 10)
+	for _, g := range nodes[1:] {
+		g.OnInvalidate(func(inv Invalidation) { received <- inv })
+	}
+
+	nodes[0].Invalidate("v2")
+	want := Invalidation{Node: "n0", Shard: "a", Version: "v2"}
+	for range nodes[1:] {
+		select {
+		case inv := <-received:
+			require.Equal(t, want, inv)
+		case <-time.After(5 * time.Second):
+			t.Fatal("invalidation not received")
+		}
+	}
+
+	// relayed copies are delivered once
+	select {
+	case inv := <-received:
+		t.Fatalf("unexpected invalidation %v", inv)
+	case <-time.After(time.Second):
+	}
+}
+
+func TestStandby(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	hs := health.NewServer()
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var changes []bool
+	s := NewStandby(conn, "grpc.health.v1.kvtilesd", time.Second, 2, func(active bool) {
+		changes = append(changes, active)
+	}, log.NewNopLogger())
+
+	ctx := context.Background()
+	steps := []struct {
+		status healthpb.HealthCheckResponse_ServingStatus
+		want   []bool
+	}{
+		{healthpb.HealthCheckResponse_SERVING, nil},
+		{healthpb.HealthCheckResponse_NOT_SERVING, nil},
+		{healthpb.HealthCheckResponse_SERVING, nil},
+		{healthpb.HealthCheckResponse_NOT_SERVING, nil},
+		{healthpb.HealthCheckResponse_NOT_SERVING, []bool{true}},
+		{healthpb.HealthCheckResponse_NOT_SERVING, []bool{true}},
+		{healthpb.HealthCheckResponse_SERVING, []bool{true}},
+		{healthpb.HealthCheckResponse_SERVING, []bool{true, false}},
+	}
+	for i, step := range steps {
+		hs.SetServingStatus("grpc.health.v1.kvtilesd", step.status)
+		s.update(s.primaryServing(ctx))
+		require.Equal(t, step.want, changes, "step %d", i)
+	}
+
+	// an unreachable primary is down
+	srv.Stop()
+	s.update(s.primaryServing(ctx))
+	s.update(s.primaryServing(ctx))
+	require.Equal(t, []bool{true, false, true}, changes)
+}
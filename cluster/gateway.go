@@ -0,0 +1,159 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// Gateway is a TileStore reading the tiles from the shard owning them over HTTP
+type Gateway struct {
+	mu    sync.RWMutex
+	ring  *Ring
+	names []string
+	// urls of the nodes serving each shard, used in turn
+	urls map[string][]string
+	next uint32
+
+	client *http.Client
+}
+
+// NewGateway returns a Gateway over the shards, urls maps the shards names to their nodes base URLs
+func NewGateway(urls map[string][]string) (*Gateway, error) {
+	g := &Gateway{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			// tiles are requested gzipped as stored, they must not be decompressed
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				DisableCompression:  true,
+				MaxIdleConnsPerHost: 64,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+
+	if err := g.SetShards(urls); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// SetShards replaces the shards, e.g. when discovered
+func (g *Gateway) SetShards(urls map[string][]string) error {
+	names := make([]string, 0, len(urls))
+	for name, u := range urls {
+		if len(u) == 0 {
+			return fmt.Errorf("no URL for shard %s", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ring, err := NewRing(names)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.ring, g.names, g.urls = ring, names, urls
+	g.mu.Unlock()
+
+	return nil
+}
+
+// shardURL returns the base URL of a node serving the shard owning t
+func (g *Gateway) shardURL(t tilemath.Tile) (string, string) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	shard := g.ring.Shard(t)
+	urls := g.urls[shard]
+	return shard, urls[int(atomic.AddUint32(&g.next, 1))%len(urls)]
+}
+
+// ReadTileData reads the tile from its shard, y is in the TMS scheme as for the other stores
+func (g *Gateway) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	t := tilemath.Tile{Z: z, X: x, Y: 1<<z - y - 1}
+	shard, base := g.shardURL(t)
+	u := fmt.Sprintf("%s/tiles/%d/%d/%d.pbf", base, t.Z, t.X, t.Y)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("can't reach shard %s: %w", shard, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("shard %s returned %s", shard, resp.Status)
+	}
+}
+
+// LoadMapInfos loads the map infos from the first reachable shard node
+func (g *Gateway) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	g.mu.RLock()
+	var bases []string
+	for _, name := range g.names {
+		bases = append(bases, g.urls[name]...)
+	}
+	g.mu.RUnlock()
+
+	var err error
+	for _, base := range bases {
+		var infos *storage.MapInfos
+		infos, err = g.shardInfos(base)
+		if err == nil {
+			return infos, infos != nil, nil
+		}
+	}
+	return nil, false, err
+}
+
+func (g *Gateway) shardInfos(base string) (*storage.MapInfos, error) {
+	resp, err := g.client.Get(base + "/version")
+	if err != nil {
+		return nil, fmt.Errorf("can't reach shard node %s: %w", base, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("shard node %s returned %s", base, resp.Status)
+	}
+
+	var v struct {
+		Infos *storage.MapInfos `json:"infos"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid version from shard node %s: %w", base, err)
+	}
+	return v.Infos, nil
+}
+
+// StoreMap is not supported, maps are imported on each shard
+func (g *Gateway) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return errors.New("can't store a map through the gateway")
+}
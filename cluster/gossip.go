@@ -0,0 +1,358 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hashicorp/memberlist"
+)
+
+// Member is advertised by every node to the others
+type Member struct {
+	Name string `json:"-"`
+	// GroupcacheURL is the URL serving the node groupcache, empty when disabled
+	GroupcacheURL string `json:"groupcache,omitempty"`
+	// Shard is the name of the shard served by the node, at ShardURL
+	Shard    string `json:"shard,omitempty"`
+	ShardURL string `json:"shard_url,omitempty"`
+}
+
+// GossipConfig configures the node gossip
+type GossipConfig struct {
+	// NodeName must be unique in the cluster
+	NodeName string
+	BindAddr string
+	BindPort int
+	// AdvertiseAddr is the address the others reach the node at, empty to detect it
+	AdvertiseAddr string
+	// Join is a list of addresses of existing members, empty to start a new cluster
+	Join []string
+	// Meta is advertised to the others
+	Meta Member
+	// SecretKey encrypts and authenticates the gossip with AES, 16, 24 or 32 bytes shared by all the members,
+	// required unless Insecure is set
+	SecretKey []byte
+	// Insecure allows a gossip in clear text, any host reaching the port can join
+	Insecure bool
+}
+
+// ParseSecretKey decodes a base64 gossip key, e.g. from head -c 32 /dev/urandom | base64
+func ParseSecretKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gossip key: %w", err)
+	}
+	return key, nil
+}
+
+// Invalidation is broadcast by a node when its dataset changes,
+// so the others drop the tiles they cached from it
+type Invalidation struct {
+	Node string `json:"node"`
+	// Shard is the shard served by Node, empty when not sharded
+	Shard   string `json:"shard,omitempty"`
+	Version string `json:"version"`
+}
+
+// msgInvalidation prefixes the invalidations user messages
+const msgInvalidation byte = 1
+
+// Gossip discovers the nodes of the cluster using memberlist
+type Gossip struct {
+	list          *memberlist.Memberlist
+	meta          []byte
+	shard         string
+	broadcasts    *memberlist.TransmitLimitedQueue
+	changed       chan struct{}
+	invalidations chan Invalidation
+	done          chan struct{}
+	mu            sync.Mutex
+	watchers      []func([]Member)
+	invalidators  []func(Invalidation)
+	// last version seen per node, invalidations are relayed once
+	seen   map[string]string
+	logger log.Logger
+}
+
+// NewGossip joins the cluster
+func NewGossip(cfg GossipConfig, logger log.Logger) (*Gossip, error) {
+	meta, err := json.Marshal(cfg.Meta)
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) > memberlist.MetaMaxSize {
+		return nil, fmt.Errorf("node metadata is too large")
+	}
+	switch len(cfg.SecretKey) {
+	case 16, 24, 32:
+	case 0:
+		if !cfg.Insecure {
+			return nil, errors.New("a gossip secret key is required, unless the gossip is explicitly insecure")
+		}
+	default:
+		return nil, fmt.Errorf("invalid gossip key size %d, 16, 24 or 32 bytes expected", len(cfg.SecretKey))
+	}
+
+	g := &Gossip{
+		meta:          meta,
+		shard:         cfg.Meta.Shard,
+		changed:       make(chan struct{}, 1),
+		invalidations: make(chan Invalidation, 16),
+		done:          make(chan struct{}),
+		seen:          make(map[string]string),
+		logger:        log.With(logger, "component", "gossip"),
+	}
+
+	conf := memberlist.DefaultLANConfig()
+	if cfg.NodeName != "" {
+		conf.Name = cfg.NodeName
+	}
+	if cfg.BindAddr != "" {
+		conf.BindAddr = cfg.BindAddr
+	}
+	conf.BindPort = cfg.BindPort
+	conf.AdvertisePort = cfg.BindPort
+	conf.AdvertiseAddr = cfg.AdvertiseAddr
+	conf.SecretKey = cfg.SecretKey
+	conf.Delegate = g
+	conf.Events = g
+	conf.Logger = stdlog.New(log.NewStdlibAdapter(level.Debug(g.logger)), "", 0)
+
+	g.list, err = memberlist.Create(conf)
+	if err != nil {
+		return nil, fmt.Errorf("can't start gossip: %w", err)
+	}
+	g.broadcasts = &memberlist.TransmitLimitedQueue{
+		NumNodes:       g.list.NumMembers,
+		RetransmitMult: conf.RetransmitMult,
+	}
+
+	if len(cfg.Join) > 0 {
+		n, err := g.list.Join(cfg.Join)
+		if err != nil {
+			g.list.Shutdown()
+			return nil, fmt.Errorf("can't join cluster: %w", err)
+		}
+		level.Info(g.logger).Log("msg", "joined cluster", "contacted", n)
+	}
+
+	go g.notify()
+
+	return g, nil
+}
+
+// LocalPort returns the port the gossip is listening on
+func (g *Gossip) LocalPort() int {
+	return int(g.list.LocalNode().Port)
+}
+
+// Members returns the alive members including this node, sorted by name
+func (g *Gossip) Members() []Member {
+	nodes := g.list.Members()
+	members := make([]Member, 0, len(nodes))
+	for _, n := range nodes {
+		var m Member
+		if err := json.Unmarshal(n.Meta, &m); err != nil {
+			level.Warn(g.logger).Log("msg", "invalid node metadata", "node", n.Name, "error", err)
+			continue
+		}
+		m.Name = n.Name
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// Watch calls fn with the members now and every time the members change
+func (g *Gossip) Watch(fn func([]Member)) {
+	g.mu.Lock()
+	g.watchers = append(g.watchers, fn)
+	g.mu.Unlock()
+
+	fn(g.Members())
+}
+
+// OnInvalidate calls fn with the invalidations broadcast by the other nodes
+func (g *Gossip) OnInvalidate(fn func(Invalidation)) {
+	g.mu.Lock()
+	g.invalidators = append(g.invalidators, fn)
+	g.mu.Unlock()
+}
+
+// Invalidate broadcasts to the other nodes that this node now serves version
+func (g *Gossip) Invalidate(version string) {
+	inv := Invalidation{Node: g.list.LocalNode().Name, Shard: g.shard, Version: version}
+	g.mu.Lock()
+	g.seen[inv.Node] = version
+	g.mu.Unlock()
+
+	if err := g.queue(inv); err != nil {
+		level.Warn(g.logger).Log("msg", "can't broadcast invalidation", "error", err)
+	}
+}
+
+func (g *Gossip) queue(inv Invalidation) error {
+	b, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	g.broadcasts.QueueBroadcast(&invalidationBroadcast{
+		node: inv.Node,
+		msg:  append([]byte{msgInvalidation}, b...),
+	})
+	return nil
+}
+
+// Leave leaves the cluster gracefully
+func (g *Gossip) Leave(timeout time.Duration) error {
+	close(g.done)
+	if err := g.list.Leave(timeout); err != nil {
+		return err
+	}
+	return g.list.Shutdown()
+}
+
+// notify calls the watchers on changes, memberlist events can't query the members
+func (g *Gossip) notify() {
+	for {
+		select {
+		case <-g.done:
+			return
+		case inv := <-g.invalidations:
+			g.mu.Lock()
+			invalidators := g.invalidators
+			g.mu.Unlock()
+			for _, fn := range invalidators {
+				fn(inv)
+			}
+			continue
+		case <-g.changed:
+		}
+
+		members := g.Members()
+		g.mu.Lock()
+		watchers := g.watchers
+		g.mu.Unlock()
+		for _, fn := range watchers {
+			fn(members)
+		}
+	}
+}
+
+func (g *Gossip) signal() {
+	select {
+	case g.changed <- struct{}{}:
+	default:
+	}
+}
+
+// NotifyJoin implements memberlist.EventDelegate
+func (g *Gossip) NotifyJoin(n *memberlist.Node) {
+	level.Info(g.logger).Log("msg", "node joined", "node", n.Name, "addr", n.Address())
+	g.signal()
+}
+
+// NotifyLeave implements memberlist.EventDelegate
+func (g *Gossip) NotifyLeave(n *memberlist.Node) {
+	level.Info(g.logger).Log("msg", "node left", "node", n.Name, "addr", n.Address())
+	g.signal()
+}
+
+// NotifyUpdate implements memberlist.EventDelegate
+func (g *Gossip) NotifyUpdate(n *memberlist.Node) {
+	g.signal()
+}
+
+// NodeMeta implements memberlist.Delegate
+func (g *Gossip) NodeMeta(limit int) []byte {
+	return g.meta
+}
+
+// NotifyMsg implements memberlist.Delegate, new invalidations are relayed to the others
+func (g *Gossip) NotifyMsg(msg []byte) {
+	if len(msg) == 0 || msg[0] != msgInvalidation {
+		return
+	}
+
+	var inv Invalidation
+	if err := json.Unmarshal(msg[1:], &inv); err != nil {
+		level.Warn(g.logger).Log("msg", "invalid invalidation message", "error", err)
+		return
+	}
+
+	g.mu.Lock()
+	known := g.seen[inv.Node] == inv.Version
+	g.seen[inv.Node] = inv.Version
+	g.mu.Unlock()
+	if known {
+		return
+	}
+
+	if err := g.queue(inv); err != nil {
+		level.Warn(g.logger).Log("msg", "can't relay invalidation", "error", err)
+	}
+
+	select {
+	case g.invalidations <- inv:
+	default:
+		level.Warn(g.logger).Log("msg", "invalidations queue full, dropping", "node", inv.Node)
+	}
+}
+
+// GetBroadcasts implements memberlist.Delegate
+func (g *Gossip) GetBroadcasts(overhead, limit int) [][]byte {
+	return g.broadcasts.GetBroadcasts(overhead, limit)
+}
+
+// LocalState implements memberlist.Delegate
+func (g *Gossip) LocalState(join bool) []byte {
+	return nil
+}
+
+// MergeRemoteState implements memberlist.Delegate
+func (g *Gossip) MergeRemoteState(buf []byte, join bool) {}
+
+// invalidationBroadcast replaces the pending invalidation of the same node
+type invalidationBroadcast struct {
+	node string
+	msg  []byte
+}
+
+func (b *invalidationBroadcast) Invalidates(other memberlist.Broadcast) bool {
+	o, ok := other.(*invalidationBroadcast)
+	return ok && o.node == b.node
+}
+
+func (b *invalidationBroadcast) Message() []byte { return b.msg }
+
+func (b *invalidationBroadcast) Finished() {}
+
+// GroupcachePeers returns the groupcache URLs of the members
+func GroupcachePeers(members []Member) []string {
+	var peers []string
+	for _, m := range members {
+		if m.GroupcacheURL != "" {
+			peers = append(peers, m.GroupcacheURL)
+		}
+	}
+	return peers
+}
+
+// ShardURLs returns the URLs of the members serving each shard
+func ShardURLs(members []Member) map[string][]string {
+	urls := make(map[string][]string)
+	for _, m := range members {
+		if m.Shard != "" && m.ShardURL != "" {
+			urls[m.Shard] = append(urls[m.Shard], m.ShardURL)
+		}
+	}
+	return urls
+}
@@ -0,0 +1,366 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/storage"
+	kvbbolt "github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+// raftWriteTimeout bounds a write without deadline, until committed by the cluster and applied by the leader
+const raftWriteTimeout = 10 * time.Second
+
+// RaftConfig configures the replicated writes
+type RaftConfig struct {
+	// NodeID must be unique in the cluster, Addr by default
+	NodeID string
+	// Addr is the address the raft transport listens at and the others reach the node at, e.g. 10.0.0.1:7000
+	Addr string
+	// Dir stores the raft log and snapshots
+	Dir string
+	// Peers are the voters bootstrapping the cluster by ID, including this node, the same on every node,
+	// ignored once the cluster has state
+	Peers map[string]string
+	// SnapshotThreshold is the count of writes after which the DB is snapshotted and the log truncated,
+	// the raft default when 0
+	SnapshotThreshold uint64
+	// DBOptions tunes the DBs restored from the snapshots
+	DBOptions kvbbolt.Options
+}
+
+// ParsePeers parses a comma separated list of id=addr raft peers
+func ParsePeers(peers []string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, p := range peers {
+		id, addr, ok := strings.Cut(p, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("invalid raft peer %q, expected id=addr", p)
+		}
+		m[id] = addr
+	}
+	return m, nil
+}
+
+// Raft replicates the tiles and map infos writes to the DB of every node, the writes are accepted by the leader,
+// a node too far behind receives a copy of the DB replacing its own
+type Raft struct {
+	raft   *raft.Raft
+	fsm    *fsm
+	closer io.Closer
+}
+
+// NewRaft starts the node replicating the writes to db, opened for writing at path,
+// db and the DBs restored from the snapshots stay owned by the caller
+func NewRaft(cfg RaftConfig, db *kvbbolt.Storage, path string, logger log.Logger) (*Raft, error) {
+	if cfg.NodeID == "" {
+		cfg.NodeID = cfg.Addr
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	out := log.NewStdlibAdapter(level.Debug(logger))
+	advertise, err := net.ResolveTCPAddr("tcp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raft address: %w", err)
+	}
+	trans, err := raft.NewTCPTransport(cfg.Addr, advertise, 3, 10*time.Second, out)
+	if err != nil {
+		return nil, err
+	}
+	store, err := raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, "raft.db"))
+	if err != nil {
+		trans.Close()
+		return nil, err
+	}
+	snaps, err := raft.NewFileSnapshotStore(cfg.Dir, 1, out)
+	if err != nil {
+		trans.Close()
+		store.Close()
+		return nil, err
+	}
+
+	r, err := newRaft(cfg, db, path, trans, store, store, snaps, logger)
+	if err != nil {
+		trans.Close()
+		store.Close()
+		return nil, err
+	}
+	r.closer = closers{trans, store}
+	return r, nil
+}
+
+// newRaft starts the node on the given transport and stores
+func newRaft(cfg RaftConfig, db *kvbbolt.Storage, path string, trans raft.Transport, logs raft.LogStore,
+	stable raft.StableStore, snaps raft.SnapshotStore, logger log.Logger) (*Raft, error) {
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(cfg.NodeID)
+	// the DB keeps the writes applied, it is not replaced by the last snapshot on every start
+	conf.NoSnapshotRestoreOnStart = true
+	if cfg.SnapshotThreshold > 0 {
+		conf.SnapshotThreshold = cfg.SnapshotThreshold
+	}
+	conf.Logger = hclog.New(&hclog.LoggerOptions{
+		Name:        "raft",
+		Level:       hclog.Info,
+		Output:      log.NewStdlibAdapter(level.Debug(logger)),
+		DisableTime: true,
+	})
+
+	f := &fsm{db: db, path: path, opts: cfg.DBOptions, logger: logger}
+	r, err := raft.NewRaft(conf, f, logs, stable, snaps, trans)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Peers) > 0 {
+		var servers []raft.Server
+		for id, addr := range cfg.Peers {
+			servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(addr)})
+		}
+		err := r.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+		if err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+			r.Shutdown()
+			return nil, fmt.Errorf("can't bootstrap the raft cluster: %w", err)
+		}
+	}
+
+	return &Raft{raft: r, fsm: f}, nil
+}
+
+// OnApply registers fn, called with the map infos after every write applied to the DB
+func (r *Raft) OnApply(fn func(infos *storage.MapInfos)) {
+	r.fsm.mu.Lock()
+	defer r.fsm.mu.Unlock()
+	r.fsm.onApply = append(r.fsm.onApply, fn)
+}
+
+// OnRestore sets fn, called with the DB replacing the current one after a snapshot is received and its close func,
+// fn owns the restored DB and must close the replaced one once its tiles are no longer read
+func (r *Raft) OnRestore(fn func(db *kvbbolt.Storage, path string, close func() error)) {
+	r.fsm.mu.Lock()
+	defer r.fsm.mu.Unlock()
+	r.fsm.onRestore = fn
+}
+
+// WriteTiles replicates the tiles, it fails with storage.ErrNotLeader on the followers
+func (r *Raft) WriteTiles(ctx context.Context, tiles []storage.Tile) error {
+	return r.apply(ctx, command{Tiles: tiles})
+}
+
+// WriteMapInfos replicates the map infos, it fails with storage.ErrNotLeader on the followers
+func (r *Raft) WriteMapInfos(ctx context.Context, infos storage.MapInfos) error {
+	return r.apply(ctx, command{Infos: &infos})
+}
+
+// apply replicates cmd, timestamped by the leader so every node stores the same index time
+func (r *Raft) apply(ctx context.Context, cmd command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cmd.Time = time.Now().UnixNano()
+	b, err := cbor.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	timeout := raftWriteTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	f := r.raft.Apply(b, timeout)
+	if err := f.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			addr, id := r.raft.LeaderWithID()
+			return fmt.Errorf("%w, the leader is %s at %s", storage.ErrNotLeader, id, addr)
+		}
+		return err
+	}
+	if err, ok := f.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Leader returns whether this node is the leader
+func (r *Raft) Leader() bool {
+	return r.raft.State() == raft.Leader
+}
+
+// Stats returns the raft state, term and indexes, for the admin status
+func (r *Raft) Stats() interface{} {
+	return r.raft.Stats()
+}
+
+// Shutdown stops the node, the DB is left open
+func (r *Raft) Shutdown() error {
+	err := r.raft.Shutdown().Error()
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// command is a write replicated in the raft log
+type command struct {
+	// Time is the index time of the map after the write, in nanoseconds
+	Time  int64             `cbor:"1,keyasint"`
+	Tiles []storage.Tile    `cbor:"2,keyasint,omitempty"`
+	Infos *storage.MapInfos `cbor:"3,keyasint,omitempty"`
+}
+
+// fsm applies the commands to the DB, the raft log index is the write sequence number
+// so the writes already in the DB are not applied again
+type fsm struct {
+	mu        sync.Mutex
+	db        *kvbbolt.Storage
+	path      string
+	opts      kvbbolt.Options
+	onApply   []func(infos *storage.MapInfos)
+	onRestore func(db *kvbbolt.Storage, path string, close func() error)
+	logger    log.Logger
+}
+
+// Apply writes a command to the DB, returns the write error if any
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var cmd command
+	if err := cbor.Unmarshal(l.Data, &cmd); err != nil {
+		level.Error(f.logger).Log("msg", "can't decode raft command", "error", err, "index", l.Index)
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var err error
+	t := time.Unix(0, cmd.Time)
+	if cmd.Infos != nil {
+		infos := *cmd.Infos
+		infos.IndexTime = t
+		err = f.db.WriteMapInfos(l.Index, infos)
+	} else {
+		err = f.db.WriteTiles(l.Index, t, cmd.Tiles)
+	}
+	if err != nil {
+		level.Error(f.logger).Log("msg", "can't apply raft command", "error", err, "index", l.Index)
+		return err
+	}
+
+	infos, ok, err := f.db.LoadMapInfos()
+	if err != nil || !ok {
+		return err
+	}
+	for _, fn := range f.onApply {
+		fn(infos)
+	}
+	return nil
+}
+
+// Snapshot returns a consistent view of the DB, copied by Persist while the writes go on
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tx, err := f.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &fsmSnapshot{tx: tx}, nil
+}
+
+// Restore replaces the DB file by the received one and opens it for writing
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	tmp := f.path + ".restore"
+	if err := writeFile(tmp, rc); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("can't receive the raft snapshot: %w", err)
+	}
+	// the current DB stays readable until closed, renaming over it only unlinks it
+	if err := os.Rename(tmp, f.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	db, closeDB, err := kvbbolt.NewRWStorageWithOptions(f.path, f.logger, f.opts)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.db = db
+	onRestore := f.onRestore
+	f.mu.Unlock()
+
+	if onRestore != nil {
+		onRestore(db, f.path, closeDB)
+	}
+	level.Info(f.logger).Log("msg", "DB restored from raft snapshot", "db_path", f.path)
+	return nil
+}
+
+func writeFile(path string, r io.Reader) error {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// fsmSnapshot holds a read transaction on the DB until released
+type fsmSnapshot struct {
+	tx *bbolt.Tx
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := s.tx.WriteTo(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {
+	s.tx.Rollback()
+}
+
+// closers closes them all, returning the first error
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var first error
+	for _, c := range cs {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
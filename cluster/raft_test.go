@@ -0,0 +1,180 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+// newMapDB creates a DB at path holding the tile 0/0/0 and opens it for writing
+func newMapDB(t *testing.T, path string) *bbolt.Storage {
+	s, clean, err := bbolt.NewStorage(path, log.NewNopLogger())
+	require.NoError(t, err)
+	w, err := s.NewTileWriter()
+	require.NoError(t, err)
+	require.NoError(t, w.Put(0, 0, 0, []byte("world")))
+	require.NoError(t, w.Close(storage.MapInfos{Region: "test"}))
+	require.NoError(t, clean())
+
+	s, _, err = bbolt.NewRWStorageWithOptions(path, log.NewNopLogger(), bbolt.Options{})
+	require.NoError(t, err)
+	return s
+}
+
+func readTile(t *testing.T, s *bbolt.Storage, z uint8, x, y uint64) string {
+	data, err := s.ReadTileData(context.Background(), z, x, y)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRaft(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvtiles-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ids := []string{"a", "b", "c"}
+	peers := make(map[string]string)
+	transports := make(map[string]*raft.InmemTransport)
+	for _, id := range ids {
+		addr, trans := raft.NewInmemTransport(raft.ServerAddress(id))
+		peers[id] = string(addr)
+		transports[id] = trans
+	}
+	for _, t1 := range transports {
+		for _, t2 := range transports {
+			t1.Connect(t2.LocalAddr(), t2)
+		}
+	}
+
+	nodes := make(map[string]*Raft)
+	dbs := make(map[string]*bbolt.Storage)
+	var applied int32
+	for _, id := range ids {
+		path := filepath.Join(dir, id+".db")
+		dbs[id] = newMapDB(t, path)
+		defer dbs[id].Close()
+
+		store := raft.NewInmemStore()
+		r, err := newRaft(RaftConfig{NodeID: id, Peers: peers}, dbs[id], path,
+			transports[id], store, store, raft.NewInmemSnapshotStore(), log.NewNopLogger())
+		require.NoError(t, err)
+		defer r.Shutdown()
+		r.OnApply(func(*storage.MapInfos) { atomic.AddInt32(&applied, 1) })
+		nodes[id] = r
+	}
+
+	var leader, follower *Raft
+	require.Eventually(t, func() bool {
+		for _, r := range nodes {
+			if r.Leader() {
+				leader = r
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 50*time.Millisecond)
+	for _, r := range nodes {
+		if r != leader {
+			follower = r
+		}
+	}
+
+	ctx := context.Background()
+	err = follower.WriteTiles(ctx, []storage.Tile{{Z: 1, X: 0, Y: 0, Data: []byte("nw")}})
+	require.True(t, errors.Is(err, storage.ErrNotLeader), err)
+
+	require.NoError(t, leader.WriteTiles(ctx, []storage.Tile{
+		{Z: 1, X: 0, Y: 0, Data: []byte("nw")},
+		{Z: 0, X: 0, Y: 0},
+	}))
+	require.NoError(t, leader.WriteMapInfos(ctx, storage.MapInfos{Region: "updated", MaxZoom: 1}))
+
+	// every node applies both writes
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&applied) == 6
+	}, 10*time.Second, 50*time.Millisecond)
+
+	var version time.Time
+	for id, db := range dbs {
+		require.Equal(t, "nw", readTile(t, db, 1, 0, 0), id)
+		require.Equal(t, "", readTile(t, db, 0, 0, 0), id)
+
+		infos, ok, err := db.LoadMapInfos()
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "updated", infos.Region)
+		require.Equal(t, 1, infos.MaxZoom)
+		if version.IsZero() {
+			version = infos.IndexTime
+		}
+		require.True(t, version.Equal(infos.IndexTime), "same version on %s", id)
+
+		seq, err := db.WriteSeq()
+		require.NoError(t, err)
+		require.NotZero(t, seq)
+	}
+}
+
+// sink collects a snapshot in memory
+type sink struct {
+	bytes.Buffer
+}
+
+func (s *sink) ID() string    { return "test" }
+func (s *sink) Cancel() error { return nil }
+func (s *sink) Close() error  { return nil }
+
+func TestFSM_SnapshotRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvtiles-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := newMapDB(t, filepath.Join(dir, "src.db"))
+	defer src.Close()
+	require.NoError(t, src.WriteTiles(3, time.Now(), []storage.Tile{{Z: 1, X: 1, Y: 1, Data: []byte("ne")}}))
+
+	snap, err := (&fsm{db: src, logger: log.NewNopLogger()}).Snapshot()
+	require.NoError(t, err)
+	var out sink
+	require.NoError(t, snap.Persist(&out))
+	snap.Release()
+
+	path := filepath.Join(dir, "dst.db")
+	dst := newMapDB(t, path)
+	var restored *bbolt.Storage
+	var closeRestored func() error
+	f := &fsm{db: dst, path: path, logger: log.NewNopLogger()}
+	f.onRestore = func(db *bbolt.Storage, p string, close func() error) {
+		require.Equal(t, path, p)
+		restored, closeRestored = db, close
+	}
+	require.NoError(t, f.Restore(ioutil.NopCloser(&out)))
+	require.NotNil(t, restored)
+	defer closeRestored()
+
+	// the replaced DB is still readable until closed
+	require.Equal(t, "", readTile(t, dst, 1, 1, 1))
+	require.NoError(t, dst.Close())
+
+	require.Equal(t, "ne", readTile(t, restored, 1, 1, 1))
+	seq, err := restored.WriteSeq()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), seq)
+
+	// the received file replaced the DB
+	_, err = os.Stat(path + ".restore")
+	require.True(t, os.IsNotExist(err))
+}
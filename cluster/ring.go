@@ -0,0 +1,108 @@
+// Package cluster partitions the tiles across shard nodes by consistent hashing,
+// a gateway presents the shards as a single tiles server.
+package cluster
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// virtualNodes is the count of points per shard on the ring,
+// the importer and the gateway must agree on it
+const virtualNodes = 128
+
+// Ring assigns tiles to shards by consistent hashing, adding or removing
+// a shard only moves the tiles owned by that shard
+type Ring struct {
+	points []uint64
+	owners []string
+}
+
+// NewRing returns a Ring over the shards names
+func NewRing(shards []string) (*Ring, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no shard")
+	}
+
+	seen := make(map[string]bool)
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	points := make([]point, 0, len(shards)*virtualNodes)
+	for _, name := range shards {
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("invalid or duplicate shard name %q", name)
+		}
+		seen[name] = true
+
+		for i := 0; i < virtualNodes; i++ {
+			points = append(points, point{hash: hash(fmt.Sprintf("%s#%d", name, i)), owner: name})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].owner < points[j].owner
+		}
+		return points[i].hash < points[j].hash
+	})
+
+	r := &Ring{
+		points: make([]uint64, len(points)),
+		owners: make([]string, len(points)),
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+
+	return r, nil
+}
+
+// Shard returns the name of the shard owning t
+func (r *Ring) Shard(t tilemath.Tile) string {
+	h := hash(fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// hash is FNV-1a followed by the splitmix64 finalizer, spreading close keys along the ring
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ParseShards parses a comma separated list of name=URL shards,
+// a name repeated with several URLs is a shard served by several nodes
+func ParseShards(s string) (map[string][]string, error) {
+	urls := make(map[string][]string)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid shard %q, expecting name=URL", part)
+		}
+		urls[kv[0]] = append(urls[kv[0]], strings.TrimSuffix(kv[1], "/"))
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no shard")
+	}
+	return urls, nil
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Standby checks the gRPC health of a primary, the standby becomes active after threshold
+// consecutive failed checks and steps back after threshold consecutive successful checks
+type Standby struct {
+	client    healthpb.HealthClient
+	service   string
+	interval  time.Duration
+	threshold int
+	onChange  func(active bool)
+	logger    log.Logger
+
+	active bool
+	// count of consecutive checks disagreeing with the current role
+	count int
+}
+
+// NewStandby returns a Standby of the primary reachable over conn, serving service,
+// onChange is called when the standby becomes active or steps back
+func NewStandby(conn *grpc.ClientConn, service string, interval time.Duration, threshold int,
+	onChange func(active bool), logger log.Logger) *Standby {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Standby{
+		client:    healthpb.NewHealthClient(conn),
+		service:   service,
+		interval:  interval,
+		threshold: threshold,
+		onChange:  onChange,
+		logger:    log.With(logger, "component", "standby"),
+	}
+}
+
+// Run checks the primary until ctx is done
+func (s *Standby) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.update(s.primaryServing(ctx))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Standby) primaryServing(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, s.interval)
+	defer cancel()
+
+	resp, err := s.client.Check(ctx, &healthpb.HealthCheckRequest{Service: s.service})
+	if err != nil {
+		level.Debug(s.logger).Log("msg", "primary health check failed", "error", err)
+		return false
+	}
+	return resp.Status == healthpb.HealthCheckResponse_SERVING
+}
+
+// update counts the checks results and switches role when the threshold is reached
+func (s *Standby) update(primaryServing bool) {
+	// the standby is expected to be active while the primary is down
+	if primaryServing != s.active {
+		s.count = 0
+		return
+	}
+
+	s.count++
+	if s.count < s.threshold {
+		return
+	}
+
+	s.active, s.count = !s.active, 0
+	if s.active {
+		level.Warn(s.logger).Log("msg", "primary is down, standby is now active")
+	} else {
+		level.Info(s.logger).Log("msg", "primary recovered, stepping back to standby")
+	}
+	s.onChange(s.active)
+}
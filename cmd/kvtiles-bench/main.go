@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	stdlog "log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/config"
+	"github.com/akhenakh/kvtiles/logformat"
+	"github.com/akhenakh/kvtiles/loglevel"
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+	"github.com/akhenakh/kvtiles/storage/cache"
+	"github.com/akhenakh/kvtiles/tilemath"
+	"github.com/akhenakh/kvtiles/warmup"
+)
+
+const appName = "kvtiles-bench"
+
+var (
+	version    = "no version from LDFLAGS"
+	logLevel   = flag.String("logLevel", "INFO", "DEBUG|INFO|WARN|ERROR")
+	logFormat  = flag.String("logFormat", "console", "json|logfmt|console")
+	configFile = config.Flag(flag.CommandLine)
+
+	dbPath    = flag.String("dbPath", "", "Database path, benchmarks the storage directly")
+	cacheSize = flag.Int("cacheSize", 0, "in memory LRU tiles cache size in MB in front of the storage, 0 to disable")
+	baseURL   = flag.String("url", "", "base URL of a running kvtilesd, e.g. http://localhost:8080, benchmarks over HTTP")
+	tilesKey  = flag.String("tilesKey", "", "key passed to the server")
+
+	pattern     = flag.String("pattern", "uniform", "uniform|zipf|replay")
+	accessLog   = flag.String("accessLog", "", "JSON or common/combined access log replayed in order with -pattern=replay")
+	speed       = flag.Float64("speed", 0, "replay speed multiplier of the recorded rate, 0 to replay as fast as possible")
+	bbox        = flag.String("bbox", "-180,-85,180,85", "minLng,minLat,maxLng,maxLat area of the synthetic requests")
+	minZoom     = flag.Int("minZoom", 0, "min zoom of the synthetic requests")
+	maxZoom     = flag.Int("maxZoom", 14, "max zoom of the synthetic requests")
+	hotTiles    = flag.Int("hotTiles", 10000, "distinct tiles requested with -pattern=zipf")
+	seed        = flag.Int64("seed", 1, "random seed of the synthetic requests")
+	requests    = flag.Int("requests", 100000, "number of requests, 0 for no limit")
+	duration    = flag.Duration("duration", 0, "max duration of the benchmark, 0 for no limit")
+	concurrency = flag.Int("concurrency", 8, "concurrent requests")
+)
+
+// fetcher returns the size of the tile t, 0 if not found
+type fetcher func(ctx context.Context, t tilemath.Tile) (int, error)
+
+type result struct {
+	latency  time.Duration
+	size     int
+	notFound bool
+	err      bool
+}
+
+func main() {
+	flag.Parse()
+	if err := configFile.Load(flag.CommandLine); err != nil {
+		stdlog.Fatal(err)
+	}
+
+	logger, err := logformat.NewLogger(os.Stderr, *logFormat)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "app", appName)
+	logger = loglevel.NewLevelFilterFromString(logger, *logLevel)
+
+	if (*dbPath == "") == (*baseURL == "") {
+		level.Error(logger).Log("msg", "one of dbPath or url is required")
+		os.Exit(2)
+	}
+
+	var fetch fetcher
+	if *dbPath != "" {
+		roStorage, clean, err := bbolt.NewROStorage(*dbPath, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to open storage", "error", err, "db_path", *dbPath)
+			os.Exit(2)
+		}
+		defer clean()
+
+		var store storage.TileStore = roStorage
+		if *cacheSize > 0 {
+			store = cache.NewLRU(store, int64(*cacheSize)*1024*1024)
+		}
+		fetch = storeFetcher(store)
+	} else {
+		fetch = httpFetcher(*baseURL, *tilesKey, *concurrency)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	source, err := newSource(ctx)
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid requests pattern", "error", err)
+		os.Exit(2)
+	}
+
+	level.Info(logger).Log("msg", "starting benchmark", "version", version, "pattern", *pattern,
+		"requests", *requests, "concurrency", *concurrency)
+
+	start := time.Now()
+	results := run(ctx, fetch, source, *requests, *concurrency)
+	elapsed := time.Since(start)
+
+	report(os.Stdout, results, elapsed)
+}
+
+// newSource returns the tiles to request according to the pattern flags
+func newSource(ctx context.Context) (warmup.Source, error) {
+	rnd := rand.New(rand.NewSource(*seed))
+
+	minLat, minLng, maxLat, maxLng, err := tilemath.ParseBBox(*bbox)
+	if err != nil {
+		return nil, err
+	}
+	if *minZoom < 0 || *maxZoom < *minZoom || *maxZoom > 30 {
+		return nil, fmt.Errorf("invalid zoom range %d-%d", *minZoom, *maxZoom)
+	}
+
+	randomTile := func() tilemath.Tile {
+		z := uint8(*minZoom + rnd.Intn(*maxZoom-*minZoom+1))
+		lat := minLat + rnd.Float64()*(maxLat-minLat)
+		lng := minLng + rnd.Float64()*(maxLng-minLng)
+		return tilemath.FromLatLng(lat, lng, z)
+	}
+
+	switch *pattern {
+	case "uniform":
+		return func(fn func(tilemath.Tile) bool) {
+			for fn(randomTile()) {
+			}
+		}, nil
+
+	case "zipf":
+		if *hotTiles < 2 {
+			return nil, fmt.Errorf("hotTiles must be at least 2")
+		}
+		tiles := make([]tilemath.Tile, *hotTiles)
+		for i := range tiles {
+			tiles[i] = randomTile()
+		}
+		zipf := rand.NewZipf(rnd, 1.1, 1, uint64(len(tiles)-1))
+		return func(fn func(tilemath.Tile) bool) {
+			for fn(tiles[zipf.Uint64()]) {
+			}
+		}, nil
+
+	case "replay":
+		if *accessLog == "" {
+			return nil, fmt.Errorf("accessLog is required to replay")
+		}
+		f, err := os.Open(*accessLog)
+		if err != nil {
+			return nil, fmt.Errorf("can't open access log: %w", err)
+		}
+		defer f.Close()
+
+		entries, err := readLog(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("no tile request found in %s", *accessLog)
+		}
+		return replaySource(ctx, entries, *speed), nil
+	}
+
+	return nil, fmt.Errorf("unknown pattern %s", *pattern)
+}
+
+// storeFetcher reads tiles from the storage
+func storeFetcher(store storage.TileStore) fetcher {
+	return func(ctx context.Context, t tilemath.Tile) (int, error) {
+		data, err := store.ReadTileData(ctx, t.Z, t.X, t.TMSY())
+		return len(data), err
+	}
+}
+
+// httpFetcher requests tiles from a running server
+func httpFetcher(baseURL, key string, concurrency int) fetcher {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: concurrency,
+			// we measure the server, not the decompression
+			DisableCompression: true,
+		},
+	}
+
+	return func(ctx context.Context, t tilemath.Tile) (int, error) {
+		u := fmt.Sprintf("%s/tiles/%d/%d/%d.pbf", baseURL, t.Z, t.X, t.Y)
+		if key != "" {
+			u += "?key=" + key
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return 0, err
+		}
+		// as browsers, the tiles are served gzipped
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+
+		n, err := io.Copy(ioutil.Discard, resp.Body)
+		if err != nil {
+			return 0, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return int(n), nil
+		case http.StatusNotFound:
+			return 0, nil
+		}
+		return 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+// run sends n requests from source using concurrency workers, n = 0 for no limit
+func run(ctx context.Context, fetch fetcher, source warmup.Source, n, concurrency int) []result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	tiles := make(chan tilemath.Tile)
+	var results []result
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tiles {
+				start := time.Now()
+				size, err := fetch(ctx, t)
+				if err != nil && ctx.Err() != nil {
+					// interrupted by the end of the benchmark
+					continue
+				}
+				r := result{
+					latency:  time.Since(start),
+					size:     size,
+					notFound: err == nil && size == 0,
+					err:      err != nil,
+				}
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var sent int
+	source(func(t tilemath.Tile) bool {
+		if n > 0 && sent >= n {
+			return false
+		}
+		select {
+		case tiles <- t:
+			sent++
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	close(tiles)
+	wg.Wait()
+
+	return results
+}
+
+// report writes throughput and latency percentiles to w
+func report(w io.Writer, results []result, elapsed time.Duration) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "no request completed")
+		return
+	}
+
+	var bytes int64
+	var notFound, errors int
+	latencies := make([]time.Duration, len(results))
+	for i, r := range results {
+		latencies[i] = r.latency
+		bytes += int64(r.size)
+		if r.notFound {
+			notFound++
+		}
+		if r.err {
+			errors++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	percentile := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+
+	fmt.Fprintf(w, "requests:   %d in %s\n", len(results), elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput: %.1f req/s, %.2f MB/s\n",
+		float64(len(results))/elapsed.Seconds(), float64(bytes)/1024/1024/elapsed.Seconds())
+	fmt.Fprintf(w, "not found:  %d (%.1f%%)\n", notFound, 100*float64(notFound)/float64(len(results)))
+	fmt.Fprintf(w, "errors:     %d\n", errors)
+	fmt.Fprintf(w, "latency:    p50 %s p90 %s p99 %s p99.9 %s max %s\n",
+		percentile(0.5), percentile(0.9), percentile(0.99), percentile(0.999), latencies[len(latencies)-1])
+}
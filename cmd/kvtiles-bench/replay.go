@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+	"github.com/akhenakh/kvtiles/warmup"
+)
+
+// clfTimeLayout is the timestamp layout of the common and combined log formats
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+var (
+	tilePathRe = regexp.MustCompile(`/tiles/(\d+)/(\d+)/(\d+)\.pbf`)
+	// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /tiles/1/0/0.pbf HTTP/1.1" 200 2326
+	clfRe = regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "\S+ (\S+)[^"]*" (\d{3}) `)
+)
+
+// logEntry is a tile request read from an access log
+type logEntry struct {
+	ts   time.Time
+	tile tilemath.Tile
+}
+
+// readLog returns the successful tiles requests, in order, found in a JSON access log
+// or a common/combined access log as written by nginx or apache
+func readLog(r io.Reader) ([]logEntry, error) {
+	var entries []logEntry
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if e, ok := parseLogLine(scanner.Text()); ok {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can't read access log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// parseLogLine parses a JSON or common log format line, returns false if the line
+// is not a successful tile request
+func parseLogLine(line string) (logEntry, bool) {
+	var ts time.Time
+	var path string
+
+	if strings.HasPrefix(line, "{") {
+		var l struct {
+			TS     time.Time `json:"ts"`
+			Path   string    `json:"path"`
+			Status int       `json:"status"`
+		}
+		if err := json.Unmarshal([]byte(line), &l); err != nil || l.Status != 200 {
+			return logEntry{}, false
+		}
+		ts, path = l.TS, l.Path
+	} else {
+		m := clfRe.FindStringSubmatch(line)
+		if m == nil || m[3] != "200" {
+			return logEntry{}, false
+		}
+		var err error
+		ts, err = time.Parse(clfTimeLayout, m[1])
+		if err != nil {
+			return logEntry{}, false
+		}
+		path = m[2]
+	}
+
+	t, ok := parseTilePath(path)
+	if !ok {
+		return logEntry{}, false
+	}
+
+	return logEntry{ts: ts, tile: t}, true
+}
+
+// parseTilePath returns the tile requested by a /tiles/z/x/y.pbf path
+func parseTilePath(path string) (tilemath.Tile, bool) {
+	m := tilePathRe.FindStringSubmatch(path)
+	if m == nil {
+		return tilemath.Tile{}, false
+	}
+
+	z, errz := strconv.ParseUint(m[1], 10, 8)
+	x, errx := strconv.ParseUint(m[2], 10, 64)
+	y, erry := strconv.ParseUint(m[3], 10, 64)
+	if errz != nil || errx != nil || erry != nil {
+		return tilemath.Tile{}, false
+	}
+
+	return tilemath.Tile{Z: uint8(z), X: x, Y: y}, true
+}
+
+// replaySource returns the logged requests in order, when speed is > 0 the requests
+// are paced as recorded, speed being the multiplier of the original rate
+func replaySource(ctx context.Context, entries []logEntry, speed float64) warmup.Source {
+	return func(fn func(tilemath.Tile) bool) {
+		start := time.Now()
+		for _, e := range entries {
+			if speed > 0 {
+				offset := time.Duration(float64(e.ts.Sub(entries[0].ts)) / speed)
+				if wait := time.Until(start.Add(offset)); wait > 0 {
+					select {
+					case <-time.After(wait):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			if !fn(e.tile) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want logEntry
+		ok   bool
+	}{
+		{
+			"json",
+			`{"method":"GET","path":"/tiles/11/125/899.pbf","status":200,"ts":"2020-06-01T10:00:00.5Z","z":"11","x":"125","y":"899"}`,
+			logEntry{ts: time.Date(2020, 6, 1, 10, 0, 0, 5e8, time.UTC), tile: tilemath.Tile{Z: 11, X: 125, Y: 899}},
+			true,
+		},
+		{
+			"json not found",
+			`{"method":"GET","path":"/tiles/11/125/899.pbf","status":404,"ts":"2020-06-01T10:00:00.5Z"}`,
+			logEntry{},
+			false,
+		},
+		{
+			"combined",
+			`10.0.0.1 - - [01/Jun/2020:10:00:00 +0000] "GET /tiles/3/1/2.pbf?key=abc HTTP/1.1" 200 2326 "-" "Mozilla/5.0"`,
+			logEntry{ts: time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC), tile: tilemath.Tile{Z: 3, X: 1, Y: 2}},
+			true,
+		},
+		{
+			"not a tile",
+			`10.0.0.1 - - [01/Jun/2020:10:00:00 +0000] "GET /static/index.html HTTP/1.1" 200 512`,
+			logEntry{},
+			false,
+		},
+		{"garbage", `hello`, logEntry{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseLogLine(tt.line)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want.tile, got.tile)
+			require.True(t, tt.want.ts.Equal(got.ts))
+		})
+	}
+}
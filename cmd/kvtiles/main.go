@@ -0,0 +1,14 @@
+// kvtiles is the command line tool to import, serve and maintain tiles DBs
+package main
+
+import (
+	"os"
+
+	"github.com/akhenakh/kvtiles/internal/cli"
+)
+
+var version = "no version from LDFLAGS"
+
+func main() {
+	cli.Main(version, os.Args[1:])
+}
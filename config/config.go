@@ -0,0 +1,256 @@
+// Package config sets the flags from a YAML or TOML config file, the flags given on the command line
+// or by the environment take precedence over the file
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/namsral/flag"
+	"gopkg.in/yaml.v3"
+)
+
+// File is the config flag of a flag set, its String is empty so namsral/flag doesn't parse the file itself
+type File struct {
+	path string
+
+	// fixed are the flags set by the command line or the environment
+	fixed map[string]bool
+	// loaded are the flags set by the file
+	loaded map[string]bool
+}
+
+// Flag defines the config flag of fs
+func Flag(fs *flag.FlagSet) *File {
+	f := &File{}
+	fs.Var(f, flag.DefaultConfigFlagname, "path of a YAML (.yaml, .yml) or TOML (.toml) config file, "+
+		"or of a file holding one flag per line, e.g. dbPath ./map.db")
+	return f
+}
+
+// String implements flag.Value
+func (f *File) String() string {
+	return ""
+}
+
+// Set implements flag.Value
+func (f *File) Set(path string) error {
+	f.path = path
+	return nil
+}
+
+// Path returns the path of the config file, empty when not set
+func (f *File) Path() string {
+	return f.path
+}
+
+// Load sets the flags of fs not set by the command line or the environment from the config file,
+// to call once fs is parsed, calling it again reloads the file
+func (f *File) Load(fs *flag.FlagSet) error {
+	f.setFixed(fs)
+	if f.path == "" {
+		return nil
+	}
+
+	values, err := f.read(fs)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if f.fixed[name] {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("config %s: invalid value %q for %s: %w", f.path, values[name], name, err)
+		}
+	}
+
+	// when reloading, the flags removed from the file are back to their default
+	for name := range f.loaded {
+		if _, ok := values[name]; !ok {
+			if fl := fs.Lookup(name); fl != nil {
+				_ = fl.Value.Set(fl.DefValue)
+			}
+		}
+	}
+	f.loaded = make(map[string]bool, len(values))
+	for name := range values {
+		if !f.fixed[name] {
+			f.loaded[name] = true
+		}
+	}
+	return nil
+}
+
+// Values returns the values of the flags of fs by name as Load would set them, without setting them,
+// the flags not set by the command line, the environment or the file have their default,
+// to reload the file while the flags are read
+func (f *File) Values(fs *flag.FlagSet) (map[string]string, error) {
+	f.setFixed(fs)
+	settings := make(map[string]string)
+	if f.path != "" {
+		var err error
+		if settings, err = f.read(fs); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make(map[string]string)
+	var err error
+	fs.VisitAll(func(fl *flag.Flag) {
+		v, ok := settings[fl.Name]
+		switch {
+		case f.fixed[fl.Name]:
+			values[fl.Name] = fl.Value.String()
+		case !ok:
+			values[fl.Name] = fl.DefValue
+		default:
+			// parse into a new value of the flag type, to validate and normalize it, e.g. 1m is 1m0s
+			t := reflect.TypeOf(fl.Value)
+			if t.Kind() != reflect.Ptr {
+				values[fl.Name] = v
+				return
+			}
+			nv := reflect.New(t.Elem()).Interface().(flag.Value)
+			if serr := nv.Set(v); serr != nil && err == nil {
+				err = fmt.Errorf("config %s: invalid value %q for %s: %w", f.path, v, fl.Name, serr)
+			}
+			values[fl.Name] = nv.String()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// setFixed records the flags set by the command line or the environment, on the first call
+func (f *File) setFixed(fs *flag.FlagSet) {
+	if f.fixed != nil {
+		return
+	}
+	f.fixed = make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) {
+		f.fixed[fl.Name] = true
+	})
+}
+
+// read returns the flag values of the config file by flag name
+func (f *File) read(fs *flag.FlagSet) (map[string]string, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string]interface{}
+	switch strings.ToLower(filepath.Ext(f.path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &settings); err != nil {
+			return nil, fmt.Errorf("invalid YAML config %s: %w", f.path, err)
+		}
+	case ".toml":
+		if settings, err = parseTOML(string(data)); err != nil {
+			return nil, fmt.Errorf("invalid TOML config %s: %w", f.path, err)
+		}
+	default:
+		settings = parseLines(string(data))
+	}
+
+	// the settings keys are case insensitive, e.g. http.apiPort is httpAPIPort
+	flags := make(map[string]string)
+	fs.VisitAll(func(fl *flag.Flag) {
+		flags[strings.ToLower(fl.Name)] = fl.Name
+	})
+	values, err := flatten(flags, "", settings)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", f.path, err)
+	}
+	return values, nil
+}
+
+// parseLines parses the namsral/flag config format, a flag per line as key value or key=value,
+// a flag without value is a boolean set to true
+func parseLines(data string) map[string]interface{} {
+	settings := make(map[string]interface{})
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.IndexAny(line, "= "); i > 0 {
+			settings[line[:i]] = line[i+1:]
+			continue
+		}
+		settings[line] = true
+	}
+	return settings
+}
+
+// flatten returns the flag values of the settings, a nested setting is the flag named by its parents and its key,
+// e.g. cache.size is cacheSize, or the flag named by its key alone when the sections only group flags, e.g. auth.keysFile,
+// lists are comma separated
+func flatten(flags map[string]string, prefix string, settings map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string)
+	for key, v := range settings {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		if m, ok := v.(map[string]interface{}); ok {
+			nested, err := flatten(flags, path, m)
+			if err != nil {
+				return nil, err
+			}
+			for name, value := range nested {
+				if _, ok := values[name]; ok {
+					return nil, fmt.Errorf("%s set twice", name)
+				}
+				values[name] = value
+			}
+			continue
+		}
+
+		name := flagName(flags, path)
+		if name == "" {
+			return nil, fmt.Errorf("unknown setting %s", path)
+		}
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("%s set twice", name)
+		}
+		values[name] = flagValue(v)
+	}
+	return values, nil
+}
+
+// flagName returns the flag of the dotted path, empty if none
+func flagName(flags map[string]string, path string) string {
+	keys := strings.Split(strings.ToLower(path), ".")
+	if name, ok := flags[strings.Join(keys, "")]; ok {
+		return name
+	}
+	return flags[keys[len(keys)-1]]
+}
+
+func flagValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		l := make([]string, len(v))
+		for i := range v {
+			l[i] = flagValue(v[i])
+		}
+		return strings.Join(l, ",")
+	}
+	return fmt.Sprint(v)
+}
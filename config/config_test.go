@@ -0,0 +1,121 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/namsral/flag"
+	"github.com/stretchr/testify/require"
+)
+
+func testFlags() (*flag.FlagSet, *File, map[string]*string) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := Flag(fs)
+	values := make(map[string]*string)
+	for _, name := range []string{"dbPath", "cacheSize", "keysFile", "overlayDBPaths", "allowOrigin", "logLevel"} {
+		values[name] = fs.String(name, "", "")
+	}
+	return fs, f, values
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "kvtiles.yaml")
+	require.NoError(t, ioutil.WriteFile(yamlPath, []byte(`
+dbPath: ./map.db
+logLevel: DEBUG
+cache:
+  size: 100
+auth:
+  keysFile: keys.json
+overlayDBPaths: [a.db, b.db]
+allow:
+  origin: "*"
+`), 0o600))
+
+	os.Setenv("LOGLEVEL", "WARN")
+	defer os.Unsetenv("LOGLEVEL")
+
+	fs, f, values := testFlags()
+	require.NoError(t, fs.Parse([]string{"-config", yamlPath, "-dbPath", "other.db"}))
+	require.Equal(t, yamlPath, f.Path())
+	require.NoError(t, f.Load(fs))
+
+	// flags > env > file
+	require.Equal(t, "other.db", *values["dbPath"])
+	require.Equal(t, "WARN", *values["logLevel"])
+	require.Equal(t, "100", *values["cacheSize"])
+	require.Equal(t, "keys.json", *values["keysFile"])
+	require.Equal(t, "a.db,b.db", *values["overlayDBPaths"])
+	require.Equal(t, "*", *values["allowOrigin"])
+
+	tomlPath := filepath.Join(dir, "kvtiles.toml")
+	require.NoError(t, ioutil.WriteFile(tomlPath, []byte(`
+dbPath = "./map.db" # the tiles
+overlayDBPaths = ["a.db", 'b,c.db']
+
+[cache]
+size = 1_000
+
+[auth]
+keysFile = "keys#1.json"
+`), 0o600))
+	fs, f, values = testFlags()
+	require.NoError(t, fs.Parse([]string{"-config", tomlPath}))
+	require.NoError(t, f.Load(fs))
+	require.Equal(t, "./map.db", *values["dbPath"])
+	require.Equal(t, "1000", *values["cacheSize"])
+	require.Equal(t, "keys#1.json", *values["keysFile"])
+	require.Equal(t, "a.db,b,c.db", *values["overlayDBPaths"])
+
+	// the flag per line format
+	linePath := filepath.Join(dir, "kvtiles.conf")
+	require.NoError(t, ioutil.WriteFile(linePath, []byte("dbPath ./map.db\n"), 0o600))
+	fs, f, values = testFlags()
+	require.NoError(t, fs.Parse([]string{"-config", linePath}))
+	require.NoError(t, f.Load(fs))
+	require.Equal(t, "./map.db", *values["dbPath"])
+
+	// reloading keeps the flags precedence, the flags removed from the file are back to their default
+	require.NoError(t, ioutil.WriteFile(linePath, []byte("cacheSize=10\n"), 0o600))
+	require.NoError(t, f.Load(fs))
+	require.Equal(t, "", *values["dbPath"])
+	require.Equal(t, "10", *values["cacheSize"])
+
+	require.NoError(t, ioutil.WriteFile(yamlPath, []byte("cache:\n  ttl: 10s\n"), 0o600))
+	fs, f, _ = testFlags()
+	require.NoError(t, fs.Parse([]string{"-config", yamlPath}))
+	require.EqualError(t, f.Load(fs), "config "+yamlPath+": unknown setting cache.ttl")
+}
+
+func TestValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvtiles.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("dbPath: ./map.db\ncacheSize: 100\nttl: 1m\n"), 0o600))
+
+	fs, f, values := testFlags()
+	ttl := fs.Duration("ttl", time.Hour, "")
+	require.NoError(t, fs.Parse([]string{"-config", path, "-logLevel", "WARN"}))
+	require.NoError(t, f.Load(fs))
+
+	// the file is changed, the flags are left untouched
+	require.NoError(t, ioutil.WriteFile(path, []byte("cacheSize: 10\nttl: 90s\nlogLevel: DEBUG\n"), 0o600))
+	got, err := f.Values(fs)
+	require.NoError(t, err)
+	require.Equal(t, "100", *values["cacheSize"])
+	require.Equal(t, "./map.db", *values["dbPath"])
+	require.Equal(t, time.Minute, *ttl)
+
+	require.Equal(t, "10", got["cacheSize"])
+	require.Equal(t, "1m30s", got["ttl"])
+	// removed from the file, back to the default
+	require.Equal(t, "", got["dbPath"])
+	// the command line takes precedence
+	require.Equal(t, "WARN", got["logLevel"])
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("ttl: soon\n"), 0o600))
+	_, err = f.Values(fs)
+	require.Error(t, err)
+}
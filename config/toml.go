@@ -0,0 +1,165 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the TOML subset needed by the flags: tables, dotted keys, strings, numbers, booleans
+// and arrays on a single line
+func parseTOML(data string) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	table := root
+
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table %s", i+1, line)
+			}
+			t, err := subTable(root, strings.Split(strings.Trim(line, "[] "), "."))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			table = t
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 1 {
+			return nil, fmt.Errorf("line %d: key = value expected", i+1)
+		}
+		keys := strings.Split(strings.TrimSpace(line[:eq]), ".")
+		t, err := subTable(table, keys[:len(keys)-1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		v, err := tomlValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		key := strings.TrimSpace(keys[len(keys)-1])
+		if _, ok := t[key]; ok {
+			return nil, fmt.Errorf("line %d: %s defined twice", i+1, key)
+		}
+		t[key] = v
+	}
+	return root, nil
+}
+
+// subTable returns the table at the keys under t, creating the missing ones
+func subTable(t map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, k := range keys {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			return nil, errors.New("empty key")
+		}
+		switch v := t[k].(type) {
+		case nil:
+			sub := make(map[string]interface{})
+			t[k] = sub
+			t = sub
+		case map[string]interface{}:
+			t = v
+		default:
+			return nil, fmt.Errorf("%s is not a table", k)
+		}
+	}
+	return t, nil
+}
+
+func tomlValue(s string) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, errors.New("missing value")
+	case s == "true" || s == "false":
+		return s == "true", nil
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("invalid array %s", s)
+		}
+		var l []interface{}
+		for _, e := range splitArray(s[1 : len(s)-1]) {
+			if e = strings.TrimSpace(e); e == "" {
+				continue
+			}
+			v, err := tomlValue(e)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	}
+
+	s = strings.ReplaceAll(s, "_", "")
+	if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %s", s)
+}
+
+// stripComment removes the comment ending the line, outside of the strings
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitArray splits the array elements on the commas outside of the strings
+func splitArray(s string) []string {
+	var elems []string
+	var quote rune
+	escaped := false
+	start := 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			elems = append(elems, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(elems, s[start:])
+}
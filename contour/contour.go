@@ -0,0 +1,156 @@
+// Package contour generates contour lines vector tiles from terrain-RGB tiles
+package contour
+
+import (
+	"math"
+
+	"github.com/akhenakh/kvtiles/terrain"
+)
+
+// Line is a contour line at Elevation, its points are in pixels from the north west corner of the grid
+type Line struct {
+	Elevation float64
+	Points    [][2]float64
+}
+
+// Lines returns the contour lines of the grid at every multiple of interval, using marching squares
+// on the centers of the pixels
+func Lines(g *terrain.Grid, interval float64) []Line {
+	if interval <= 0 || g.Width < 2 || g.Height < 2 {
+		return nil
+	}
+
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range g.Values {
+		min, max = math.Min(min, v), math.Max(max, v)
+	}
+
+	var lines []Line
+	for level := math.Ceil(min/interval) * interval; level <= max; level += interval {
+		lines = append(lines, levelLines(g, level)...)
+	}
+	return lines
+}
+
+// segment joins two cell edges, identified by edgeID
+type segment struct {
+	a, b int
+}
+
+// levelLines returns the contour lines at level
+func levelLines(g *terrain.Grid, level float64) []Line {
+	value := func(x, y int) float64 { return g.Values[y*g.Width+x] }
+	// horizontal edges from x, y to x+1, y are even, vertical ones from x, y to x, y+1 are odd
+	hEdge := func(x, y int) int { return (y*g.Width + x) * 2 }
+	vEdge := func(x, y int) int { return (y*g.Width+x)*2 + 1 }
+
+	var segments []segment
+	for y := 0; y+1 < g.Height; y++ {
+		for x := 0; x+1 < g.Width; x++ {
+			tl, tr, br, bl := value(x, y), value(x+1, y), value(x+1, y+1), value(x, y+1)
+			var c int
+			if tl >= level {
+				c |= 8
+			}
+			if tr >= level {
+				c |= 4
+			}
+			if br >= level {
+				c |= 2
+			}
+			if bl >= level {
+				c |= 1
+			}
+
+			top, right, bottom, left := hEdge(x, y), vEdge(x+1, y), hEdge(x, y+1), vEdge(x, y)
+			center := (tl+tr+br+bl)/4 >= level
+			switch c {
+			case 1, 14:
+				segments = append(segments, segment{left, bottom})
+			case 2, 13:
+				segments = append(segments, segment{bottom, right})
+			case 3, 12:
+				segments = append(segments, segment{left, right})
+			case 4, 11:
+				segments = append(segments, segment{top, right})
+			case 6, 9:
+				segments = append(segments, segment{top, bottom})
+			case 7, 8:
+				segments = append(segments, segment{left, top})
+			case 5:
+				// saddles are resolved by the center value
+				if center {
+					segments = append(segments, segment{left, top}, segment{bottom, right})
+				} else {
+					segments = append(segments, segment{top, right}, segment{left, bottom})
+				}
+			case 10:
+				if center {
+					segments = append(segments, segment{top, right}, segment{left, bottom})
+				} else {
+					segments = append(segments, segment{left, top}, segment{bottom, right})
+				}
+			}
+		}
+	}
+
+	// the point where the edge crosses level
+	point := func(id int) [2]float64 {
+		cell := id / 2
+		x, y := cell%g.Width, cell/g.Width
+		v0 := value(x, y)
+		if id%2 == 0 {
+			t := (level - v0) / (value(x+1, y) - v0)
+			return [2]float64{float64(x) + 0.5 + t, float64(y) + 0.5}
+		}
+		t := (level - v0) / (value(x, y+1) - v0)
+		return [2]float64{float64(x) + 0.5, float64(y) + 0.5 + t}
+	}
+
+	// an edge is shared by the segments of at most two cells
+	byEdge := make(map[int][]int, 2*len(segments))
+	for i, s := range segments {
+		byEdge[s.a] = append(byEdge[s.a], i)
+		byEdge[s.b] = append(byEdge[s.b], i)
+	}
+	used := make([]bool, len(segments))
+	next := func(edge int) (int, bool) {
+		for _, i := range byEdge[edge] {
+			if !used[i] {
+				used[i] = true
+				if segments[i].a == edge {
+					return segments[i].b, true
+				}
+				return segments[i].a, true
+			}
+		}
+		return 0, false
+	}
+
+	var lines []Line
+	for i, s := range segments {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		chain := []int{s.a, s.b}
+		for e, ok := next(chain[len(chain)-1]); ok; e, ok = next(e) {
+			chain = append(chain, e)
+		}
+		var head []int
+		for e, ok := next(chain[0]); ok; e, ok = next(e) {
+			head = append(head, e)
+		}
+		for l, r := 0, len(head)-1; l < r; l, r = l+1, r-1 {
+			head[l], head[r] = head[r], head[l]
+		}
+		chain = append(head, chain...)
+
+		points := make([][2]float64, len(chain))
+		for j, e := range chain {
+			points[j] = point(e)
+		}
+		lines = append(lines, Line{Elevation: level, Points: points})
+	}
+	return lines
+}
@@ -0,0 +1,104 @@
+package contour
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/terrain"
+)
+
+func TestLines(t *testing.T) {
+	// a slope rising 10 m per pixel to the east
+	slope := &terrain.Grid{Width: 4, Height: 4}
+	for y := 0; y < 4; y++ {
+		slope.Values = append(slope.Values, 0, 10, 20, 30)
+	}
+	lines := Lines(slope, 15)
+	require.Len(t, lines, 2)
+	require.Equal(t, 15.0, lines[0].Elevation)
+	require.Len(t, lines[0].Points, 4)
+	for _, p := range lines[0].Points {
+		require.InDelta(t, 2, p[0], 1e-9)
+	}
+
+	// a peak is surrounded by a closed line
+	peak := &terrain.Grid{Width: 3, Height: 3, Values: []float64{0, 0, 0, 0, 100, 0, 0, 0, 0}}
+	lines = Lines(peak, 50)
+	require.Len(t, lines, 2)
+	require.Len(t, lines[0].Points, 5)
+	require.Equal(t, lines[0].Points[0], lines[0].Points[4])
+
+	require.Empty(t, Lines(peak, 0))
+}
+
+// terrainStore serves the same terrain-RGB tile for every coordinates
+type terrainStore []byte
+
+func (s terrainStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	return s, nil
+}
+
+func (terrainStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 12, Format: "png", Encoding: terrain.EncodingTerrarium}, true, nil
+}
+
+func (terrainStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	// terrarium, 0 m on the west half, 100 m on the east half
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			c := color.RGBA{128, 0, 0, 255}
+			if x >= 4 {
+				c.G = 100
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	s, err := NewStore(terrainStore(buf.Bytes()), 100)
+	require.NoError(t, err)
+
+	infos, ok, err := s.LoadMapInfos()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Empty(t, infos.Format)
+	require.Empty(t, infos.Encoding)
+
+	data, err := s.ReadTileData(context.Background(), 12, 0, 0)
+	require.NoError(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	tile, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	layers, err := mvt.Decode(tile)
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	require.Equal(t, LayerName, layers[0].Name)
+
+	// no line at 0 m, the min elevation, the 100 m line joins the centers of the first pixels of the east half
+	require.Len(t, layers[0].Features, 1)
+	require.EqualValues(t, 100, layers[0].Features[0].Properties["ele"])
+	lines, err := mvt.DecodeGeometry(layers[0].Features[0])
+	require.NoError(t, err)
+	require.Equal(t, [2]int64{2304, 256}, lines[0][0])
+
+	_, err = NewStore(terrainStore(nil), 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package contour
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/terrain"
+)
+
+const (
+	// LayerName is the layer of the contour lines in the generated tiles
+	LayerName = "contour"
+
+	extent = 4096
+)
+
+// Store serves vector tiles of the contour lines generated from the terrain-RGB tiles of a store,
+// with a contour layer whose features have an ele attribute, the elevation in meters
+type Store struct {
+	terrain  storage.TileStore
+	interval float64
+}
+
+// NewStore returns a Store generating the contour lines of the tiles of terrain every interval meters
+func NewStore(terrain storage.TileStore, interval float64) (*Store, error) {
+	if interval <= 0 {
+		return nil, errors.New("the contour interval must be positive")
+	}
+	return &Store{terrain: terrain, interval: interval}, nil
+}
+
+// ReadTileData returns the gzipped contour lines vector tile, nil if the terrain tile is not found
+func (s *Store) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	// read for each tile, the terrain store may be swapped
+	infos, ok, err := s.terrain.LoadMapInfos()
+	if err != nil {
+		return nil, err
+	}
+	if !ok || infos.Encoding == "" {
+		return nil, errors.New("no terrain tiles to generate contour lines")
+	}
+
+	data, err := s.terrain.ReadTileData(ctx, z, x, y)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	grid, err := terrain.Decode(infos.Encoding, data)
+	if err != nil {
+		return nil, fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+	}
+
+	tile, err := mvt.Encode([]mvt.Layer{s.layer(grid)})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(tile); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// layer returns the contour layer of the grid
+func (s *Store) layer(grid *terrain.Grid) mvt.Layer {
+	l := mvt.Layer{Name: LayerName, Extent: extent, Features: []mvt.Feature{}}
+	sx, sy := float64(extent)/float64(grid.Width), float64(extent)/float64(grid.Height)
+	for _, line := range Lines(grid, s.interval) {
+		var points [][2]int64
+		for _, p := range line.Points {
+			q := [2]int64{int64(math.Round(p[0] * sx)), int64(math.Round(p[1] * sy))}
+			if len(points) == 0 || points[len(points)-1] != q {
+				points = append(points, q)
+			}
+		}
+		if len(points) < 2 {
+			continue
+		}
+
+		var ele interface{} = line.Elevation
+		if line.Elevation == math.Trunc(line.Elevation) {
+			ele = int64(line.Elevation)
+		}
+		l.Features = append(l.Features, mvt.Feature{
+			Type:       mvt.LineString,
+			Geometry:   mvt.EncodeGeometry(mvt.LineString, [][][2]int64{points}),
+			Properties: map[string]interface{}{"ele": ele},
+		})
+	}
+	return l
+}
+
+// LoadMapInfos returns the map infos of the terrain store, describing vector tiles
+func (s *Store) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	infos, ok, err := s.terrain.LoadMapInfos()
+	if err != nil || !ok {
+		return infos, ok, err
+	}
+	vector := *infos
+	vector.Format, vector.Encoding = "", ""
+	return &vector, true, nil
+}
+
+// StoreMap is not supported, the contour lines are generated
+func (s *Store) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return errors.New("can't store a map in the contour lines store")
+}
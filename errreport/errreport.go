@@ -0,0 +1,128 @@
+// Package errreport sends panics and server errors to an external error tracker
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Event is an error occurring while serving a request
+type Event struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	Status    int       `json:"status,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Stack     string    `json:"stack,omitempty"`
+	App       string    `json:"app,omitempty"`
+	Version   string    `json:"version,omitempty"`
+}
+
+// Sender delivers an event to an error tracker
+type Sender interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// Reporter sends events asynchronously, events are dropped when the queue is full
+type Reporter struct {
+	sender  Sender
+	logger  log.Logger
+	app     string
+	version string
+	events  chan Event
+}
+
+// NewReporter returns a Reporter delivering events through sender
+func NewReporter(sender Sender, app, version string, logger log.Logger) *Reporter {
+	return &Reporter{
+		sender:  sender,
+		logger:  log.With(logger, "component", "errreport"),
+		app:     app,
+		version: version,
+		events:  make(chan Event, 100),
+	}
+}
+
+// Report queues the event
+func (r *Reporter) Report(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.App = r.app
+	e.Version = r.version
+
+	select {
+	case r.events <- e:
+	default:
+		level.Warn(r.logger).Log("msg", "error reporting queue full, dropping event")
+	}
+}
+
+// Run delivers the events until ctx is done
+func (r *Reporter) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-r.events:
+			sctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			if err := r.sender.Send(sctx, e); err != nil {
+				level.Warn(r.logger).Log("msg", "can't report error", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Webhook posts events as JSON to a URL
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook returns a Sender posting JSON events to url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Send posts the event
+func (w *Webhook) Send(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return post(ctx, w.client, w.url, b, nil)
+}
+
+func post(ctx context.Context, client *http.Client, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error tracker returned status %d", resp.StatusCode)
+	}
+	return nil
+}
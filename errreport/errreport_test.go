@@ -0,0 +1,151 @@
+package errreport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// chanSender sends the events to a channel
+type chanSender chan Event
+
+func (c chanSender) Send(ctx context.Context, e Event) error {
+	c <- e
+	return nil
+}
+
+func TestReporter(t *testing.T) {
+	sent := make(chanSender, 1)
+	r := NewReporter(sent, "kvtilesd", "1.2.3", log.NewNopLogger())
+
+	at := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.Report(Event{Time: at, Level: "error", Message: "GET /tiles/1/1/1.pbf returned 500", Status: 500})
+	r.Report(Event{Level: "fatal", Message: "panic: boom"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+
+	e := <-sent
+	require.Equal(t, at, e.Time)
+	require.Equal(t, "error", e.Level)
+	require.Equal(t, 500, e.Status)
+	require.Equal(t, "kvtilesd", e.App)
+	require.Equal(t, "1.2.3", e.Version)
+
+	e = <-sent
+	require.Equal(t, "panic: boom", e.Message)
+	require.False(t, e.Time.IsZero())
+	require.Equal(t, "kvtilesd", e.App)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestReporter_QueueFull(t *testing.T) {
+	r := NewReporter(make(chanSender), "kvtilesd", "", log.NewNopLogger())
+	for i := 0; i < cap(r.events)+10; i++ {
+		r.Report(Event{Message: "error"})
+	}
+	require.Len(t, r.events, cap(r.events))
+}
+
+func TestWebhook_Send(t *testing.T) {
+	events := make(chan Event, 1)
+	status := http.StatusNoContent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		require.Equal(t, http.MethodPost, req.Method)
+		require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		var e Event
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&e))
+		events <- e
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	wh := NewWebhook(ts.URL)
+	require.NoError(t, wh.Send(context.Background(), Event{
+		Level:     "error",
+		Message:   "storage degraded",
+		RequestID: "abc",
+		App:       "kvtilesd",
+	}))
+	e := <-events
+	require.Equal(t, "storage degraded", e.Message)
+	require.Equal(t, "abc", e.RequestID)
+	require.Equal(t, "kvtilesd", e.App)
+
+	status = http.StatusInternalServerError
+	require.EqualError(t, wh.Send(context.Background(), Event{}), "error tracker returned status 500")
+	<-events
+}
+
+func TestNewSentry(t *testing.T) {
+	for _, dsn := range []string{
+		"https://o1.ingest.sentry.io/42",
+		"https://key@o1.ingest.sentry.io/",
+		"://key@o1",
+	} {
+		_, err := NewSentry(dsn)
+		require.Error(t, err, dsn)
+	}
+
+	s, err := NewSentry("https://key@o1.ingest.sentry.io/42")
+	require.NoError(t, err)
+	require.Equal(t, "https://o1.ingest.sentry.io/api/42/store/", s.storeURL)
+	require.Equal(t, "key", s.publicKey)
+}
+
+func TestSentry_Send(t *testing.T) {
+	bodies := make(chan map[string]interface{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "/api/42/store/", req.URL.Path)
+		auth := req.Header.Get("X-Sentry-Auth")
+		require.True(t, strings.HasPrefix(auth, "Sentry sentry_version=7,"), auth)
+		require.Contains(t, auth, "sentry_key=key")
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	s, err := NewSentry(strings.Replace(ts.URL, "://", "://key@", 1) + "/42")
+	require.NoError(t, err)
+
+	require.NoError(t, s.Send(context.Background(), Event{
+		Time:      time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+		Level:     "fatal",
+		Message:   "panic: boom",
+		Status:    500,
+		Method:    "GET",
+		URL:       "/tiles/1/1/1.pbf",
+		RequestID: "abc",
+		ClientIP:  "192.0.2.1",
+		Stack:     "goroutine 1",
+		App:       "kvtilesd",
+		Version:   "1.2.3",
+	}))
+
+	body := <-bodies
+	require.Len(t, body["event_id"], 32)
+	require.Equal(t, "2021-01-02T03:04:05", body["timestamp"])
+	require.Equal(t, "fatal", body["level"])
+	require.Equal(t, "panic: boom", body["message"])
+	require.Equal(t, "1.2.3", body["release"])
+	require.Equal(t, "kvtilesd", body["server_name"])
+	require.Equal(t, map[string]interface{}{"request_id": "abc"}, body["tags"])
+	require.Equal(t, map[string]interface{}{"ip_address": "192.0.2.1"}, body["user"])
+	require.Equal(t, map[string]interface{}{"stack": "goroutine 1", "status": float64(500)}, body["extra"])
+	request := body["request"].(map[string]interface{})
+	require.Equal(t, "GET", request["method"])
+	require.Equal(t, "/tiles/1/1/1.pbf", request["url"])
+}
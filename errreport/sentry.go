@@ -0,0 +1,83 @@
+package errreport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Sentry sends events to the Sentry store API
+type Sentry struct {
+	storeURL  string
+	publicKey string
+	client    *http.Client
+}
+
+// NewSentry returns a Sender for the Sentry DSN, e.g. https://key@o1.ingest.sentry.io/42
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: missing public key")
+	}
+
+	project := strings.TrimPrefix(u.Path, "/")
+	if project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: missing project ID")
+	}
+
+	return &Sentry{
+		storeURL:  fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		publicKey: u.User.Username(),
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Send posts the event to Sentry
+func (s *Sentry) Send(ctx context.Context, e Event) error {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	extra := map[string]interface{}{}
+	if e.Stack != "" {
+		extra["stack"] = e.Stack
+	}
+	if e.Status != 0 {
+		extra["status"] = e.Status
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   e.Time.UTC().Format("2006-01-02T15:04:05"),
+		"level":       e.Level,
+		"logger":      e.App,
+		"platform":    "go",
+		"release":     e.Version,
+		"message":     e.Message,
+		"server_name": e.App,
+		"request": map[string]interface{}{
+			"method":  e.Method,
+			"url":     e.URL,
+			"headers": map[string]string{"User-Agent": e.UserAgent},
+		},
+		"user":  map[string]string{"ip_address": e.ClientIP},
+		"tags":  map[string]string{"request_id": e.RequestID},
+		"extra": extra,
+	})
+	if err != nil {
+		return err
+	}
+
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=kvtiles/1.0, sentry_timestamp=%d, sentry_key=%s",
+		time.Now().Unix(), s.publicKey)
+
+	return post(ctx, s.client, s.storeURL, b, map[string]string{"X-Sentry-Auth": auth})
+}
@@ -0,0 +1,147 @@
+// Package events publishes the server events to a message broker, NATS or Kafka,
+// for the analytics and cache invalidation systems
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Events types
+const (
+	TileServed       = "tile_served"
+	TileMissing      = "tile_missing"
+	DBSwapped        = "db_swapped"
+	ImportCompleted  = "import_completed"
+	StorageDegraded  = "storage_degraded"
+	StorageRecovered = "storage_recovered"
+)
+
+var eventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kvtiles",
+	Name:      "events_total",
+	Help:      "Events sent to the broker, by type and result: published, failed or dropped.",
+}, []string{"type", "result"})
+
+// Event is a server event, published as JSON
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Node is the host name of the emitter
+	Node string `json:"node,omitempty"`
+	// Tile is the z/x/y of the tiles events
+	Tile string `json:"tile,omitempty"`
+	// Version is the dataset version of the DB events
+	Version string            `json:"version,omitempty"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+// Publisher sends events to a broker
+type Publisher interface {
+	Publish(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// NewPublisher returns a Publisher to the NATS server at natsURL, e.g. nats://localhost:4222,
+// or to the Kafka REST proxy at kafkaURL, e.g. http://localhost:8082, publishing to topic,
+// nil if none is set
+func NewPublisher(natsURL, kafkaURL, topic string) (Publisher, error) {
+	switch {
+	case natsURL != "" && kafkaURL != "":
+		return nil, fmt.Errorf("only one of NATS or Kafka can be used")
+	case natsURL != "":
+		return NewNATS(natsURL, topic)
+	case kafkaURL != "":
+		return NewKafkaREST(kafkaURL, topic)
+	}
+	return nil, nil
+}
+
+// Bus publishes the events emitted in batches in the background, events are dropped when the
+// broker can't keep up, emitting never blocks
+type Bus struct {
+	pub      Publisher
+	node     string
+	events   chan Event
+	interval time.Duration
+	logger   log.Logger
+}
+
+// NewBus returns a Bus buffering up to size events, published every interval
+func NewBus(pub Publisher, node string, size int, interval time.Duration, logger log.Logger) *Bus {
+	return &Bus{
+		pub:      pub,
+		node:     node,
+		events:   make(chan Event, size),
+		interval: interval,
+		logger:   log.With(logger, "component", "events"),
+	}
+}
+
+// Emit queues the event e, setting its time and node
+func (b *Bus) Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Node = b.node
+
+	select {
+	case b.events <- e:
+	default:
+		eventsTotal.WithLabelValues(e.Type, "dropped").Inc()
+	}
+}
+
+// Run publishes the events until ctx is done, then publishes the remaining events and closes the publisher
+func (b *Bus) Run(ctx context.Context) error {
+	defer b.pub.Close()
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	var batch []Event
+	for {
+		select {
+		case e := <-b.events:
+			batch = append(batch, e)
+			if len(batch) < cap(b.events) {
+				continue
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			for len(b.events) > 0 {
+				batch = append(batch, <-b.events)
+			}
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			b.publish(flushCtx, batch)
+			cancel()
+			return nil
+		}
+
+		if len(batch) > 0 {
+			b.publish(ctx, batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (b *Bus) publish(ctx context.Context, batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+
+	result := "published"
+	if err := b.pub.Publish(ctx, batch); err != nil {
+		level.Warn(b.logger).Log("msg", "can't publish events", "error", err, "count", len(batch))
+		result = "failed"
+	}
+	for _, e := range batch {
+		eventsTotal.WithLabelValues(e.Type, result).Inc()
+	}
+}
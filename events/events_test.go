@@ -0,0 +1,136 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNATS accepts a connection and sends the payloads published to msgs
+func fakeNATS(t *testing.T, msgs chan<- string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte(`INFO {"server_id":"test","max_payload":1048576}` + "\r\n"))
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					switch fields[0] {
+					case "CONNECT":
+						if !strings.Contains(line, `"auth_token":"secret"`) {
+							conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+							return
+						}
+					case "PUB":
+						n, _ := strconv.Atoi(fields[2])
+						payload := make([]byte, n+2)
+						if _, err := io.ReadFull(r, payload); err != nil {
+							return
+						}
+						msgs <- fields[1] + " " + string(payload[:n])
+					case "PING":
+						conn.Write([]byte("PONG\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestNATS(t *testing.T) {
+	msgs := make(chan string, 10)
+	addr := fakeNATS(t, msgs)
+	ctx := context.Background()
+
+	pub, err := NewNATS("nats://secret@"+addr, "kvtiles.events")
+	require.NoError(t, err)
+	defer pub.Close()
+
+	err = pub.Publish(ctx, []Event{{Type: TileMissing, Tile: "3/1/2"}, {Type: DBSwapped, Version: "v2"}})
+	require.NoError(t, err)
+
+	var e Event
+	msg := <-msgs
+	require.True(t, strings.HasPrefix(msg, "kvtiles.events "))
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(msg, "kvtiles.events ")), &e))
+	require.Equal(t, "3/1/2", e.Tile)
+	require.Contains(t, <-msgs, `"version":"v2"`)
+
+	// reconnects once the connection is closed
+	pub.nc.Close()
+	require.NoError(t, pub.Publish(ctx, []Event{{Type: DBSwapped}}))
+	require.Contains(t, <-msgs, DBSwapped)
+
+	bad, err := NewNATS("nats://"+addr, "kvtiles.events")
+	require.NoError(t, err)
+	require.Error(t, bad.Publish(ctx, []Event{{Type: DBSwapped}}))
+
+	_, err = NewNATS("http://"+addr, "kvtiles.events")
+	require.Error(t, err)
+}
+
+func TestBus_kafka(t *testing.T) {
+	bodies := make(chan map[string][]kafkaRecord, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/topics/tiles", r.URL.Path)
+		require.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+		var body map[string][]kafkaRecord
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	pub, err := NewPublisher("", ts.URL, "tiles")
+	require.NoError(t, err)
+
+	bus := NewBus(pub, "node-1", 100, time.Hour, log.NewNopLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bus.Run(ctx)
+		close(done)
+	}()
+
+	bus.Emit(Event{Type: TileServed, Tile: "1/0/0"})
+	bus.Emit(Event{Type: ImportCompleted})
+	// remaining events are published when stopping
+	cancel()
+	<-done
+
+	body := <-bodies
+	require.Len(t, body["records"], 2)
+	require.Equal(t, "node-1", body["records"][0].Key)
+	require.Equal(t, "1/0/0", body["records"][0].Value.Tile)
+	require.False(t, body["records"][1].Value.Time.IsZero())
+
+	_, err = NewPublisher("nats://localhost", ts.URL, "tiles")
+	require.Error(t, err)
+	pub, err = NewPublisher("", "", "tiles")
+	require.NoError(t, err)
+	require.Nil(t, pub)
+}
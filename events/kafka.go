@@ -0,0 +1,77 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KafkaREST publishes the events as JSON records to a topic through a Kafka REST proxy
+type KafkaREST struct {
+	url    string
+	client *http.Client
+}
+
+// NewKafkaREST returns a publisher to the Kafka REST proxy at baseURL, e.g. http://localhost:8082
+func NewKafkaREST(baseURL, topic string) (*KafkaREST, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid Kafka REST proxy URL %q", baseURL)
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("a Kafka topic is required")
+	}
+
+	return &KafkaREST{
+		url:    strings.TrimSuffix(baseURL, "/") + "/topics/" + url.PathEscape(topic),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key,omitempty"`
+	Value Event  `json:"value"`
+}
+
+// Publish sends the events in a single request, keyed by node to keep the events of a node in order
+func (k *KafkaREST) Publish(ctx context.Context, events []Event) error {
+	records := make([]kafkaRecord, len(events))
+	for i, e := range events {
+		records[i] = kafkaRecord{Key: e.Node, Value: e}
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Kafka REST proxy status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Close does nothing, requests are stateless
+func (k *KafkaREST) Close() error {
+	return nil
+}
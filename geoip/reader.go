@@ -0,0 +1,83 @@
+// Package geoip resolves IPs to their country and region from a MaxMind DB, e.g. GeoLite2-Country or GeoLite2-City
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location is the country and region of an IP, as ISO codes, e.g. FR and IDF, empty when unknown
+type Location struct {
+	Country string
+	Region  string
+}
+
+// Reader looks up IPs in a MaxMind DB loaded in memory, safe for concurrent use
+type Reader struct {
+	db *maxminddb.Reader
+}
+
+// record holds the fields of the Country and City DBs records used by Lookup
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+}
+
+// Open loads the MaxMind DB at path
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read MaxMind DB: %w", err)
+	}
+	return New(buf)
+}
+
+// New returns a Reader of the MaxMind DB buf
+func New(buf []byte) (*Reader, error) {
+	db, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB: %w", err)
+	}
+	return &Reader{db: db}, nil
+}
+
+// Type returns the database type of the DB, e.g. GeoLite2-City
+func (r *Reader) Type() string {
+	return r.db.Metadata.DatabaseType
+}
+
+// Lookup returns the location of ip, false when ip is not in the DB
+func (r *Reader) Lookup(ip net.IP) (Location, bool, error) {
+	var loc Location
+	if ip == nil || (ip.To4() == nil && r.db.Metadata.IPVersion == 4) {
+		return loc, false, nil
+	}
+
+	var rec record
+	_, ok, err := r.db.LookupNetwork(ip, &rec)
+	if err != nil {
+		return loc, false, fmt.Errorf("can't decode MaxMind DB record: %w", err)
+	}
+	if !ok {
+		return loc, false, nil
+	}
+
+	loc.Country = rec.Country.ISOCode
+	if loc.Country == "" {
+		loc.Country = rec.RegisteredCountry.ISOCode
+	}
+	if len(rec.Subdivisions) > 0 {
+		loc.Region = rec.Subdivisions[0].ISOCode
+	}
+	return loc, true, nil
+}
@@ -0,0 +1,168 @@
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// metadataMarker precedes the metadata map at the end of the file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSeparator is the size of the zeroes between the search tree and the data section
+const dataSeparator = 16
+
+// data types of the data section used by encode
+const (
+	typeString = 2
+	typeUint32 = 6
+	typeMap    = 7
+	typeArray  = 11
+)
+
+// encode appends the MaxMind DB encoding of v, sizes under 29 only
+func encode(b []byte, v interface{}) []byte {
+	ctrl := func(typ int, size int) {
+		if typ > 7 {
+			b = append(b, byte(size), byte(typ-7))
+			return
+		}
+		b = append(b, byte(typ<<5|size))
+	}
+	switch v := v.(type) {
+	case string:
+		ctrl(typeString, len(v))
+		b = append(b, v...)
+	case int:
+		ctrl(typeUint32, 4)
+		b = append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	case []interface{}:
+		ctrl(typeArray, len(v))
+		for _, e := range v {
+			b = encode(b, e)
+		}
+	case map[string]interface{}:
+		ctrl(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b = encode(b, k)
+			b = encode(b, v[k])
+		}
+	}
+	return b
+}
+
+// buildDB returns an IPv6 MaxMind DB with 24 bits records mapping the networks to their records
+func buildDB(t *testing.T, networks map[string]map[string]interface{}) []byte {
+	type node struct {
+		children [2]int
+		data     [2]int
+	}
+	nodes := []*node{{children: [2]int{-1, -1}, data: [2]int{-1, -1}}}
+	var data []byte
+
+	for cidr, rec := range networks {
+		_, n, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		ones, _ := n.Mask.Size()
+		ip := n.IP.To16()
+		if ip4 := n.IP.To4(); ip4 != nil {
+			// the IPv4 networks are under ::/96, not the IPv4 mapped ::ffff:0:0/96
+			ip = append(make(net.IP, 12), ip4...)
+			ones += 96
+		}
+
+		offset := len(data)
+		data = encode(data, rec)
+
+		cur := 0
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - uint(i%8)) & 1
+			if i == ones-1 {
+				nodes[cur].data[bit] = offset
+				break
+			}
+			if nodes[cur].children[bit] < 0 {
+				nodes = append(nodes, &node{children: [2]int{-1, -1}, data: [2]int{-1, -1}})
+				nodes[cur].children[bit] = len(nodes) - 1
+			}
+			cur = nodes[cur].children[bit]
+		}
+	}
+
+	var buf []byte
+	count := len(nodes)
+	for _, n := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			r := count
+			switch {
+			case n.children[bit] >= 0:
+				r = n.children[bit]
+			case n.data[bit] >= 0:
+				r = count + dataSeparator + n.data[bit]
+			}
+			buf = append(buf, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	buf = append(buf, make([]byte, dataSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	return encode(buf, map[string]interface{}{
+		"node_count":                  count,
+		"record_size":                 24,
+		"ip_version":                  6,
+		"database_type":               "GeoLite2-City",
+		"binary_format_major_version": 2,
+		"languages":                   []interface{}{"en"},
+	})
+}
+
+func TestReader_Lookup(t *testing.T) {
+	buf := buildDB(t, map[string]map[string]interface{}{
+		"81.2.69.0/24": {
+			"country":      map[string]interface{}{"iso_code": "GB"},
+			"subdivisions": []interface{}{map[string]interface{}{"iso_code": "ENG"}},
+		},
+		"1.0.0.0/8": {
+			"registered_country": map[string]interface{}{"iso_code": "AU"},
+		},
+		"2001:db8::/32": {
+			"country": map[string]interface{}{"iso_code": "FR"},
+		},
+	})
+
+	r, err := New(buf)
+	require.NoError(t, err)
+	require.Equal(t, "GeoLite2-City", r.Type())
+
+	for ip, want := range map[string]Location{
+		"81.2.69.160": {Country: "GB", Region: "ENG"},
+		"1.2.3.4":     {Country: "AU"},
+		"2001:db8::1": {Country: "FR"},
+	} {
+		loc, ok, err := r.Lookup(net.ParseIP(ip))
+		require.NoError(t, err)
+		require.True(t, ok, ip)
+		require.Equal(t, want, loc, ip)
+	}
+
+	for _, ip := range []string{"81.2.70.1", "10.0.0.1", "2001:db9::1"} {
+		_, ok, err := r.Lookup(net.ParseIP(ip))
+		require.NoError(t, err)
+		require.False(t, ok, ip)
+	}
+
+	_, ok, err := r.Lookup(nil)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, err = New(bytes.Repeat([]byte{0}, 64))
+	require.Error(t, err)
+}
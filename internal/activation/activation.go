@@ -0,0 +1,104 @@
+// Package activation retrieves the listening sockets passed by systemd socket activation,
+// or handed over by the previous process during an upgrade
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd
+const listenFDsStart = 3
+
+// UpgradeEnv is set to its PID by a process handing over its listening sockets to its child,
+// the child can't be designated by LISTEN_PID as its PID is unknown before it starts
+const UpgradeEnv = "KVTILES_UPGRADE_PID"
+
+// Listeners returns the TCP listeners passed by systemd or by the parent process during an upgrade by port,
+// empty when the process was not socket activated, parent is the PID of the upgraded process, 0 if none,
+// the activation environment variables are unset so they are not inherited by child processes
+func Listeners() (ls map[int]net.Listener, parent int, err error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		os.Unsetenv(UpgradeEnv)
+	}()
+	return listeners(os.Getenv, os.Getpid(), os.Getppid(), listenFDsStart)
+}
+
+func listeners(getenv func(string) string, pid, ppid, start int) (map[int]net.Listener, int, error) {
+	var parent int
+	switch {
+	case getenv("LISTEN_PID") == strconv.Itoa(pid):
+	case ppid > 1 && getenv(UpgradeEnv) == strconv.Itoa(ppid):
+		parent = ppid
+	default:
+		// the sockets are for another process, e.g. our parent
+		return nil, 0, nil
+	}
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, 0, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+
+	ls := make(map[int]net.Listener, count)
+	for fd := start; fd < start+count; fd++ {
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("listen-fd-%d", fd))
+		// the listener uses a duplicate of the descriptor
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, 0, fmt.Errorf("socket %d is not a listening socket: %w", fd, err)
+		}
+		addr, ok := l.Addr().(*net.TCPAddr)
+		if !ok {
+			l.Close()
+			return nil, 0, fmt.Errorf("socket %d is not a TCP socket: %s", fd, l.Addr())
+		}
+		if _, ok := ls[addr.Port]; ok {
+			l.Close()
+			return nil, 0, fmt.Errorf("several sockets listening on port %d", addr.Port)
+		}
+		ls[addr.Port] = l
+	}
+	return ls, parent, nil
+}
+
+// Handover starts the running executable with the same arguments, passing it the listeners as systemd would,
+// the new process gets them from Listeners
+func Handover(ls []net.Listener) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	for _, l := range ls {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("can't hand over the listener %s", l.Addr())
+		}
+		// a duplicate of the descriptor, the listener keeps serving
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("can't hand over the listener %s: %w", l.Addr(), err)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	env := []string{
+		"LISTEN_FDS=" + strconv.Itoa(len(ls)),
+		UpgradeEnv + "=" + strconv.Itoa(os.Getpid()),
+	}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "LISTEN_") && !strings.HasPrefix(kv, UpgradeEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return os.StartProcess(exe, os.Args, &os.ProcAttr{Env: env, Files: files})
+}
@@ -0,0 +1,52 @@
+package activation
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+
+	env := map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "1"}
+	ls, parent, err := listeners(func(k string) string { return env[k] }, 42, 1, int(f.Fd()))
+	require.NoError(t, err)
+	require.Zero(t, parent)
+	port := l.Addr().(*net.TCPAddr).Port
+	require.Len(t, ls, 1)
+	require.Contains(t, ls, port)
+
+	// the inherited socket accepts the connections
+	go func() {
+		c, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		if err == nil {
+			c.Close()
+		}
+	}()
+	c, err := ls[port].Accept()
+	require.NoError(t, err)
+	c.Close()
+	ls[port].Close()
+
+	// for another process
+	ls, _, err = listeners(func(k string) string { return env[k] }, 43, 1, int(f.Fd()))
+	require.NoError(t, err)
+	require.Empty(t, ls)
+
+	// handed over by the parent
+	f, err = l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	env = map[string]string{UpgradeEnv: "42", "LISTEN_FDS": "1"}
+	ls, parent, err = listeners(func(k string) string { return env[k] }, 43, 42, int(f.Fd()))
+	require.NoError(t, err)
+	require.Equal(t, 42, parent)
+	require.Contains(t, ls, port)
+	ls[port].Close()
+}
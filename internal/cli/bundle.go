@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/bundle"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+func init() {
+	register(command{
+		name:    "bundle",
+		summary: "package the tiles of a bounding box and a zoom range with the style, glyphs and sprites in a zip for offline mobile use",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			dbPath := fs.String("dbPath", "./map.db", "Database path")
+			outPath := fs.String("out", "./bundle.zip", "zip path out, must not exist")
+			bbox := fs.String("bbox", "-180,-85,180,85", "minLng,minLat,maxLng,maxLat area of the bundled tiles")
+			minZoom := fs.Int("minZoom", 0, "min zoom of the bundled tiles")
+			maxZoom := fs.Int("maxZoom", -1, "max zoom of the bundled tiles, -1 for the max zoom of the DB")
+			staticDir := fs.String("staticDir", "./static", "directory of the glyphs, its files override the embedded style and sprites")
+			baseURL := fs.String("baseURL", "asset://map", "URL the bundle is extracted at on the devices, the style references the bundled files from it")
+			maxTiles := fs.Int("maxTiles", 100000, "max count of tiles in the bundle, 0 for no limit")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				minLat, minLng, maxLat, maxLng, err := tilemath.ParseBBox(*bbox)
+				if err != nil {
+					return err
+				}
+				if *maxZoom < 0 || *maxZoom > 255 {
+					*maxZoom = 255
+				}
+				if *minZoom < 0 || *minZoom > *maxZoom {
+					return fmt.Errorf("invalid zoom range %d-%d", *minZoom, *maxZoom)
+				}
+
+				if _, err := os.Stat(*outPath); err == nil {
+					return fmt.Errorf("%s already exists", *outPath)
+				}
+
+				s, _, clean, err := openDB(*dbPath, logger)
+				if err != nil {
+					return err
+				}
+				defer clean()
+
+				f, err := os.Create(*outPath)
+				if err != nil {
+					return err
+				}
+				stats, err := bundle.Write(ctx, f, s, bundle.Options{
+					MinLat: minLat, MinLng: minLng, MaxLat: maxLat, MaxLng: maxLng,
+					MinZoom:   uint8(*minZoom),
+					MaxZoom:   uint8(*maxZoom),
+					StaticDir: *staticDir,
+					BaseURL:   strings.TrimSuffix(*baseURL, "/"),
+					MaxTiles:  *maxTiles,
+				})
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+				if err != nil {
+					os.Remove(*outPath)
+					return err
+				}
+
+				if len(stats.MissingFonts) > 0 {
+					level.Warn(logger).Log("msg", "glyphs missing from staticDir, labels won't render offline",
+						"fonts", strings.Join(stats.MissingFonts, "|"), "static_dir", *staticDir)
+				}
+				level.Info(logger).Log("msg", "bundle written", "tiles", stats.Tiles, "fonts", len(stats.Fonts), "out", *outPath)
+				return nil
+			}
+		},
+	})
+}
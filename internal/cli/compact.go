@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+func init() {
+	register(command{
+		name:    "compact",
+		summary: "rewrite a DB to a new file without its free pages, e.g. after an import using a key layout",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			dbPath := fs.String("dbPath", "./map.db", "Database path")
+			outPath := fs.String("out", "./compact.db", "DB path out, must not exist")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				if _, err := os.Stat(*outPath); err == nil {
+					return fmt.Errorf("%s already exists", *outPath)
+				}
+
+				src, err := bolt.Open(*dbPath, 0600, &bolt.Options{ReadOnly: true})
+				if err != nil {
+					return fmt.Errorf("failed to open DB for reading at %s: %w", *dbPath, err)
+				}
+				defer src.Close()
+
+				dst, err := bolt.Open(*outPath, 0600, nil)
+				if err != nil {
+					return err
+				}
+				defer dst.Close()
+
+				if err := bbolt.Compact(dst, src); err != nil {
+					os.Remove(*outPath)
+					return err
+				}
+
+				srcInfo, err := os.Stat(*dbPath)
+				if err != nil {
+					return err
+				}
+				dstInfo, err := os.Stat(*outPath)
+				if err != nil {
+					return err
+				}
+				level.Info(logger).Log("msg", "DB compacted", "out", *outPath, "size", srcInfo.Size(), "compacted_size", dstInfo.Size())
+				return nil
+			}
+		},
+	})
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/go-kit/kit/log"
+
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+// openDB opens the DB at path for reading, it must hold a map
+func openDB(path string, logger log.Logger) (*bbolt.Storage, *storage.MapInfos, func() error, error) {
+	s, clean, err := bbolt.NewROStorage(path, logger)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	infos, ok, err := s.LoadMapInfos()
+	if err != nil {
+		clean()
+		return nil, nil, nil, fmt.Errorf("failed to read infos from %s: %w", path, err)
+	}
+	if !ok {
+		clean()
+		return nil, nil, nil, fmt.Errorf("no map infos in %s", path)
+	}
+
+	return s, infos, clean, nil
+}
+
+// createDB creates a new DB at path using the key layout, path must not exist
+func createDB(path, layout string, logger log.Logger) (*bbolt.Storage, func() error, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, nil, fmt.Errorf("%s already exists", path)
+	}
+
+	s, clean, err := bbolt.NewStorage(path, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open storage for writing: %w", err)
+	}
+	if err := s.UseKeyLayout(layout); err != nil {
+		clean()
+		os.Remove(path)
+		return nil, nil, err
+	}
+
+	return s, clean, nil
+}
+
+// tileKey identifies a tile, y in the TMS scheme as in the storage
+type tileKey struct {
+	z    uint8
+	x, y uint64
+}
@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/namsral/flag"
+)
+
+func init() {
+	register(command{
+		name:    "diff",
+		args:    "<old db> <new db>",
+		summary: "compare the tiles of 2 DBs, counting the tiles added, removed and changed per zoom",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			list := fs.Bool("list", false, "print every tile added (+), removed (-) and changed (~) as z/x/y in the XYZ scheme")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				if len(args) != 2 {
+					return fmt.Errorf("2 DBs are required")
+				}
+
+				old, err := tileHashes(ctx, args[0], logger)
+				if err != nil {
+					return err
+				}
+
+				src, _, clean, err := openDB(args[1], logger)
+				if err != nil {
+					return err
+				}
+				defer clean()
+
+				var counts [256]struct{ added, removed, changed, same int }
+				report := func(op byte, t tileKey) {
+					if *list {
+						fmt.Printf("%c %d/%d/%d\n", op, t.z, t.x, 1<<t.z-t.y-1)
+					}
+				}
+
+				err = src.ForEachTile(func(z uint8, x, y uint64, data []byte) error {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					h, err := tileHash(data)
+					if err != nil {
+						return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+					}
+
+					t := tileKey{z, x, y}
+					oh, ok := old[t]
+					switch {
+					case !ok:
+						counts[z].added++
+						report('+', t)
+					case oh != h:
+						counts[z].changed++
+						report('~', t)
+					default:
+						counts[z].same++
+					}
+					delete(old, t)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+
+				for t := range old {
+					counts[t.z].removed++
+					report('-', t)
+				}
+
+				tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+				fmt.Fprintln(tw, "zoom\tadded\tremoved\tchanged\tunchanged\t")
+				var total struct{ added, removed, changed, same int }
+				for z, c := range counts {
+					if c.added+c.removed+c.changed+c.same == 0 {
+						continue
+					}
+					fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t\n", z, c.added, c.removed, c.changed, c.same)
+					total.added += c.added
+					total.removed += c.removed
+					total.changed += c.changed
+					total.same += c.same
+				}
+				fmt.Fprintf(tw, "total\t%d\t%d\t%d\t%d\t\n", total.added, total.removed, total.changed, total.same)
+				return tw.Flush()
+			}
+		},
+	})
+}
+
+// tileHashes returns the hash of every tile of the DB at path
+func tileHashes(ctx context.Context, path string, logger log.Logger) (map[tileKey][sha256.Size]byte, error) {
+	s, _, clean, err := openDB(path, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer clean()
+
+	hashes := make(map[tileKey][sha256.Size]byte)
+	err = s.ForEachTile(func(z uint8, x, y uint64, data []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		h, err := tileHash(data)
+		if err != nil {
+			return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+		}
+		hashes[tileKey{z, x, y}] = h
+		return nil
+	})
+	return hashes, err
+}
+
+// tileHash hashes the uncompressed tile, the same tile may be gzipped differently
+func tileHash(data []byte) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return sum, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
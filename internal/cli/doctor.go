@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+func init() {
+	register(command{
+		name:    "doctor",
+		summary: "diagnose a DB: map infos, zoom gaps, corrupted tiles, free pages, compression flags, center and bounds, exits with an error status on errors",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			dbPath := fs.String("dbPath", "./map.db", "Database path")
+			jsonOutput := fs.Bool("json", false, "print the findings as JSON")
+			var opts bbolt.DiagnoseOptions
+			fs.IntVar(&opts.MaxProblems, "maxProblems", 100, "stops reporting corrupted tiles after this count")
+			fs.IntVar(&opts.Samples, "samples", 1000, "count of tiles sampled across zooms and decoded as the map format")
+			fs.Float64Var(&opts.FreeRatio, "freeRatio", 0.2, "ratio of free pages in the file reported as bloat")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				s, clean, err := bbolt.NewROStorage(*dbPath, logger)
+				if err != nil {
+					return fmt.Errorf("failed to open storage: %w", err)
+				}
+				defer clean()
+
+				findings, err := s.Diagnose(opts)
+				if err != nil {
+					return err
+				}
+
+				var errs int
+				for _, f := range findings {
+					if f.Severity == bbolt.SeverityError {
+						errs++
+					}
+				}
+
+				if *jsonOutput {
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					if err := enc.Encode(findings); err != nil {
+						return err
+					}
+				} else {
+					for _, f := range findings {
+						fmt.Printf("%s: %s\n  fix: %s\n", f.Severity, f.Problem, f.Fix)
+					}
+					if len(findings) == 0 {
+						fmt.Printf("%s: no problem found\n", *dbPath)
+					}
+				}
+
+				if errs > 0 {
+					return fmt.Errorf("%d errors found in %s", errs, *dbPath)
+				}
+				return nil
+			}
+		},
+	})
+}
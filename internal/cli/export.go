@@ -0,0 +1,47 @@
+//go:build cgo
+// +build cgo
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/mbtiles"
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+func init() {
+	register(command{
+		name:    "export",
+		summary: "export the tiles of a DB to a new mbtiles file",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			dbPath := fs.String("dbPath", "./map.db", "Database path")
+			tilesPath := fs.String("tilesPath", "./map.mbtiles", "mbtiles file path out, must not exist")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				if _, err := os.Stat(*tilesPath); err == nil {
+					return fmt.Errorf("%s already exists", *tilesPath)
+				}
+
+				s, clean, err := bbolt.NewROStorage(*dbPath, logger)
+				if err != nil {
+					return fmt.Errorf("failed to open storage: %w", err)
+				}
+				defer clean()
+
+				if err := mbtiles.Export(s, *tilesPath); err != nil {
+					os.Remove(*tilesPath)
+					return err
+				}
+				level.Info(logger).Log("msg", "tiles exported", "tiles_path", *tilesPath)
+				return nil
+			}
+		},
+	})
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+func init() {
+	register(command{
+		name:    "extract",
+		summary: "copy the tiles of a DB within a bounding box and a zoom range to a new DB",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			dbPath := fs.String("dbPath", "./map.db", "Database path")
+			outPath := fs.String("out", "./extract.db", "DB path out, must not exist")
+			bbox := fs.String("bbox", "-180,-85,180,85", "minLng,minLat,maxLng,maxLat area of the extracted tiles")
+			minZoom := fs.Int("minZoom", 0, "min zoom of the extracted tiles")
+			maxZoom := fs.Int("maxZoom", -1, "max zoom of the extracted tiles, -1 for the max zoom of the DB")
+			keyLayout := fs.String("keyLayout", "", "tiles keys layout of the new DB: zxy|quadkey|hilbert, the layout of the DB when empty")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				minLat, minLng, maxLat, maxLng, err := tilemath.ParseBBox(*bbox)
+				if err != nil {
+					return err
+				}
+
+				src, infos, srcClean, err := openDB(*dbPath, logger)
+				if err != nil {
+					return err
+				}
+				defer srcClean()
+
+				if *maxZoom < 0 || *maxZoom > infos.MaxZoom {
+					*maxZoom = infos.MaxZoom
+				}
+				if *minZoom < 0 || *minZoom > *maxZoom {
+					return fmt.Errorf("invalid zoom range %d-%d", *minZoom, *maxZoom)
+				}
+				if *keyLayout == "" {
+					*keyLayout = infos.KeyLayout
+				}
+
+				dst, dstClean, err := createDB(*outPath, *keyLayout, logger)
+				if err != nil {
+					return err
+				}
+				defer dstClean()
+
+				w, err := dst.NewTileWriter()
+				if err != nil {
+					return err
+				}
+
+				var count int
+				err = src.ForEachTile(func(z uint8, x, y uint64, data []byte) error {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					if int(z) < *minZoom || int(z) > *maxZoom {
+						return nil
+					}
+					// y is in the TMS scheme
+					tMinLat, tMinLng, tMaxLat, tMaxLng := tilemath.Tile{Z: z, X: x, Y: 1<<z - y - 1}.Bounds()
+					if tMinLat >= maxLat || tMaxLat <= minLat || tMinLng >= maxLng || tMaxLng <= minLng {
+						return nil
+					}
+					count++
+					return w.Put(z, x, y, data)
+				})
+				if err != nil {
+					w.Abort()
+					return err
+				}
+
+				infos.MaxZoom = *maxZoom
+				// the debug map is centered in the extracted area
+				if infos.CenterLat < minLat || infos.CenterLat > maxLat || infos.CenterLng < minLng || infos.CenterLng > maxLng {
+					infos.CenterLat = (minLat + maxLat) / 2
+					infos.CenterLng = (minLng + maxLng) / 2
+				}
+				if err := w.Close(*infos); err != nil {
+					return err
+				}
+				level.Info(logger).Log("msg", "tiles extracted", "count", count, "out", *outPath)
+				return nil
+			}
+		},
+	})
+}
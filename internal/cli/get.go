@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+
+	client "github.com/akhenakh/kvtiles/client/kvtiles"
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+func init() {
+	register(command{
+		name:    "get",
+		args:    "[z/x/y]",
+		summary: "fetch a tile from a DB or a server, write it uncompressed or decoded to GeoJSON",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			dbPath := fs.String("dbPath", "", "Database path to read the tile from")
+			baseURL := fs.String("url", "", "base URL of a running kvtilesd to fetch the tile from, e.g. http://localhost:8080")
+			tilesKey := fs.String("tilesKey", "", "key passed to the server")
+			latLng := fs.String("latLng", "", "lat,lng of a location in the tile, instead of z/x/y")
+			zoom := fs.Int("zoom", 0, "zoom of the tile with latLng")
+			outPath := fs.String("out", "", "output file path, stdout when empty")
+			geoJSON := fs.Bool("geojson", false, "decode the tile to GeoJSON")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				if (*dbPath == "") == (*baseURL == "") {
+					return fmt.Errorf("one of dbPath or url is required")
+				}
+
+				var t tilemath.Tile
+				switch {
+				case len(args) == 1 && *latLng == "":
+					var err error
+					if t, err = parseTile(args[0]); err != nil {
+						return err
+					}
+				case len(args) == 0 && *latLng != "":
+					lat, lng, err := parseLatLng(*latLng)
+					if err != nil {
+						return err
+					}
+					if *zoom < 0 || *zoom > 30 {
+						return fmt.Errorf("invalid zoom %d", *zoom)
+					}
+					t = tilemath.FromLatLng(lat, lng, uint8(*zoom))
+				default:
+					return fmt.Errorf("one of z/x/y or latLng is required")
+				}
+
+				var data []byte
+				var err error
+				if *dbPath != "" {
+					data, err = readTile(ctx, *dbPath, t, logger)
+				} else {
+					data, err = fetchTile(ctx, *baseURL, *tilesKey, t)
+				}
+				if err != nil {
+					return err
+				}
+				level.Info(logger).Log("msg", "tile found", "tile", fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y), "size", len(data))
+
+				if *geoJSON {
+					layers, err := mvt.Decode(data)
+					if err != nil {
+						return err
+					}
+					fc, err := mvt.GeoJSON(layers, t)
+					if err != nil {
+						return err
+					}
+					if data, err = json.MarshalIndent(fc, "", "  "); err != nil {
+						return err
+					}
+					data = append(data, '\n')
+				}
+
+				if *outPath == "" {
+					_, err = os.Stdout.Write(data)
+					return err
+				}
+				return ioutil.WriteFile(*outPath, data, 0644)
+			}
+		},
+	})
+}
+
+// readTile returns the uncompressed tile t from the DB at path
+func readTile(ctx context.Context, path string, t tilemath.Tile, logger log.Logger) ([]byte, error) {
+	s, clean, err := bbolt.NewROStorage(path, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer clean()
+
+	data, err := s.ReadTileData(ctx, t.Z, t.X, t.TMSY())
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("tile %d/%d/%d not found", t.Z, t.X, t.Y)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// fetchTile returns the uncompressed tile t from the server at baseURL
+func fetchTile(ctx context.Context, baseURL, key string, t tilemath.Tile) ([]byte, error) {
+	c, err := client.New(baseURL, client.WithKey(key))
+	if err != nil {
+		return nil, err
+	}
+	return c.GetTile(ctx, t.Z, t.X, t.Y)
+}
+
+// parseTile parses a z/x/y tile in the XYZ scheme
+func parseTile(s string) (tilemath.Tile, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return tilemath.Tile{}, fmt.Errorf("invalid tile %q, expecting z/x/y", s)
+	}
+	z, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil || z > 30 {
+		return tilemath.Tile{}, fmt.Errorf("invalid tile zoom %q", s)
+	}
+	x, errX := strconv.ParseUint(parts[1], 10, 64)
+	y, errY := strconv.ParseUint(parts[2], 10, 64)
+	if errX != nil || errY != nil || x >= 1<<z || y >= 1<<z {
+		return tilemath.Tile{}, fmt.Errorf("invalid tile %q", s)
+	}
+	return tilemath.Tile{Z: uint8(z), X: x, Y: y}, nil
+}
+
+// parseLatLng parses a "lat,lng" location
+func parseLatLng(s string) (float64, float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid location %q, expecting lat,lng", s)
+	}
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lng, errLng := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if errLat != nil || errLng != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return 0, 0, fmt.Errorf("invalid location %q", s)
+	}
+	return lat, lng, nil
+}
@@ -0,0 +1,80 @@
+//go:build cgo
+// +build cgo
+
+package cli
+
+import (
+	"context"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/cdnpurge"
+	"github.com/akhenakh/kvtiles/events"
+	"github.com/akhenakh/kvtiles/internal/flagutil"
+	"github.com/akhenakh/kvtiles/internal/sigv4"
+	"github.com/akhenakh/kvtiles/mbtiles"
+)
+
+func init() {
+	register(command{
+		name:    "import",
+		summary: "import an mbtiles file into a new DB, or migrate a DB to another key layout",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			var cfg mbtiles.ImportConfig
+			fs.Float64Var(&cfg.CenterLat, "centerLat", 48.8, "Latitude center used for the debug map")
+			fs.Float64Var(&cfg.CenterLng, "centerLng", 2.2, "Longitude center used for the debug map")
+			fs.IntVar(&cfg.MaxZoom, "maxZoom", 9, "max zoom level")
+			fs.StringVar(&cfg.TilesPath, "tilesPath", "./hawaii.mbtiles", "mbtiles file path")
+			fs.StringVar(&cfg.DBPath, "dbPath", "./map.db", "db path out")
+			fs.StringVar(&cfg.KeyLayout, "keyLayout", "zxy", "tiles keys layout: zxy|quadkey|hilbert, quadkey and hilbert store adjacent tiles close to each other")
+			fs.StringVar(&cfg.MigrateFrom, "migrateFrom", "", "existing DB path copied to dbPath using keyLayout, instead of importing an mbtiles")
+			shards := fs.String("shards", "", "comma separated names of the shards the tiles are partitioned across, as given to the gateway")
+			fs.StringVar(&cfg.Shard, "shard", "", "name of the shard whose tiles are imported, requires shards")
+			fs.IntVar(&cfg.ZstdDictSize, "zstdDictSize", 0, "store tiles compressed with a trained zstd dictionary of this size in bytes, e.g. 112640, 0 to store tiles as gzipped in the mbtiles")
+			fs.IntVar(&cfg.ZstdSamples, "zstdSamples", 10000, "count of tiles sampled to train the zstd dictionary")
+			fs.StringVar(&cfg.TerrainEncoding, "terrainEncoding", "", "elevation encoding of terrain-RGB raster tiles: mapbox|terrarium, when missing from the mbtiles metadata")
+			fs.IntVar(&cfg.SearchZoom, "searchIndexZoom", 0, "zoom of the tiles whose named features are indexed for /search, e.g. 14, 0 to disable")
+
+			var purge cdnpurge.Config
+			var aws sigv4.Credentials
+			fs.StringVar(&purge.BaseURL, "cdnBaseURL", "", "public URL of the tiles server behind the CDN, e.g. https://tiles.example.com")
+			fs.StringVar(&purge.FastlyServiceID, "fastlyServiceID", "", "Fastly service purged after import")
+			fs.StringVar(&purge.FastlyToken, "fastlyToken", "", "Fastly API token")
+			fs.StringVar(&purge.CloudflareZoneID, "cloudflareZoneID", "", "Cloudflare zone purged after import")
+			fs.StringVar(&purge.CloudflareToken, "cloudflareToken", "", "Cloudflare API token")
+			fs.StringVar(&purge.CloudFrontDistributionID, "cloudFrontDistributionID", "", "CloudFront distribution invalidated after import")
+			fs.StringVar(&aws.AccessKeyID, "awsAccessKeyID", "", "AWS access key ID")
+			fs.StringVar(&aws.SecretAccessKey, "awsSecretAccessKey", "", "AWS secret access key")
+			fs.StringVar(&aws.SessionToken, "awsSessionToken", "", "AWS session token")
+			natsURL := fs.String("eventsNATSURL", "", "NATS URL where an import completed event is published, e.g. nats://localhost:4222")
+			kafkaURL := fs.String("eventsKafkaURL", "", "Kafka REST proxy URL where an import completed event is published, e.g. http://localhost:8082")
+			topic := fs.String("eventsTopic", "kvtiles.events", "NATS subject or Kafka topic of the events")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				purge.AWS = aws
+				purger, err := cdnpurge.New(purge)
+				if err != nil {
+					return err
+				}
+				cfg.Purger = purger
+				pub, err := events.NewPublisher(*natsURL, *kafkaURL, *topic)
+				if err != nil {
+					return err
+				}
+				if pub != nil {
+					defer pub.Close()
+					cfg.Events = pub
+				}
+				cfg.Shards = flagutil.SplitList(*shards)
+
+				if err := mbtiles.Import(ctx, cfg, logger); err != nil {
+					return err
+				}
+				level.Info(logger).Log("msg", "tiles imported", "db_path", cfg.DBPath)
+				return nil
+			}
+		},
+	})
+}
@@ -0,0 +1,139 @@
+// Package cli is the kvtiles command line tool, the commands are registered by the init of their file
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/config"
+	"github.com/akhenakh/kvtiles/logformat"
+	"github.com/akhenakh/kvtiles/loglevel"
+)
+
+const appName = "kvtiles"
+
+// version is the version of the binary
+var version string
+
+// command is a kvtiles subcommand, setup defines its flags and returns the function running it,
+// or main runs it with its args when it parses its own flags
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error
+	main    func(args []string)
+}
+
+// commands are registered by the init of their file
+var commands = map[string]command{}
+
+func register(c command) {
+	commands[c.name] = c
+}
+
+// Main runs the command named by the first of args
+func Main(binaryVersion string, args []string) {
+	version = binaryVersion
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	case "version", "-version", "--version":
+		fmt.Println(version)
+		return
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+
+	run(appName, appName+" "+cmd.name, cmd, "console", os.Stderr, args[1:])
+}
+
+// Alias runs the command name as the binary app, for the binaries predating kvtiles,
+// their logs stay in JSON by default and on stdout
+func Alias(app, name, binaryVersion string, args []string) {
+	version = binaryVersion
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: command %q not available in this build\n", app, name)
+		os.Exit(2)
+	}
+
+	run(app, app, cmd, "json", os.Stdout, args)
+}
+
+// run parses the flags of cmd from args and runs it as app, invoked is how the command was called, e.g. kvtiles import,
+// the logs are written to logOut
+func run(app, invoked string, cmd command, defaultLogFormat string, logOut io.Writer, args []string) {
+	if cmd.main != nil {
+		cmd.main(args)
+		return
+	}
+
+	// the flags shared by every command, flags can also be set by environment variables or a config file
+	fs := flag.NewFlagSet(invoked, flag.ExitOnError)
+	logLevel := fs.String("logLevel", "INFO", "DEBUG|INFO|WARN|ERROR")
+	logFormat := fs.String("logFormat", defaultLogFormat, "json|logfmt|console")
+	configFile := config.Flag(fs)
+	runCmd := cmd.setup(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] %s\n\n%s\n\nFlags:\n", invoked, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := configFile.Load(fs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, err := logformat.NewLogger(logOut, *logFormat)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "app", app, "cmd", cmd.name)
+	logger = loglevel.NewLevelFilterFromString(logger, *logLevel)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := runCmd(ctx, logger, fs.Args()); err != nil {
+		level.Error(logger).Log("msg", cmd.name+" failed", "error", err)
+		stop()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", appName)
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", appName)
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+func init() {
+	register(command{
+		name:    "merge",
+		args:    "<db>...",
+		summary: "merge the tiles of several DBs into a new DB, a tile present in several DBs is taken from the last one",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			outPath := fs.String("out", "./merged.db", "DB path out, must not exist")
+			keyLayout := fs.String("keyLayout", "", "tiles keys layout of the new DB: zxy|quadkey|hilbert, the layout of the last DB when empty")
+			region := fs.String("region", "", "region name of the new DB, the region of the last DB when empty")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				if len(args) < 2 {
+					return fmt.Errorf("at least 2 DBs are required")
+				}
+
+				// reading from the last DB, tiles already written are skipped
+				var infos storage.MapInfos
+				var w *bbolt.TileWriter
+				seen := make(map[tileKey]struct{})
+				for i := len(args) - 1; i >= 0; i-- {
+					src, srcInfos, srcClean, err := openDB(args[i], logger)
+					if err != nil {
+						return err
+					}
+
+					if w == nil {
+						infos = *srcInfos
+						if *keyLayout == "" {
+							*keyLayout = srcInfos.KeyLayout
+						}
+						if *region != "" {
+							infos.Region = *region
+						}
+						dst, dstClean, err := createDB(*outPath, *keyLayout, logger)
+						if err != nil {
+							srcClean()
+							return err
+						}
+						defer dstClean()
+						if w, err = dst.NewTileWriter(); err != nil {
+							srcClean()
+							return err
+						}
+					}
+					if srcInfos.MaxZoom > infos.MaxZoom {
+						infos.MaxZoom = srcInfos.MaxZoom
+					}
+
+					var count int
+					err = src.ForEachTile(func(z uint8, x, y uint64, data []byte) error {
+						if err := ctx.Err(); err != nil {
+							return err
+						}
+						t := tileKey{z, x, y}
+						if _, ok := seen[t]; ok {
+							return nil
+						}
+						seen[t] = struct{}{}
+						count++
+						return w.Put(z, x, y, data)
+					})
+					srcClean()
+					if err != nil {
+						w.Abort()
+						return err
+					}
+					level.Info(logger).Log("msg", "DB merged", "db_path", args[i], "count", count)
+				}
+
+				if err := w.Close(infos); err != nil {
+					return err
+				}
+				level.Info(logger).Log("msg", "DBs merged", "count", len(seen), "out", *outPath)
+				return nil
+			}
+		},
+	})
+}
@@ -0,0 +1,15 @@
+package cli
+
+import (
+	"github.com/akhenakh/kvtiles/internal/daemon"
+)
+
+func init() {
+	register(command{
+		name:    "serve",
+		summary: "serve the tiles of a DB over HTTP, the kvtilesd server, see its flags with serve -h",
+		main: func(args []string) {
+			daemon.Main(version, args)
+		},
+	})
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+func init() {
+	register(command{
+		name:    "stats",
+		summary: "report the tiles counts and sizes per zoom, the duplicate ratio and the DB overhead",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			dbPath := fs.String("dbPath", "./map.db", "Database path")
+			jsonOutput := fs.Bool("json", false, "print the stats as JSON")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				s, infos, clean, err := openDB(*dbPath, logger)
+				if err != nil {
+					return err
+				}
+				defer clean()
+
+				st, err := s.Stats()
+				if err != nil {
+					return err
+				}
+
+				if *jsonOutput {
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					return enc.Encode(map[string]interface{}{
+						"infos":           infos,
+						"stats":           st,
+						"duplicate_ratio": st.DuplicateRatio(),
+						"overhead":        st.Overhead(),
+					})
+				}
+
+				fmt.Printf("region %s, max zoom %d, key layout %s, compression %s, indexed %s\n\n",
+					infos.Region, infos.MaxZoom, orDefault(infos.KeyLayout, "zxy"), orDefault(infos.Compression, "gzip"),
+					infos.IndexTime.Format("2006-01-02 15:04:05"))
+
+				tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+				fmt.Fprintln(tw, "zoom\ttiles\tbytes\tmin\tavg\tmax\tbounds\t")
+				for z, zs := range st.Zooms {
+					if zs == nil {
+						continue
+					}
+					// the TMS max y is the XYZ min y
+					minLat, _, _, maxLng := tilemath.Tile{Z: uint8(z), X: zs.MaxX, Y: 1<<z - zs.MinY - 1}.Bounds()
+					_, minLng, maxLat, _ := tilemath.Tile{Z: uint8(z), X: zs.MinX, Y: 1<<z - zs.MaxY - 1}.Bounds()
+					fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t%.4f,%.4f,%.4f,%.4f\t\n", z, zs.Tiles, humanBytes(zs.Bytes),
+						humanBytes(int64(zs.MinSize)), humanBytes(zs.Bytes/int64(zs.Tiles)), humanBytes(int64(zs.MaxSize)),
+						minLng, minLat, maxLng, maxLat)
+				}
+				if err := tw.Flush(); err != nil {
+					return err
+				}
+
+				fmt.Printf("\ntiles %d, distinct %d, duplicate ratio %.1f%%\n", st.Tiles, st.Blobs, st.DuplicateRatio()*100)
+				fmt.Printf("file %s: tiles %s, index %s, dictionary %s, overhead %s (%.1f%%)\n",
+					humanBytes(st.FileSize), humanBytes(st.BlobsBytes), humanBytes(st.IndexBytes), humanBytes(int64(st.DictBytes)),
+					humanBytes(st.Overhead()), float64(st.Overhead())/float64(st.FileSize)*100)
+				return nil
+			}
+		},
+	})
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// humanBytes formats n using binary units
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
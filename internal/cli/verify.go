@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+func init() {
+	register(command{
+		name:    "verify",
+		summary: "check the integrity of a DB, exits with an error status when problems are found",
+		setup: func(fs *flag.FlagSet) func(ctx context.Context, logger log.Logger, args []string) error {
+			dbPath := fs.String("dbPath", "./map.db", "Database path")
+			maxProblems := fs.Int("maxProblems", 100, "stops after this count of problems")
+
+			return func(ctx context.Context, logger log.Logger, args []string) error {
+				s, clean, err := bbolt.NewROStorage(*dbPath, logger)
+				if err != nil {
+					return fmt.Errorf("failed to open storage: %w", err)
+				}
+				defer clean()
+
+				problems, count, err := s.Check(*maxProblems)
+				if err != nil {
+					return err
+				}
+				for _, p := range problems {
+					level.Warn(logger).Log("msg", "problem found", "error", p)
+				}
+				if len(problems) > 0 {
+					return fmt.Errorf("%d problems found in %s", len(problems), *dbPath)
+				}
+
+				level.Info(logger).Log("msg", "DB verified", "db_path", *dbPath, "tiles", count)
+				return nil
+			}
+		},
+	})
+}
@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+)
+
+// debugHandler returns a handler exposing pprof, expvar and GC stats, only served on the opt-in debugPort,
+// the handlers registered by the pprof and expvar imports on http.DefaultServeMux are never served
+func debugHandler() http.Handler {
+	m := http.NewServeMux()
+
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	m.Handle("/debug/vars", expvar.Handler())
+
+	m.HandleFunc("/debug/gcstats", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runtimeStats())
+	})
+
+	return m
+}
+
+// runtimeStats returns the GC, memory and goroutines stats
+func runtimeStats() map[string]interface{} {
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return map[string]interface{}{
+		"last_gc":        gc.LastGC,
+		"num_gc":         gc.NumGC,
+		"pause_total":    gc.PauseTotal.String(),
+		"heap_alloc":     mem.HeapAlloc,
+		"heap_inuse":     mem.HeapInuse,
+		"heap_objects":   mem.HeapObjects,
+		"sys":            mem.Sys,
+		"next_gc":        mem.NextGC,
+		"gc_cpu_percent": mem.GCCPUFraction * 100,
+		"goroutines":     runtime.NumGoroutine(),
+		"memory_limit":   debug.SetMemoryLimit(-1),
+	}
+}
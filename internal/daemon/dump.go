@@ -0,0 +1,41 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"runtime/pprof"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// dumpOnSignal logs the goroutines stacks then the runtime stats and the stats returned by stats
+// on every signal of sig, to debug a stuck instance
+func dumpOnSignal(ctx context.Context, logger log.Logger, sig <-chan os.Signal, stats func() (map[string]interface{}, error)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sig:
+		}
+
+		var stacks bytes.Buffer
+		if err := pprof.Lookup("goroutine").WriteTo(&stacks, 2); err != nil {
+			level.Error(logger).Log("msg", "can't dump goroutines", "error", err)
+		}
+		level.Info(logger).Log("msg", "goroutines dump", "stacks", stacks.String())
+
+		kv := []interface{}{"msg", "stats dump", "runtime", runtimeStats()}
+		st, err := stats()
+		if err != nil {
+			level.Error(logger).Log("msg", "can't dump stats", "error", err)
+		}
+		for _, name := range []string{"requests", "caches", "db", "ready", "started"} {
+			if v, ok := st[name]; ok {
+				kv = append(kv, name, v)
+			}
+		}
+		level.Info(logger).Log(kv...)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package daemon
+
+import "os"
+
+// dumpSignals is empty, no user signal on this platform
+var dumpSignals []os.Signal
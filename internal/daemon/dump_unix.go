@@ -0,0 +1,12 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// dumpSignals log the goroutines stacks and the stats, SIGUSR2 is the upgrade signal
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
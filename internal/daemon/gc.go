@@ -0,0 +1,29 @@
+package daemon
+
+import (
+	"runtime/debug"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// ballast is a never touched allocation raising the heap size triggering the GC,
+// it only costs virtual memory, the pages are never written
+var ballast []byte
+
+// tuneGC applies the GC percent, memory limit in MB and ballast size in MB, the zero values
+// keep the runtime defaults, set by GOGC and GOMEMLIMIT
+func tuneGC(logger log.Logger, percent, limit, ballastSize int) {
+	if percent != 0 {
+		prev := debug.SetGCPercent(percent)
+		level.Info(logger).Log("msg", "GC percent set", "gc_percent", percent, "previous", prev)
+	}
+	if limit > 0 {
+		debug.SetMemoryLimit(int64(limit) << 20)
+		level.Info(logger).Log("msg", "memory limit set", "limit_mb", limit)
+	}
+	if ballastSize > 0 {
+		ballast = make([]byte, int64(ballastSize)<<20)
+		level.Info(logger).Log("msg", "GC ballast allocated", "size_mb", ballastSize)
+	}
+}
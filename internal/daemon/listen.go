@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+var (
+	// activated are the listening sockets passed by systemd socket activation or by the upgraded process by port,
+	// set at startup
+	activated map[int]net.Listener
+
+	// opened are the listening sockets in use, handed over on upgrade
+	openedMu sync.Mutex
+	opened   []net.Listener
+)
+
+// listen returns the socket passed at startup for the port of addr, or listens on addr
+func listen(addr string) (net.Listener, error) {
+	ln, err := activatedOrListen(addr)
+	if err != nil {
+		return nil, err
+	}
+	openedMu.Lock()
+	opened = append(opened, ln)
+	openedMu.Unlock()
+	return ln, nil
+}
+
+func activatedOrListen(addr string) (net.Listener, error) {
+	if _, p, err := net.SplitHostPort(addr); err == nil {
+		if port, err := strconv.Atoi(p); err == nil {
+			if ln, ok := activated[port]; ok {
+				return ln, nil
+			}
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenAddr returns addr, or the port on all the interfaces when addr is empty, empty when both are unset
+func listenAddr(addr string, port int) string {
+	if addr != "" || port == 0 {
+		return addr
+	}
+	return fmt.Sprintf(":%d", port)
+}
+
+// openListeners returns the listening sockets in use
+func openListeners() []net.Listener {
+	openedMu.Lock()
+	defer openedMu.Unlock()
+	return append([]net.Listener(nil), opened...)
+}
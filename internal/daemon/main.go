@@ -0,0 +1,1651 @@
+// Package daemon is the kvtilesd tiles server, run by kvtilesd and kvtiles serve
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/namsral/flag"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	"github.com/akhenakh/kvtiles"
+	"github.com/akhenakh/kvtiles/apikey"
+	"github.com/akhenakh/kvtiles/backup"
+	"github.com/akhenakh/kvtiles/cdnpurge"
+	"github.com/akhenakh/kvtiles/cluster"
+	"github.com/akhenakh/kvtiles/config"
+	"github.com/akhenakh/kvtiles/contour"
+	"github.com/akhenakh/kvtiles/errreport"
+	"github.com/akhenakh/kvtiles/events"
+	"github.com/akhenakh/kvtiles/geoip"
+	"github.com/akhenakh/kvtiles/internal/activation"
+	"github.com/akhenakh/kvtiles/internal/flagutil"
+	"github.com/akhenakh/kvtiles/internal/s3"
+	"github.com/akhenakh/kvtiles/internal/sigv4"
+	"github.com/akhenakh/kvtiles/logformat"
+	"github.com/akhenakh/kvtiles/loglevel"
+	"github.com/akhenakh/kvtiles/logrotate"
+	"github.com/akhenakh/kvtiles/mask"
+	"github.com/akhenakh/kvtiles/replication"
+	"github.com/akhenakh/kvtiles/server"
+	"github.com/akhenakh/kvtiles/statsd"
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+	"github.com/akhenakh/kvtiles/storage/cache"
+	"github.com/akhenakh/kvtiles/tilemath"
+	"github.com/akhenakh/kvtiles/warmup"
+)
+
+const appName = "kvtilesd"
+
+var (
+	version string
+
+	logLevel        = flag.String("logLevel", "INFO", "DEBUG|INFO|WARN|ERROR")
+	logFormat       = flag.String("logFormat", "json", "json|logfmt|console")
+	configFile      = config.Flag(flag.CommandLine)
+	dbPath          = flag.String("dbPath", "map.db", "Database path")
+	dbURL           = flag.String("dbURL", "", "HTTP(S) or s3://bucket/key URL the DB is downloaded from at startup when dbPath is missing or stale")
+	dbSHA256        = flag.String("dbSHA256", "", "hex encoded sha256 of the dbURL DB, checked after the download")
+	dbSHA256URL     = flag.String("dbSHA256URL", "", "URL of the sha256 of the dbURL DB as written by sha256sum, instead of dbSHA256 for updated DBs")
+	dbURLPollEvery  = flag.Duration("dbURLPollInterval", 0, "interval dbURL is checked for a new DB, downloaded and served without restart, 0 to disable")
+	bboltPopulate   = flag.Bool("bboltPopulate", false, "pre-fault the whole DB in memory at startup (MAP_POPULATE), linux only")
+	bboltAdvice     = flag.String("bboltAdvice", "", "madvise hint for the DB mmap: normal|random|sequential|willneed, empty to skip")
+	bboltMlock      = flag.Bool("bboltMlock", false, "lock the DB mmap in memory, requires CAP_IPC_LOCK or a large enough RLIMIT_MEMLOCK")
+	bboltMmapSize   = flag.Int("bboltInitialMmapSize", 0, "initial DB mmap size in MB, 0 to use the file size")
+	bboltFreelist   = flag.String("bboltFreelistType", "array", "DB freelist type: array|hashmap")
+	bboltPageSize   = flag.Int("bboltPageSize", 0, "expected DB page size, a warning is logged on mismatch, 0 to skip the check")
+	cacheSize       = flag.Int("cacheSize", 0, "in memory LRU tiles cache size in MB, 0 to disable")
+	layersCacheSize = flag.Int("layersCacheSize", 16, "in memory cache size in MB of the tiles filtered by the layers query parameter, 0 to disable")
+	retinaCacheSize = flag.Int("retinaCacheSize", 32, "in memory cache size in MB of the @2x raster tiles stitched or upscaled, 0 to disable")
+	warmupBBox      = flag.String("warmupBBox", "", "minLng,minLat,maxLng,maxLat area to pre-load at startup, from zoom 0 to warmupMaxZoom")
+	warmupMaxZoom   = flag.Int("warmupMaxZoom", 10, "max zoom pre-loaded for warmupBBox")
+	warmupAccessLog = flag.String("warmupAccessLog", "", "JSON access log path used to pre-load the most requested tiles at startup")
+	warmupTop       = flag.Int("warmupTop", 10000, "number of most requested tiles pre-loaded from warmupAccessLog")
+	warmupTimeout   = flag.Duration("warmupTimeout", 5*time.Minute, "max duration of the startup warmup")
+	selfCheckN      = flag.Int("selfCheckSamples", 100, "tiles sampled across zooms and decoded at startup and after a DB swap, the server stays not ready when one is corrupted, 0 to disable")
+	negativeTTL     = flag.Duration("negativeCacheTTL", 0, "duration missing tiles are remembered as missing, 0 to disable")
+	prefetchWorkers = flag.Int("prefetchWorkers", 0, "workers reading ahead the neighbors and children of the requested tiles into the caches, 0 to disable")
+	redisAddr       = flag.String("redisAddr", "", "Redis address used as a shared tiles cache, e.g. localhost:6379")
+	memcachedAddrs  = flag.String("memcachedAddrs", "", "comma separated memcached servers used as a shared tiles cache")
+	remoteCacheTTL  = flag.Duration("remoteCacheTTL", 24*time.Hour, "TTL of the tiles stored in Redis or memcached, 0 for no expiration, at most 30 days for memcached")
+	groupcacheSize  = flag.Int("groupcacheSize", 0, "distributed groupcache size in MB per peer, 0 to disable")
+	groupcacheSelf  = flag.String("groupcacheSelf", "", "groupcache URL of this peer as seen by the others, e.g. http://10.0.0.1:8090")
+	groupcachePeers = flag.String("groupcachePeers", "", "comma separated groupcache URLs of all the peers, including self")
+	groupcachePort  = flag.Int("groupcachePort", 8090, "http port serving the groupcache to the peers")
+	groupcacheFill  = flag.Duration("groupcacheFillTimeout", 10*time.Second, "timeout of the groupcache tiles loads from the storage or the owning peer, shared by the requests of the tile")
+	groupcacheAddr  = flag.String("groupcacheAddr", "", "listen address serving the groupcache, e.g. 10.0.0.1:8090, overrides groupcachePort")
+	httpMetricsPort = flag.Int("httpMetricsPort", 8088, "http port")
+	httpMetricsAddr = flag.String("httpMetricsAddr", "", "http metrics listen address, e.g. 127.0.0.1:8088, overrides httpMetricsPort")
+	statsdAddr      = flag.String("statsdAddr", "", "StatsD or DogStatsD UDP address the metrics are pushed to, alongside the Prometheus endpoint, e.g. localhost:8125, empty to disable")
+	statsdFormat    = flag.String("statsdFormat", "statsd", "statsd, the labels values are appended to the metrics names, or dogstatsd, the labels are sent as tags")
+	statsdPrefix    = flag.String("statsdPrefix", "", "prefix of the metrics names pushed to statsdAddr, e.g. tiles.")
+	statsdTags      = flag.String("statsdTags", "", "comma separated DogStatsD tags added to every metric, e.g. env:prod")
+	statsdInterval  = flag.Duration("statsdInterval", 10*time.Second, "interval the metrics are pushed to statsdAddr")
+	httpAPIPort     = flag.Int("httpAPIPort", 8080, "http API port")
+	httpAPIAddr     = flag.String("httpAPIAddr", "", "http API listen address, e.g. 127.0.0.1:8080, overrides httpAPIPort")
+	healthPort      = flag.Int("healthPort", 6666, "grpc health port")
+	healthAddr      = flag.String("healthAddr", "", "grpc health listen address, e.g. 127.0.0.1:6666, overrides healthPort")
+	grpcReflect     = flag.Bool("grpcReflection", false, "register the gRPC server reflection on the health port, for grpcurl")
+	gcPercent       = flag.Int("gcPercent", 0, "GC target percentage of heap growth as GOGC, -1 to only collect at memoryLimit, 0 to keep GOGC")
+	memoryLimit     = flag.Int("memoryLimit", 0, "soft memory limit in MB the GC keeps the process under as GOMEMLIMIT, e.g. 90% of the container limit, 0 to keep GOMEMLIMIT")
+	gcBallast       = flag.Int("gcBallast", 0, "size in MB of a heap ballast making the GC run less often on small heaps, 0 to disable")
+	debugPort       = flag.Int("debugPort", 0, "localhost http port exposing pprof, expvar and GC stats, 0 to disable")
+	tilesKey        = flag.String("tilesKey", "", "A key to protect your tiles access")
+	accessLog       = flag.String("accessLog", "", "access log output: stdout, stderr or a file path, empty to disable")
+	accessSampling  = flag.Float64("accessLogSampling", 1, "ratio of successful requests written to the access log, errors are always logged")
+	accessMaxSize   = flag.Int("accessLogMaxSize", 0, "size in MB rotating the access log file, 0 to disable")
+	accessRotate    = flag.Duration("accessLogRotateInterval", 0, "interval rotating the access log file, e.g. 24h, 0 to disable")
+	accessBackups   = flag.Int("accessLogMaxBackups", 0, "number of rotated access log files kept, 0 to keep all")
+	accessMaxAge    = flag.Duration("accessLogMaxAge", 0, "age after which rotated access log files are removed, 0 to keep them")
+	shutdownWait    = flag.Duration("shutdownTimeout", 5*time.Second, "on shutdown, how long in flight requests are waited for once new connections are refused, before cutting them off")
+	requestTimeout  = flag.Duration("requestTimeout", 5*time.Second, "deadline of a tile read through the caches and the storage, 0 for no deadline")
+	handlerTimeout  = flag.Duration("handlerTimeout", 0, "deadline of a whole tiles, search, query or elevation request, replied with a 503 once passed, 0 for no deadline")
+	maxInFlight     = flag.Int("maxInFlight", 0, "max tiles, search, query or elevation requests served at once, 0 for no limit")
+	mapInFlight     = flag.Int("maxInFlightPerMap", 0, "max tiles requests served at once per map, the canary and each snapshot having their own limit, 0 for no limit")
+	inFlightQueue   = flag.Int("inFlightQueue", 100, "requests over maxInFlight or maxInFlightPerMap waiting for a slot, the others are replied with a 503")
+	inFlightWait    = flag.Duration("inFlightQueueWait", time.Second, "max duration a request waits for a slot, replied with a 503 once passed")
+	probeInterval   = flag.Duration("probeInterval", 0, "interval of the random tiles reads probing the storage behind the caches, the health status is NOT_SERVING while it fails, 0 to disable")
+	probeLatency    = flag.Duration("probeMaxLatency", 500*time.Millisecond, "mean latency of the last probeWindow storage probes over which the storage is unhealthy, 0 for no limit")
+	probeErrorRate  = flag.Float64("probeMaxErrorRate", 0.2, "error rate of the last probeWindow storage probes over which the storage is unhealthy")
+	probeWindow     = flag.Int("probeWindow", 10, "number of the last storage probes the latency and error rate are computed on")
+	degradeErrors   = flag.Int("degradeErrors", 0, "storage read errors within degradeWindow after which the tiles are served from the caches only, 0 to disable")
+	degradeWindow   = flag.Duration("degradeWindow", 10*time.Second, "window the storage read errors are counted in")
+	degradeRetry    = flag.Duration("degradeRetryInterval", 5*time.Second, "interval of the reads let through to the degraded storage to detect its recovery")
+	stylesDir       = flag.String("stylesDir", "", "directory of *.json map styles templated with the tiles URL and served under /styles/")
+	staticDir       = flag.String("staticDir", "./static", "directory overriding the embedded debug map files and holding the glyphs, empty to disable the debug map")
+	disableUI       = flag.Bool("disableUI", false, "remove the debug map, templates, static files, styles and admin dashboard routes, for API only deployments")
+	slowThreshold   = flag.Duration("slowRequestThreshold", 0, "log details of tiles requests slower than this duration, 0 to disable")
+	errorWebhook    = flag.String("errorWebhookURL", "", "URL where panics and 5xx errors are posted as JSON")
+	sentryDSN       = flag.String("sentryDSN", "", "Sentry DSN where panics and 5xx errors are reported")
+	eventsNATSURL   = flag.String("eventsNATSURL", "", "NATS URL where server events are published, e.g. nats://localhost:4222")
+	eventsKafkaURL  = flag.String("eventsKafkaURL", "", "Kafka REST proxy URL where server events are published, e.g. http://localhost:8082")
+	eventsTopic     = flag.String("eventsTopic", "kvtiles.events", "NATS subject or Kafka topic of the server events")
+	auditLogPath    = flag.String("auditLogPath", "", "file path where audit logs are appended, empty to disable")
+	keysFile        = flag.String("keysFile", "", "JSON file describing API keys with their quotas and zoom restrictions")
+	keysUsagePath   = flag.String("keysUsagePath", "usage.db", "Database path where API keys usage counters are persisted")
+	oidcIssuer      = flag.String("oidcIssuer", "", "OIDC issuer URL used to discover the token introspection endpoint")
+	oauthIntrospect = flag.String("oauthIntrospectionURL", "", "OAuth2 token introspection endpoint protecting the admin routes, enables the admin routes")
+	oauthClientID   = flag.String("oauthClientID", "", "OAuth2 client ID used for token introspection")
+	oauthSecret     = flag.String("oauthClientSecret", "", "OAuth2 client secret used for token introspection")
+	oauthScope      = flag.String("oauthScope", "", "OAuth2 scope required to access the admin routes")
+	adminAddr       = flag.String("adminAddr", "", "listen address of the admin routes, e.g. 127.0.0.1:8089, removes them from the API listener")
+	adminClientCA   = flag.String("adminClientCA", "", "CA path used to verify the admin clients certificates, instead of tlsClientCA")
+	maskPath        = flag.String("maskPath", "", "GeoJSON polygons file, tiles outside are served empty and features outside are removed from the tiles crossing its border")
+	redactAttrs     = flag.String("redactAttributes", "", "comma separated attributes, or layer.attribute, removed from the served tiles features, trusted API keys are not redacted")
+	urlSigningKey   = flag.String("urlSigningKey", "", "A secret used to validate HMAC signed expiring tiles URLs, signed URLs are then required")
+	tileDigest      = flag.Bool("tileDigest", false, "add the X-Tile-Digest header, the SHA-256 of the tiles responses bodies")
+	tileDigestKey   = flag.String("tileDigestKey", "", "A secret used to sign the tiles digests in the X-Tile-Signature header, enables tileDigest")
+	allowOrigin     = flag.String("allowOrigin", "*", "comma separated CORS allowed origins, empty to disable CORS")
+	allowMethods    = flag.String("allowMethods", "GET", "comma separated CORS allowed methods")
+	allowHeaders    = flag.String("allowHeaders", "", "comma separated CORS allowed headers")
+	corsMaxAge      = flag.Int("corsMaxAge", 0, "CORS preflight max age in seconds, 0 to omit")
+	securityHeaders = flag.Bool("securityHeaders", true, "set the X-Content-Type-Options, Content-Security-Policy, Referrer-Policy and HSTS headers")
+	contentPolicy   = flag.String("contentSecurityPolicy", server.DefaultContentSecurityPolicy, "Content-Security-Policy of the debug maps and admin dashboard, empty to omit")
+	referrerPolicy  = flag.String("referrerPolicy", "strict-origin-when-cross-origin", "Referrer-Policy of the responses, empty to omit")
+	hstsMaxAge      = flag.Duration("hstsMaxAge", 365*24*time.Hour, "Strict-Transport-Security max age of the TLS responses, 0 to omit")
+	allowedReferers = flag.String("allowedReferers", "", "comma separated hosts allowed to request tiles via Referer/Origin, *.domain.com allowed, empty to disable")
+	allowNoReferer  = flag.Bool("allowNoReferer", true, "accept tiles requests without Referer nor Origin when allowedReferers is set")
+	allowCIDRs      = flag.String("allowCIDRs", "", "comma separated CIDRs allowed to request tiles and the admin routes, empty to allow all")
+	denyCIDRs       = flag.String("denyCIDRs", "", "comma separated CIDRs denied to request tiles and the admin routes")
+	geoIPDB         = flag.String("geoIPDB", "", "MaxMind DB path, e.g. GeoLite2-City.mmdb, adding the clients country and region to the access log and counting the requests per country")
+	trustedProxies  = flag.String("trustedProxies", "", "comma separated CIDRs of proxies trusted to set X-Forwarded-For")
+	tlsCert         = flag.String("tlsCert", "", "TLS certificate path, enables TLS on all listeners")
+	tlsKey          = flag.String("tlsKey", "", "TLS private key path")
+	tlsClientCA     = flag.String("tlsClientCA", "", "CA path used to verify client certificates, enables mTLS")
+	acmeDomain      = flag.String("acmeDomain", "", "comma separated domains to get Let's Encrypt certificates for, enables TLS on the API")
+	acmeCacheDir    = flag.String("acmeCacheDir", "acme-cache", "directory used to store ACME certificates")
+	acmeEmail       = flag.String("acmeEmail", "", "contact email for the ACME account")
+	acmeHTTPPort    = flag.Int("acmeHTTPPort", 80, "http port used for ACME http-01 challenges, 0 to disable")
+	acmeHTTPAddr    = flag.String("acmeHTTPAddr", "", "listen address used for ACME http-01 challenges, e.g. 10.0.0.1:80, overrides acmeHTTPPort")
+	dbReloadEvery   = flag.Duration("dbReloadInterval", 0, "interval dbPath is checked for a replaced DB to serve without restart, 0 to disable")
+	replicationPort = flag.Int("replicationPort", 0, "grpc port streaming the DB to the replicas, 0 to disable")
+	replicationAddr = flag.String("replicationAddr", "", "grpc listen address streaming the DB to the replicas, e.g. 10.0.0.1:7777, overrides replicationPort")
+	replicaOf       = flag.String("replicaOf", "", "primary replication address, e.g. primary:7777, the DB is then received from the primary")
+	raftAddr        = flag.String("raftAddr", "", "raft address replicating the tiles and map infos writes of the admin routes between the nodes, e.g. 10.0.0.1:7000, dbPath is then opened for writing, empty to disable")
+	raftNodeID      = flag.String("raftNodeID", "", "unique raft node ID in the cluster, empty to use raftAddr")
+	raftPeers       = flag.String("raftPeers", "", "comma separated id=addr raft voters bootstrapping a new cluster, including this node, the same on every node")
+	raftDir         = flag.String("raftDir", "raft", "directory where the raft log and snapshots are stored")
+	raftSnapshot    = flag.Int("raftSnapshotThreshold", 8192, "count of writes after which the DB is snapshotted and the raft log truncated")
+	gatewayDiscover = flag.Bool("gatewayDiscovery", false, "route tiles requests to the shards discovered by gossip instead of gatewayShards")
+	gossipPort      = flag.Int("gossipPort", 0, "gossip port used to discover the groupcache peers and the shards, e.g. 7946, 0 to disable")
+	gossipBindAddr  = flag.String("gossipBindAddr", "", "IP address the gossip listens on, empty for all the interfaces")
+	gossipJoin      = flag.String("gossipJoin", "", "comma separated gossip addresses of existing members, e.g. a DNS name resolving to the nodes")
+	gossipAdvertise = flag.String("gossipAdvertiseAddr", "", "gossip address advertised to the others, empty to detect it")
+	gossipNodeName  = flag.String("gossipNodeName", "", "unique node name in the cluster, empty to use the hostname")
+	gossipKey       = flag.String("gossipKey", "", "base64 encoded 16, 24 or 32 bytes AES key shared by the members, encrypting the gossip, e.g. from head -c 32 /dev/urandom | base64")
+	gossipInsecure  = flag.Bool("gossipInsecure", false, "allow the gossip without gossipKey, in clear text, any host reaching gossipPort can join")
+	shardName       = flag.String("shardName", "", "name of the shard served by this node, advertised to the gateways by gossip")
+	shardURL        = flag.String("shardURL", "", "tiles API base URL of this node advertised to the gateways, e.g. http://10.0.0.2:8080")
+	gatewayShards   = flag.String("gatewayShards", "", "comma separated name=URL shards, e.g. a=http://shard-a:8080, tiles requests are then routed to the shard owning the tile instead of a local DB")
+	replicaDir      = flag.String("replicaDir", "replica", "directory where the DBs received from the primary or S3 are stored")
+	s3Bucket        = flag.String("s3Bucket", "", "S3 bucket where the DB is published, the DB is then downloaded when its ETag changes")
+	s3Key           = flag.String("s3Key", "map.db", "S3 key of the DB, or of a JSON manifest {\"key\", \"sha256\"} pointing to the DB when ending with .json")
+	s3Region        = flag.String("s3Region", "us-east-1", "S3 bucket region")
+	s3Endpoint      = flag.String("s3Endpoint", "", "S3 compatible endpoint using path style URLs, e.g. http://minio:9000, empty for AWS")
+	s3PollInterval  = flag.Duration("s3PollInterval", time.Minute, "interval the S3 object ETag is checked")
+	backupInterval  = flag.Duration("backupInterval", 0, "interval the DB is backed up to backupBucket, 0 to disable")
+	backupBucket    = flag.String("backupBucket", "", "S3 bucket the backups are uploaded to, using s3Region, s3Endpoint and the AWS credentials")
+	backupPrefix    = flag.String("backupPrefix", "backups/", "key prefix of the backups, followed by the backup time")
+	backupKeep      = flag.Int("backupKeep", 7, "count of backups kept in backupBucket, the older ones are deleted, 0 to keep all")
+	awsAccessKeyID  = flag.String("awsAccessKeyID", "", "AWS access key ID, S3 requests are not signed when empty")
+	awsSecretKey    = flag.String("awsSecretAccessKey", "", "AWS secret access key")
+	awsSessionToken = flag.String("awsSessionToken", "", "AWS session token")
+	cdnBaseURL      = flag.String("cdnBaseURL", "", "public URL of the tiles server behind the CDN, e.g. https://tiles.example.com")
+	fastlyService   = flag.String("fastlyServiceID", "", "Fastly service purged after a DB swap")
+	fastlyToken     = flag.String("fastlyToken", "", "Fastly API token")
+	cloudflareZone  = flag.String("cloudflareZoneID", "", "Cloudflare zone purged after a DB swap")
+	cloudflareToken = flag.String("cloudflareToken", "", "Cloudflare API token")
+	cloudFrontDist  = flag.String("cloudFrontDistributionID", "", "CloudFront distribution invalidated after a DB swap, using the AWS credentials")
+	overlayDBPaths  = flag.String("overlayDBPaths", "", "comma separated DB paths whose layers are merged over dbPath tiles, e.g. poi.db,events.db=events|closures to only take some layers, a layer in several DBs is taken from the last one")
+	contourDBPath   = flag.String("contourDBPath", "", "terrain-RGB DB whose contour lines are merged into dbPath tiles as a contour layer")
+	contourOnly     = flag.Bool("contourOnly", false, "serve the contour lines of the terrain-RGB dbPath as vector tiles instead of its raster tiles")
+	contourInterval = flag.Float64("contourInterval", 10, "elevation interval in meters of the contour lines")
+	contourCache    = flag.Int("contourCacheSize", 64, "size in MB of the in memory LRU cache of the generated contour tiles, 0 to disable")
+	canaryDBPath    = flag.String("canaryDBPath", "", "path of a second DB version served to canarySampling of the clients and to canaryKeys")
+	canarySampling  = flag.Float64("canarySampling", 0.05, "ratio of the clients, by IP, served from canaryDBPath")
+	canaryKeys      = flag.String("canaryKeys", "", "comma separated API key IDs always served from canaryDBPath")
+	snapshotDBPaths = flag.String("snapshotDBPaths", "", "comma separated dated snapshots of the map served at /tiles/{date}/{z}/{x}/{y}, e.g. 2024-01-01=jan.db,2024-02-01=feb.db")
+	shadowURL       = flag.String("shadowURL", "", "base URL of a backend receiving a copy of the tiles requests, e.g. http://kvtilesd-next:8080, responses are compared with the served ones")
+	shadowSampling  = flag.Float64("shadowSampling", 0.1, "ratio of the tiles requests mirrored to shadowURL")
+	standbyOf       = flag.String("standbyOf", "", "grpc health address of the primary, e.g. primary:6666, tiles are then refused until the primary fails")
+	standbyInterval = flag.Duration("standbyCheckInterval", 2*time.Second, "interval the primary health is checked")
+	standbyFailures = flag.Int("standbyFailures", 3, "consecutive failed or successful primary health checks before taking over or stepping back")
+	analyticsRetain = flag.Duration("analyticsRetention", 0, "duration the requests per tile are counted for /admin/analytics, e.g. 24h, 0 to disable")
+	analyticsPeriod = flag.Duration("analyticsPeriod", time.Hour, "granularity of analyticsRetention, the oldest period is dropped as a whole")
+	analyticsTiles  = flag.Int("analyticsMaxTiles", 100000, "distinct tiles counted per analyticsPeriod, the requests of the other tiles are only counted as dropped")
+
+	httpServer        *http.Server
+	acmeHTTPServer    *http.Server
+	debugServer       *http.Server
+	groupcacheServer  *http.Server
+	grpcHealthServer  *grpc.Server
+	replicationServer *grpc.Server
+	httpMetricsServer *http.Server
+	adminServer       *http.Server
+)
+
+// Main runs the server with the command line args, set by the flags of flag.CommandLine,
+// version is the version of the binary
+func Main(binaryVersion string, args []string) {
+	version = binaryVersion
+	flag.CommandLine.Parse(args)
+	if err := configFile.Load(flag.CommandLine); err != nil {
+		stdlog.Fatal(err)
+	}
+
+	out, err := logformat.NewLogger(os.Stdout, *logFormat)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	// the level can be changed on SIGHUP
+	logLevels := loglevel.NewSwitch(out, *logLevel)
+	logger := log.With(logLevels, "caller", log.DefaultCaller, "ts", log.DefaultTimestampUTC)
+	logger = log.With(logger, "app", appName)
+
+	stdlog.SetOutput(log.NewStdlibAdapter(logger))
+
+	level.Info(logger).Log("msg", "Starting app", "version", version)
+
+	tuneGC(logger, *gcPercent, *memoryLimit, *gcBallast)
+
+	// systemd owns the listening sockets when socket activated, the upgraded process when upgrading
+	var upgradedPID int
+	activated, upgradedPID, err = activation.Listeners()
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid socket activation", "error", err)
+		os.Exit(2)
+	}
+	if len(activated) > 0 {
+		level.Info(logger).Log("msg", "socket activated", "sockets", len(activated), "upgrade", upgradedPID != 0)
+	}
+
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// catch termination
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	// debug server
+	if *debugPort != 0 {
+		g.Go(func() error {
+			debugServer = &http.Server{
+				Addr:    fmt.Sprintf("localhost:%d", *debugPort),
+				Handler: debugHandler(),
+			}
+			level.Info(logger).Log("msg", fmt.Sprintf("HTTP debug server listening at localhost:%d", *debugPort))
+
+			if err := listenAndServe(debugServer); err != http.ErrServerClosed {
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	tlsConfig, err := newTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid TLS configuration", "error", err)
+		os.Exit(2)
+	}
+	if tlsConfig != nil {
+		level.Info(logger).Log("msg", "TLS enabled", "mtls", tlsConfig.ClientCAs != nil)
+	}
+
+	// the API listener uses the ACME certificates if requested
+	apiTLSConfig := tlsConfig
+	if *acmeDomain != "" {
+		if *tlsCert != "" {
+			level.Error(logger).Log("msg", "acmeDomain and tlsCert are mutually exclusive")
+			os.Exit(2)
+		}
+
+		m := newACMEManager(*acmeDomain, *acmeCacheDir, *acmeEmail)
+		apiTLSConfig = m.TLSConfig()
+		level.Info(logger).Log("msg", "ACME enabled", "domains", *acmeDomain)
+
+		if addr := listenAddr(*acmeHTTPAddr, *acmeHTTPPort); addr != "" {
+			g.Go(func() error {
+				acmeHTTPServer = &http.Server{
+					Addr:         addr,
+					ReadTimeout:  10 * time.Second,
+					WriteTimeout: 10 * time.Second,
+					Handler:      m.HTTPHandler(nil),
+				}
+				level.Info(logger).Log("msg", fmt.Sprintf("HTTP ACME challenge server listening at %s", addr))
+
+				if err := listenAndServe(acmeHTTPServer); err != http.ErrServerClosed {
+					return err
+				}
+
+				return nil
+			})
+		}
+	}
+
+	// gRPC Health Server
+	// listening before the DB is bootstrapped, NOT_SERVING until the DB is served
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(fmt.Sprintf("grpc.health.v1.%s", appName), healthpb.HealthCheckResponse_NOT_SERVING)
+	g.Go(func() error {
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcHealthServer = grpc.NewServer(opts...)
+
+		healthpb.RegisterHealthServer(grpcHealthServer, healthServer)
+		if *grpcReflect {
+			reflection.Register(grpcHealthServer)
+		}
+
+		haddr := listenAddr(*healthAddr, *healthPort)
+		hln, err := listen(haddr)
+		if err != nil {
+			level.Error(logger).Log("msg", "gRPC Health server: failed to listen", "error", err)
+			os.Exit(2)
+		}
+		level.Info(logger).Log("msg", fmt.Sprintf("gRPC health server listening at %s", haddr))
+		return grpcHealthServer.Serve(hln)
+	})
+
+	// API server, /livez succeeds and /readyz fails until the DB is served
+	apiHandler := &swappableHandler{}
+	apiHandler.set(startingHandler())
+	g.Go(func() error {
+		httpServer = &http.Server{
+			Addr:         listenAddr(*httpAPIAddr, *httpAPIPort),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			Handler:      apiHandler,
+			TLSConfig:    apiTLSConfig,
+		}
+		level.Info(logger).Log("msg", fmt.Sprintf("HTTP API server listening at %s", httpServer.Addr))
+
+		if err := listenAndServe(httpServer); err != http.ErrServerClosed {
+			return err
+		}
+
+		return nil
+	})
+
+	var syncModes int
+	for _, enabled := range []bool{*replicaOf != "", *s3Bucket != "", *dbReloadEvery > 0, *dbURLPollEvery > 0} {
+		if enabled {
+			syncModes++
+		}
+	}
+	if syncModes > 1 {
+		level.Error(logger).Log("msg", "replicaOf, s3Bucket, dbReloadInterval and dbURLPollInterval are mutually exclusive")
+		os.Exit(2)
+	}
+	if *dbURL != "" && (*replicaOf != "" || *s3Bucket != "") {
+		level.Error(logger).Log("msg", "dbURL can't be used with replicaOf nor s3Bucket, they provide the DB")
+		os.Exit(2)
+	}
+	if *dbURLPollEvery > 0 && *dbURL == "" {
+		level.Error(logger).Log("msg", "dbURLPollInterval requires dbURL")
+		os.Exit(2)
+	}
+	gatewayMode := *gatewayShards != "" || *gatewayDiscover
+	replAddr := listenAddr(*replicationAddr, *replicationPort)
+	if gatewayMode && (syncModes > 0 || *dbURL != "" || replAddr != "" || *overlayDBPaths != "" || *contourDBPath != "" || *contourOnly || *backupInterval > 0) {
+		level.Error(logger).Log("msg", "the gateway serves no local DB, it can't be used with replication, downloads, reloads, overlays, contours nor backups")
+		os.Exit(2)
+	}
+	if *contourOnly && *contourDBPath != "" {
+		level.Error(logger).Log("msg", "contourOnly serves the contour lines of dbPath, it can't be used with contourDBPath")
+		os.Exit(2)
+	}
+	if *raftAddr != "" && (syncModes > 0 || gatewayMode || replAddr != "") {
+		level.Error(logger).Log("msg", "raftAddr writes to dbPath, it can't be used with replicaOf, s3Bucket, dbReloadInterval, dbURLPollInterval, replication nor the gateway")
+		os.Exit(2)
+	}
+
+	// the swapper is set before the replica updates are accepted
+	var swapper *dbSwapper
+	swapperReady := make(chan struct{})
+	firstUpdate := make(chan string)
+	onUpdate := func(path string) error {
+		select {
+		case firstUpdate <- path:
+			return nil
+		case <-swapperReady:
+			return swapper.swap(path)
+		}
+	}
+
+	// checkUpdate checks the DB source for a new DB without waiting for the poll interval, nil if not polled
+	var checkUpdate func()
+
+	// the DB is downloaded before serving when missing or stale
+	var dbSource *replication.Source
+	if *dbURL != "" {
+		dbSource = &replication.Source{
+			URL:       *dbURL,
+			SHA256:    *dbSHA256,
+			SHA256URL: *dbSHA256URL,
+			// a DB without map is not downloaded over the served one
+			Verify: func(path string) error {
+				db, _, err := openDB(path, logger)
+				if err != nil {
+					return err
+				}
+				return db.close()
+			},
+			S3: s3.Bucket{
+				Endpoint: *s3Endpoint,
+				Region:   *s3Region,
+				AWS: sigv4.Credentials{
+					AccessKeyID:     *awsAccessKeyID,
+					SecretAccessKey: *awsSecretKey,
+					SessionToken:    *awsSessionToken,
+				},
+			},
+		}
+		downloaded, err := dbSource.Fetch(ctx, *dbPath, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't download the DB", "error", err, "url", *dbURL)
+			os.Exit(2)
+		}
+		level.Info(logger).Log("msg", "DB bootstrapped", "url", *dbURL, "downloaded", downloaded)
+	}
+
+	dbFile := *dbPath
+	replicated := *replicaOf != "" || *s3Bucket != ""
+	if replicated {
+		if err := os.MkdirAll(*replicaDir, 0700); err != nil {
+			level.Error(logger).Log("msg", "can't create replica directory", "error", err)
+			os.Exit(2)
+		}
+
+		_, err := os.Stat(dbFile)
+		if os.IsNotExist(err) {
+			dbFile = ""
+		}
+	}
+
+	switch {
+	case *replicaOf != "":
+		replica, err := replication.NewReplica(*replicaOf, *replicaDir, dbFile, onUpdate, logger, replicaDialOption(tlsConfig))
+		if err != nil {
+			level.Error(logger).Log("msg", "can't start replica", "error", err)
+			os.Exit(2)
+		}
+		g.Go(func() error {
+			return replica.Run(ctx)
+		})
+		level.Info(logger).Log("msg", "replicating from primary", "primary", *replicaOf)
+	case *s3Bucket != "":
+		poller, err := replication.NewS3Poller(replication.S3Config{
+			Endpoint: *s3Endpoint,
+			Region:   *s3Region,
+			Bucket:   *s3Bucket,
+			Key:      *s3Key,
+			AWS: sigv4.Credentials{
+				AccessKeyID:     *awsAccessKeyID,
+				SecretAccessKey: *awsSecretKey,
+				SessionToken:    *awsSessionToken,
+			},
+		}, *replicaDir, *s3PollInterval, onUpdate, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't start S3 sync", "error", err)
+			os.Exit(2)
+		}
+		if poller.Path() != "" {
+			dbFile = poller.Path()
+		}
+		g.Go(func() error {
+			return poller.Run(ctx)
+		})
+		checkUpdate = poller.Check
+		level.Info(logger).Log("msg", "syncing from S3", "bucket", *s3Bucket, "key", *s3Key)
+	}
+
+	// no local DB, waiting for the first DB to be received
+	if replicated && dbFile == "" {
+		level.Info(logger).Log("msg", "waiting for the first replicated DB")
+		select {
+		case dbFile = <-firstUpdate:
+		case <-interrupt:
+			level.Warn(logger).Log("msg", "received shutdown signal")
+			os.Exit(2)
+		}
+	}
+
+	// peers and shards discovery
+	var gossip *cluster.Gossip
+	if *gossipPort != 0 {
+		if *shardName != "" && *shardURL == "" {
+			level.Error(logger).Log("msg", "shardURL is required to advertise shardName")
+			os.Exit(2)
+		}
+		meta := cluster.Member{Shard: *shardName, ShardURL: *shardURL}
+		if *groupcacheSize > 0 {
+			meta.GroupcacheURL = *groupcacheSelf
+		}
+		var key []byte
+		if *gossipKey != "" {
+			key, err = cluster.ParseSecretKey(*gossipKey)
+			if err != nil {
+				level.Error(logger).Log("msg", "invalid gossipKey", "error", err)
+				os.Exit(2)
+			}
+		}
+		gossip, err = cluster.NewGossip(cluster.GossipConfig{
+			NodeName:      *gossipNodeName,
+			BindAddr:      *gossipBindAddr,
+			BindPort:      *gossipPort,
+			AdvertiseAddr: *gossipAdvertise,
+			Join:          flagutil.SplitList(*gossipJoin),
+			SecretKey:     key,
+			Insecure:      *gossipInsecure,
+			Meta:          meta,
+		}, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't start gossip", "error", err)
+			os.Exit(2)
+		}
+		level.Info(logger).Log("msg", fmt.Sprintf("gossip listening at %s", net.JoinHostPort(*gossipBindAddr, strconv.Itoa(*gossipPort))))
+	}
+
+	var (
+		tileStore storage.TileStore
+		infos     *storage.MapInfos
+		db        openedDB
+		raftNode  *cluster.Raft
+		// purged when the DB is swapped, with contourOnly
+		contourLRU *cache.LRU
+	)
+
+	if gatewayMode {
+		var urls map[string][]string
+		if *gatewayDiscover {
+			if gossip == nil {
+				level.Error(logger).Log("msg", "gatewayDiscovery requires gossipPort")
+				os.Exit(2)
+			}
+			urls = cluster.ShardURLs(gossip.Members())
+		} else {
+			urls, err = cluster.ParseShards(*gatewayShards)
+			if err != nil {
+				level.Error(logger).Log("msg", "invalid gateway shards", "error", err)
+				os.Exit(2)
+			}
+		}
+		gw, err := cluster.NewGateway(urls)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't create gateway", "error", err)
+			os.Exit(2)
+		}
+		if *gatewayDiscover {
+			gossip.Watch(func(members []cluster.Member) {
+				if err := gw.SetShards(cluster.ShardURLs(members)); err != nil {
+					level.Warn(logger).Log("msg", "can't update gateway shards", "error", err)
+				}
+			})
+		}
+
+		var ok bool
+		infos, ok, err = gw.LoadMapInfos()
+		if err != nil || !ok {
+			level.Error(logger).Log("msg", "can't read map infos from the shards", "error", err)
+			os.Exit(2)
+		}
+		tileStore = gw
+		level.Info(logger).Log("msg", "gateway mode enabled", "shards", len(urls))
+	} else {
+		open := openDB
+		if *raftAddr != "" {
+			open = openWritableDB
+		}
+		db, infos, err = open(dbFile, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to open storage", "error", err, "db_path", dbFile)
+			os.Exit(2)
+		}
+		swapper = newDBSwapper(db, logger)
+		if replicated {
+			swapper.removeDir = *replicaDir
+		}
+		defer swapper.close()
+		tileStore = swapper.store
+
+		if *raftAddr != "" {
+			peers, err := cluster.ParsePeers(flagutil.SplitList(*raftPeers))
+			if err != nil {
+				level.Error(logger).Log("msg", "invalid raftPeers", "error", err)
+				os.Exit(2)
+			}
+			raftNode, err = cluster.NewRaft(cluster.RaftConfig{
+				NodeID:            *raftNodeID,
+				Addr:              *raftAddr,
+				Dir:               *raftDir,
+				Peers:             peers,
+				SnapshotThreshold: uint64(*raftSnapshot),
+				DBOptions:         bboltOptions(),
+			}, db.Storage, dbFile, logger)
+			if err != nil {
+				level.Error(logger).Log("msg", "can't start raft", "error", err)
+				os.Exit(2)
+			}
+			defer func() {
+				if err := raftNode.Shutdown(); err != nil {
+					level.Warn(logger).Log("msg", "can't stop raft", "error", err)
+				}
+			}()
+			// a node too far behind receives the DB of the leader
+			raftNode.OnRestore(func(s *bbolt.Storage, path string, close func() error) {
+				<-swapperReady
+				restored, infos, err := withMap(s, close, path)
+				if err != nil {
+					level.Error(logger).Log("msg", "can't serve the DB restored from raft", "error", err)
+					return
+				}
+				swapper.serve(restored, infos)
+			})
+			level.Info(logger).Log("msg", "raft writes enabled", "addr", *raftAddr, "peers", len(peers))
+		}
+
+		var terrainStore storage.TileStore
+		switch {
+		case *contourOnly:
+			terrainStore = tileStore
+		case *contourDBPath != "":
+			terrainDB, _, err := openDB(*contourDBPath, logger)
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to open terrain storage", "error", err, "db_path", *contourDBPath)
+				os.Exit(2)
+			}
+			defer terrainDB.close()
+			terrainStore = terrainDB.Storage
+		}
+		var contours storage.TileStore
+		if terrainStore != nil {
+			cs, err := contour.NewStore(terrainStore, *contourInterval)
+			if err != nil {
+				level.Error(logger).Log("msg", "can't generate contour lines", "error", err)
+				os.Exit(2)
+			}
+			contours = cs
+			// generating the contour lines is costly
+			if *contourCache > 0 {
+				contourLRU = cache.NewLRUWithTier(cs, int64(*contourCache)<<20, cache.ContourLRUTier)
+				contours = contourLRU
+			}
+			level.Info(logger).Log("msg", "contour lines enabled", "interval", *contourInterval)
+		}
+		if *contourOnly {
+			tileStore = contours
+		}
+
+		if *overlayDBPaths != "" || *contourDBPath != "" {
+			sources := []storage.CompositeSource{{Store: tileStore}}
+			for _, o := range flagutil.SplitList(*overlayDBPaths) {
+				path, layers := o, ""
+				if i := strings.Index(o, "="); i >= 0 {
+					path, layers = o[:i], o[i+1:]
+				}
+				overlay, _, err := openDB(path, logger)
+				if err != nil {
+					level.Error(logger).Log("msg", "failed to open overlay storage", "error", err, "db_path", path)
+					os.Exit(2)
+				}
+				defer overlay.close()
+				var names []string
+				if layers != "" {
+					names = strings.Split(layers, "|")
+				}
+				sources = append(sources, storage.CompositeSource{Store: overlay.Storage, Layers: names})
+			}
+			if *contourDBPath != "" {
+				sources = append(sources, storage.CompositeSource{Store: contours, Layers: []string{contour.LayerName}})
+			}
+			tileStore, err = storage.NewComposite(sources...)
+			if err != nil {
+				level.Error(logger).Log("msg", "can't create composite", "error", err)
+				os.Exit(2)
+			}
+			level.Info(logger).Log("msg", "serving composite tiles", "overlays", len(sources)-1)
+		}
+	}
+
+	proxies, err := server.ParseTrustedProxies(flagutil.SplitList(*trustedProxies))
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid trusted proxies", "error", err)
+		os.Exit(2)
+	}
+
+	// filtering the tiles and the admin routes
+	var ipFilter *server.IPFilter
+	if *allowCIDRs != "" || *denyCIDRs != "" {
+		ipFilter, err = server.NewIPFilter(flagutil.SplitList(*allowCIDRs), flagutil.SplitList(*denyCIDRs), proxies)
+		if err != nil {
+			level.Error(logger).Log("msg", "invalid IP filter", "error", err)
+			os.Exit(2)
+		}
+	}
+
+	introspectionURL := *oauthIntrospect
+	if introspectionURL == "" && *oidcIssuer != "" {
+		dctx, dcancel := context.WithTimeout(ctx, 10*time.Second)
+		introspectionURL, err = server.DiscoverIntrospectionEndpoint(dctx, *oidcIssuer)
+		dcancel()
+		if err != nil {
+			level.Error(logger).Log("msg", "can't discover OIDC introspection endpoint", "error", err)
+			os.Exit(2)
+		}
+	}
+
+	// server
+	serverOpts := []server.Option{
+		server.WithTrustedProxies(proxies),
+		server.WithStaticDir(*staticDir),
+		server.WithStylesDir(*stylesDir),
+		server.WithSlowRequestThreshold(*slowThreshold),
+		server.WithRequestTimeout(*requestTimeout),
+		server.WithHandlerTimeout(*handlerTimeout),
+		server.WithConcurrencyLimits(*maxInFlight, *mapInFlight, *inFlightQueue, *inFlightWait),
+		server.WithLayersCache(int64(*layersCacheSize) << 20),
+		server.WithRetinaCache(int64(*retinaCacheSize) << 20),
+		server.WithRedaction(server.ParseRedactRules(flagutil.SplitList(*redactAttrs))...),
+	}
+
+	if *geoIPDB != "" {
+		geo, err := geoip.Open(*geoIPDB)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't open GeoIP DB", "error", err)
+			os.Exit(2)
+		}
+		serverOpts = append(serverOpts, server.WithGeoIP(geo))
+		level.Info(logger).Log("msg", "GeoIP enabled", "path", *geoIPDB, "type", geo.Type())
+	}
+
+	var accessLogFile *logrotate.Writer
+	if *accessLog != "" {
+		var w io.Writer = os.Stdout
+		switch *accessLog {
+		case "stdout":
+		case "stderr":
+			w = os.Stderr
+		default:
+			accessLogFile, err = logrotate.Open(*accessLog, logrotate.Options{
+				MaxSize:    int64(*accessMaxSize) << 20,
+				Interval:   *accessRotate,
+				MaxBackups: *accessBackups,
+				MaxAge:     *accessMaxAge,
+			})
+			if err != nil {
+				level.Error(logger).Log("msg", "can't open access log", "error", err)
+				os.Exit(2)
+			}
+			defer accessLogFile.Close()
+			w = accessLogFile
+		}
+
+		accessLogger := log.NewJSONLogger(log.NewSyncWriter(w))
+		accessLogger = log.With(accessLogger, "ts", log.DefaultTimestampUTC)
+		serverOpts = append(serverOpts, server.WithAccessLog(accessLogger, *accessSampling))
+	}
+
+	var sender errreport.Sender
+	switch {
+	case *sentryDSN != "":
+		sender, err = errreport.NewSentry(*sentryDSN)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't configure Sentry", "error", err)
+			os.Exit(2)
+		}
+	case *errorWebhook != "":
+		sender = errreport.NewWebhook(*errorWebhook)
+	}
+	if sender != nil {
+		reporter := errreport.NewReporter(sender, appName, version, logger)
+		g.Go(func() error {
+			return reporter.Run(ctx)
+		})
+		serverOpts = append(serverOpts, server.WithErrorReporter(reporter))
+	}
+
+	pub, err := events.NewPublisher(*eventsNATSURL, *eventsKafkaURL, *eventsTopic)
+	if err != nil {
+		level.Error(logger).Log("msg", "can't configure events publishing", "error", err)
+		os.Exit(2)
+	}
+	var bus *events.Bus
+	if pub != nil {
+		node, _ := os.Hostname()
+		bus = events.NewBus(pub, node, 10000, time.Second, logger)
+		g.Go(func() error {
+			return bus.Run(ctx)
+		})
+		serverOpts = append(serverOpts, server.WithEvents(bus))
+		level.Info(logger).Log("msg", "publishing events", "topic", *eventsTopic)
+	}
+
+	if *auditLogPath != "" {
+		f, err := os.OpenFile(*auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't open audit log", "error", err)
+			os.Exit(2)
+		}
+		defer f.Close()
+
+		auditLogger := log.NewJSONLogger(log.NewSyncWriter(f))
+		auditLogger = log.With(auditLogger, "ts", log.DefaultTimestampUTC, "app", appName)
+		serverOpts = append(serverOpts, server.WithAuditLogger(auditLogger))
+	}
+
+	var keys *apikey.Store
+	if *keysFile != "" {
+		var closeKeys func() error
+		keys, closeKeys, err = apikey.Open(*keysFile, *keysUsagePath)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't load API keys", "error", err)
+			os.Exit(2)
+		}
+		defer closeKeys()
+
+		// persisting usage counters periodically
+		g.Go(func() error {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+					if err := keys.Flush(); err != nil {
+						level.Error(logger).Log("msg", "can't persist API keys usage", "error", err)
+					}
+				}
+			}
+		})
+
+		serverOpts = append(serverOpts, server.WithAPIKeys(keys))
+	}
+	if *maskPath != "" {
+		m, err := mask.Load(*maskPath)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't load mask", "error", err, "path", *maskPath)
+			os.Exit(2)
+		}
+		serverOpts = append(serverOpts, server.WithMask(m))
+		level.Info(logger).Log("msg", "tiles restricted to mask", "path", *maskPath)
+	}
+	if swapper != nil {
+		serverOpts = append(serverOpts, server.WithSearch(swapper.store), server.WithBackup(swapper.store))
+	}
+	if raftNode != nil {
+		serverOpts = append(serverOpts, server.WithWriter(raftNode))
+	}
+	if *urlSigningKey != "" {
+		serverOpts = append(serverOpts, server.WithURLSigningKey([]byte(*urlSigningKey)))
+	}
+	if *tileDigest || *tileDigestKey != "" {
+		var key []byte
+		if *tileDigestKey != "" {
+			key = []byte(*tileDigestKey)
+		}
+		serverOpts = append(serverOpts, server.WithTileDigest(key))
+	}
+	if *canaryDBPath != "" {
+		canaryDB, canaryInfos, err := openDB(*canaryDBPath, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to open canary storage", "error", err, "db_path", *canaryDBPath)
+			os.Exit(2)
+		}
+		defer canaryDB.close()
+		serverOpts = append(serverOpts, server.WithCanary(canaryDB.Storage, *canarySampling, flagutil.SplitList(*canaryKeys)))
+		level.Info(logger).Log("msg", "serving canary DB", "db_path", *canaryDBPath,
+			"region", canaryInfos.Region, "index_time", canaryInfos.IndexTime.Format(time.RFC3339), "sampling", *canarySampling)
+	}
+	if *snapshotDBPaths != "" {
+		snapshots := make(map[string]storage.TileStore)
+		for _, sp := range flagutil.SplitList(*snapshotDBPaths) {
+			date, path, ok := strings.Cut(sp, "=")
+			if !ok {
+				level.Error(logger).Log("msg", "invalid snapshot, expected date=path", "snapshot", sp)
+				os.Exit(2)
+			}
+			if err := server.ParseSnapshotDate(date); err != nil {
+				level.Error(logger).Log("msg", "invalid snapshot", "error", err, "snapshot", sp)
+				os.Exit(2)
+			}
+			snapshotDB, snapshotInfos, err := openDB(path, logger)
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to open snapshot storage", "error", err, "db_path", path)
+				os.Exit(2)
+			}
+			defer snapshotDB.close()
+			snapshots[date] = snapshotDB.Storage
+			level.Info(logger).Log("msg", "serving snapshot DB", "date", date, "db_path", path,
+				"region", snapshotInfos.Region, "index_time", snapshotInfos.IndexTime.Format(time.RFC3339))
+		}
+		serverOpts = append(serverOpts, server.WithSnapshots(snapshots))
+	}
+	if *shadowURL != "" {
+		serverOpts = append(serverOpts, server.WithShadow(*shadowURL, *shadowSampling))
+		level.Info(logger).Log("msg", "mirroring tiles requests", "url", *shadowURL, "sampling", *shadowSampling)
+	}
+	if *analyticsRetain > 0 {
+		if *analyticsPeriod <= 0 || *analyticsPeriod > *analyticsRetain || *analyticsTiles <= 0 {
+			level.Error(logger).Log("msg", "analyticsPeriod must be positive and under analyticsRetention, analyticsMaxTiles positive")
+			os.Exit(2)
+		}
+		slots := int((*analyticsRetain + *analyticsPeriod - 1) / *analyticsPeriod)
+		serverOpts = append(serverOpts, server.WithAnalytics(*analyticsPeriod, slots, *analyticsTiles))
+		level.Info(logger).Log("msg", "tiles analytics enabled", "retention", *analyticsRetain, "period", *analyticsPeriod)
+	}
+
+	// the storage probe reads behind the caches
+	serverOpts = append(serverOpts, server.WithStorageProbe(tileStore, *probeInterval, *probeLatency, *probeErrorRate, *probeWindow))
+
+	// under the caches, so they keep serving when the storage fails
+	var breaker *cache.Breaker
+	if *degradeErrors > 0 {
+		breaker = cache.NewBreaker(tileStore, *degradeErrors, *degradeWindow, *degradeRetry, logger)
+		tileStore = breaker
+		level.Info(logger).Log("msg", "degraded mode enabled", "errors", *degradeErrors, "window", *degradeWindow)
+	}
+
+	// caches purged when the DB is swapped
+	var (
+		remote   *cache.Remote
+		group    *cache.Group
+		lru      *cache.LRU
+		negative *cache.Negative
+	)
+
+	switch {
+	case *redisAddr != "":
+		remote = cache.NewRedis(tileStore, *redisAddr, remoteCachePrefix(infos), *remoteCacheTTL, logger)
+		tileStore = remote
+		level.Info(logger).Log("msg", "Redis cache enabled", "addr", *redisAddr)
+	case *memcachedAddrs != "":
+		remote = cache.NewMemcached(tileStore, flagutil.SplitList(*memcachedAddrs), remoteCachePrefix(infos), *remoteCacheTTL, logger)
+		tileStore = remote
+		level.Info(logger).Log("msg", "memcached cache enabled", "addrs", *memcachedAddrs)
+	}
+
+	if *groupcacheSize > 0 {
+		if *groupcacheSelf == "" {
+			level.Error(logger).Log("msg", "groupcacheSelf is required to enable groupcache")
+			os.Exit(2)
+		}
+
+		group = cache.NewGroup(tileStore, "tiles", int64(*groupcacheSize)<<20, *groupcacheFill)
+		tileStore = group
+		pool := cache.NewHTTPPool(*groupcacheSelf, flagutil.SplitList(*groupcachePeers))
+		if gossip != nil {
+			gossip.Watch(func(members []cluster.Member) {
+				pool.Set(cluster.GroupcachePeers(members)...)
+			})
+		}
+
+		g.Go(func() error {
+			groupcacheServer = &http.Server{
+				Addr:         listenAddr(*groupcacheAddr, *groupcachePort),
+				ReadTimeout:  10 * time.Second,
+				WriteTimeout: 10 * time.Second,
+				Handler:      pool,
+				TLSConfig:    tlsConfig,
+			}
+			level.Info(logger).Log("msg", fmt.Sprintf("HTTP groupcache server listening at %s", groupcacheServer.Addr))
+
+			if err := listenAndServe(groupcacheServer); err != http.ErrServerClosed {
+				return err
+			}
+
+			return nil
+		})
+	}
+	if *cacheSize > 0 {
+		lru = cache.NewLRU(tileStore, int64(*cacheSize)<<20)
+		tileStore = lru
+		level.Info(logger).Log("msg", "LRU cache enabled", "size_mb", *cacheSize)
+	}
+
+	if *negativeTTL > 0 {
+		negative = cache.NewNegative(tileStore, *negativeTTL, 1000000)
+		tileStore = negative
+		level.Info(logger).Log("msg", "negative cache enabled", "ttl", *negativeTTL)
+	}
+
+	if err := warmupStore(ctx, tileStore, logger); err != nil {
+		level.Error(logger).Log("msg", "warmup failed", "error", err)
+		os.Exit(2)
+	}
+
+	if *prefetchWorkers > 0 {
+		// reading ahead is only useful to fill a cache
+		if remote == nil && group == nil && lru == nil {
+			level.Warn(logger).Log("msg", "prefetchWorkers ignored, no tiles cache enabled")
+		} else {
+			// the tiles read ahead are dropped under load rather than queued
+			tileStore = cache.NewPrefetch(ctx, tileStore, *prefetchWorkers, *prefetchWorkers*100)
+			level.Info(logger).Log("msg", "tiles prefetch enabled", "workers", *prefetchWorkers)
+		}
+	}
+
+	var tilesMiddlewares []func(http.Handler) http.Handler
+	if *allowedReferers != "" {
+		tilesMiddlewares = append(tilesMiddlewares, server.NewRefererFilter(flagutil.SplitList(*allowedReferers), *allowNoReferer))
+	}
+	var adminMiddlewares []func(http.Handler) http.Handler
+	if ipFilter != nil {
+		tilesMiddlewares = append(tilesMiddlewares, ipFilter.Handler)
+		adminMiddlewares = append(adminMiddlewares, ipFilter.Handler)
+	}
+
+	// admin routes are only exposed behind authentication
+	var adminMiddleware func(http.Handler) http.Handler
+	if introspectionURL != "" {
+		introspector := server.NewTokenIntrospector(introspectionURL, *oauthClientID, *oauthSecret, *oauthScope)
+		adminMiddleware = introspector.Handler
+		level.Info(logger).Log("msg", "admin routes enabled", "introspection_url", introspectionURL)
+	}
+
+	// the admin listener authenticates with OAuth2 tokens or client certificates
+	adminTLSConfig := tlsConfig
+	if *adminAddr != "" {
+		if *adminClientCA != "" {
+			adminTLSConfig, err = newTLSConfig(*tlsCert, *tlsKey, *adminClientCA)
+			if err != nil {
+				level.Error(logger).Log("msg", "invalid admin TLS configuration", "error", err)
+				os.Exit(2)
+			}
+		}
+		if adminMiddleware == nil && (adminTLSConfig == nil || adminTLSConfig.ClientCAs == nil) {
+			level.Error(logger).Log("msg", "adminAddr requires oauthIntrospectionURL, oidcIssuer or client certificates with adminClientCA or tlsClientCA")
+			os.Exit(2)
+		}
+	}
+
+	var secHeaders *server.SecurityHeaders
+	if *securityHeaders {
+		secHeaders = &server.SecurityHeaders{
+			ContentSecurityPolicy: *contentPolicy,
+			ReferrerPolicy:        *referrerPolicy,
+			HSTSMaxAge:            *hstsMaxAge,
+		}
+	}
+
+	handler, err := kvtiles.NewHandler(tileStore, kvtiles.HandlerOptions{
+		AppName:          appName,
+		Logger:           logger,
+		HealthServer:     healthServer,
+		TilesKey:         *tilesKey,
+		ServerOptions:    append(serverOpts, server.WithVersion(version)),
+		TilesMiddlewares: tilesMiddlewares,
+		AdminMiddleware:  adminMiddleware,
+		AdminMiddlewares: adminMiddlewares,
+		SeparateAdmin:    *adminAddr != "",
+		SecurityHeaders:  secHeaders,
+		DisableUI:        *disableUI,
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "can't get a working server", "error", err)
+		os.Exit(2)
+	}
+	srv := handler.Server
+	if breaker != nil {
+		breaker.OnDegraded(srv.SetDegraded)
+		srv.SetDegraded(breaker.Degraded())
+	}
+
+	// a DB failing the self-check is not served, the check runs again on every swapped DB
+	selfChecked := true
+	if swapper != nil && *selfCheckN > 0 {
+		err := selfCheck(db.Storage, logger)
+		srv.SetSelfCheck(err)
+		selfChecked = err == nil
+		swapper.hooks = append(swapper.hooks, func(s *bbolt.Storage, _ *storage.MapInfos) error {
+			err := selfCheck(s, logger)
+			srv.SetSelfCheck(err)
+			if *standbyOf == "" {
+				status := healthpb.HealthCheckResponse_SERVING
+				if !srv.Serving() {
+					status = healthpb.HealthCheckResponse_NOT_SERVING
+				}
+				healthServer.SetServingStatus(fmt.Sprintf("grpc.health.v1.%s", appName), status)
+			}
+			return err
+		})
+	}
+
+	// refresh drops the cached tiles and map infos after a dataset change
+	refresh := func(infos *storage.MapInfos) error {
+		if remote != nil {
+			remote.SetPrefix(remoteCachePrefix(infos))
+		}
+		if group != nil {
+			group.Purge()
+		}
+		if lru != nil {
+			lru.Purge()
+		}
+		if contourLRU != nil {
+			contourLRU.Purge()
+		}
+		if negative != nil {
+			negative.Purge()
+		}
+		setDataVersion(infos)
+		return srv.RefreshMapInfos()
+	}
+
+	if swapper != nil {
+		swapper.hooks = append(swapper.hooks, func(_ *bbolt.Storage, infos *storage.MapInfos) error {
+			if err := refresh(infos); err != nil {
+				return err
+			}
+			if gossip != nil {
+				gossip.Invalidate(datasetVersion(infos))
+			}
+			if bus != nil {
+				bus.Emit(events.Event{Type: events.DBSwapped, Version: datasetVersion(infos)})
+			}
+			return nil
+		})
+	}
+
+	// every node applies the writes, dropping the tiles it cached
+	if raftNode != nil {
+		raftNode.OnApply(func(infos *storage.MapInfos) {
+			if err := refresh(infos); err != nil {
+				level.Error(logger).Log("msg", "can't refresh after a write", "error", err)
+			}
+			if gossip != nil {
+				gossip.Invalidate(datasetVersion(infos))
+			}
+		})
+	}
+
+	// the CDNs may hold tiles from a replaced dataset
+	purger, err := cdnpurge.New(cdnpurge.Config{
+		BaseURL:                  *cdnBaseURL,
+		FastlyServiceID:          *fastlyService,
+		FastlyToken:              *fastlyToken,
+		CloudflareZoneID:         *cloudflareZone,
+		CloudflareToken:          *cloudflareToken,
+		CloudFrontDistributionID: *cloudFrontDist,
+		AWS: sigv4.Credentials{
+			AccessKeyID:     *awsAccessKeyID,
+			SecretAccessKey: *awsSecretKey,
+			SessionToken:    *awsSessionToken,
+		},
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid CDN purge configuration", "error", err)
+		os.Exit(2)
+	}
+	if purger != nil && swapper == nil {
+		level.Error(logger).Log("msg", "the CDNs are purged after a DB swap, the gateway serves no local DB")
+		os.Exit(2)
+	}
+	if purger != nil {
+		swapper.hooks = append(swapper.hooks, func(_ *bbolt.Storage, infos *storage.MapInfos) error {
+			// not holding the swap
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				defer cancel()
+				if err := purger.Purge(ctx, cdnpurge.DataPaths); err != nil {
+					level.Error(logger).Log("msg", "can't purge the CDNs after the DB swap", "error", err, "version", datasetVersion(infos))
+					return
+				}
+				level.Info(logger).Log("msg", "CDN purged", "paths", strings.Join(cdnpurge.DataPaths, ","), "version", datasetVersion(infos))
+			}()
+			return nil
+		})
+	}
+
+	// the caches of the other nodes may hold tiles from a replaced dataset,
+	// e.g. a gateway in front of a shard or a groupcache peer
+	if gossip != nil {
+		gossip.OnInvalidate(func(inv cluster.Invalidation) {
+			if *shardName != "" && inv.Shard != *shardName {
+				return
+			}
+			infos, ok, err := tileStore.LoadMapInfos()
+			if err != nil || !ok {
+				level.Warn(logger).Log("msg", "can't load map infos after invalidation", "error", err)
+				return
+			}
+			// a node serving its own DB already refreshed when swapping to this version
+			if swapper != nil && datasetVersion(infos) == inv.Version {
+				return
+			}
+			level.Info(logger).Log("msg", "invalidating caches", "node", inv.Node, "version", inv.Version)
+			if err := refresh(infos); err != nil {
+				level.Warn(logger).Log("msg", "can't refresh after invalidation", "error", err)
+			}
+		})
+	}
+
+	if replAddr != "" {
+		primary, err := replication.NewPrimary(db.Storage, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't start replication", "error", err)
+			os.Exit(2)
+		}
+		swapper.hooks = append(swapper.hooks, func(s *bbolt.Storage, _ *storage.MapInfos) error {
+			return primary.SetStorage(s)
+		})
+
+		g.Go(func() error {
+			var opts []grpc.ServerOption
+			if tlsConfig != nil {
+				opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+			}
+			replicationServer = grpc.NewServer(opts...)
+			primary.Register(replicationServer)
+
+			rln, err := listen(replAddr)
+			if err != nil {
+				level.Error(logger).Log("msg", "replication server: failed to listen", "error", err)
+				os.Exit(2)
+			}
+			level.Info(logger).Log("msg", fmt.Sprintf("gRPC replication server listening at %s", replAddr))
+			return replicationServer.Serve(rln)
+		})
+	}
+
+	if *backupInterval > 0 {
+		scheduler, err := backup.NewScheduler(swapper.store, backup.Config{
+			Bucket: s3.Bucket{
+				Endpoint: *s3Endpoint,
+				Region:   *s3Region,
+				Name:     *backupBucket,
+				AWS: sigv4.Credentials{
+					AccessKeyID:     *awsAccessKeyID,
+					SecretAccessKey: *awsSecretKey,
+					SessionToken:    *awsSessionToken,
+				},
+			},
+			Prefix:   *backupPrefix,
+			Interval: *backupInterval,
+			Keep:     *backupKeep,
+		}, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't schedule backups", "error", err)
+			os.Exit(2)
+		}
+		g.Go(func() error {
+			return scheduler.Run(ctx)
+		})
+		level.Info(logger).Log("msg", "backups scheduled", "bucket", *backupBucket, "interval", *backupInterval)
+	}
+
+	// replica updates and DB reloads can be served from now on
+	close(swapperReady)
+
+	if *dbReloadEvery > 0 {
+		g.Go(func() error {
+			return swapper.watch(ctx, dbFile, *dbReloadEvery)
+		})
+		checkUpdate = swapper.check
+	}
+
+	if *dbURLPollEvery > 0 {
+		checks := make(chan struct{}, 1)
+		dbSource.Trigger = checks
+		checkUpdate = func() {
+			select {
+			case checks <- struct{}{}:
+			default:
+			}
+		}
+		g.Go(func() error {
+			return dbSource.Poll(ctx, dbFile, *dbURLPollEvery, swapper.swap, logger)
+		})
+		level.Info(logger).Log("msg", "syncing from DB URL", "url", *dbURL, "interval", *dbURLPollEvery)
+	}
+
+	// web server metrics
+	g.Go(func() error {
+		// not http.DefaultServeMux, where the pprof and expvar imports register the debug handlers
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		httpMetricsServer = &http.Server{
+			Addr:         listenAddr(*httpMetricsAddr, *httpMetricsPort),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			Handler:      mux,
+			TLSConfig:    tlsConfig,
+		}
+		level.Info(logger).Log("msg", fmt.Sprintf("HTTP Metrics server listening at %s", httpMetricsServer.Addr))
+
+		versionGauge.WithLabelValues(version).Add(1)
+		setDataVersion(infos)
+
+		if err := listenAndServe(httpMetricsServer); err != http.ErrServerClosed {
+			return err
+		}
+
+		return nil
+	})
+
+	// push the metrics to StatsD for the stacks which can't scrape
+	if *statsdAddr != "" {
+		if *statsdFormat != "statsd" && *statsdFormat != "dogstatsd" {
+			level.Error(logger).Log("msg", "invalid statsdFormat, statsd or dogstatsd expected", "format", *statsdFormat)
+			os.Exit(2)
+		}
+		exporter, err := statsd.New(prometheus.DefaultGatherer, statsd.Options{
+			Addr:      *statsdAddr,
+			Prefix:    *statsdPrefix,
+			DogStatsD: *statsdFormat == "dogstatsd",
+			Tags:      flagutil.SplitList(*statsdTags),
+			Interval:  *statsdInterval,
+		}, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't push metrics to StatsD", "error", err)
+			os.Exit(2)
+		}
+		g.Go(func() error {
+			return exporter.Run(ctx)
+		})
+		level.Info(logger).Log("msg", "pushing metrics to StatsD", "addr", *statsdAddr, "format", *statsdFormat, "interval", *statsdInterval)
+	}
+
+	// web server, the API listener serves the routes from now on
+	values := flagValues()
+	cfg, err := newReloadConfig(values)
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid flags", "error", err)
+		os.Exit(2)
+	}
+	apiHandler.set(withCORS(handler, cfg))
+	r := &reloader{
+		logLevels:  logLevels,
+		lru:        lru,
+		contourLRU: contourLRU,
+		srv:        srv,
+		keys:       keys,
+		api:        apiHandler,
+		handler:    handler,
+		logger:     logger,
+		values:     values,
+	}
+	r.cfg.Store(cfg)
+
+	// operations offered by the admin dashboard
+	srv.AddAdminAction("reload", "Reload the config file and the keys file", func(context.Context) error {
+		return r.reload()
+	})
+	srv.AddAdminAction("purge-caches", "Drop the cached tiles", func(context.Context) error {
+		infos, ok, err := tileStore.LoadMapInfos()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no map in DB")
+		}
+		return refresh(infos)
+	})
+	if checkUpdate != nil {
+		srv.AddAdminAction("check-update", "Check for a new DB now", func(context.Context) error {
+			checkUpdate()
+			return nil
+		})
+	}
+	if accessLogFile != nil {
+		srv.AddAdminAction("rotate-access-log", "Rotate the access log file", func(context.Context) error {
+			return accessLogFile.Rotate()
+		})
+	}
+	if swapper != nil {
+		srv.AddAdminStatus("db", swapper.stats)
+	}
+	if raftNode != nil {
+		srv.AddAdminStatus("raft", raftNode.Stats)
+	}
+
+	// admin server, the API listener is then read only
+	if handler.Admin != nil {
+		g.Go(func() error {
+			adminServer = &http.Server{
+				Addr:         *adminAddr,
+				ReadTimeout:  10 * time.Second,
+				WriteTimeout: 10 * time.Second,
+				Handler:      handler.Admin,
+				TLSConfig:    adminTLSConfig,
+			}
+			level.Info(logger).Log("msg", fmt.Sprintf("HTTP admin server listening at %s", adminServer.Addr),
+				"mtls", adminTLSConfig != nil && adminTLSConfig.ClientCAs != nil)
+
+			if err := listenAndServe(adminServer); err != http.ErrServerClosed {
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	if *standbyOf != "" {
+		conn, err := grpc.Dial(*standbyOf, replicaDialOption(tlsConfig))
+		if err != nil {
+			level.Error(logger).Log("msg", "can't dial primary", "error", err)
+			os.Exit(2)
+		}
+		defer conn.Close()
+
+		healthName := fmt.Sprintf("grpc.health.v1.%s", appName)
+		standby := cluster.NewStandby(conn, healthName, *standbyInterval, *standbyFailures, func(active bool) {
+			srv.SetStandby(!active)
+			status := healthpb.HealthCheckResponse_NOT_SERVING
+			if srv.Serving() {
+				status = healthpb.HealthCheckResponse_SERVING
+			}
+			healthServer.SetServingStatus(healthName, status)
+		}, logger)
+
+		srv.SetStandby(true)
+		healthServer.SetServingStatus(healthName, healthpb.HealthCheckResponse_NOT_SERVING)
+		g.Go(func() error {
+			return standby.Run(ctx)
+		})
+		level.Info(logger).Log("msg", "standing by", "primary", *standbyOf)
+	} else if !selfChecked {
+		healthServer.SetServingStatus(fmt.Sprintf("grpc.health.v1.%s", appName), healthpb.HealthCheckResponse_NOT_SERVING)
+		level.Error(logger).Log("msg", "DB looks corrupted, serving status stays NOT_SERVING", "db_path", dbFile)
+	} else {
+		healthServer.SetServingStatus(fmt.Sprintf("grpc.health.v1.%s", appName), healthpb.HealthCheckResponse_SERVING)
+		level.Info(logger).Log("msg", "serving status to SERVING")
+	}
+
+	// the load balancers stop sending traffic while the storage probe fails
+	g.Go(func() error {
+		return srv.RunStorageProbe(ctx, func(bool) {
+			status := healthpb.HealthCheckResponse_NOT_SERVING
+			if srv.Serving() {
+				status = healthpb.HealthCheckResponse_SERVING
+			}
+			healthServer.SetServingStatus(fmt.Sprintf("grpc.health.v1.%s", appName), status)
+		})
+	})
+
+	// SIGHUP reloads the config file and the keys file
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	g.Go(func() error {
+		return r.run(ctx, hup)
+	})
+
+	// SIGUSR2 hands the listeners over to the executable, e.g. a new binary,
+	// not with raft since the new process can't open the DB for writing until this one exits
+	if len(upgradeSignals) > 0 && raftNode == nil {
+		usr2 := make(chan os.Signal, 1)
+		signal.Notify(usr2, upgradeSignals...)
+		defer signal.Stop(usr2)
+		g.Go(func() error {
+			return upgrade(ctx, logger, usr2)
+		})
+	}
+
+	// SIGUSR1 logs the goroutines stacks and the stats
+	if len(dumpSignals) > 0 {
+		usr1 := make(chan os.Signal, 1)
+		signal.Notify(usr1, dumpSignals...)
+		defer signal.Stop(usr1)
+		g.Go(func() error {
+			return dumpOnSignal(ctx, logger, usr1, srv.Status)
+		})
+	}
+
+	srv.SetReady(true)
+
+	if upgradedPID != 0 {
+		terminateParent(logger, upgradedPID)
+	}
+
+	select {
+	case <-interrupt:
+		cancel()
+		break
+	case <-ctx.Done():
+		break
+	}
+
+	level.Warn(logger).Log("msg", "received shutdown signal")
+
+	srv.SetReady(false)
+	healthServer.SetServingStatus(fmt.Sprintf("grpc.health.v1.%s", appName), healthpb.HealthCheckResponse_NOT_SERVING)
+
+	// drain: new connections are refused, the in flight requests are waited for up to the timeout
+	shutdownStart := time.Now()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), *shutdownWait)
+	defer shutdownCancel()
+	level.Info(logger).Log("msg", "draining", "in_flight", srv.InFlight(), "timeout", *shutdownWait)
+
+	if httpServer != nil {
+		_ = httpServer.Shutdown(shutdownCtx)
+	}
+
+	if adminServer != nil {
+		_ = adminServer.Shutdown(shutdownCtx)
+	}
+
+	if n := srv.InFlight(); n > 0 {
+		level.Warn(logger).Log("msg", "in flight requests cut off by the shutdown timeout", "requests", n)
+	} else {
+		level.Info(logger).Log("msg", "drained", "duration", time.Since(shutdownStart))
+	}
+
+	// the metrics are served while draining
+	if httpMetricsServer != nil {
+		_ = httpMetricsServer.Shutdown(shutdownCtx)
+	}
+
+	if acmeHTTPServer != nil {
+		_ = acmeHTTPServer.Shutdown(shutdownCtx)
+	}
+
+	if debugServer != nil {
+		_ = debugServer.Shutdown(shutdownCtx)
+	}
+
+	if groupcacheServer != nil {
+		_ = groupcacheServer.Shutdown(shutdownCtx)
+	}
+
+	if grpcHealthServer != nil {
+		grpcHealthServer.GracefulStop()
+	}
+
+	if gossip != nil {
+		_ = gossip.Leave(time.Second)
+	}
+
+	if replicationServer != nil {
+		replicationServer.Stop()
+	}
+
+	err = g.Wait()
+	if err != nil {
+		level.Error(logger).Log("msg", "server returning an error", "error", err)
+		os.Exit(2)
+	}
+}
+
+// remoteCachePrefix prefixes the remote cache keys by the dataset version
+func remoteCachePrefix(infos *storage.MapInfos) string {
+	return fmt.Sprintf("kvtiles/%s/%d/", infos.Region, infos.IndexTime.Unix())
+}
+
+// warmupStore pre-loads the tiles requested by the warmup flags
+func warmupStore(ctx context.Context, store storage.TileStore, logger log.Logger) error {
+	var sources []warmup.Source
+
+	if *warmupBBox != "" {
+		minLat, minLng, maxLat, maxLng, err := tilemath.ParseBBox(*warmupBBox)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, warmup.BBox(minLat, minLng, maxLat, maxLng, 0, uint8(*warmupMaxZoom)))
+	}
+
+	if *warmupAccessLog != "" {
+		f, err := os.Open(*warmupAccessLog)
+		if err != nil {
+			return fmt.Errorf("can't open warmup access log: %w", err)
+		}
+		defer f.Close()
+
+		tiles, err := warmup.TopFromAccessLog(f, *warmupTop)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, warmup.List(tiles))
+	}
+
+	if len(sources) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *warmupTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var count int
+	for _, src := range sources {
+		n, err := warmup.Run(ctx, store, src, 8)
+		count += n
+		if err == context.DeadlineExceeded {
+			level.Warn(logger).Log("msg", "warmup timed out", "tiles", count)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	level.Info(logger).Log("msg", "warmup completed", "tiles", count, "duration", time.Since(start))
+
+	return nil
+}
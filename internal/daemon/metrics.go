@@ -1,8 +1,13 @@
-package main
+package daemon
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/akhenakh/kvtiles/storage"
 )
 
 var (
@@ -18,3 +23,14 @@ var (
 		Help:      "Dataset version.",
 	}, []string{"version"})
 )
+
+// datasetVersion identifies the served dataset
+func datasetVersion(infos *storage.MapInfos) string {
+	return fmt.Sprintf("%s %s", infos.Region, infos.IndexTime.Format(time.RFC3339))
+}
+
+// setDataVersion exposes the version of the served dataset
+func setDataVersion(infos *storage.MapInfos) {
+	dataVersionGauge.Reset()
+	dataVersionGauge.WithLabelValues(datasetVersion(infos)).Set(1)
+}
@@ -0,0 +1,220 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/handlers"
+	"github.com/namsral/flag"
+
+	"github.com/akhenakh/kvtiles/apikey"
+	"github.com/akhenakh/kvtiles/internal/flagutil"
+	"github.com/akhenakh/kvtiles/loglevel"
+	"github.com/akhenakh/kvtiles/server"
+	"github.com/akhenakh/kvtiles/storage/cache"
+)
+
+// reloadable are the flags applied on SIGHUP, changing the others requires a restart
+var reloadable = map[string]bool{
+	"logLevel":         true,
+	"cacheSize":        true,
+	"layersCacheSize":  true,
+	"contourCacheSize": true,
+	"allowOrigin":      true,
+	"allowMethods":     true,
+	"allowHeaders":     true,
+	"corsMaxAge":       true,
+	"keysFile":         true,
+}
+
+// reloadConfig holds the reloadable settings, a new one is parsed from the flags values on every reload
+type reloadConfig struct {
+	logLevel         string
+	cacheSize        int
+	layersCacheSize  int
+	contourCacheSize int
+	allowOrigin      string
+	allowMethods     string
+	allowHeaders     string
+	corsMaxAge       int
+	keysFile         string
+}
+
+// newReloadConfig parses the reloadable settings from the flags values by name
+func newReloadConfig(values map[string]string) (*reloadConfig, error) {
+	cfg := &reloadConfig{
+		logLevel:     values["logLevel"],
+		allowOrigin:  values["allowOrigin"],
+		allowMethods: values["allowMethods"],
+		allowHeaders: values["allowHeaders"],
+		keysFile:     values["keysFile"],
+	}
+	for name, v := range map[string]*int{
+		"cacheSize":        &cfg.cacheSize,
+		"layersCacheSize":  &cfg.layersCacheSize,
+		"contourCacheSize": &cfg.contourCacheSize,
+		"corsMaxAge":       &cfg.corsMaxAge,
+	} {
+		i, err := strconv.Atoi(values[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: %w", values[name], name, err)
+		}
+		*v = i
+	}
+	return cfg, nil
+}
+
+// withCORS returns h answering the CORS requests as set by cfg, h when CORS is disabled
+func withCORS(h http.Handler, cfg *reloadConfig) http.Handler {
+	if cfg.allowOrigin == "" {
+		return h
+	}
+	corsOpts := []handlers.CORSOption{
+		handlers.AllowedOrigins(flagutil.SplitList(cfg.allowOrigin)),
+		handlers.AllowedMethods(flagutil.SplitList(cfg.allowMethods)),
+	}
+	if cfg.allowHeaders != "" {
+		corsOpts = append(corsOpts, handlers.AllowedHeaders(flagutil.SplitList(cfg.allowHeaders)))
+	}
+	if cfg.corsMaxAge > 0 {
+		corsOpts = append(corsOpts, handlers.MaxAge(cfg.corsMaxAge))
+	}
+	return handlers.CORS(corsOpts...)(h)
+}
+
+// swappableHandler serves the last handler set, without dropping the connections
+type swappableHandler struct {
+	v atomic.Value
+}
+
+type handlerBox struct {
+	http.Handler
+}
+
+func (h *swappableHandler) set(handler http.Handler) {
+	h.v.Store(handlerBox{handler})
+}
+
+func (h *swappableHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.v.Load().(handlerBox).ServeHTTP(w, req)
+}
+
+// reloader applies the reloadable settings to the running components, the nil ones are disabled,
+// the flags are never set after startup, they may be read concurrently
+type reloader struct {
+	logLevels  *loglevel.Switch
+	lru        *cache.LRU
+	contourLRU *cache.LRU
+	srv        *server.Server
+	keys       *apikey.Store
+	api        *swappableHandler
+	handler    http.Handler
+	logger     log.Logger
+
+	// values are the flags values in use by name
+	values map[string]string
+	// cfg is the *reloadConfig in use, swapped on reload
+	cfg atomic.Value
+
+	// mu serializes the reloads from the signals and from the admin dashboard
+	mu sync.Mutex
+}
+
+// run reloads the config file and the keys file on every signal of hup
+func (r *reloader) run(ctx context.Context, hup <-chan os.Signal) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+		}
+		if err := r.reload(); err != nil {
+			level.Error(r.logger).Log("msg", "can't reload the configuration", "error", err)
+		}
+	}
+}
+
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	values, err := configFile.Values(flag.CommandLine)
+	if err != nil {
+		return err
+	}
+
+	var changed []string
+	for name, v := range values {
+		if v == r.values[name] {
+			continue
+		}
+		if !reloadable[name] {
+			level.Warn(r.logger).Log("msg", "changing this flag requires a restart, ignored", "flag", name)
+			values[name] = r.values[name]
+			continue
+		}
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+
+	cfg, err := newReloadConfig(values)
+	if err != nil {
+		return err
+	}
+	r.values = values
+	r.cfg.Store(cfg)
+
+	for _, name := range changed {
+		switch name {
+		case "logLevel":
+			r.logLevels.SetLevel(cfg.logLevel)
+		case "cacheSize":
+			r.setCacheSize(r.lru, name, cfg.cacheSize)
+		case "contourCacheSize":
+			r.setCacheSize(r.contourLRU, name, cfg.contourCacheSize)
+		case "layersCacheSize":
+			r.srv.SetLayersCacheSize(int64(cfg.layersCacheSize) << 20)
+		case "allowOrigin", "allowMethods", "allowHeaders", "corsMaxAge":
+			r.api.set(withCORS(r.handler, cfg))
+		}
+	}
+
+	// the keys file content may have changed
+	switch {
+	case r.keys != nil && cfg.keysFile != "":
+		if err := r.keys.Reload(cfg.keysFile); err != nil {
+			return err
+		}
+	case (r.keys == nil) != (cfg.keysFile == ""):
+		level.Warn(r.logger).Log("msg", "enabling or disabling the API keys requires a restart")
+	}
+
+	level.Info(r.logger).Log("msg", "configuration reloaded", "changed", strings.Join(changed, ","))
+	return nil
+}
+
+func (r *reloader) setCacheSize(lru *cache.LRU, name string, sizeMB int) {
+	if lru == nil {
+		level.Warn(r.logger).Log("msg", "the cache was disabled at startup, a restart is required to enable it", "flag", name)
+		return
+	}
+	lru.SetMaxBytes(int64(sizeMB) << 20)
+}
+
+// flagValues returns the values of the flags by name
+func flagValues() map[string]string {
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
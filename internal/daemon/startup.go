@@ -0,0 +1,32 @@
+package daemon
+
+import (
+	"net/http"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startingHandler is served by the API listener while the DB is bootstrapped, downloaded or replicated,
+// the process is alive but not ready, so the probes don't kill a node still fetching a large DB
+func startingHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": "ok"}`))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status": "not_ready", "checks": {"startup": "starting"}}`))
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"status": "` + healthpb.HealthCheckResponse_NOT_SERVING.String() + `"}`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	})
+	return mux
+}
@@ -0,0 +1,218 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+// openedDB is a DB with its path, read only unless opened for the raft writes
+type openedDB struct {
+	*bbolt.Storage
+	path  string
+	close func() error
+}
+
+// bboltOptions returns the bbolt flags
+func bboltOptions() bbolt.Options {
+	return bbolt.Options{
+		MmapPopulate:    *bboltPopulate,
+		InitialMmapSize: *bboltMmapSize * 1024 * 1024,
+		Advice:          *bboltAdvice,
+		Mlock:           *bboltMlock,
+		FreelistType:    *bboltFreelist,
+		PageSize:        *bboltPageSize,
+	}
+}
+
+// openDB opens the DB at path using the bbolt flags, the DB must contain a map
+func openDB(path string, logger log.Logger) (openedDB, *storage.MapInfos, error) {
+	s, clean, err := bbolt.NewROStorageWithOptions(path, logger, bboltOptions())
+	if err != nil {
+		return openedDB{}, nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	return withMap(s, clean, path)
+}
+
+// openWritableDB opens the DB at path for writing using the bbolt flags, the DB must contain a map
+func openWritableDB(path string, logger log.Logger) (openedDB, *storage.MapInfos, error) {
+	s, clean, err := bbolt.NewRWStorageWithOptions(path, logger, bboltOptions())
+	if err != nil {
+		return openedDB{}, nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	return withMap(s, clean, path)
+}
+
+// withMap returns the opened DB with its map infos, it is closed if it contains no map
+func withMap(s *bbolt.Storage, clean func() error, path string) (openedDB, *storage.MapInfos, error) {
+	infos, ok, err := s.LoadMapInfos()
+	if err != nil {
+		clean()
+		return openedDB{}, nil, fmt.Errorf("failed to read infos: %w", err)
+	}
+	if !ok {
+		clean()
+		return openedDB{}, nil, fmt.Errorf("no map infos in %s", path)
+	}
+
+	return openedDB{Storage: s, path: path, close: clean}, infos, nil
+}
+
+// selfCheck decodes selfCheckSamples tiles of s sampled across zooms
+func selfCheck(s *bbolt.Storage, logger log.Logger) error {
+	start := time.Now()
+	n, err := s.CheckSample(*selfCheckN, rand.New(rand.NewSource(start.UnixNano())))
+	if err != nil {
+		level.Error(logger).Log("msg", "DB self-check failed", "error", err, "tiles", n)
+		return err
+	}
+	level.Info(logger).Log("msg", "DB self-check passed", "tiles", n, "duration", time.Since(start))
+	return nil
+}
+
+// dbSwapper replaces the served DB while running, a replaced DB is only closed
+// on the next swap, since tiles read from it may still be in use
+type dbSwapper struct {
+	mu        sync.Mutex
+	store     *storage.Swappable
+	cur, prev openedDB
+	// hooks are called with the new DB after every swap
+	hooks []func(s *bbolt.Storage, infos *storage.MapInfos) error
+	// removeDir closed DBs located in this directory are deleted, replica DBs
+	removeDir string
+	// checks makes watch check the DB file without waiting for the interval
+	checks chan struct{}
+	logger log.Logger
+}
+
+func newDBSwapper(db openedDB, logger log.Logger) *dbSwapper {
+	return &dbSwapper{
+		store:  storage.NewSwappable(db.Storage),
+		cur:    db,
+		checks: make(chan struct{}, 1),
+		logger: logger,
+	}
+}
+
+// swap opens the DB at path and serves it
+func (sw *dbSwapper) swap(path string) error {
+	db, infos, err := openDB(path, sw.logger)
+	if err != nil {
+		return err
+	}
+	sw.serve(db, infos)
+	return nil
+}
+
+// serve serves the opened db, replacing the current one
+func (sw *dbSwapper) serve(db openedDB, infos *storage.MapInfos) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.store.Swap(db.Storage)
+	old := sw.prev
+	sw.prev, sw.cur = sw.cur, db
+
+	for _, hook := range sw.hooks {
+		if err := hook(db.Storage, infos); err != nil {
+			level.Error(sw.logger).Log("msg", "DB swap hook failed", "error", err)
+		}
+	}
+
+	level.Info(sw.logger).Log("msg", "serving new DB", "db_path", db.path,
+		"region", infos.Region, "index_time", infos.IndexTime.Format(time.RFC3339))
+
+	sw.closeDB(old)
+}
+
+// stats returns the path, the size and the transactions stats of the served DB
+func (sw *dbSwapper) stats() interface{} {
+	sw.mu.Lock()
+	cur := sw.cur
+	sw.mu.Unlock()
+
+	st := map[string]interface{}{"path": cur.path}
+	if fi, err := os.Stat(cur.path); err == nil {
+		st["size"] = fi.Size()
+	}
+	bs := cur.DB.Stats()
+	st["open_tx"] = bs.OpenTxN
+	st["tx"] = bs.TxN
+	return st
+}
+
+// check makes watch check the DB file now
+func (sw *dbSwapper) check() {
+	select {
+	case sw.checks <- struct{}{}:
+	default:
+	}
+}
+
+// close closes all the opened DBs
+func (sw *dbSwapper) close() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.closeDB(sw.prev)
+	sw.closeDB(sw.cur)
+	sw.prev, sw.cur = openedDB{}, openedDB{}
+}
+
+func (sw *dbSwapper) closeDB(db openedDB) {
+	if db.Storage == nil {
+		return
+	}
+	if err := db.close(); err != nil {
+		level.Warn(sw.logger).Log("msg", "can't close DB", "error", err, "db_path", db.path)
+	}
+	if sw.removeDir != "" && filepath.Dir(db.path) == filepath.Clean(sw.removeDir) {
+		if err := os.Remove(db.path); err != nil {
+			level.Warn(sw.logger).Log("msg", "can't remove DB", "error", err, "db_path", db.path)
+		}
+	}
+}
+
+// watch swaps the DB when the file at path is replaced, polling every interval
+func (sw *dbSwapper) watch(ctx context.Context, path string, interval time.Duration) error {
+	last, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-sw.checks:
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			level.Warn(sw.logger).Log("msg", "can't stat DB", "error", err, "db_path", path)
+			continue
+		}
+		if os.SameFile(fi, last) && fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size() {
+			continue
+		}
+
+		if err := sw.swap(path); err != nil {
+			level.Error(sw.logger).Log("msg", "can't swap DB", "error", err, "db_path", path)
+			continue
+		}
+		last = fi
+	}
+}
@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/akhenakh/kvtiles/internal/flagutil"
+)
+
+// newTLSConfig returns a TLS config for the listeners,
+// returns nil if no certificate was provided.
+// When clientCAPath is set, clients must present a certificate signed by this CA (mTLS).
+func newTLSConfig(certPath, keyPath, clientCAPath string) (*tls.Config, error) {
+	if certPath == "" && keyPath == "" {
+		if clientCAPath != "" {
+			return nil, errors.New("a server certificate is required to verify client certificates")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't load server certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAPath == "" {
+		return cfg, nil
+	}
+
+	pem, err := ioutil.ReadFile(clientCAPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't read client CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid certificate found in client CA %s", clientCAPath)
+	}
+
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return cfg, nil
+}
+
+// listenAndServe serves using TLS if the server has a TLS config,
+// on the socket passed by systemd for its port if any
+func listenAndServe(srv *http.Server) error {
+	ln, err := listen(srv.Addr)
+	if err != nil {
+		return err
+	}
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
+
+// newACMEManager returns an autocert manager obtaining certificates from Let's Encrypt
+// for the comma separated domains list
+func newACMEManager(domains, cacheDir, email string) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(flagutil.SplitList(domains)...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+}
+
+// replicaDialOption returns the credentials used by a replica to connect to its primary,
+// the server certificate is presented and the client CA trusted when TLS is enabled
+func replicaDialOption(cfg *tls.Config) grpc.DialOption {
+	if cfg == nil {
+		return grpc.WithInsecure()
+	}
+
+	return grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		Certificates: cfg.Certificates,
+		RootCAs:      cfg.ClientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}))
+}
@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"syscall"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/internal/activation"
+)
+
+// upgrade starts the executable on the listeners on every signal of sig, the new process terminates this one
+// once ready, it can be a new binary replacing the running one
+func upgrade(ctx context.Context, logger log.Logger, sig <-chan os.Signal) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sig:
+		}
+
+		p, err := activation.Handover(openListeners())
+		if err != nil {
+			level.Error(logger).Log("msg", "can't start the upgraded process", "error", err)
+			continue
+		}
+		level.Info(logger).Log("msg", "upgrading, waiting for the new process to be ready", "pid", p.Pid)
+
+		go func() {
+			// this process is terminated when the new one is ready, it returns earlier on failure
+			state, err := p.Wait()
+			if err != nil {
+				level.Error(logger).Log("msg", "upgraded process failed", "pid", p.Pid, "error", err)
+				return
+			}
+			level.Error(logger).Log("msg", "upgraded process exited", "pid", p.Pid, "state", state)
+		}()
+	}
+}
+
+// terminateParent asks the process that handed over its listeners to drain and exit
+func terminateParent(logger log.Logger, pid int) {
+	p, err := os.FindProcess(pid)
+	if err == nil {
+		err = p.Signal(syscall.SIGTERM)
+	}
+	if err != nil {
+		level.Error(logger).Log("msg", "can't terminate the upgraded process", "pid", pid, "error", err)
+		return
+	}
+	level.Info(logger).Log("msg", "took over the listeners, terminating the upgraded process", "pid", pid)
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package daemon
+
+import "os"
+
+// upgradeSignals is empty, the listeners can't be handed over on this platform
+var upgradeSignals []os.Signal
@@ -0,0 +1,12 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals start the new binary on the listeners
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,15 @@
+// Package flagutil parses the flag values shared by the commands
+package flagutil
+
+import "strings"
+
+// SplitList splits a comma separated flag value, ignoring empty entries
+func SplitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
@@ -0,0 +1,13 @@
+package flagutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitList(t *testing.T) {
+	require.Nil(t, SplitList(""))
+	require.Nil(t, SplitList(" , "))
+	require.Equal(t, []string{"a.db", "b.db"}, SplitList("a.db, b.db,"))
+}
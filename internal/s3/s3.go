@@ -0,0 +1,136 @@
+// Package s3 implements the few S3 requests used by kvtiles, for AWS and the S3 compatible storages
+// such as MinIO or Google Cloud Storage with HMAC keys
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/akhenakh/kvtiles/internal/sigv4"
+)
+
+// Bucket locates a bucket and the credentials to access it
+type Bucket struct {
+	// Endpoint of an S3 compatible storage, using path style URLs, empty for AWS
+	Endpoint string
+	Region   string
+	Name     string
+	// AWS credentials, requests are not signed when empty
+	AWS sigv4.Credentials
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// URL returns the URL of the object key
+func (b Bucket) URL(key string) string {
+	u := &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", b.Name, b.Region), Path: "/" + key}
+	if b.Endpoint != "" {
+		eu, err := url.Parse(strings.TrimSuffix(b.Endpoint, "/"))
+		if err == nil {
+			u = eu
+			u.Path += "/" + b.Name + "/" + key
+		}
+	}
+	return u.String()
+}
+
+// Put uploads size bytes of body to key, sum is the hex encoded sha256 of the content
+func (b Bucket) Put(ctx context.Context, key string, body io.Reader, size int64, sum string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, b.URL(key), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := b.do(req, sum)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Delete deletes the object key
+func (b Bucket) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, b.URL(key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(req, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns the keys starting with prefix, in lexicographic order
+func (b Bucket) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	var token string
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(b.URL(""), "/")+"/?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := b.do(req, "")
+		if err != nil {
+			return nil, err
+		}
+
+		var res struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 list response: %w", err)
+		}
+		for _, c := range res.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// do signs and sends req, sum is the hex encoded sha256 of the payload, empty for no payload,
+// a non 2xx response is an error
+func (b Bucket) do(req *http.Request, sum string) (*http.Response, error) {
+	if b.AWS.AccessKeyID != "" {
+		if sum == "" {
+			sigv4.Sign(req, nil, b.AWS, b.Region, "s3", time.Now())
+		} else {
+			sigv4.SignHash(req, sum, b.AWS, b.Region, "s3", time.Now())
+		}
+	}
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 returned %s for %s %s", resp.Status, req.Method, req.URL.Path)
+	}
+	return resp, nil
+}
@@ -0,0 +1,145 @@
+// Package sigv4 signs HTTP requests to AWS APIs with the Signature Version 4 process
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+)
+
+// Credentials are the AWS credentials used to sign
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Sign adds the authentication headers to req, body is the request payload,
+// the content hash header is only added for s3.
+func Sign(req *http.Request, body []byte, creds Credentials, region, service string, now time.Time) {
+	SignHash(req, hashHex(body), creds, region, service, now)
+}
+
+// SignHash signs as Sign using the hex encoded sha256 of the payload, for payloads not held in memory
+func SignHash(req *http.Request, payloadHash string, creds Credentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(timeFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers, signedHeaders := canonicalHeaders(req)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{now.Format(dateFormat), region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{algorithm, amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), now.Format(dateFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalURI(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+	return p
+}
+
+func canonicalQuery(u *url.URL) string {
+	q := u.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := q[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, escape(k)+"="+escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// canonicalHeaders returns the canonical headers block and the signed headers list,
+// signing the host, the content type and the x-amz-* headers
+func canonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values := map[string]string{"host": host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || strings.HasPrefix(lk, "x-amz-") {
+			values[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k)
+		b.WriteByte(':')
+		b.WriteString(values[k])
+		b.WriteByte('\n')
+	}
+
+	return b.String(), strings.Join(names, ";")
+}
+
+// escape encodes s as required by AWS: RFC 3986 unreserved characters are kept
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,317 @@
+// Package kvtiles embeds the tiles server in other applications: NewHandler serves a TileStore
+// from an existing HTTP server, Run serves a DB on its own listener. kvtilesd is built on it.
+package kvtiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	httpmetrics "github.com/slok/go-http-metrics/metrics"
+	metrics "github.com/slok/go-http-metrics/metrics/prometheus"
+	"github.com/slok/go-http-metrics/middleware"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/akhenakh/kvtiles/server"
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+	"github.com/akhenakh/kvtiles/storage/cache"
+)
+
+// HandlerOptions configures the handler
+type HandlerOptions struct {
+	// AppName names the health service and prefixes the HTTP metrics, kvtiles if empty,
+	// the handlers with the same AppName and Registerer share their metrics
+	AppName string
+	// Registerer registers the HTTP metrics, prometheus.DefaultRegisterer if nil
+	Registerer prometheus.Registerer
+	// Logger defaults to no logging
+	Logger log.Logger
+	// HealthServer reports the status at /healthz, a new one reporting SERVING is used when nil
+	HealthServer *health.Server
+	// TilesKey protects the tiles access when not empty
+	TilesKey string
+	// ServerOptions enable the optional features of the tiles server
+	ServerOptions []server.Option
+	// TilesMiddlewares wrap the tiles route, e.g. referer or IP filters
+	TilesMiddlewares []func(http.Handler) http.Handler
+	// AdminMiddleware authenticates the /admin/ routes, they are disabled when nil unless SeparateAdmin is set
+	AdminMiddleware func(http.Handler) http.Handler
+	// AdminMiddlewares wrap the /admin/ routes before the authentication, e.g. IP filters,
+	// and all the routes of Handler.Admin when SeparateAdmin is set
+	AdminMiddlewares []func(http.Handler) http.Handler
+	// SeparateAdmin serves the /admin/ routes from Handler.Admin instead of Handler, to expose them on their own listener,
+	// the listener must authenticate the clients when AdminMiddleware is nil, e.g. with client certificates
+	SeparateAdmin bool
+	// SecurityHeaders sets the security headers on the responses of the routes when not nil
+	SecurityHeaders *server.SecurityHeaders
+	// DisableUI removes the debug map, the templates, the static files, the styles and the admin dashboard routes,
+	// for API only deployments
+	DisableUI bool
+}
+
+// Handler serves the kvtilesd HTTP API
+type Handler struct {
+	http.Handler
+	// Server handles the tiles requests, e.g. to refresh the map infos after a DB swap
+	Server *server.Server
+	// Admin serves the /admin/ routes when SeparateAdmin is set, nil otherwise
+	Admin http.Handler
+}
+
+// recorderKey identifies the HTTP metrics of the handlers
+type recorderKey struct {
+	registerer prometheus.Registerer
+	prefix     string
+}
+
+var (
+	recordersMu sync.Mutex
+	recorders   = make(map[recorderKey]httpmetrics.Recorder)
+)
+
+// httpRecorder returns the HTTP metrics recorder registered with registerer and prefixed with prefix,
+// created once, as registering the same metrics again panics
+func httpRecorder(registerer prometheus.Registerer, prefix string) httpmetrics.Recorder {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	recordersMu.Lock()
+	defer recordersMu.Unlock()
+	key := recorderKey{registerer: registerer, prefix: prefix}
+	rec, ok := recorders[key]
+	if !ok {
+		rec = metrics.NewRecorder(metrics.Config{Prefix: prefix, Registry: registerer})
+		recorders[key] = rec
+	}
+	return rec
+}
+
+// NewHandler returns a Handler serving the tiles from store
+func NewHandler(store storage.TileStore, opts HandlerOptions) (*Handler, error) {
+	if opts.AppName == "" {
+		opts.AppName = "kvtiles"
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.NewNopLogger()
+	}
+	if opts.HealthServer == nil {
+		opts.HealthServer = health.NewServer()
+		opts.HealthServer.SetServingStatus(fmt.Sprintf("grpc.health.v1.%s", opts.AppName), healthpb.HealthCheckResponse_SERVING)
+	}
+
+	serverOpts := opts.ServerOptions
+	if opts.DisableUI {
+		// applied last, nothing is read from the static and styles dirs
+		serverOpts = append(serverOpts[:len(serverOpts):len(serverOpts)], server.WithStaticDir(""), server.WithStylesDir(""))
+	}
+	srv, err := server.New(opts.AppName, opts.TilesKey, store, opts.Logger, opts.HealthServer, serverOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// metrics middleware.
+	metricsMwr := middleware.New(middleware.Config{
+		Recorder: httpRecorder(opts.Registerer, opts.AppName),
+	})
+
+	r := mux.NewRouter()
+	r.Use(server.RequestIDHandler, srv.AccessLogHandler, srv.GeoIPHandler, srv.RecoverHandler)
+	securityHeaders(r, opts.SecurityHeaders)
+	jsonErrors(r)
+
+	// the data routes share the tiles middlewares
+	data := func(h http.Handler) http.Handler {
+		for _, mw := range opts.TilesMiddlewares {
+			h = mw(h)
+		}
+		return srv.InFlightHandler(srv.LimitHandler(srv.TimeoutHandler(h)))
+	}
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf|png|jpg|jpeg|webp|grid\\.json}", metricsMwr.Handler("/tiles/", data(srv)))
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}@2x.{format:png|jpg|jpeg|webp}", metricsMwr.Handler("/tiles/", data(srv)))
+	r.Handle("/tiles/{date:[0-9]+-[0-9]+-[0-9]+}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf|png|jpg|jpeg|webp|grid\\.json}", metricsMwr.Handler("/tiles/", data(srv)))
+	r.HandleFunc("/snapshots", srv.SnapshotsHandler).Methods("GET")
+	r.Handle("/search", metricsMwr.Handler("/search", data(http.HandlerFunc(srv.SearchHandler)))).Methods("GET")
+	r.Handle("/query", metricsMwr.Handler("/query", data(http.HandlerFunc(srv.QueryHandler)))).Methods("GET")
+	r.Handle("/elevation", metricsMwr.Handler("/elevation", data(http.HandlerFunc(srv.ElevationHandler)))).Methods("GET")
+
+	// serving templates and static files
+	if !opts.DisableUI {
+		r.PathPrefix("/static/").HandlerFunc(srv.StaticHandler)
+		r.HandleFunc("/styles/", srv.StylesHandler).Methods("GET")
+		r.HandleFunc("/styles/{style}.json", srv.StyleHandler).Methods("GET")
+	}
+
+	r.HandleFunc("/healthz", srv.HealthHandler)
+	r.HandleFunc("/livez", srv.LivezHandler)
+	r.HandleFunc("/readyz", srv.ReadyzHandler)
+	r.HandleFunc("/version", srv.VersionHandler)
+	r.HandleFunc("/metadata", srv.MetadataHandler).Methods("GET")
+
+	// admin routes are only exposed behind authentication
+	h := &Handler{Handler: r, Server: srv}
+	var adminRouter *mux.Router
+	if opts.AdminMiddleware != nil || opts.SeparateAdmin {
+		ar := r
+		if opts.SeparateAdmin {
+			ar = mux.NewRouter()
+			ar.Use(server.RequestIDHandler, srv.AccessLogHandler, srv.RecoverHandler)
+			for _, mw := range opts.AdminMiddlewares {
+				ar.Use(mux.MiddlewareFunc(mw))
+			}
+			securityHeaders(ar, opts.SecurityHeaders)
+			jsonErrors(ar)
+			h.Admin = ar
+			adminRouter = ar
+		}
+		admin := ar.PathPrefix("/admin/").Subrouter()
+		if !opts.SeparateAdmin {
+			for _, mw := range opts.AdminMiddlewares {
+				admin.Use(mux.MiddlewareFunc(mw))
+			}
+		}
+		if opts.AdminMiddleware != nil {
+			admin.Use(mux.MiddlewareFunc(opts.AdminMiddleware))
+		}
+		admin.Use(srv.AuditHandler)
+		admin.HandleFunc("/mapinfos", srv.MapInfosHandler).Methods("GET")
+		admin.HandleFunc("/mapinfos", srv.MapInfosWriteHandler).Methods("PUT")
+		admin.HandleFunc("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}", srv.TileWriteHandler).Methods("PUT", "DELETE")
+		admin.HandleFunc("/keys/usage", srv.KeysUsageHandler).Methods("GET")
+		admin.HandleFunc("/backup", srv.BackupHandler).Methods("GET")
+		admin.HandleFunc("/status", srv.AdminStatusHandler).Methods("GET")
+		admin.HandleFunc("/analytics", srv.AnalyticsHandler).Methods("GET")
+		admin.HandleFunc("/actions/{name}", srv.AdminActionHandler).Methods("POST")
+		if !opts.DisableUI {
+			admin.HandleFunc("/", srv.DashboardHandler).Methods("GET")
+		}
+	}
+
+	// the OpenAPI document describes the routes registered above, including the separate admin routes
+	var apiDoc []byte
+	r.HandleFunc("/openapi.json", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(apiDoc)
+	}).Methods("GET")
+	// the capabilities list the endpoints of the API listener
+	var endpoints []string
+	r.HandleFunc("/capabilities", func(w http.ResponseWriter, req *http.Request) {
+		caps := srv.Capabilities()
+		caps.Endpoints = endpoints
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(caps)
+	}).Methods("GET")
+	routers := []*mux.Router{r}
+	if adminRouter != nil {
+		routers = append(routers, adminRouter)
+	}
+	if apiDoc, err = openAPI(srv.Version(), routers...); err != nil {
+		return nil, err
+	}
+	if endpoints, err = routePaths(r); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// jsonErrors replies with JSON errors to the requests not matching the routes of r,
+// the middlewares don't apply to them
+func jsonErrors(r *mux.Router) {
+	r.NotFoundHandler = server.RequestIDHandler(http.HandlerFunc(server.NotFoundHandler))
+	r.MethodNotAllowedHandler = server.RequestIDHandler(http.HandlerFunc(server.MethodNotAllowedHandler))
+}
+
+// securityHeaders sets the security headers h on the responses of the routes of r, if any
+func securityHeaders(r *mux.Router, h *server.SecurityHeaders) {
+	if h != nil {
+		r.Use(server.SecurityHeadersHandler(*h))
+	}
+}
+
+// Config configures Run
+type Config struct {
+	HandlerOptions
+	// DBPath is the path of the DB to serve
+	DBPath string
+	// Addr is the HTTP listening address, e.g. :8080
+	Addr string
+	// CacheSize is the in memory LRU tiles cache size in MB, 0 to disable
+	CacheSize int
+	// ShutdownTimeout is how long in flight requests are waited for on shutdown, 5s if 0
+	ShutdownTimeout time.Duration
+}
+
+// Run serves the DB at cfg.DBPath on cfg.Addr until ctx is done
+func Run(ctx context.Context, cfg Config) error {
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewNopLogger()
+	}
+
+	db, clean, err := bbolt.NewROStorage(cfg.DBPath, cfg.Logger)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer clean()
+
+	_, ok, err := db.LoadMapInfos()
+	if err != nil {
+		return fmt.Errorf("failed to read infos: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("no map infos in %s", cfg.DBPath)
+	}
+
+	var store storage.TileStore = db
+	if cfg.CacheSize > 0 {
+		store = cache.NewLRU(store, int64(cfg.CacheSize)<<20)
+	}
+
+	opts := cfg.HandlerOptions
+	opts.ServerOptions = append([]server.Option{server.WithSearch(db)}, opts.ServerOptions...)
+	h, err := NewHandler(store, opts)
+	if err != nil {
+		return err
+	}
+	h.Server.SetReady(true)
+
+	httpServer := &http.Server{
+		Addr:         cfg.Addr,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		Handler:      h,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- httpServer.ListenAndServe()
+	}()
+	level.Info(cfg.Logger).Log("msg", "HTTP API server listening at "+cfg.Addr)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	h.Server.SetReady(false)
+	timeout := cfg.ShutdownTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		level.Warn(cfg.Logger).Log("msg", "in flight requests cut off on shutdown", "requests", h.Server.InFlight())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package kvtiles
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/server"
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// memStore is a TileStore holding the tile 1/0/0 in the XYZ scheme
+type memStore struct{}
+
+func (memStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	// TMS y 1 is XYZ y 0
+	if z == 1 && x == 0 && y == 1 {
+		return []byte("tile"), nil
+	}
+	return nil, nil
+}
+
+func (memStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 1, Region: "test"}, true, nil
+}
+
+func (memStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func TestNewHandler(t *testing.T) {
+	h, err := NewHandler(memStore{}, HandlerOptions{
+		AppName:       "kvtiles_test",
+		TilesKey:      "secret",
+		ServerOptions: []server.Option{server.WithStaticDir("")},
+	})
+	require.NoError(t, err)
+	h.Server.SetReady(true)
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/tiles/1/0/0.pbf?key=secret", http.StatusOK},
+		{"/tiles/1/0/0.pbf", http.StatusUnauthorized},
+		{"/tiles/1/1/0.pbf?key=secret", http.StatusNotFound},
+		{"/tiles/2/0/0.pbf?key=secret", http.StatusNotFound},
+		{"/version", http.StatusOK},
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusOK},
+		{"/static/index.html", http.StatusNotFound},
+		{"/admin/mapinfos", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + tt.path)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, tt.want, resp.StatusCode)
+		})
+	}
+}
+
+func TestNewHandlerDefaultOptionsTwice(t *testing.T) {
+	// registering the HTTP metrics of the same AppName twice would panic
+	for i := 0; i < 2; i++ {
+		h, err := NewHandler(memStore{}, HandlerOptions{ServerOptions: []server.Option{server.WithStaticDir("")}})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/1/0/0.pbf", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	reg := prometheus.NewRegistry()
+	h, err := NewHandler(memStore{}, HandlerOptions{Registerer: reg, ServerOptions: []server.Option{server.WithStaticDir("")}})
+	require.NoError(t, err)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tiles/1/0/0.pbf", nil))
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	require.NotEmpty(t, mfs)
+	require.Equal(t, "kvtiles_http_request_duration_seconds", mfs[0].GetName())
+}
+
+func TestNewHandlerDisableUI(t *testing.T) {
+	h, err := NewHandler(memStore{}, HandlerOptions{
+		AppName:   "kvtiles_noui_test",
+		DisableUI: true,
+	})
+	require.NoError(t, err)
+	h.Server.SetReady(true)
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	for path, want := range map[string]int{
+		"/tiles/1/0/0.pbf":         http.StatusOK,
+		"/static/index.html":       http.StatusNotFound,
+		"/static/planet.json":      http.StatusNotFound,
+		"/styles/":                 http.StatusNotFound,
+		"/styles/osm-liberty.json": http.StatusNotFound,
+	} {
+		resp, err := http.Get(ts.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, want, resp.StatusCode, path)
+	}
+}
+
+func TestNewHandlerSeparateAdmin(t *testing.T) {
+	h, err := NewHandler(memStore{}, HandlerOptions{
+		AppName:       "kvtiles_admin_test",
+		ServerOptions: []server.Option{server.WithStaticDir("")},
+		SeparateAdmin: true,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, h.Admin)
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	admin := httptest.NewServer(h.Admin)
+	defer admin.Close()
+
+	// the public listener only serves the read only routes
+	resp, err := http.Get(ts.URL + "/admin/mapinfos")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(admin.URL + "/admin/mapinfos")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(admin.URL + "/tiles/1/0/0.pbf")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestNewHandlerAdminIPFilter(t *testing.T) {
+	// httptest.NewRecorder requests come from 192.0.2.1
+	filter, err := server.NewIPFilter(nil, []string{"192.0.2.0/24"}, nil)
+	require.NoError(t, err)
+
+	h, err := NewHandler(memStore{}, HandlerOptions{
+		AppName:          "kvtiles_admin_filter_test",
+		ServerOptions:    []server.Option{server.WithStaticDir("")},
+		AdminMiddleware:  func(next http.Handler) http.Handler { return next },
+		AdminMiddlewares: []func(http.Handler) http.Handler{filter.Handler},
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/admin/mapinfos", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	// the tiles are not filtered without TilesMiddlewares
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/1/0/0.pbf", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// the admin listener is filtered
+	h, err = NewHandler(memStore{}, HandlerOptions{
+		AppName:          "kvtiles_admin_filter_test",
+		ServerOptions:    []server.Option{server.WithStaticDir("")},
+		SeparateAdmin:    true,
+		AdminMiddlewares: []func(http.Handler) http.Handler{filter.Handler},
+	})
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.Admin.ServeHTTP(w, httptest.NewRequest("GET", "/admin/mapinfos", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestNewHandlerOpenAPI(t *testing.T) {
+	h, err := NewHandler(memStore{}, HandlerOptions{
+		AppName:         "kvtiles_openapi_test",
+		ServerOptions:   []server.Option{server.WithStaticDir(""), server.WithVersion("1.2.3")},
+		AdminMiddleware: func(next http.Handler) http.Handler { return next },
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name   string `json:"name"`
+				In     string `json:"in"`
+				Schema struct {
+					Type string   `json:"type"`
+					Enum []string `json:"enum"`
+				} `json:"schema"`
+			} `json:"parameters"`
+			Responses map[string]interface{} `json:"responses"`
+		} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	require.Equal(t, "3.0.3", doc.OpenAPI)
+	require.Equal(t, "1.2.3", doc.Info.Version)
+
+	tiles := doc.Paths["/tiles/{z}/{x}/{y}.{format}"]["get"]
+	require.Equal(t, "z", tiles.Parameters[0].Name)
+	require.Equal(t, "integer", tiles.Parameters[0].Schema.Type)
+	require.Equal(t, []string{"pbf", "png", "jpg", "jpeg", "webp", "grid.json"}, tiles.Parameters[3].Schema.Enum)
+	require.Contains(t, tiles.Responses, "404")
+
+	require.Contains(t, doc.Paths, "/static/planet.json")
+	require.Contains(t, doc.Paths["/admin/actions/{name}"], "post")
+	require.Contains(t, doc.Paths, "/openapi.json")
+
+	// the methods of a path can be documented apart
+	require.Contains(t, doc.Paths["/admin/mapinfos"]["get"].Responses, "200")
+	require.Contains(t, doc.Paths["/admin/mapinfos"]["put"].Responses, "204")
+	require.Contains(t, doc.Paths["/admin/tiles/{z}/{x}/{y}"], "delete")
+}
+
+func TestNewHandlerCapabilities(t *testing.T) {
+	h, err := NewHandler(memStore{}, HandlerOptions{
+		AppName:       "kvtiles_capabilities_test",
+		TilesKey:      "secret",
+		ServerOptions: []server.Option{server.WithStaticDir(""), server.WithVersion("1.2.3")},
+		SeparateAdmin: true,
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/capabilities", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var caps server.Capabilities
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &caps))
+	require.Equal(t, "1.2.3", caps.Version)
+	require.Equal(t, []string{"pbf", "grid.json"}, caps.Formats)
+	require.Equal(t, []string{"tiles_key"}, caps.Auth)
+	require.NotContains(t, caps.Features, "search")
+	require.Equal(t, []server.MapLayout{{Name: "stable", Format: "pbf", Region: "test", MaxZoom: 1}}, caps.Maps)
+
+	// the admin routes are served by another listener
+	require.Contains(t, caps.Endpoints, "/tiles/{z}/{x}/{y}.{format}")
+	require.Contains(t, caps.Endpoints, "/capabilities")
+	require.NotContains(t, caps.Endpoints, "/admin/mapinfos")
+}
+
+func TestNewHandlerJSONErrors(t *testing.T) {
+	h, err := NewHandler(memStore{}, HandlerOptions{
+		AppName:       "kvtiles_errors_test",
+		TilesKey:      "secret",
+		ServerOptions: []server.Option{server.WithStaticDir("")},
+	})
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/tiles/1/0/0.pbf", http.StatusUnauthorized},
+		{"GET", "/unknown", http.StatusNotFound},
+		{"POST", "/search", http.StatusMethodNotAllowed},
+		{"GET", "/static/index.html", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set(server.RequestIDHeader, "req-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		require.Equal(t, tt.want, w.Code, tt.path)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"), tt.path)
+		var body struct {
+			Code      int    `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), tt.path)
+		require.Equal(t, tt.want, body.Code, tt.path)
+		require.NotEmpty(t, body.Message, tt.path)
+		require.Equal(t, "req-1", body.RequestID, tt.path)
+	}
+}
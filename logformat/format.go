@@ -0,0 +1,46 @@
+// Package logformat creates loggers for the supported output formats
+package logformat
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/log/term"
+)
+
+// NewLogger returns a logger writing to w using the format "json|logfmt|console",
+// console is logfmt colorized by level when w is a terminal
+func NewLogger(w io.Writer, format string) (log.Logger, error) {
+	w = log.NewSyncWriter(w)
+
+	switch strings.ToLower(format) {
+	case "json", "":
+		return log.NewJSONLogger(w), nil
+	case "logfmt":
+		return log.NewLogfmtLogger(w), nil
+	case "console":
+		return term.NewLogger(w, log.NewLogfmtLogger, levelColor), nil
+	}
+
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+func levelColor(keyvals ...interface{}) term.FgBgColor {
+	for i := 0; i < len(keyvals)-1; i += 2 {
+		if keyvals[i] != level.Key() {
+			continue
+		}
+		switch keyvals[i+1] {
+		case level.DebugValue():
+			return term.FgBgColor{Fg: term.DarkGray}
+		case level.WarnValue():
+			return term.FgBgColor{Fg: term.Yellow}
+		case level.ErrorValue():
+			return term.FgBgColor{Fg: term.Red}
+		}
+	}
+	return term.FgBgColor{}
+}
@@ -2,6 +2,7 @@ package loglevel
 
 import (
 	"strings"
+	"sync/atomic"
 
 	log "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -22,3 +23,27 @@ func NewLevelFilterFromString(next log.Logger, ls string) log.Logger {
 
 	return level.NewFilter(next, level.AllowAll())
 }
+
+// Switch is a level filter whose level can be changed while logging
+type Switch struct {
+	filter atomic.Value
+	next   log.Logger
+}
+
+// NewSwitch returns a Switch filtering next using the string "DEBUG|INFO|WARN|ERROR",
+// placed in front of the output logger it leaves the context of the loggers above, e.g. log.Caller, untouched
+func NewSwitch(next log.Logger, ls string) *Switch {
+	s := &Switch{next: next}
+	s.SetLevel(ls)
+	return s
+}
+
+// SetLevel changes the level of the filter
+func (s *Switch) SetLevel(ls string) {
+	s.filter.Store(NewLevelFilterFromString(s.next, ls))
+}
+
+// Log implements log.Logger
+func (s *Switch) Log(keyvals ...interface{}) error {
+	return s.filter.Load().(log.Logger).Log(keyvals...)
+}
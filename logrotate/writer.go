@@ -0,0 +1,193 @@
+// Package logrotate writes logs to a file rotated by size and time, keeping a bounded number of rotated files
+package logrotate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeFormat is the rotation time added to the rotated files names, e.g. access-2020-10-01T12-00-00.000.log
+const timeFormat = "2006-01-02T15-04-05.000"
+
+// Options are the rotation and retention settings, zero values disable them
+type Options struct {
+	// MaxSize rotates the file before it grows over this size in bytes
+	MaxSize int64
+	// Interval rotates the file when a new interval starts, e.g. every hour
+	Interval time.Duration
+	// MaxBackups is the number of rotated files kept
+	MaxBackups int
+	// MaxAge removes the rotated files older than this duration
+	MaxAge time.Duration
+}
+
+// Writer is an io.Writer appending to a file rotated according to its Options, safe for concurrent use
+type Writer struct {
+	path string
+	opts Options
+	now  func() time.Time
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// Open opens or creates the file at path for appending
+func Open(path string, opts Options) (*Writer, error) {
+	w := &Writer{path: path, opts: opts, now: time.Now}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("can't open log file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("can't stat log file: %w", err)
+	}
+
+	w.f = f
+	w.size = fi.Size()
+	// an existing file was written since its last modification, not since now
+	w.opened = w.now()
+	if w.size > 0 {
+		w.opened = fi.ModTime()
+	}
+	return nil
+}
+
+// Write appends p to the file, rotating it first when p would overflow MaxSize or an interval has passed
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	now := w.now()
+	if w.size > 0 && (w.opts.MaxSize > 0 && w.size+int64(len(p)) > w.opts.MaxSize ||
+		w.opts.Interval > 0 && !now.Before(w.opened.Truncate(w.opts.Interval).Add(w.opts.Interval))) {
+		if err := w.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate renames the current file with the rotation time and opens a new one
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return os.ErrClosed
+	}
+	return w.rotate(w.now())
+}
+
+func (w *Writer) rotate(now time.Time) error {
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("can't close log file: %w", err)
+	}
+	w.f = nil
+
+	// don't overwrite a file rotated in the same millisecond
+	rotated := now
+	for {
+		if _, err := os.Stat(w.backupName(rotated)); os.IsNotExist(err) {
+			break
+		}
+		rotated = rotated.Add(time.Millisecond)
+	}
+	if err := os.Rename(w.path, w.backupName(rotated)); err != nil {
+		return fmt.Errorf("can't rename log file: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.opened = now
+
+	return w.prune(now)
+}
+
+// backupName returns the name of the file rotated at t, dir/name-time.ext
+func (w *Writer) backupName(t time.Time) string {
+	ext := filepath.Ext(w.path)
+	return w.path[:len(w.path)-len(ext)] + "-" + t.UTC().Format(timeFormat) + ext
+}
+
+// backup is a rotated file
+type backup struct {
+	path    string
+	rotated time.Time
+}
+
+// prune removes the rotated files over MaxBackups or older than MaxAge
+func (w *Writer) prune(now time.Time) error {
+	if w.opts.MaxBackups <= 0 && w.opts.MaxAge <= 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(w.path)
+	base := filepath.Base(w.path)
+	ext := filepath.Ext(base)
+	prefix := base[:len(base)-len(ext)] + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("can't list rotated log files: %w", err)
+	}
+
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.Parse(timeFormat, name[len(prefix):len(name)-len(ext)])
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, name), rotated: t})
+	}
+	// most recent first
+	sort.Slice(backups, func(i, j int) bool { return backups[i].rotated.After(backups[j].rotated) })
+
+	for i, b := range backups {
+		if (w.opts.MaxBackups > 0 && i >= w.opts.MaxBackups) || (w.opts.MaxAge > 0 && now.Sub(b.rotated) > w.opts.MaxAge) {
+			if err := os.Remove(b.path); err != nil {
+				return fmt.Errorf("can't remove rotated log file: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
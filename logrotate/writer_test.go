@@ -0,0 +1,95 @@
+package logrotate
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func files(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWriter_Size(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	w, err := Open(filepath.Join(dir, "access.log"), Options{MaxSize: 10, MaxBackups: 2})
+	require.NoError(t, err)
+	w.now = func() time.Time { return now }
+	defer w.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := w.Write([]byte("12345678\n"))
+		require.NoError(t, err)
+		now = now.Add(time.Second)
+	}
+
+	// the first line was rotated then removed
+	require.Equal(t, []string{
+		"access-2020-10-01T12-00-02.000.log",
+		"access-2020-10-01T12-00-03.000.log",
+		"access.log",
+	}, files(t, dir))
+
+	b, err := os.ReadFile(filepath.Join(dir, "access.log"))
+	require.NoError(t, err)
+	require.Equal(t, "12345678\n", string(b))
+}
+
+func TestWriter_Interval(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2020, 10, 1, 12, 30, 0, 0, time.UTC)
+
+	w, err := Open(filepath.Join(dir, "access.log"), Options{Interval: time.Hour, MaxAge: 2 * time.Hour})
+	require.NoError(t, err)
+	w.now = func() time.Time { return now }
+	w.opened = now
+	defer w.Close()
+
+	write := func() {
+		_, err := w.Write([]byte("line\n"))
+		require.NoError(t, err)
+	}
+
+	write()
+	now = now.Add(20 * time.Minute)
+	write()
+	require.Equal(t, []string{"access.log"}, files(t, dir))
+
+	// a new hour has started
+	now = now.Add(20 * time.Minute)
+	write()
+	require.Equal(t, []string{"access-2020-10-01T13-10-00.000.log", "access.log"}, files(t, dir))
+
+	now = now.Add(time.Hour)
+	write()
+	now = now.Add(time.Hour)
+	write()
+	now = now.Add(time.Hour)
+	write()
+	// the first rotated file is over MaxAge
+	require.Equal(t, []string{
+		"access-2020-10-01T14-10-00.000.log",
+		"access-2020-10-01T15-10-00.000.log",
+		"access-2020-10-01T16-10-00.000.log",
+		"access.log",
+	}, files(t, dir))
+
+	require.NoError(t, w.Rotate())
+	require.Contains(t, files(t, dir), "access-2020-10-01T16-10-00.001.log")
+	require.NoError(t, w.Close())
+	_, err = w.Write([]byte("line\n"))
+	require.ErrorIs(t, err, os.ErrClosed)
+}
@@ -0,0 +1,216 @@
+package mask
+
+import (
+	"math"
+	"sort"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// Clip returns the uncompressed vector tile t without the features outside the mask:
+// points outside are removed, lines are cut at the mask border and polygons not intersecting
+// the mask are removed, polygons crossing the border are kept whole
+func (m *Mask) Clip(t tilemath.Tile, tile []byte) ([]byte, error) {
+	layers, err := mvt.SplitLayers(tile)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := layers[:0]
+	for _, raw := range layers {
+		l, err := mvt.DecodeLayer(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		toWorld := worldProjection(t, l.Extent)
+		features := l.Features[:0]
+		changed := false
+		for _, f := range l.Features {
+			lines, err := mvt.DecodeGeometry(f)
+			if err != nil {
+				return nil, err
+			}
+
+			var clipped [][][2]int64
+			switch f.Type {
+			case mvt.Point:
+				clipped = m.clipPoints(lines, toWorld)
+			case mvt.LineString:
+				clipped = m.clipLines(lines, toWorld)
+			case mvt.Polygon:
+				if m.intersectsPolygon(lines, toWorld) {
+					clipped = lines
+				}
+			default:
+				clipped = lines
+			}
+
+			if len(clipped) == 0 {
+				changed = true
+				continue
+			}
+			if f.Type == mvt.Point || f.Type == mvt.LineString {
+				geom := mvt.EncodeGeometry(f.Type, clipped)
+				if !equalGeometry(geom, f.Geometry) {
+					f.Geometry = geom
+					changed = true
+				}
+			}
+			features = append(features, f)
+		}
+
+		switch {
+		case len(features) == 0:
+			continue
+		case changed:
+			l.Features = features
+			if raw, err = mvt.EncodeLayer(l); err != nil {
+				return nil, err
+			}
+		}
+		kept = append(kept, raw)
+	}
+
+	return mvt.JoinLayers(kept), nil
+}
+
+// worldProjection returns the function converting the tile coordinates to world coordinates
+func worldProjection(t tilemath.Tile, extent uint32) func(p [2]int64) point {
+	n := float64(uint64(1) << t.Z)
+	e := float64(extent)
+	return func(p [2]int64) point {
+		return point{
+			x: (float64(t.X) + float64(p[0])/e) / n,
+			y: (float64(t.Y) + float64(p[1])/e) / n,
+		}
+	}
+}
+
+func (m *Mask) clipPoints(lines [][][2]int64, toWorld func(p [2]int64) point) [][][2]int64 {
+	var points [][2]int64
+	for _, l := range lines {
+		for _, p := range l {
+			if m.contains(toWorld(p)) {
+				points = append(points, p)
+			}
+		}
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	return [][][2]int64{points}
+}
+
+// clipLines returns the parts of the lines inside the mask
+func (m *Mask) clipLines(lines [][][2]int64, toWorld func(p [2]int64) point) [][][2]int64 {
+	var clipped [][][2]int64
+	var cur [][2]int64
+	add := func(p [2]int64) {
+		if len(cur) == 0 || cur[len(cur)-1] != p {
+			cur = append(cur, p)
+		}
+	}
+	flush := func() {
+		if len(cur) > 1 {
+			clipped = append(clipped, cur)
+		}
+		cur = nil
+	}
+
+	for _, l := range lines {
+		for i := 0; i+1 < len(l); i++ {
+			p, q := l[i], l[i+1]
+			wp, wq := toWorld(p), toWorld(q)
+
+			// positions along the segment crossing the mask border
+			ts := []float64{0, 1}
+			m.near(math.Min(wp.x, wq.x), math.Min(wp.y, wq.y), math.Max(wp.x, wq.x), math.Max(wp.y, wq.y), func(e edge) bool {
+				if t, ok := intersection(wp, wq, e.a, e.b); ok && t > 0 && t < 1 {
+					ts = append(ts, t)
+				}
+				return true
+			})
+			sort.Float64s(ts)
+
+			at := func(t float64) [2]int64 {
+				return [2]int64{
+					p[0] + int64(math.Round(t*float64(q[0]-p[0]))),
+					p[1] + int64(math.Round(t*float64(q[1]-p[1]))),
+				}
+			}
+			for j := 0; j+1 < len(ts); j++ {
+				if ts[j] == ts[j+1] {
+					continue
+				}
+				mid := (ts[j] + ts[j+1]) / 2
+				if !m.contains(point{wp.x + mid*(wq.x-wp.x), wp.y + mid*(wq.y-wp.y)}) {
+					flush()
+					continue
+				}
+				add(at(ts[j]))
+				add(at(ts[j+1]))
+			}
+		}
+		flush()
+	}
+	return clipped
+}
+
+// intersectsPolygon returns true if the polygon rings intersect the mask
+func (m *Mask) intersectsPolygon(rings [][][2]int64, toWorld func(p [2]int64) point) bool {
+	world := make([][]point, len(rings))
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for i, r := range rings {
+		world[i] = make([]point, len(r))
+		for j, p := range r {
+			wp := toWorld(p)
+			if m.contains(wp) {
+				return true
+			}
+			world[i][j] = wp
+			minX, maxX = math.Min(minX, wp.x), math.Max(maxX, wp.x)
+			minY, maxY = math.Min(minY, wp.y), math.Max(maxY, wp.y)
+		}
+	}
+
+	// no vertex inside, the polygon crosses the border or holds parts of the mask
+	found := false
+	m.near(minX, minY, maxX, maxY, func(e edge) bool {
+		found = ringsContain(world, e.a)
+		for _, r := range world {
+			for i := 0; !found && i+1 < len(r); i++ {
+				_, found = intersection(r[i], r[i+1], e.a, e.b)
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// ringsContain returns true if p is inside the closed rings, by the even-odd rule
+func ringsContain(rings [][]point, p point) bool {
+	var inside bool
+	for _, r := range rings {
+		for i := 0; i+1 < len(r); i++ {
+			a, b := r[i], r[i+1]
+			if (a.y > p.y) != (b.y > p.y) && p.x < a.x+(p.y-a.y)*(b.x-a.x)/(b.y-a.y) {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+func equalGeometry(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,259 @@
+// Package mask restricts the served tiles to a territory described by GeoJSON polygons
+package mask
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// maxLat is the max latitude of the Web Mercator projection
+const maxLat = 85.0511287798
+
+// Relation is the position of a tile relative to the mask
+type Relation int
+
+// Relations of a tile to the mask
+const (
+	Outside Relation = iota
+	Partial
+	Inside
+)
+
+// point is in Web Mercator world coordinates, from 0 to 1, y pointing down as in the tiles
+type point struct {
+	x, y float64
+}
+
+type edge struct {
+	a, b point
+}
+
+// Mask is a set of polygons, holes and multiple polygons follow the even-odd rule
+type Mask struct {
+	edges                  []edge
+	minX, minY, maxX, maxY float64
+
+	// bands index the edges by latitude bands of bandHeight
+	bands      [][]int32
+	bandHeight float64
+}
+
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Features    []geoJSON       `json:"features"`
+}
+
+// Load reads the GeoJSON mask at path
+func Load(path string) (*Mask, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse returns the mask of a GeoJSON Polygon or MultiPolygon, or of the polygons of a Feature or a FeatureCollection
+func Parse(data []byte) (*Mask, error) {
+	var g geoJSON
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+
+	var rings [][][2]float64
+	if err := g.rings(&rings); err != nil {
+		return nil, err
+	}
+
+	m := &Mask{minX: math.Inf(1), minY: math.Inf(1), maxX: math.Inf(-1), maxY: math.Inf(-1)}
+	for _, r := range rings {
+		if len(r) < 3 {
+			return nil, errors.New("a polygon ring requires at least 3 positions")
+		}
+		for i := range r {
+			a, b := project(r[i]), project(r[(i+1)%len(r)])
+			if a == b {
+				continue
+			}
+			m.edges = append(m.edges, edge{a, b})
+			m.minX, m.maxX = math.Min(m.minX, a.x), math.Max(m.maxX, a.x)
+			m.minY, m.maxY = math.Min(m.minY, a.y), math.Max(m.maxY, a.y)
+		}
+	}
+	if len(m.edges) == 0 {
+		return nil, errors.New("no polygon in the mask")
+	}
+
+	count := len(m.edges) / 4
+	if count < 1 {
+		count = 1
+	}
+	if count > 4096 {
+		count = 4096
+	}
+	m.bands = make([][]int32, count)
+	m.bandHeight = (m.maxY - m.minY) / float64(count)
+	for i, e := range m.edges {
+		for b := m.band(math.Min(e.a.y, e.b.y)); b <= m.band(math.Max(e.a.y, e.b.y)); b++ {
+			m.bands[b] = append(m.bands[b], int32(i))
+		}
+	}
+	return m, nil
+}
+
+func (g *geoJSON) rings(rings *[][][2]float64) error {
+	switch g.Type {
+	case "FeatureCollection":
+		for i := range g.Features {
+			if err := g.Features[i].rings(rings); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if g.Geometry == nil {
+			return nil
+		}
+		return g.Geometry.rings(rings)
+	case "Polygon":
+		var p [][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &p); err != nil {
+			return fmt.Errorf("invalid Polygon: %w", err)
+		}
+		*rings = append(*rings, p...)
+	case "MultiPolygon":
+		var mp [][][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &mp); err != nil {
+			return fmt.Errorf("invalid MultiPolygon: %w", err)
+		}
+		for _, p := range mp {
+			*rings = append(*rings, p...)
+		}
+	default:
+		return fmt.Errorf("unsupported GeoJSON type %q, Polygon or MultiPolygon expected", g.Type)
+	}
+	return nil
+}
+
+// project returns the world coordinates of a lng lat position
+func project(p [2]float64) point {
+	lat := math.Max(-maxLat, math.Min(maxLat, p[1])) * math.Pi / 180
+	return point{
+		x: (p[0] + 180) / 360,
+		y: (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2,
+	}
+}
+
+// band returns the index of the band holding y, clamped to the existing bands
+func (m *Mask) band(y float64) int {
+	if m.bandHeight == 0 {
+		return 0
+	}
+	b := int((y - m.minY) / m.bandHeight)
+	if b < 0 {
+		return 0
+	}
+	if b >= len(m.bands) {
+		return len(m.bands) - 1
+	}
+	return b
+}
+
+// near calls fn with the edges possibly crossing the box, an edge may be seen several times,
+// stops when fn returns false
+func (m *Mask) near(minX, minY, maxX, maxY float64, fn func(e edge) bool) {
+	if maxX < m.minX || minX > m.maxX || maxY < m.minY || minY > m.maxY {
+		return
+	}
+	for b := m.band(minY); b <= m.band(maxY); b++ {
+		for _, i := range m.bands[b] {
+			e := m.edges[i]
+			if math.Max(e.a.x, e.b.x) < minX || math.Min(e.a.x, e.b.x) > maxX ||
+				math.Max(e.a.y, e.b.y) < minY || math.Min(e.a.y, e.b.y) > maxY {
+				continue
+			}
+			if !fn(e) {
+				return
+			}
+		}
+	}
+}
+
+// contains returns true if p is inside the mask
+func (m *Mask) contains(p point) bool {
+	if p.x < m.minX || p.x > m.maxX || p.y < m.minY || p.y > m.maxY {
+		return false
+	}
+
+	var inside bool
+	for _, i := range m.bands[m.band(p.y)] {
+		e := m.edges[i]
+		if (e.a.y > p.y) != (e.b.y > p.y) &&
+			p.x < e.a.x+(p.y-e.a.y)*(e.b.x-e.a.x)/(e.b.y-e.a.y) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Contains returns true if the lat lng position is inside the mask
+func (m *Mask) Contains(lat, lng float64) bool {
+	return m.contains(project([2]float64{lng, lat}))
+}
+
+// Relation returns the position of the tile t relative to the mask
+func (m *Mask) Relation(t tilemath.Tile) Relation {
+	n := float64(uint64(1) << t.Z)
+	x0, y0 := float64(t.X)/n, float64(t.Y)/n
+	x1, y1 := float64(t.X+1)/n, float64(t.Y+1)/n
+
+	crossed := false
+	m.near(x0, y0, x1, y1, func(e edge) bool {
+		crossed = segmentCrossesBox(e.a, e.b, x0, y0, x1, y1)
+		return !crossed
+	})
+	switch {
+	case crossed:
+		return Partial
+	case m.contains(point{(x0 + x1) / 2, (y0 + y1) / 2}):
+		return Inside
+	}
+	return Outside
+}
+
+// segmentCrossesBox returns true if the segment ab intersects the box
+func segmentCrossesBox(a, b point, x0, y0, x1, y1 float64) bool {
+	in := func(p point) bool { return p.x >= x0 && p.x <= x1 && p.y >= y0 && p.y <= y1 }
+	if in(a) || in(b) {
+		return true
+	}
+	corners := [4]point{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}
+	for i := range corners {
+		if _, ok := intersection(a, b, corners[i], corners[(i+1)%4]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// intersection returns the position t along pq of its intersection with the segment ab
+func intersection(p, q, a, b point) (float64, bool) {
+	r := point{q.x - p.x, q.y - p.y}
+	s := point{b.x - a.x, b.y - a.y}
+	d := r.x*s.y - r.y*s.x
+	if d == 0 {
+		return 0, false
+	}
+	ap := point{a.x - p.x, a.y - p.y}
+	t := (ap.x*s.y - ap.y*s.x) / d
+	u := (ap.x*r.y - ap.y*r.x) / d
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return t, true
+}
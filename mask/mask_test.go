@@ -0,0 +1,89 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// square is a feature collection of a 20 degrees square centered on 0,0 with a hole
+const square = `{"type": "FeatureCollection", "features": [{"type": "Feature", "properties": {}, "geometry":
+	{"type": "Polygon", "coordinates": [
+		[[-10, -10], [10, -10], [10, 10], [-10, 10], [-10, -10]],
+		[[-1, -1], [-1, 1], [1, 1], [1, -1], [-1, -1]]
+	]}}]}`
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]byte(square))
+	require.NoError(t, err)
+	require.True(t, m.Contains(5, 5))
+	require.False(t, m.Contains(0, 0))
+	require.False(t, m.Contains(20, 0))
+
+	m, err = Parse([]byte(`{"type": "MultiPolygon", "coordinates": [[[[0, 0], [1, 0], [1, 1], [0, 0]]], [[[50, 50], [51, 50], [51, 51], [50, 50]]]]}`))
+	require.NoError(t, err)
+	require.True(t, m.Contains(50.2, 50.8))
+
+	_, err = Parse([]byte(`{"type": "Point", "coordinates": [0, 0]}`))
+	require.Error(t, err)
+	_, err = Parse([]byte(`{"type": "FeatureCollection", "features": []}`))
+	require.Error(t, err)
+}
+
+func TestRelation(t *testing.T) {
+	m, err := Parse([]byte(square))
+	require.NoError(t, err)
+
+	require.Equal(t, Partial, m.Relation(tilemath.Tile{Z: 0}))
+	require.Equal(t, Inside, m.Relation(tilemath.FromLatLng(5, 5, 8)))
+	require.Equal(t, Outside, m.Relation(tilemath.FromLatLng(0.5, 0.5, 10)))
+	require.Equal(t, Outside, m.Relation(tilemath.FromLatLng(48.8, 2.3, 8)))
+}
+
+func TestClip(t *testing.T) {
+	m, err := Parse([]byte(square))
+	require.NoError(t, err)
+
+	polygon := func(x0, y0, x1, y1 int64) []uint32 {
+		return mvt.EncodeGeometry(mvt.Polygon, [][][2]int64{{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}, {x0, y0}}})
+	}
+	tile, err := mvt.Encode([]mvt.Layer{
+		{Name: "poi", Extent: 4096, Features: []mvt.Feature{
+			{ID: 1, Type: mvt.Point, Geometry: mvt.EncodeGeometry(mvt.Point, [][][2]int64{{{2100, 2100}}})},
+			{ID: 2, Type: mvt.Point, Geometry: mvt.EncodeGeometry(mvt.Point, [][][2]int64{{{100, 100}}})},
+		}},
+		{Name: "roads", Extent: 4096, Features: []mvt.Feature{
+			{ID: 3, Type: mvt.LineString, Geometry: mvt.EncodeGeometry(mvt.LineString, [][][2]int64{{{0, 2100}, {4096, 2100}}})},
+		}},
+		{Name: "landcover", Extent: 4096, Features: []mvt.Feature{
+			{ID: 4, Type: mvt.Polygon, Geometry: polygon(0, 0, 100, 100)},
+			{ID: 5, Type: mvt.Polygon, Geometry: polygon(0, 0, 4096, 4096)},
+			{ID: 6, Type: mvt.Polygon, Geometry: polygon(2000, 2000, 3000, 3000)},
+		}},
+		{Name: "outside", Extent: 4096, Features: []mvt.Feature{
+			{ID: 7, Type: mvt.Point, Geometry: mvt.EncodeGeometry(mvt.Point, [][][2]int64{{{4000, 100}}})},
+		}},
+	})
+	require.NoError(t, err)
+
+	clipped, err := m.Clip(tilemath.Tile{Z: 0}, tile)
+	require.NoError(t, err)
+	layers, err := mvt.Decode(clipped)
+	require.NoError(t, err)
+	require.Len(t, layers, 3)
+
+	require.Len(t, layers[0].Features, 1)
+	require.EqualValues(t, 1, layers[0].Features[0].ID)
+
+	// lng -10 and 10 are at 1934 and 2162
+	lines, err := mvt.DecodeGeometry(layers[1].Features[0])
+	require.NoError(t, err)
+	require.Equal(t, [][][2]int64{{{1934, 2100}, {2162, 2100}}}, lines)
+
+	require.Len(t, layers[2].Features, 2)
+	require.EqualValues(t, 5, layers[2].Features[0].ID)
+	require.EqualValues(t, 6, layers[2].Features[1].ID)
+}
@@ -0,0 +1,108 @@
+//go:build cgo
+// +build cgo
+
+package mbtiles
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	bstorage "github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+// schema is the deduplicated layout written by tilemill and read by Import
+const schema = `
+CREATE TABLE map (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_id TEXT, grid_id TEXT);
+CREATE TABLE images (tile_data BLOB, tile_id TEXT);
+CREATE TABLE metadata (name TEXT, value TEXT);
+CREATE UNIQUE INDEX map_index ON map (zoom_level, tile_column, tile_row);
+CREATE UNIQUE INDEX images_id ON images (tile_id);
+CREATE UNIQUE INDEX name ON metadata (name);
+CREATE VIEW tiles AS SELECT map.zoom_level AS zoom_level, map.tile_column AS tile_column,
+	map.tile_row AS tile_row, images.tile_data AS tile_data
+	FROM map JOIN images ON images.tile_id = map.tile_id;
+`
+
+// Export writes the tiles of s to a new mbtiles file at path
+func Export(s *bstorage.Storage, path string) error {
+	infos, ok, err := s.LoadMapInfos()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no map in DB")
+	}
+
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("can't open mbtiles sqlite: %w", err)
+	}
+	defer database.Close()
+
+	if _, err := database.Exec(schema); err != nil {
+		return fmt.Errorf("can't create mbtiles schema: %w", err)
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	format := infos.Format
+	if format == "" {
+		format = "pbf"
+	}
+	metadata := [][2]string{
+		{"name", infos.Region},
+		{"format", format},
+		{"minzoom", "0"},
+		{"maxzoom", strconv.Itoa(infos.MaxZoom)},
+		{"center", fmt.Sprintf("%f,%f,%d", infos.CenterLng, infos.CenterLat, infos.MaxZoom)},
+	}
+	if infos.Encoding != "" {
+		metadata = append(metadata, [2]string{"encoding", infos.Encoding})
+	}
+	if len(infos.VectorLayers) > 0 {
+		js, err := json.Marshal(map[string]interface{}{"vector_layers": infos.VectorLayers})
+		if err != nil {
+			return fmt.Errorf("can't encode vector layers: %w", err)
+		}
+		metadata = append(metadata, [2]string{"json", string(js)})
+	}
+	for _, m := range metadata {
+		if _, err := tx.Exec("INSERT INTO metadata (name, value) VALUES (?, ?)", m[0], m[1]); err != nil {
+			return err
+		}
+	}
+
+	mapStmt, err := tx.Prepare("INSERT INTO map (zoom_level, tile_column, tile_row, tile_id, grid_id) VALUES (?, ?, ?, ?, '')")
+	if err != nil {
+		return err
+	}
+	imageStmt, err := tx.Prepare("INSERT OR IGNORE INTO images (tile_data, tile_id) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+
+	err = s.ForEachTile(func(z uint8, x, y uint64, data []byte) error {
+		h := sha256.Sum256(data)
+		id := hex.EncodeToString(h[:])
+		if _, err := imageStmt.Exec(data, id); err != nil {
+			return fmt.Errorf("can't write tile %d/%d/%d: %w", z, x, y, err)
+		}
+		if _, err := mapStmt.Exec(z, x, y, id); err != nil {
+			return fmt.Errorf("can't write tile %d/%d/%d: %w", z, x, y, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,189 @@
+//go:build cgo
+// +build cgo
+
+// Package mbtiles converts MBTiles files to kvtiles DBs and back
+package mbtiles
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/akhenakh/kvtiles/cdnpurge"
+	"github.com/akhenakh/kvtiles/cluster"
+	"github.com/akhenakh/kvtiles/events"
+	bstorage "github.com/akhenakh/kvtiles/storage/bbolt"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// ImportConfig configures Import
+type ImportConfig struct {
+	// TilesPath is the mbtiles file imported
+	TilesPath string
+	// DBPath is the DB written
+	DBPath string
+	// CenterLat and CenterLng are used by the debug map
+	CenterLat, CenterLng float64
+	// MaxZoom is the max zoom level imported
+	MaxZoom int
+	// KeyLayout of the tiles keys: zxy|quadkey|hilbert
+	KeyLayout string
+	// MigrateFrom is an existing DB copied using KeyLayout instead of importing TilesPath
+	MigrateFrom string
+	// Shards are the names of the shards the tiles are partitioned across, Shard is the one imported
+	Shards []string
+	Shard  string
+	// ZstdDictSize stores the tiles compressed with a trained zstd dictionary of this size,
+	// 0 to store the tiles gzipped as in the mbtiles, ZstdSamples tiles are used for training
+	ZstdDictSize int
+	ZstdSamples  int
+	// TerrainEncoding records the raster tiles as terrain-RGB tiles with this elevation encoding:
+	// mapbox|terrarium, when the mbtiles metadata has no encoding
+	TerrainEncoding string
+	// SearchZoom is the zoom of the tiles whose named features are indexed for search, 0 to disable
+	SearchZoom int
+	// Purger is called with the data paths once imported, if not nil
+	Purger cdnpurge.Purger
+	// Events receives an import completed event once imported, if not nil
+	Events events.Publisher
+}
+
+// Import converts the mbtiles at cfg.TilesPath to a DB at cfg.DBPath
+func Import(ctx context.Context, cfg ImportConfig, logger log.Logger) error {
+	storage, clean, err := bstorage.NewStorage(cfg.DBPath, logger)
+	if err != nil {
+		return fmt.Errorf("can't open storage for writing: %w", err)
+	}
+	defer clean()
+
+	if err := storage.UseKeyLayout(cfg.KeyLayout); err != nil {
+		return err
+	}
+
+	if cfg.MigrateFrom != "" {
+		src, srcClean, err := bstorage.NewROStorage(cfg.MigrateFrom, logger)
+		if err != nil {
+			return fmt.Errorf("can't open storage to migrate: %w", err)
+		}
+		defer srcClean()
+
+		if err := bstorage.Migrate(src, storage); err != nil {
+			return fmt.Errorf("can't migrate storage: %w", err)
+		}
+		level.Info(logger).Log("msg", "storage migrated", "from", cfg.MigrateFrom, "key_layout", cfg.KeyLayout)
+		if err := buildSearchIndex(cfg, storage, logger); err != nil {
+			return err
+		}
+		return publishCompleted(ctx, cfg, storage)
+	}
+
+	database, err := sql.Open("sqlite3", cfg.TilesPath)
+	if err != nil {
+		return fmt.Errorf("can't read mbtiles sqlite: %w", err)
+	}
+	defer database.Close()
+
+	if len(cfg.Shards) > 0 {
+		ring, err := cluster.NewRing(cfg.Shards)
+		if err != nil {
+			return fmt.Errorf("invalid shards: %w", err)
+		}
+		if !contains(cfg.Shards, cfg.Shard) {
+			return fmt.Errorf("shard %q is not part of shards", cfg.Shard)
+		}
+		// mbtiles rows are in the TMS scheme
+		storage.UseTileFilter(func(z uint8, x, y uint64) bool {
+			return ring.Shard(tilemath.Tile{Z: z, X: x, Y: 1<<z - y - 1}) == cfg.Shard
+		})
+		level.Info(logger).Log("msg", "importing a single shard", "shard", cfg.Shard)
+	}
+
+	if cfg.TerrainEncoding != "" {
+		if err := storage.UseTerrainEncoding(cfg.TerrainEncoding); err != nil {
+			return err
+		}
+	}
+	if cfg.ZstdDictSize > 0 {
+		storage.UseZstdDict(cfg.ZstdSamples, cfg.ZstdDictSize)
+	}
+
+	err = storage.StoreMap(database, cfg.CenterLat, cfg.CenterLng, cfg.MaxZoom, path.Base(cfg.TilesPath))
+	if err != nil {
+		return fmt.Errorf("can't store tiles in db: %w", err)
+	}
+
+	if err := buildSearchIndex(cfg, storage, logger); err != nil {
+		return err
+	}
+
+	if cfg.Purger != nil {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		if err := cfg.Purger.Purge(ctx, cdnpurge.DataPaths); err != nil {
+			return fmt.Errorf("can't purge CDN: %w", err)
+		}
+		level.Info(logger).Log("msg", "CDN purged", "paths", strings.Join(cdnpurge.DataPaths, ","))
+	}
+
+	return publishCompleted(ctx, cfg, storage)
+}
+
+// buildSearchIndex indexes the features names at cfg.SearchZoom if set
+func buildSearchIndex(cfg ImportConfig, storage *bstorage.Storage, logger log.Logger) error {
+	if cfg.SearchZoom <= 0 {
+		return nil
+	}
+	count, err := storage.BuildSearchIndex(uint8(cfg.SearchZoom))
+	if err != nil {
+		return fmt.Errorf("can't build search index: %w", err)
+	}
+	level.Info(logger).Log("msg", "search index built", "zoom", cfg.SearchZoom, "features", count)
+	return nil
+}
+
+// publishCompleted sends an import completed event to cfg.Events
+func publishCompleted(ctx context.Context, cfg ImportConfig, storage *bstorage.Storage) error {
+	if cfg.Events == nil {
+		return nil
+	}
+
+	infos, _, err := storage.LoadMapInfos()
+	if err != nil {
+		return fmt.Errorf("can't read map infos: %w", err)
+	}
+
+	e := events.Event{
+		Type:  events.ImportCompleted,
+		Time:  time.Now(),
+		Attrs: map[string]string{"db_path": cfg.DBPath},
+	}
+	e.Node, _ = os.Hostname()
+	if infos != nil {
+		e.Version = fmt.Sprintf("%s %s", infos.Region, infos.IndexTime.Format(time.RFC3339))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := cfg.Events.Publish(ctx, []events.Event{e}); err != nil {
+		return fmt.Errorf("can't publish import event: %w", err)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+//go:build cgo
+// +build cgo
+
+package mbtiles
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/events"
+	bstorage "github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+type eventsRecorder []events.Event
+
+func (r *eventsRecorder) Publish(ctx context.Context, e []events.Event) error {
+	*r = append(*r, e...)
+	return nil
+}
+
+func (r *eventsRecorder) Close() error {
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	var recorder eventsRecorder
+
+	err := Import(ctx, ImportConfig{
+		TilesPath: "../testdata/hawaii.mbtiles",
+		DBPath:    filepath.Join(dir, "hawaii.db"),
+		CenterLat: 21.315603,
+		CenterLng: -157.858093,
+		MaxZoom:   6,
+		KeyLayout: "hilbert",
+		Events:    &recorder,
+	}, logger)
+	require.NoError(t, err)
+	require.Len(t, recorder, 1)
+	require.Equal(t, events.ImportCompleted, recorder[0].Type)
+	require.NotEmpty(t, recorder[0].Version)
+
+	src, srcClean, err := bstorage.NewROStorage(filepath.Join(dir, "hawaii.db"), logger)
+	require.NoError(t, err)
+	defer srcClean()
+	infos, _, err := src.LoadMapInfos()
+	require.NoError(t, err)
+	// the vector layers of the json metadata, limited to the imported zooms
+	require.NotEmpty(t, infos.VectorLayers)
+	require.Equal(t, "water", infos.VectorLayers[0].ID)
+	require.Equal(t, "String", infos.VectorLayers[0].Fields["class"])
+	require.Equal(t, 6, infos.VectorLayers[0].MaxZoom)
+	require.NoError(t, Export(src, filepath.Join(dir, "export.mbtiles")))
+
+	// importing the export again gives the same tiles
+	err = Import(ctx, ImportConfig{
+		TilesPath: filepath.Join(dir, "export.mbtiles"),
+		DBPath:    filepath.Join(dir, "export.db"),
+		MaxZoom:   6,
+	}, logger)
+	require.NoError(t, err)
+
+	dst, dstClean, err := bstorage.NewROStorage(filepath.Join(dir, "export.db"), logger)
+	require.NoError(t, err)
+	defer dstClean()
+	dstInfos, _, err := dst.LoadMapInfos()
+	require.NoError(t, err)
+	require.Equal(t, infos.VectorLayers, dstInfos.VectorLayers)
+
+	var count int
+	err = src.ForEachTile(func(z uint8, x, y uint64, data []byte) error {
+		count++
+		got, err := dst.ReadTileData(ctx, z, x, y)
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotZero(t, count)
+}
+
+func TestImportTerrain(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	database, err := sql.Open("sqlite3", filepath.Join(dir, "terrain.mbtiles"))
+	require.NoError(t, err)
+	_, err = database.Exec(schema)
+	require.NoError(t, err)
+	_, err = database.Exec(`INSERT INTO metadata (name, value) VALUES ('format', 'png');
+		INSERT INTO map VALUES (0, 0, 0, 'a', '');
+		INSERT INTO images VALUES (x'89504e47', 'a');`)
+	require.NoError(t, err)
+	require.NoError(t, database.Close())
+
+	cfg := ImportConfig{
+		TilesPath:       filepath.Join(dir, "terrain.mbtiles"),
+		DBPath:          filepath.Join(dir, "terrain.db"),
+		MaxZoom:         0,
+		TerrainEncoding: "mapbox",
+	}
+	require.NoError(t, Import(ctx, cfg, logger))
+
+	s, clean, err := bstorage.NewROStorage(cfg.DBPath, logger)
+	require.NoError(t, err)
+	infos, _, err := s.LoadMapInfos()
+	require.NoError(t, err)
+	require.Equal(t, "png", infos.Format)
+	require.Equal(t, "mapbox", infos.Encoding)
+	data, err := s.ReadTileData(ctx, 0, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x89, 'P', 'N', 'G'}, data)
+
+	// the format and the encoding are exported in the metadata
+	require.NoError(t, Export(s, filepath.Join(dir, "export.mbtiles")))
+	require.NoError(t, clean())
+	cfg.TilesPath, cfg.DBPath, cfg.TerrainEncoding = filepath.Join(dir, "export.mbtiles"), filepath.Join(dir, "export.db"), ""
+	require.NoError(t, Import(ctx, cfg, logger))
+	s, clean, err = bstorage.NewROStorage(cfg.DBPath, logger)
+	require.NoError(t, err)
+	defer clean()
+	infos, _, err = s.LoadMapInfos()
+	require.NoError(t, err)
+	require.Equal(t, "mapbox", infos.Encoding)
+
+	cfg.DBPath, cfg.ZstdDictSize = filepath.Join(dir, "zstd.db"), 1024
+	require.Error(t, Import(ctx, cfg, logger))
+}
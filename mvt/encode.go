@@ -0,0 +1,138 @@
+package mvt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// EncodeLayer returns the encoded layer, the properties keys and values are deduplicated
+func EncodeLayer(l Layer) (RawLayer, error) {
+	var keys []string
+	var values []interface{}
+	keyIndex := make(map[string]uint32)
+	valueIndex := make(map[interface{}]uint32)
+
+	var b []byte
+	b = protowire.AppendTag(b, 15, protowire.VarintType)
+	b = protowire.AppendVarint(b, 2)
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, l.Name)
+
+	var fb, packed []byte
+	for _, f := range l.Features {
+		fb = fb[:0]
+		if f.ID != 0 {
+			fb = protowire.AppendTag(fb, 1, protowire.VarintType)
+			fb = protowire.AppendVarint(fb, f.ID)
+		}
+
+		// sorted for a stable output
+		names := make([]string, 0, len(f.Properties))
+		for k := range f.Properties {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		packed = packed[:0]
+		for _, k := range names {
+			v := f.Properties[k]
+			switch v.(type) {
+			case string, float32, float64, int64, uint64, bool:
+			default:
+				return RawLayer{}, fmt.Errorf("unsupported value %v of type %T for %s", v, v, k)
+			}
+
+			ki, ok := keyIndex[k]
+			if !ok {
+				ki = uint32(len(keys))
+				keyIndex[k] = ki
+				keys = append(keys, k)
+			}
+			vi, ok := valueIndex[v]
+			if !ok {
+				vi = uint32(len(values))
+				valueIndex[v] = vi
+				values = append(values, v)
+			}
+			packed = protowire.AppendVarint(packed, uint64(ki))
+			packed = protowire.AppendVarint(packed, uint64(vi))
+		}
+		if len(packed) > 0 {
+			fb = protowire.AppendTag(fb, 2, protowire.BytesType)
+			fb = protowire.AppendBytes(fb, packed)
+		}
+
+		fb = protowire.AppendTag(fb, 3, protowire.VarintType)
+		fb = protowire.AppendVarint(fb, uint64(f.Type))
+
+		packed = packed[:0]
+		for _, c := range f.Geometry {
+			packed = protowire.AppendVarint(packed, uint64(c))
+		}
+		fb = protowire.AppendTag(fb, 4, protowire.BytesType)
+		fb = protowire.AppendBytes(fb, packed)
+
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, fb)
+	}
+
+	for _, k := range keys {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, k)
+	}
+	for _, v := range values {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodeValue(v))
+	}
+
+	b = protowire.AppendTag(b, 5, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(l.Extent))
+
+	return RawLayer{Name: l.Name, Data: b}, nil
+}
+
+// Encode returns the uncompressed vector tile made of layers
+func Encode(layers []Layer) ([]byte, error) {
+	raws := make([]RawLayer, len(layers))
+	for i, l := range layers {
+		var err error
+		if raws[i], err = EncodeLayer(l); err != nil {
+			return nil, fmt.Errorf("layer %s: %w", l.Name, err)
+		}
+	}
+	return JoinLayers(raws), nil
+}
+
+func encodeValue(v interface{}) []byte {
+	var b []byte
+	switch v := v.(type) {
+	case string:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	case float32:
+		b = protowire.AppendTag(b, 2, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, math.Float32bits(v))
+	case float64:
+		b = protowire.AppendTag(b, 3, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case int64:
+		// negative integers are smaller zigzag encoded
+		if v < 0 {
+			b = protowire.AppendTag(b, 6, protowire.VarintType)
+			b = protowire.AppendVarint(b, protowire.EncodeZigZag(v))
+		} else {
+			b = protowire.AppendTag(b, 4, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(v))
+		}
+	case uint64:
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, v)
+	case bool:
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	}
+	return b
+}
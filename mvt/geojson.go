@@ -0,0 +1,125 @@
+package mvt
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// FeatureCollection is a GeoJSON feature collection
+type FeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// GeoJSONFeature is a GeoJSON feature, Layer is the vector tile layer it comes from
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         uint64                 `json:"id,omitempty"`
+	Layer      string                 `json:"layer"`
+	Geometry   Geometry               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Geometry is a GeoJSON geometry
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoJSON returns the features of the layers of the tile t in WGS84 coordinates
+func GeoJSON(layers []Layer, t tilemath.Tile) (*FeatureCollection, error) {
+	fc := &FeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+	for _, l := range layers {
+		project := projection(t, l.Extent)
+		for _, f := range l.Features {
+			g, err := geometry(f, project)
+			if err != nil {
+				return nil, fmt.Errorf("layer %s feature %d: %w", l.Name, f.ID, err)
+			}
+			if g == nil {
+				continue
+			}
+			fc.Features = append(fc.Features, GeoJSONFeature{
+				Type:       "Feature",
+				ID:         f.ID,
+				Layer:      l.Name,
+				Geometry:   *g,
+				Properties: f.Properties,
+			})
+		}
+	}
+	return fc, nil
+}
+
+// projection returns the function converting the tile coordinates to lng lat
+func projection(t tilemath.Tile, extent uint32) func(x, y int64) [2]float64 {
+	n := float64(uint64(1) << t.Z)
+	e := float64(extent)
+	return func(x, y int64) [2]float64 {
+		lng := (float64(t.X)+float64(x)/e)/n*360 - 180
+		lat := math.Atan(math.Sinh(math.Pi*(1-2*(float64(t.Y)+float64(y)/e)/n))) * 180 / math.Pi
+		return [2]float64{lng, lat}
+	}
+}
+
+// geometry decodes the feature commands, nil for unknown geometries
+func geometry(f Feature, project func(x, y int64) [2]float64) (*Geometry, error) {
+	lines, err := DecodeGeometry(f)
+	if err != nil {
+		return nil, err
+	}
+
+	toLngLat := func(line [][2]int64) [][2]float64 {
+		ll := make([][2]float64, len(line))
+		for i, p := range line {
+			ll[i] = project(p[0], p[1])
+		}
+		return ll
+	}
+
+	switch f.Type {
+	case Point:
+		var points [][2]float64
+		for _, l := range lines {
+			points = append(points, toLngLat(l)...)
+		}
+		if len(points) == 1 {
+			return &Geometry{Type: "Point", Coordinates: points[0]}, nil
+		}
+		return &Geometry{Type: "MultiPoint", Coordinates: points}, nil
+	case LineString:
+		var ls [][][2]float64
+		for _, l := range lines {
+			ls = append(ls, toLngLat(l))
+		}
+		if len(ls) == 1 {
+			return &Geometry{Type: "LineString", Coordinates: ls[0]}, nil
+		}
+		return &Geometry{Type: "MultiLineString", Coordinates: ls}, nil
+	case Polygon:
+		// an exterior ring, with a positive area in tile coordinates, starts a new polygon
+		var polygons [][][][2]float64
+		for _, l := range lines {
+			if area(l) > 0 || len(polygons) == 0 {
+				polygons = append(polygons, nil)
+			}
+			polygons[len(polygons)-1] = append(polygons[len(polygons)-1], toLngLat(l))
+		}
+		if len(polygons) == 1 {
+			return &Geometry{Type: "Polygon", Coordinates: polygons[0]}, nil
+		}
+		return &Geometry{Type: "MultiPolygon", Coordinates: polygons}, nil
+	}
+	return nil, nil
+}
+
+// area returns twice the signed area of the ring
+func area(ring [][2]int64) int64 {
+	var a int64
+	for i := 0; i+1 < len(ring); i++ {
+		a += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return a
+}
@@ -0,0 +1,155 @@
+package mvt
+
+import (
+	"fmt"
+	"math"
+)
+
+// geometry commands
+const (
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+// DecodeGeometry returns the point sequences started by a MoveTo of the feature, in tile coordinates,
+// the polygons rings are closed, their last point repeats the first one
+func DecodeGeometry(f Feature) ([][][2]int64, error) {
+	var lines [][][2]int64
+	var x, y int64
+	geom := f.Geometry
+	for len(geom) > 0 {
+		cmd, count := geom[0]&7, int(geom[0]>>3)
+		geom = geom[1:]
+		switch cmd {
+		case cmdMoveTo, cmdLineTo:
+			if len(geom) < 2*count {
+				return nil, fmt.Errorf("truncated geometry")
+			}
+			for i := 0; i < count; i++ {
+				x += int64(decodeZigZag(geom[2*i]))
+				y += int64(decodeZigZag(geom[2*i+1]))
+				if cmd == cmdMoveTo || len(lines) == 0 {
+					lines = append(lines, nil)
+				}
+				lines[len(lines)-1] = append(lines[len(lines)-1], [2]int64{x, y})
+			}
+			geom = geom[2*count:]
+		case cmdClosePath:
+			if len(lines) > 0 && len(lines[len(lines)-1]) > 0 {
+				lines[len(lines)-1] = append(lines[len(lines)-1], lines[len(lines)-1][0])
+			}
+		default:
+			return nil, fmt.Errorf("unknown command %d", cmd)
+		}
+	}
+	return lines, nil
+}
+
+// EncodeGeometry returns the commands of the lines of a feature of type t, as decoded by DecodeGeometry
+func EncodeGeometry(t GeomType, lines [][][2]int64) []uint32 {
+	var geom []uint32
+	var x, y int64
+	moveTo := func(points [][2]int64, cmd uint32) {
+		if len(points) == 0 {
+			return
+		}
+		geom = append(geom, cmd|uint32(len(points))<<3)
+		for _, p := range points {
+			geom = append(geom, encodeZigZag(int32(p[0]-x)), encodeZigZag(int32(p[1]-y)))
+			x, y = p[0], p[1]
+		}
+	}
+
+	switch t {
+	case Point:
+		var points [][2]int64
+		for _, l := range lines {
+			points = append(points, l...)
+		}
+		moveTo(points, cmdMoveTo)
+	case LineString:
+		for _, l := range lines {
+			if len(l) < 2 {
+				continue
+			}
+			moveTo(l[:1], cmdMoveTo)
+			moveTo(l[1:], cmdLineTo)
+		}
+	case Polygon:
+		for _, l := range lines {
+			// the closing point is implied by ClosePath
+			if len(l) > 1 && l[0] == l[len(l)-1] {
+				l = l[:len(l)-1]
+			}
+			if len(l) < 3 {
+				continue
+			}
+			moveTo(l[:1], cmdMoveTo)
+			moveTo(l[1:], cmdLineTo)
+			geom = append(geom, cmdClosePath|1<<3)
+		}
+	}
+	return geom
+}
+
+// Distance returns the distance in tile coordinates from the point x, y to the feature geometry,
+// 0 inside a polygon, +Inf for unknown geometries
+func Distance(f Feature, x, y float64) (float64, error) {
+	lines, err := DecodeGeometry(f)
+	if err != nil {
+		return 0, err
+	}
+	return GeometryDistance(f.Type, lines, x, y), nil
+}
+
+// GeometryDistance returns the distance from the point x, y to the lines of a geometry of type t, as decoded by DecodeGeometry
+func GeometryDistance(t GeomType, lines [][][2]int64, x, y float64) float64 {
+	d := math.Inf(1)
+	switch t {
+	case Point:
+		for _, l := range lines {
+			for _, p := range l {
+				d = math.Min(d, math.Hypot(float64(p[0])-x, float64(p[1])-y))
+			}
+		}
+	case LineString, Polygon:
+		inside := false
+		for _, l := range lines {
+			for i := 0; i+1 < len(l); i++ {
+				ax, ay := float64(l[i][0]), float64(l[i][1])
+				bx, by := float64(l[i+1][0]), float64(l[i+1][1])
+				d = math.Min(d, segmentDistance(x, y, ax, ay, bx, by))
+				// even-odd rule, holes and multiple polygons included
+				if (ay > y) != (by > y) && x < ax+(y-ay)*(bx-ax)/(by-ay) {
+					inside = !inside
+				}
+			}
+			if len(l) == 1 {
+				d = math.Min(d, math.Hypot(float64(l[0][0])-x, float64(l[0][1])-y))
+			}
+		}
+		if t == Polygon && inside {
+			return 0
+		}
+	}
+	return d
+}
+
+// segmentDistance returns the distance from p to the segment ab
+func segmentDistance(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/l))
+	}
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
+
+func decodeZigZag(n uint32) int32 {
+	return int32(n>>1) ^ -int32(n&1)
+}
+
+func encodeZigZag(n int32) uint32 {
+	return uint32((n << 1) ^ (n >> 31))
+}
@@ -0,0 +1,240 @@
+// Package mvt decodes Mapbox vector tiles, to inspect the served tiles
+package mvt
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// GeomType is the geometry type of a feature
+type GeomType int
+
+// Geometry types
+const (
+	Unknown GeomType = iota
+	Point
+	LineString
+	Polygon
+)
+
+// Layer is a layer of a vector tile
+type Layer struct {
+	Name     string
+	Extent   uint32
+	Features []Feature
+}
+
+// Feature is a feature of a layer, Geometry holds the encoded commands in tile coordinates,
+// the properties values are string, float32, float64, int64, uint64 or bool
+type Feature struct {
+	ID         uint64
+	Type       GeomType
+	Properties map[string]interface{}
+	Geometry   []uint32
+}
+
+// RawLayer is an encoded layer, to select or combine the layers of tiles without decoding them
+type RawLayer struct {
+	Name string
+	Data []byte
+}
+
+// SplitLayers returns the encoded layers of an uncompressed vector tile, Data points into data
+func SplitLayers(data []byte) ([]RawLayer, error) {
+	var layers []RawLayer
+	err := fields(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		if num != 3 || typ != protowire.BytesType {
+			return nil
+		}
+		var name string
+		err := fields(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+			if num == 1 {
+				name = string(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		layers = append(layers, RawLayer{Name: name, Data: v})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid vector tile: %w", err)
+	}
+	return layers, nil
+}
+
+// JoinLayers returns the uncompressed vector tile made of layers
+func JoinLayers(layers []RawLayer) []byte {
+	var size int
+	for _, l := range layers {
+		size += len(l.Data) + 6
+	}
+	b := make([]byte, 0, size)
+	for _, l := range layers {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, l.Data)
+	}
+	return b
+}
+
+// Decode parses an uncompressed vector tile
+func Decode(data []byte) ([]Layer, error) {
+	raws, err := SplitLayers(data)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make([]Layer, len(raws))
+	for i, raw := range raws {
+		if layers[i], err = DecodeLayer(raw); err != nil {
+			return nil, fmt.Errorf("invalid vector tile: %w", err)
+		}
+	}
+	return layers, nil
+}
+
+// DecodeLayer parses an encoded layer
+func DecodeLayer(raw RawLayer) (Layer, error) {
+	return decodeLayer(raw.Data)
+}
+
+func decodeLayer(data []byte) (Layer, error) {
+	l := Layer{Extent: 4096}
+	var keys []string
+	var values []interface{}
+	var features [][]byte
+	err := fields(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			l.Name = string(v)
+		case 2:
+			features = append(features, v)
+		case 3:
+			keys = append(keys, string(v))
+		case 4:
+			value, err := decodeValue(v)
+			if err != nil {
+				return err
+			}
+			values = append(values, value)
+		case 5:
+			l.Extent = uint32(n)
+		}
+		return nil
+	})
+	if err != nil {
+		return l, err
+	}
+
+	// features are decoded once the keys and values are known
+	for _, data := range features {
+		f := Feature{Properties: make(map[string]interface{})}
+		var tags []uint32
+		err := fields(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+			switch num {
+			case 1:
+				f.ID = n
+			case 2:
+				return packed(typ, v, n, &tags)
+			case 3:
+				f.Type = GeomType(n)
+			case 4:
+				return packed(typ, v, n, &f.Geometry)
+			}
+			return nil
+		})
+		if err != nil {
+			return l, err
+		}
+		for i := 0; i+1 < len(tags); i += 2 {
+			if int(tags[i]) >= len(keys) || int(tags[i+1]) >= len(values) {
+				return l, fmt.Errorf("invalid tag in layer %s", l.Name)
+			}
+			f.Properties[keys[tags[i]]] = values[tags[i+1]]
+		}
+		l.Features = append(l.Features, f)
+	}
+	return l, nil
+}
+
+func decodeValue(data []byte) (interface{}, error) {
+	var value interface{}
+	err := fields(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			value = string(v)
+		case 2:
+			value = math.Float32frombits(uint32(n))
+		case 3:
+			value = math.Float64frombits(n)
+		case 4:
+			value = int64(n)
+		case 5:
+			value = n
+		case 6:
+			value = protowire.DecodeZigZag(n)
+		case 7:
+			value = n != 0
+		}
+		return nil
+	})
+	return value, err
+}
+
+// fields calls fn for every field of the message, v is set for the bytes fields, n for the others
+func fields(data []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(data) > 0 {
+		num, typ, l := protowire.ConsumeTag(data)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		data = data[l:]
+
+		var v []byte
+		var n uint64
+		switch typ {
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(data)
+		case protowire.Fixed32Type:
+			var n32 uint32
+			n32, l = protowire.ConsumeFixed32(data)
+			n = uint64(n32)
+		case protowire.Fixed64Type:
+			n, l = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(data)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		data = data[l:]
+
+		if err := fn(num, typ, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packed appends a repeated uint32 field to dst, packed or not
+func packed(typ protowire.Type, data []byte, n uint64, dst *[]uint32) error {
+	if typ != protowire.BytesType {
+		*dst = append(*dst, uint32(n))
+		return nil
+	}
+	for len(data) > 0 {
+		n, l := protowire.ConsumeVarint(data)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		*dst = append(*dst, uint32(n))
+		data = data[l:]
+	}
+	return nil
+}
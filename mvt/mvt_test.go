@@ -0,0 +1,167 @@
+package mvt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendPacked(b []byte, num protowire.Number, vs ...uint32) []byte {
+	var p []byte
+	for _, v := range vs {
+		p = protowire.AppendVarint(p, uint64(v))
+	}
+	return appendBytes(b, num, p)
+}
+
+func zz(n int32) uint32 {
+	return uint32(protowire.EncodeZigZag(int64(n)))
+}
+
+// testTile returns a tile with a water layer holding a point and a polygon
+func testTile() []byte {
+	var point, square, layer, tile []byte
+
+	point = appendVarint(point, 1, 7)
+	point = appendPacked(point, 2, 0, 0)
+	point = appendVarint(point, 3, uint64(Point))
+	point = appendPacked(point, 4, 1<<3|cmdMoveTo, zz(2048), zz(2048))
+
+	// the exterior ring is clockwise in tile coordinates, y pointing down
+	square = appendVarint(square, 3, uint64(Polygon))
+	square = appendPacked(square, 4,
+		1<<3|cmdMoveTo, zz(0), zz(0),
+		3<<3|cmdLineTo, zz(4096), zz(0), zz(0), zz(4096), zz(-4096), zz(0),
+		1<<3|cmdClosePath)
+
+	var value []byte
+	value = appendBytes(value, 1, []byte("ocean"))
+
+	layer = appendBytes(layer, 1, []byte("water"))
+	layer = appendBytes(layer, 2, point)
+	layer = appendBytes(layer, 2, square)
+	layer = appendBytes(layer, 3, []byte("class"))
+	layer = appendBytes(layer, 4, value)
+	layer = appendVarint(layer, 5, 4096)
+	layer = appendVarint(layer, 15, 2)
+	tile = appendBytes(tile, 3, layer)
+	return tile
+}
+
+func TestGeoJSON(t *testing.T) {
+	layers, err := Decode(testTile())
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	require.Equal(t, "water", layers[0].Name)
+	require.Len(t, layers[0].Features, 2)
+
+	fc, err := GeoJSON(layers, tilemath.Tile{Z: 1, X: 0, Y: 0})
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 2)
+
+	p := fc.Features[0]
+	require.Equal(t, "water", p.Layer)
+	require.EqualValues(t, 7, p.ID)
+	require.Equal(t, "ocean", p.Properties["class"])
+	require.Equal(t, "Point", p.Geometry.Type)
+	coords := p.Geometry.Coordinates.([2]float64)
+	require.InDeltaSlice(t, []float64{-90, 66.5133}, coords[:], 1e-4)
+
+	sq := fc.Features[1].Geometry
+	require.Equal(t, "Polygon", sq.Type)
+	rings := sq.Coordinates.([][][2]float64)
+	require.Len(t, rings, 1)
+	require.Len(t, rings[0], 5)
+	require.InDeltaSlice(t, []float64{-180, 85.0511}, rings[0][0][:], 1e-4)
+	require.InDeltaSlice(t, []float64{0, 85.0511}, rings[0][1][:], 1e-4)
+	require.InDeltaSlice(t, []float64{0, 0}, rings[0][2][:], 1e-4)
+	require.Equal(t, rings[0][0], rings[0][4])
+
+	_, err = Decode([]byte{0x1a, 0xff})
+	require.Error(t, err)
+}
+
+func TestEncode(t *testing.T) {
+	layers, err := Decode(testTile())
+	require.NoError(t, err)
+	layers = append(layers, Layer{Name: "poi", Extent: 4096, Features: []Feature{{
+		Type:       Point,
+		Properties: map[string]interface{}{"name": "shop", "rank": int64(-2), "height": float32(1.5), "open": true, "id": uint64(3)},
+		Geometry:   []uint32{1<<3 | cmdMoveTo, zz(10), zz(10)},
+	}}})
+
+	data, err := Encode(layers)
+	require.NoError(t, err)
+	got, err := Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, layers, got)
+
+	raws, err := SplitLayers(data)
+	require.NoError(t, err)
+	require.Len(t, raws, 2)
+	require.Equal(t, "poi", raws[1].Name)
+
+	// keeping a single layer
+	got, err = Decode(JoinLayers(raws[1:]))
+	require.NoError(t, err)
+	require.Equal(t, layers[1:], got)
+}
+
+func TestEncodeGeometry(t *testing.T) {
+	layers, err := Decode(testTile())
+	require.NoError(t, err)
+
+	for _, f := range layers[0].Features {
+		lines, err := DecodeGeometry(f)
+		require.NoError(t, err)
+		require.Equal(t, f.Geometry, EncodeGeometry(f.Type, lines))
+	}
+
+	lines := [][][2]int64{{{1, 1}, {10, -5}}, {{3, 3}}}
+	got, err := DecodeGeometry(Feature{Type: LineString, Geometry: EncodeGeometry(LineString, lines)})
+	require.NoError(t, err)
+	// a line needs two points
+	require.Equal(t, lines[:1], got)
+}
+
+func TestDistance(t *testing.T) {
+	feature := func(typ GeomType, lines [][][2]int64) Feature {
+		return Feature{Type: typ, Geometry: EncodeGeometry(typ, lines)}
+	}
+	square := [][2]int64{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	hole := [][2]int64{{4, 4}, {4, 6}, {6, 6}, {6, 4}, {4, 4}}
+
+	tests := []struct {
+		name string
+		f    Feature
+		x, y float64
+		want float64
+	}{
+		{"point", feature(Point, [][][2]int64{{{3, 4}, {100, 100}}}), 0, 0, 5},
+		{"line", feature(LineString, [][][2]int64{{{0, 0}, {10, 0}}}), 5, 3, 3},
+		{"line end", feature(LineString, [][][2]int64{{{0, 0}, {10, 0}}}), 13, 4, 5},
+		{"inside polygon", feature(Polygon, [][][2]int64{square}), 2, 2, 0},
+		{"outside polygon", feature(Polygon, [][][2]int64{square}), 12, 5, 2},
+		{"in the hole", feature(Polygon, [][][2]int64{square, hole}), 5, 5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Distance(tt.f, tt.x, tt.y)
+			require.NoError(t, err)
+			require.InDelta(t, tt.want, d, 1e-9)
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package kvtiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPIParam is a query param of an operation
+type openAPIParam struct {
+	name        string
+	description string
+	typ         string
+	required    bool
+}
+
+// openAPIOperation documents a route in the OpenAPI document
+type openAPIOperation struct {
+	// path overrides the OpenAPI path, e.g. a file served by a prefix route
+	path        string
+	tag         string
+	summary     string
+	params      []openAPIParam
+	contentType string
+	// status is the success status, 200 when 0, without content when contentType is empty
+	status int
+	// errors are the error statuses returned as a JSON error
+	errors map[int]string
+}
+
+var (
+	keyParam    = openAPIParam{name: "key", description: "tiles key or API key, when the server requires one", typ: "string"}
+	pointParams = []openAPIParam{
+		{name: "lat", description: "latitude", typ: "number", required: true},
+		{name: "lng", description: "longitude", typ: "number", required: true},
+		{name: "zoom", description: "zoom of the tile read, the map max zoom by default", typ: "integer"},
+		keyParam,
+	}
+	dataErrors = map[int]string{
+		http.StatusBadRequest:          "invalid params",
+		http.StatusUnauthorized:        "missing or invalid key",
+		http.StatusForbidden:           "zoom not allowed for the key",
+		http.StatusTooManyRequests:     "key quota exceeded",
+		http.StatusServiceUnavailable:  "standby server or request timed out",
+		http.StatusInternalServerError: "storage error",
+	}
+	writeErrors = map[int]string{
+		http.StatusBadRequest:          "invalid body or tile coordinates",
+		http.StatusNotFound:            "writes not enabled",
+		http.StatusServiceUnavailable:  "not the raft leader",
+		http.StatusInternalServerError: "write failed",
+	}
+)
+
+// openAPIOperations documents the routes of the handler by their path, the route templates without the patterns,
+// or by their method and path when the methods of a path differ
+var openAPIOperations = map[string]openAPIOperation{
+	"/tiles/{z}/{x}/{y}.{format}": {
+		tag: "tiles", summary: "Tile in the XYZ scheme, as stored or as UTFGrid with the grid.json format",
+		params: []openAPIParam{
+			keyParam,
+			{name: "layers", description: "comma separated layers kept in the vector tile", typ: "string"},
+			{name: "redact", description: "redaction rules applied to the features, requires a trusted key", typ: "string"},
+			{name: "lang", description: "language of the features names, e.g. fr", typ: "string"},
+			{name: "callback", description: "JSONP callback of a UTFGrid", typ: "string"},
+			{name: "expires", description: "expiration of a signed URL, as a unix time", typ: "integer"},
+			{name: "signature", description: "HMAC of a signed URL", typ: "string"},
+		},
+		contentType: "application/octet-stream",
+		errors:      mergeErrors(dataErrors, map[int]string{http.StatusNotFound: "no tile at this position"}),
+	},
+	"/tiles/{z}/{x}/{y}@2x.{format}": {
+		tag: "tiles", summary: "High-DPI raster tile in the XYZ scheme, stored, stitched from the next zoom tiles or upscaled",
+		params: []openAPIParam{
+			keyParam,
+			{name: "expires", description: "expiration of a signed URL, as a unix time", typ: "integer"},
+			{name: "signature", description: "HMAC of a signed URL", typ: "string"},
+		},
+		contentType: "image/png",
+		errors:      mergeErrors(dataErrors, map[int]string{http.StatusNotFound: "no tile at this position or a vector map"}),
+	},
+	"/tiles/{date}/{z}/{x}/{y}.{format}": {
+		tag: "tiles", summary: "Tile in the XYZ scheme of the map as of date, YYYY-MM-DD, from the last snapshot at or before it",
+		params: []openAPIParam{
+			keyParam,
+			{name: "layers", description: "comma separated layers kept in the vector tile", typ: "string"},
+			{name: "redact", description: "redaction rules applied to the features, requires a trusted key", typ: "string"},
+			{name: "lang", description: "language of the features names, e.g. fr", typ: "string"},
+			{name: "callback", description: "JSONP callback of a UTFGrid", typ: "string"},
+			{name: "expires", description: "expiration of a signed URL, as a unix time", typ: "integer"},
+			{name: "signature", description: "HMAC of a signed URL", typ: "string"},
+		},
+		contentType: "application/octet-stream",
+		errors:      mergeErrors(dataErrors, map[int]string{http.StatusNotFound: "no tile at this position or no snapshot at this date"}),
+	},
+	"/snapshots": {
+		tag: "tiles", summary: "Dates of the map snapshots served under /tiles/{date}/",
+		contentType: "application/json",
+	},
+	"/search": {
+		tag: "data", summary: "Features whose name matches q, as GeoJSON",
+		params: []openAPIParam{
+			{name: "q", description: "searched name", typ: "string", required: true},
+			{name: "limit", description: "max features returned", typ: "integer"},
+			keyParam,
+		},
+		contentType: "application/geo+json",
+		errors:      mergeErrors(dataErrors, map[int]string{http.StatusNotFound: "no search index"}),
+	},
+	"/query": {
+		tag: "data", summary: "Features of the tile covering a point, as GeoJSON",
+		params: append(pointParams[:len(pointParams):len(pointParams)],
+			openAPIParam{name: "layers", description: "comma separated layers queried", typ: "string"},
+			openAPIParam{name: "redact", description: "redaction rules applied to the features, requires a trusted key", typ: "string"},
+			openAPIParam{name: "lang", description: "language of the features names, e.g. fr", typ: "string"},
+		),
+		contentType: "application/geo+json",
+		errors:      dataErrors,
+	},
+	"/elevation": {
+		tag: "data", summary: "Elevation in meters at a point, from the terrain-RGB tiles",
+		params:      pointParams,
+		contentType: "application/json",
+		errors:      mergeErrors(dataErrors, map[int]string{http.StatusNotFound: "no terrain tiles or point outside of the map"}),
+	},
+	"/static/": {
+		path: "/static/planet.json",
+		tag:  "ui", summary: "TileJSON of the map, the other static files are served under /static/",
+		contentType: "application/json",
+	},
+	"/styles/": {
+		tag: "ui", summary: "Names of the map styles",
+		contentType: "application/json",
+	},
+	"/styles/{style}.json": {
+		tag: "ui", summary: "Map style templated with the tiles URL",
+		contentType: "application/json",
+		errors:      map[int]string{http.StatusNotFound: "unknown style"},
+	},
+	"/healthz": {
+		tag: "health", summary: "gRPC health status of the server, 500 unless SERVING",
+		contentType: "application/json",
+	},
+	"/livez": {
+		tag: "health", summary: "The process is up",
+		contentType: "application/json",
+	},
+	"/readyz": {
+		tag: "health", summary: "Checks of the server readiness, 503 when not ready",
+		contentType: "application/json",
+	},
+	"/version": {
+		tag: "health", summary: "Application version and map infos",
+		contentType: "application/json",
+	},
+	"/metadata": {
+		tag: "health", summary: "MBTiles metadata of the map, with the vector layers, their fields and zoom ranges",
+		contentType: "application/json",
+		errors:      map[int]string{http.StatusNotFound: "no map in DB"},
+	},
+	"/capabilities": {
+		tag: "health", summary: "Enabled formats, authentication methods, features, endpoints and maps",
+		contentType: "application/json",
+	},
+	"/openapi.json": {
+		tag: "health", summary: "This OpenAPI document",
+		contentType: "application/json",
+	},
+	"/admin/mapinfos": {
+		tag: "admin", summary: "Map infos stored in the DB",
+		contentType: "application/json",
+	},
+	"PUT /admin/mapinfos": {
+		tag: "admin", summary: "Replaces the map infos by the JSON body, the writes are replicated by raft",
+		status: http.StatusNoContent,
+		errors: writeErrors,
+	},
+	"/admin/tiles/{z}/{x}/{y}": {
+		tag: "admin", summary: "Stores the body as the tile in the XYZ scheme with PUT, deletes it with DELETE, the writes are replicated by raft",
+		status: http.StatusNoContent,
+		errors: writeErrors,
+	},
+	"/admin/keys/usage": {
+		tag: "admin", summary: "Current month usage of the API keys",
+		contentType: "application/json",
+	},
+	"/admin/backup": {
+		tag: "admin", summary: "Consistent copy of the DB",
+		params:      []openAPIParam{{name: "gzip", description: "gzip the copy", typ: "boolean"}},
+		contentType: "application/octet-stream",
+	},
+	"/admin/status": {
+		tag: "admin", summary: "Map infos, requests and caches counters, recent errors and actions",
+		contentType: "application/json",
+	},
+	"/admin/analytics": {
+		tag: "admin", summary: "Most requested tiles",
+		params: []openAPIParam{
+			{name: "zoom", description: "tiles counted as their parent at this zoom", typ: "integer"},
+			{name: "limit", description: "max tiles returned", typ: "integer"},
+			{name: "format", description: "json or geojson", typ: "string"},
+		},
+		contentType: "application/json",
+		errors:      map[int]string{http.StatusBadRequest: "invalid params", http.StatusNotFound: "analytics not enabled"},
+	},
+	"/admin/actions/{name}": {
+		tag: "admin", summary: "Runs an admin action, requires an X-Requested-With header",
+		contentType: "application/json",
+		errors: map[int]string{
+			http.StatusForbidden:           "missing X-Requested-With header",
+			http.StatusNotFound:            "unknown action",
+			http.StatusInternalServerError: "action failed",
+		},
+	},
+	"/admin/": {
+		tag: "admin", summary: "Admin dashboard",
+		contentType: "text/html",
+	},
+}
+
+func mergeErrors(maps ...map[int]string) map[int]string {
+	m := make(map[int]string)
+	for _, errs := range maps {
+		for code, desc := range errs {
+			m[code] = desc
+		}
+	}
+	return m
+}
+
+// routeVar matches the variables of a route template, e.g. {z:[0-9]+}
+var routeVar = regexp.MustCompile(`\{(\w+)(?::([^}]+))?\}`)
+
+// openAPIPath returns the OpenAPI path of a route template and its path params, typed from their patterns
+func openAPIPath(tpl string) (string, []interface{}) {
+	var params []interface{}
+	for _, m := range routeVar.FindAllStringSubmatch(tpl, -1) {
+		schema := map[string]interface{}{"type": "string"}
+		switch {
+		case m[2] == "[0-9]+":
+			schema["type"] = "integer"
+		case strings.Contains(m[2], "|"):
+			schema["enum"] = strings.Split(strings.ReplaceAll(m[2], `\.`, "."), "|")
+		}
+		params = append(params, map[string]interface{}{"name": m[1], "in": "path", "required": true, "schema": schema})
+	}
+	return routeVar.ReplaceAllString(tpl, "{$1}"), params
+}
+
+// routePaths returns the sorted OpenAPI paths of the routes registered on r
+func routePaths(r *mux.Router) ([]string, error) {
+	seen := make(map[string]bool)
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		path, _ := openAPIPath(tpl)
+		if op := openAPIOperations[path]; op.path != "" {
+			path = op.path
+		}
+		seen[path] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(seen))
+	for path := range seen {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// openAPI returns the OpenAPI 3 document of the routes registered on routers,
+// a route missing from openAPIOperations is an error
+func openAPI(version string, routers ...*mux.Router) ([]byte, error) {
+	paths := make(map[string]map[string]interface{})
+	tags := make(map[string]bool)
+
+	walk := func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		// subrouters have no handler, their routes are walked
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		path, pathParams := openAPIPath(tpl)
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+
+		for _, m := range methods {
+			// an operation of a single method overrides the one of the path
+			op, ok := openAPIOperations[m+" "+path]
+			if !ok {
+				op, ok = openAPIOperations[path]
+			}
+			if !ok {
+				return fmt.Errorf("route %s is not documented in the OpenAPI document", tpl)
+			}
+			opPath := path
+			if op.path != "" {
+				opPath = op.path
+			}
+
+			params := pathParams
+			for _, p := range op.params {
+				params = append(params, map[string]interface{}{
+					"name": p.name, "in": "query", "description": p.description,
+					"required": p.required, "schema": map[string]interface{}{"type": p.typ},
+				})
+			}
+
+			success := map[string]interface{}{"description": http.StatusText(http.StatusOK)}
+			status := http.StatusOK
+			if op.status != 0 {
+				status = op.status
+				success["description"] = http.StatusText(status)
+			}
+			if op.contentType != "" {
+				success["content"] = map[string]interface{}{op.contentType: map[string]interface{}{}}
+			}
+			responses := map[string]interface{}{fmt.Sprint(status): success}
+			for code, desc := range op.errors {
+				responses[fmt.Sprint(code)] = map[string]interface{}{
+					"description": desc,
+					"content": map[string]interface{}{"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+					}},
+				}
+			}
+
+			if paths[opPath] == nil {
+				paths[opPath] = make(map[string]interface{})
+			}
+			operation := map[string]interface{}{
+				"tags":      []string{op.tag},
+				"summary":   op.summary,
+				"responses": responses,
+			}
+			if len(params) > 0 {
+				operation["parameters"] = params
+			}
+			paths[opPath][strings.ToLower(m)] = operation
+			tags[op.tag] = true
+		}
+		return nil
+	}
+
+	for _, r := range routers {
+		if err := r.Walk(walk); err != nil {
+			return nil, err
+		}
+	}
+
+	tagList := make([]map[string]string, 0, len(tags))
+	for tag := range tags {
+		tagList = append(tagList, map[string]string{"name": tag})
+	}
+	sort.Slice(tagList, func(i, j int) bool { return tagList[i]["name"] < tagList[j]["name"] })
+
+	if version == "" {
+		version = "unknown"
+	}
+	return json.Marshal(map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "kvtiles",
+			"version": version,
+		},
+		"tags":  tagList,
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"code":       map[string]string{"type": "integer"},
+						"message":    map[string]string{"type": "string"},
+						"request_id": map[string]string{"type": "string"},
+					},
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,170 @@
+package replication
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+// Primary streams its DB to the replicas
+type Primary struct {
+	mu          sync.RWMutex
+	cur, prev   *bbolt.Storage
+	curVersion  int64
+	prevVersion int64
+	// updated is closed when the DB is replaced
+	updated chan struct{}
+	logger  log.Logger
+}
+
+// NewPrimary returns a Primary replicating s
+func NewPrimary(s *bbolt.Storage, logger log.Logger) (*Primary, error) {
+	v, err := s.Version()
+	if err != nil {
+		return nil, fmt.Errorf("can't read DB version: %w", err)
+	}
+
+	return &Primary{
+		cur:        s,
+		curVersion: v,
+		updated:    make(chan struct{}),
+		logger:     log.With(logger, "component", "replication"),
+	}, nil
+}
+
+// Register registers the replication service on srv
+func (p *Primary) Register(srv *grpc.Server) {
+	srv.RegisterService(&serviceDesc, p)
+}
+
+// SetStorage replaces the replicated DB, replicas up to date with the previous DB
+// receive the changed entries, the previous DB must stay open until the next call
+func (p *Primary) SetStorage(s *bbolt.Storage) error {
+	v, err := s.Version()
+	if err != nil {
+		return fmt.Errorf("can't read DB version: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prev, p.prevVersion = p.cur, p.curVersion
+	p.cur, p.curVersion = s, v
+	close(p.updated)
+	p.updated = make(chan struct{})
+
+	return nil
+}
+
+func (p *Primary) sync(req *SyncRequest, stream grpc.ServerStream) error {
+	logger := p.logger
+	if pr, ok := peer.FromContext(stream.Context()); ok {
+		logger = log.With(logger, "replica", pr.Addr.String())
+	}
+
+	version := req.Version
+	for {
+		p.mu.RLock()
+		cur, curVersion := p.cur, p.curVersion
+		prev, prevVersion := p.prev, p.prevVersion
+		updated := p.updated
+		p.mu.RUnlock()
+
+		if version != curVersion {
+			var err error
+			if version != 0 && prev != nil && version == prevVersion {
+				level.Info(logger).Log("msg", "sending update", "from", version, "to", curVersion)
+				err = sendUpdate(stream, prev, cur, prevVersion, curVersion)
+			} else {
+				level.Info(logger).Log("msg", "sending snapshot", "version", curVersion)
+				err = sendSnapshot(stream, cur, curVersion)
+			}
+			if err != nil {
+				level.Warn(logger).Log("msg", "replication stream failed", "error", err)
+				return err
+			}
+			version = curVersion
+		}
+
+		select {
+		case <-updated:
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// chunkWriter streams the snapshot in messages of maxMessageSize
+type chunkWriter struct {
+	stream  grpc.ServerStream
+	version int64
+	buf     []byte
+}
+
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		l := maxMessageSize - len(w.buf)
+		if l > len(b) {
+			l = len(b)
+		}
+		w.buf = append(w.buf, b[:l]...)
+		b = b[l:]
+		if len(w.buf) == maxMessageSize {
+			if err := w.flush(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *chunkWriter) flush(done bool) error {
+	err := w.stream.SendMsg(&SyncMessage{Version: w.version, Snapshot: w.buf, Done: done})
+	w.buf = w.buf[:0]
+	return err
+}
+
+func sendSnapshot(stream grpc.ServerStream, s *bbolt.Storage, version int64) error {
+	w := &chunkWriter{stream: stream, version: version, buf: make([]byte, 0, maxMessageSize)}
+	if _, err := s.WriteSnapshot(w); err != nil {
+		return err
+	}
+	return w.flush(true)
+}
+
+func sendUpdate(stream grpc.ServerStream, from, to *bbolt.Storage, fromVersion, toVersion int64) error {
+	msg := &SyncMessage{Version: toVersion, BaseVersion: fromVersion}
+	var size int
+
+	err := bbolt.Diff(from, to, func(k, v []byte) error {
+		// k and v are only valid during the transaction, they are copied since the last batch is sent after it
+		msg.Keys = append(msg.Keys, append([]byte(nil), k...))
+		if v != nil {
+			v = append(make([]byte, 0, len(v)), v...)
+		}
+		msg.Values = append(msg.Values, v)
+		size += len(k) + len(v)
+		if size < maxMessageSize {
+			return nil
+		}
+
+		if err := stream.SendMsg(msg); err != nil {
+			return err
+		}
+		msg.Keys, msg.Values, size = msg.Keys[:0], msg.Values[:0], 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	msg.Done = true
+	return stream.SendMsg(msg)
+}
@@ -0,0 +1,243 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc"
+
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+const retryDelay = 5 * time.Second
+
+// Replica keeps a local copy of the primary DB in dir
+type Replica struct {
+	addr     string
+	dir      string
+	dialOpts []grpc.DialOption
+	onUpdate func(path string) error
+	logger   log.Logger
+
+	path    string
+	version int64
+}
+
+// NewReplica returns a Replica of the primary at addr, path is the current local DB if any,
+// onUpdate is called with the path of every new local DB
+func NewReplica(addr, dir, path string, onUpdate func(path string) error, logger log.Logger, dialOpts ...grpc.DialOption) (*Replica, error) {
+	r := &Replica{
+		addr:     addr,
+		dir:      dir,
+		dialOpts: dialOpts,
+		onUpdate: onUpdate,
+		logger:   log.With(logger, "component", "replication", "primary", addr),
+	}
+
+	if path == "" {
+		return r, nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return r, nil
+	}
+
+	s, clean, err := bbolt.NewROStorage(path, logger)
+	if err != nil {
+		return nil, fmt.Errorf("can't open replica DB: %w", err)
+	}
+	defer clean()
+
+	v, err := s.Version()
+	if err != nil {
+		return nil, fmt.Errorf("can't read replica DB version: %w", err)
+	}
+	r.path, r.version = path, v
+
+	return r, nil
+}
+
+// Run syncs with the primary until ctx is done, reconnecting on errors
+func (r *Replica) Run(ctx context.Context) error {
+	for {
+		err := r.sync(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		level.Warn(r.logger).Log("msg", "replication stream failed, retrying", "error", err)
+
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *Replica) sync(ctx context.Context) error {
+	conn, err := grpc.DialContext(ctx, r.addr, r.dialOpts...)
+	if err != nil {
+		return fmt.Errorf("can't dial primary: %w", err)
+	}
+	defer conn.Close()
+
+	stream, err := conn.NewStream(ctx, &serviceDesc.Streams[0], syncMethod, grpc.CallContentSubtype(cborCodec{}.Name()))
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&SyncRequest{Version: r.version}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	level.Info(r.logger).Log("msg", "syncing with primary", "version", r.version)
+
+	for {
+		msg := &SyncMessage{}
+		if err := stream.RecvMsg(msg); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("primary closed the stream")
+			}
+			return err
+		}
+
+		if msg.BaseVersion == 0 {
+			err = r.receiveSnapshot(msg, stream)
+		} else {
+			err = r.receiveUpdate(msg, stream)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receiveSnapshot writes the snapshot starting with msg to a new local DB
+func (r *Replica) receiveSnapshot(msg *SyncMessage, stream grpc.ClientStream) error {
+	f, err := os.CreateTemp(r.dir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	for {
+		if _, err := f.Write(msg.Snapshot); err != nil {
+			return err
+		}
+		if msg.Done {
+			break
+		}
+
+		version := msg.Version
+		msg = &SyncMessage{}
+		if err := stream.RecvMsg(msg); err != nil {
+			return err
+		}
+		if msg.Version != version || msg.BaseVersion != 0 {
+			return fmt.Errorf("unexpected message during snapshot %d", version)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return r.commit(f.Name(), msg.Version)
+}
+
+// receiveUpdate applies the update starting with msg to a copy of the local DB
+func (r *Replica) receiveUpdate(msg *SyncMessage, stream grpc.ClientStream) error {
+	if r.path == "" || msg.BaseVersion != r.version {
+		return fmt.Errorf("update from version %d does not apply to local version %d", msg.BaseVersion, r.version)
+	}
+
+	tmp, err := copyFile(r.path, r.dir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	s, clean, err := bbolt.NewStorage(tmp, r.logger)
+	if err != nil {
+		return err
+	}
+	defer clean()
+
+	var count int
+	for {
+		if err := s.Apply(msg.Keys, msg.Values); err != nil {
+			return fmt.Errorf("can't apply update: %w", err)
+		}
+		count += len(msg.Keys)
+		if msg.Done {
+			break
+		}
+
+		version := msg.Version
+		msg = &SyncMessage{}
+		if err := stream.RecvMsg(msg); err != nil {
+			return err
+		}
+		if msg.Version != version || msg.BaseVersion != r.version {
+			return fmt.Errorf("unexpected message during update %d", version)
+		}
+	}
+
+	if err := clean(); err != nil {
+		return err
+	}
+	level.Debug(r.logger).Log("msg", "update applied", "entries", count)
+
+	return r.commit(tmp, msg.Version)
+}
+
+// commit moves the received DB in place and hands it over to onUpdate
+func (r *Replica) commit(tmp string, version int64) error {
+	path := filepath.Join(r.dir, fmt.Sprintf("replica-%d.db", version))
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	level.Info(r.logger).Log("msg", "replica DB updated", "version", version, "path", path)
+
+	if err := r.onUpdate(path); err != nil {
+		return fmt.Errorf("can't use replica DB %s: %w", path, err)
+	}
+	r.path, r.version = path, version
+
+	return nil
+}
+
+// copyFile copies src to a new temporary file in dir
+func copyFile(src, dir string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(dir, ".update-*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+
+	return out.Name(), nil
+}
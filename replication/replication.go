@@ -0,0 +1,74 @@
+// Package replication keeps replicas DBs in sync with a primary over gRPC:
+// replicas receive a snapshot of the primary DB, then the changed entries
+// every time the primary DB is replaced.
+// Replicas can also download the DB published in S3 when its ETag changes.
+package replication
+
+import (
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	serviceName = "kvtiles.replication.v1.Replication"
+	syncMethod  = "/" + serviceName + "/Sync"
+
+	// maxMessageSize is the target size of the streamed messages
+	maxMessageSize = 1 << 20
+)
+
+// SyncRequest is sent by a replica with the version of its DB, 0 if none
+type SyncRequest struct {
+	Version int64 `cbor:"1,keyasint,omitempty"`
+}
+
+// SyncMessage is a part of a snapshot or of an update streamed to the replicas
+type SyncMessage struct {
+	// Version of the DB the message belongs to
+	Version int64 `cbor:"1,keyasint,omitempty"`
+	// BaseVersion is the version an update applies to, 0 for a snapshot
+	BaseVersion int64 `cbor:"2,keyasint,omitempty"`
+	// Snapshot is a chunk of the DB file
+	Snapshot []byte `cbor:"3,keyasint,omitempty"`
+	// Keys and Values are changed entries, a nil value is a deleted entry
+	Keys   [][]byte `cbor:"4,keyasint,omitempty"`
+	Values [][]byte `cbor:"5,keyasint"`
+	// Done is set on the last message of a snapshot or an update
+	Done bool `cbor:"6,keyasint,omitempty"`
+}
+
+// cborCodec encodes the replication messages, avoiding protobuf generated code
+type cborCodec struct{}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error)      { return cbor.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v interface{}) error { return cbor.Unmarshal(data, v) }
+func (cborCodec) Name() string                               { return "cbor" }
+
+func init() {
+	encoding.RegisterCodec(cborCodec{})
+}
+
+// syncServer is implemented by Primary
+type syncServer interface {
+	sync(req *SyncRequest, stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*syncServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Sync",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := &SyncRequest{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(syncServer).sync(req, stream)
+			},
+		},
+	},
+	Metadata: "replication",
+}
@@ -0,0 +1,147 @@
+package replication
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/storage/bbolt"
+)
+
+func newDB(t *testing.T, path string, indexTime time.Time, entries map[string]string) *bbolt.Storage {
+	s, _, err := bbolt.NewStorage(path, log.NewNopLogger())
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	infos, err := cbor.Marshal(&storage.MapInfos{IndexTime: indexTime, MaxZoom: 9})
+	require.NoError(t, err)
+
+	keys := [][]byte{storage.MapKey()}
+	values := [][]byte{infos}
+	for k, v := range entries {
+		keys = append(keys, []byte(k))
+		values = append(values, []byte(v))
+	}
+	require.NoError(t, s.Apply(keys, values))
+
+	return s
+}
+
+func readDB(t *testing.T, path string) map[string]string {
+	s, clean, err := bbolt.NewROStorage(path, log.NewNopLogger())
+	require.NoError(t, err)
+	defer clean()
+
+	entries := make(map[string]string)
+	err = s.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(storage.MapKey()).ForEach(func(k, v []byte) error {
+			if string(k) != string(storage.MapKey()) {
+				entries[string(k)] = string(v)
+			}
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	return entries
+}
+
+func TestReplication(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.NewNopLogger()
+
+	v1 := map[string]string{"t1/0/0": "a", "t2/1/1": "b", "t3/2/2": "c"}
+	v2 := map[string]string{"t1/0/0": "a", "t2/1/1": "B", "t4/3/3": "d"}
+
+	db1 := newDB(t, filepath.Join(dir, "v1.db"), time.Unix(1, 0), v1)
+	db2 := newDB(t, filepath.Join(dir, "v2.db"), time.Unix(2, 0), v2)
+
+	p, err := NewPrimary(db1, logger)
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	p.Register(srv)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	updates := make(chan string)
+	replicaDir := t.TempDir()
+	r, err := NewReplica("bufnet", replicaDir, "", func(path string) error {
+		updates <- path
+		return nil
+	}, logger,
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	receive := func() string {
+		select {
+		case path := <-updates:
+			return path
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for replica update")
+		}
+		return ""
+	}
+
+	// snapshot
+	path := receive()
+	require.Equal(t, filepath.Join(replicaDir, "replica-1000000000.db"), path)
+	require.Equal(t, v1, readDB(t, path))
+
+	// update
+	require.NoError(t, p.SetStorage(db2))
+	path = receive()
+	require.Equal(t, filepath.Join(replicaDir, "replica-2000000000.db"), path)
+	require.Equal(t, v2, readDB(t, path))
+}
+
+// recordStream records the entries of the sent messages
+type recordStream struct {
+	grpc.ServerStream
+	keys, values [][]byte
+}
+
+func (s *recordStream) SendMsg(m interface{}) error {
+	msg := m.(*SyncMessage)
+	s.keys = append(s.keys, msg.Keys...)
+	s.values = append(s.values, msg.Values...)
+	return nil
+}
+
+func TestSendUpdate(t *testing.T) {
+	dir := t.TempDir()
+
+	// the values are large enough to be read from the mmap
+	b := strings.Repeat("b", 64<<10)
+	db1 := newDB(t, filepath.Join(dir, "v1.db"), time.Unix(1, 0), map[string]string{"t1/0/0": strings.Repeat("a", 64<<10)})
+	db2 := newDB(t, filepath.Join(dir, "v2.db"), time.Unix(2, 0), map[string]string{"t1/0/0": b})
+
+	// the sent entries stay valid once the DBs are closed
+	stream := &recordStream{}
+	require.NoError(t, sendUpdate(stream, db1, db2, 1, 2))
+	require.NoError(t, db1.Close())
+	require.NoError(t, db2.Close())
+
+	entries := make(map[string]string)
+	for i, k := range stream.keys {
+		entries[string(k)] = string(stream.values[i])
+	}
+	require.Equal(t, b, entries["t1/0/0"])
+}
@@ -0,0 +1,243 @@
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/internal/s3"
+	"github.com/akhenakh/kvtiles/internal/sigv4"
+)
+
+const (
+	s3StateFile = "s3-state.json"
+
+	// maxManifestSize limits the size of a manifest read in memory
+	maxManifestSize = 1 << 20
+)
+
+// S3Config locates the object polled in S3
+type S3Config struct {
+	// Endpoint of an S3 compatible storage, using path style URLs, empty for AWS
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Key of the DB file, or of a JSON manifest pointing to the DB file when ending with .json
+	Key string
+	// AWS credentials, requests are not signed when empty
+	AWS sigv4.Credentials
+}
+
+// manifest points to the published DB
+type manifest struct {
+	Key    string `json:"key"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// s3State is persisted in dir, so a DB already downloaded is not fetched again on restart
+type s3State struct {
+	ETag string `json:"etag"`
+	Path string `json:"path"`
+}
+
+// S3Poller downloads the DB published in S3 to dir every time the object ETag changes
+type S3Poller struct {
+	cfg      S3Config
+	dir      string
+	interval time.Duration
+	onUpdate func(path string) error
+	client   *http.Client
+	logger   log.Logger
+	checks   chan struct{}
+
+	state s3State
+}
+
+// NewS3Poller returns an S3Poller checking the object every interval,
+// onUpdate is called with the path of every new local DB
+func NewS3Poller(cfg S3Config, dir string, interval time.Duration, onUpdate func(path string) error, logger log.Logger) (*S3Poller, error) {
+	if cfg.Bucket == "" || cfg.Key == "" {
+		return nil, fmt.Errorf("an S3 bucket and key are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	p := &S3Poller{
+		cfg:      cfg,
+		dir:      dir,
+		interval: interval,
+		onUpdate: onUpdate,
+		client:   &http.Client{},
+		checks:   make(chan struct{}, 1),
+		logger:   log.With(logger, "component", "replication", "bucket", cfg.Bucket, "key", cfg.Key),
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, s3StateFile))
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read S3 sync state: %w", err)
+	}
+	if err := json.Unmarshal(b, &p.state); err != nil {
+		return nil, fmt.Errorf("invalid S3 sync state: %w", err)
+	}
+	if _, err := os.Stat(p.state.Path); err != nil {
+		p.state = s3State{}
+	}
+
+	return p, nil
+}
+
+// Path returns the last downloaded DB, empty if none
+func (p *S3Poller) Path() string {
+	return p.state.Path
+}
+
+// Check makes Run poll S3 without waiting for the interval
+func (p *S3Poller) Check() {
+	select {
+	case p.checks <- struct{}{}:
+	default:
+	}
+}
+
+// Run polls S3 until ctx is done
+func (p *S3Poller) Run(ctx context.Context) error {
+	for {
+		if err := p.poll(ctx); err != nil && ctx.Err() == nil {
+			level.Warn(p.logger).Log("msg", "S3 sync failed", "error", err)
+		}
+
+		select {
+		case <-time.After(p.interval):
+		case <-p.checks:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// poll downloads the DB if the object changed since the last download
+func (p *S3Poller) poll(ctx context.Context) error {
+	resp, err := p.get(ctx, p.cfg.Key, p.state.ETag)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+
+	etag := resp.Header.Get("ETag")
+	body := resp.Body
+	var sum string
+
+	if strings.HasSuffix(p.cfg.Key, ".json") {
+		var m manifest
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&m); err != nil {
+			return fmt.Errorf("invalid manifest: %w", err)
+		}
+		if m.Key == "" {
+			return fmt.Errorf("no DB key in manifest")
+		}
+
+		dbResp, err := p.get(ctx, m.Key, "")
+		if err != nil {
+			return err
+		}
+		defer dbResp.Body.Close()
+		body, sum = dbResp.Body, m.SHA256
+	}
+
+	level.Info(p.logger).Log("msg", "downloading DB", "etag", etag)
+
+	path, err := p.download(body, sum)
+	if err != nil {
+		return err
+	}
+
+	if err := p.onUpdate(path); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("can't use downloaded DB: %w", err)
+	}
+	p.state = s3State{ETag: etag, Path: path}
+
+	b, err := json.Marshal(p.state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(p.dir, s3StateFile), b, 0600)
+}
+
+// get requests the object key, conditionally to etag when not empty,
+// the response is either 200 or 304
+func (p *S3Poller) get(ctx context.Context, key, etag string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if p.cfg.AWS.AccessKeyID != "" {
+		sigv4.Sign(req, nil, p.cfg.AWS, p.cfg.Region, "s3", time.Now())
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 returned %s for %s", resp.Status, key)
+	}
+
+	return resp, nil
+}
+
+func (p *S3Poller) objectURL(key string) string {
+	return s3.Bucket{Endpoint: p.cfg.Endpoint, Region: p.cfg.Region, Name: p.cfg.Bucket}.URL(key)
+}
+
+// download writes body to a new DB in dir, checking its sha256 sum when not empty
+func (p *S3Poller) download(body io.Reader, sum string) (string, error) {
+	f, err := os.CreateTemp(p.dir, ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(f, io.TeeReader(body, h)); err != nil {
+		return "", fmt.Errorf("can't download DB: %w", err)
+	}
+	if sum != "" && !strings.EqualFold(sum, hex.EncodeToString(h.Sum(nil))) {
+		return "", fmt.Errorf("downloaded DB sha256 does not match the manifest")
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(p.dir, fmt.Sprintf("s3-%d.db", time.Now().UnixNano()))
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
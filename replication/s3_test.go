@@ -0,0 +1,129 @@
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/internal/sigv4"
+)
+
+func TestS3Poller(t *testing.T) {
+	objects := map[string]string{"/bucket/map.db": "v1"}
+	var signed bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signed = r.Header.Get("Authorization") != ""
+		body, ok := objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		etag := fmt.Sprintf("%q", body)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	var updates []string
+	onUpdate := func(path string) error {
+		updates = append(updates, path)
+		return nil
+	}
+
+	dir := t.TempDir()
+	cfg := S3Config{
+		Endpoint: ts.URL,
+		Bucket:   "bucket",
+		Key:      "map.db",
+		AWS:      sigv4.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+	}
+	p, err := NewS3Poller(cfg, dir, 0, onUpdate, log.NewNopLogger())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, p.poll(ctx))
+	require.True(t, signed)
+	require.Len(t, updates, 1)
+	b, err := ioutil.ReadFile(updates[0])
+	require.NoError(t, err)
+	require.Equal(t, "v1", string(b))
+
+	// not modified
+	require.NoError(t, p.poll(ctx))
+	require.Len(t, updates, 1)
+
+	// the state survives a restart
+	p, err = NewS3Poller(cfg, dir, 0, onUpdate, log.NewNopLogger())
+	require.NoError(t, err)
+	require.Equal(t, updates[0], p.Path())
+	require.NoError(t, p.poll(ctx))
+	require.Len(t, updates, 1)
+
+	objects["/bucket/map.db"] = "v2"
+	require.NoError(t, p.poll(ctx))
+	require.Len(t, updates, 2)
+	b, err = ioutil.ReadFile(updates[1])
+	require.NoError(t, err)
+	require.Equal(t, "v2", string(b))
+}
+
+func TestS3Poller_Manifest(t *testing.T) {
+	sum := sha256.Sum256([]byte("v1"))
+	objects := map[string]string{
+		"/bucket/maps/v1.db":  "v1",
+		"/bucket/latest.json": fmt.Sprintf(`{"key": "maps/v1.db", "sha256": "%s"}`, hex.EncodeToString(sum[:])),
+		"/bucket/bad.json":    `{"key": "maps/v1.db", "sha256": "00"}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf("%q", body))
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"valid manifest", "latest.json", false},
+		{"checksum mismatch", "bad.json", true},
+		{"missing object", "missing.json", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updates []string
+			p, err := NewS3Poller(S3Config{Endpoint: ts.URL, Bucket: "bucket", Key: tt.key}, t.TempDir(), 0,
+				func(path string) error {
+					updates = append(updates, path)
+					return nil
+				}, log.NewNopLogger())
+			require.NoError(t, err)
+
+			err = p.poll(context.Background())
+			if tt.wantErr {
+				require.Error(t, err)
+				require.Empty(t, updates)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, updates, 1)
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/internal/s3"
+	"github.com/akhenakh/kvtiles/internal/sigv4"
+)
+
+// Source is a DB published at an HTTP(S) URL or in S3 as s3://bucket/key
+type Source struct {
+	URL string
+	// SHA256 is the hex encoded sum of the DB, not checked when empty
+	SHA256 string
+	// SHA256URL publishes the sum of every new DB, as written by sha256sum, instead of SHA256
+	SHA256URL string
+	// Verify checks a downloaded DB before it replaces the local DB, e.g. by opening it
+	Verify func(path string) error
+	// Trigger makes Poll check the URL without waiting for the interval, optional
+	Trigger <-chan struct{}
+	// S3 holds the endpoint, the region and the credentials used for the s3:// URLs, its name is ignored
+	S3 s3.Bucket
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// sourceState is persisted next to the DB, to detect a stale DB and resume a partial download
+type sourceState struct {
+	URL string `json:"url"`
+	// ETag of the DB at path
+	ETag string `json:"etag,omitempty"`
+	// SHA256 of the DB at path, as verified after the download
+	SHA256 string `json:"sha256,omitempty"`
+	// PartETag of the partial download
+	PartETag string `json:"part_etag,omitempty"`
+}
+
+// remote describes the published DB
+type remote struct {
+	etag         string
+	lastModified time.Time
+	// sum is the expected sha256, empty if unknown
+	sum string
+}
+
+// Fetch downloads the DB to path when missing or stale, resuming a previous partial download,
+// returns true if a new DB was downloaded.
+// The local DB is stale when its sum differs from the expected sum, when the ETag of the DB has changed since the download,
+// or when it is older than the published DB for a DB not downloaded from this URL.
+func (s *Source) Fetch(ctx context.Context, path string, logger log.Logger) (bool, error) {
+	r, err := s.head(ctx)
+	if err != nil {
+		return false, err
+	}
+	if r.sum, err = s.sum(ctx); err != nil {
+		return false, err
+	}
+	state := s.readState(path)
+
+	stale, err := s.stale(path, state, r)
+	if err != nil || !stale {
+		return false, err
+	}
+
+	level.Info(logger).Log("msg", "downloading DB", "url", s.URL, "etag", r.etag)
+	if err := s.download(ctx, path, state, r); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Poll fetches the DB every interval until ctx is done, onUpdate is called with path after every download
+func (s *Source) Poll(ctx context.Context, path string, interval time.Duration, onUpdate func(path string) error, logger log.Logger) error {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-s.Trigger:
+		case <-ctx.Done():
+			return nil
+		}
+
+		downloaded, err := s.Fetch(ctx, path, logger)
+		if err != nil {
+			if ctx.Err() == nil {
+				level.Warn(logger).Log("msg", "DB URL sync failed", "error", err, "url", s.URL)
+			}
+			continue
+		}
+		if !downloaded {
+			continue
+		}
+		if err := onUpdate(path); err != nil {
+			level.Error(logger).Log("msg", "can't use downloaded DB", "error", err, "url", s.URL)
+		}
+	}
+}
+
+func (s *Source) stale(path string, state sourceState, r remote) (bool, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case r.sum != "" && state.URL == s.URL && state.SHA256 != "":
+		return !strings.EqualFold(state.SHA256, r.sum), nil
+	case r.sum != "":
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return false, err
+		}
+		return !strings.EqualFold(sum, r.sum), nil
+	case state.URL == s.URL && state.ETag != "" && r.etag != "":
+		return state.ETag != r.etag, nil
+	}
+	return r.lastModified.After(fi.ModTime()), nil
+}
+
+// download writes the DB to path.part then replaces path, the partial download of the same ETag is resumed
+func (s *Source) download(ctx context.Context, path string, state sourceState, r remote) error {
+	part := path + ".part"
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var offset int64
+	if state.URL == s.URL && state.PartETag != "" && state.PartETag == r.etag {
+		if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	state.URL, state.PartETag = s.URL, r.etag
+	if err := s.writeState(path, state); err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		header.Set("If-Range", r.etag)
+	}
+	resp, err := s.request(ctx, s.URL, http.MethodGet, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the whole DB is sent when it changed or the range is not supported
+	if resp.StatusCode != http.StatusPartialContent {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("can't download DB, it will be resumed: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if r.sum != "" {
+		sum, err := fileSHA256(part)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sum, r.sum) {
+			os.Remove(part)
+			return fmt.Errorf("downloaded DB sha256 %s does not match %s", sum, r.sum)
+		}
+	}
+	if s.Verify != nil {
+		if err := s.Verify(part); err != nil {
+			os.Remove(part)
+			return fmt.Errorf("invalid downloaded DB: %w", err)
+		}
+	}
+
+	if err := os.Rename(part, path); err != nil {
+		return err
+	}
+	return s.writeState(path, sourceState{URL: s.URL, ETag: r.etag, SHA256: r.sum})
+}
+
+// sum returns the expected sum of the published DB, empty if unknown
+func (s *Source) sum(ctx context.Context) (string, error) {
+	if s.SHA256URL == "" {
+		return s.SHA256, nil
+	}
+	resp, err := s.request(ctx, s.SHA256URL, http.MethodGet, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no sha256 at %s", s.SHA256URL)
+	}
+	return fields[0], nil
+}
+
+// head returns the validators of the published DB
+func (s *Source) head(ctx context.Context) (remote, error) {
+	resp, err := s.request(ctx, s.URL, http.MethodHead, nil)
+	if err != nil {
+		return remote{}, err
+	}
+	resp.Body.Close()
+
+	r := remote{etag: resp.Header.Get("ETag")}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		r.lastModified, _ = http.ParseTime(lm)
+	}
+	return r, nil
+}
+
+// request sends a request for rawURL, signed for S3, the response is 2xx
+func (s *Source) request(ctx context.Context, rawURL, method string, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	target := rawURL
+	b := s.S3
+	switch u.Scheme {
+	case "http", "https":
+	case "s3":
+		b.Name = u.Host
+		if b.Region == "" {
+			b.Region = "us-east-1"
+		}
+		target = b.URL(strings.TrimPrefix(u.Path, "/"))
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if u.Scheme == "s3" && b.AWS.AccessKeyID != "" {
+		sigv4.Sign(req, nil, b.AWS, b.Region, "s3", time.Now())
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s", rawURL, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s *Source) readState(path string) sourceState {
+	var state sourceState
+	b, err := ioutil.ReadFile(path + ".source.json")
+	if err == nil {
+		_ = json.Unmarshal(b, &state)
+	}
+	return state
+}
+
+func (s *Source) writeState(path string, state sourceState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".source.json", b, 0600)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,169 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceFetch(t *testing.T) {
+	content := []byte("db version 1")
+	etag := `"v1"`
+	modified := time.Now().Add(-time.Hour)
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "map.db", modified, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "map.db")
+	src := &Source{URL: ts.URL + "/map.db"}
+
+	// missing
+	ok, err := src.Fetch(ctx, path, log.NewNopLogger())
+	require.NoError(t, err)
+	require.True(t, ok)
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, b)
+
+	// up to date
+	ok, err = src.Fetch(ctx, path, log.NewNopLogger())
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// a partial download of the new version is resumed
+	content, etag = []byte("db version 2"), `"v2"`
+	require.NoError(t, ioutil.WriteFile(path+".part", content[:5], 0644))
+	require.NoError(t, src.writeState(path, sourceState{URL: src.URL, ETag: `"v1"`, PartETag: `"v2"`}))
+	ranges = nil
+	ok, err = src.Fetch(ctx, path, log.NewNopLogger())
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []string{"bytes=5-"}, ranges)
+	b, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, b)
+	_, err = os.Stat(path + ".part")
+	require.True(t, os.IsNotExist(err))
+
+	// the partial download of another version is restarted
+	content, etag = []byte("db version 3"), `"v3"`
+	require.NoError(t, ioutil.WriteFile(path+".part", []byte("db version 2"), 0644))
+	require.NoError(t, src.writeState(path, sourceState{URL: src.URL, ETag: `"v2"`, PartETag: `"v2"`}))
+	ranges = nil
+	_, err = src.Fetch(ctx, path, log.NewNopLogger())
+	require.NoError(t, err)
+	require.Equal(t, []string{""}, ranges)
+	b, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, b)
+
+	// checksum
+	sum := sha256.Sum256([]byte("db version 4"))
+	src.SHA256 = hex.EncodeToString(sum[:])
+	_, err = src.Fetch(ctx, path, log.NewNopLogger())
+	require.Error(t, err)
+	b, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []byte("db version 3"), b)
+
+	content, etag = []byte("db version 4"), `"v4"`
+	ok, err = src.Fetch(ctx, path, log.NewNopLogger())
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// a DB copied by other means is replaced when older than the published one
+	src.SHA256 = ""
+	other := filepath.Join(t.TempDir(), "map.db")
+	require.NoError(t, ioutil.WriteFile(other, []byte("copied"), 0644))
+	ok, err = src.Fetch(ctx, other, log.NewNopLogger())
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, os.Chtimes(other, modified.Add(-time.Hour), modified.Add(-time.Hour)))
+	ok, err = src.Fetch(ctx, other, log.NewNopLogger())
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = (&Source{URL: "ftp://host/map.db"}).Fetch(ctx, path, log.NewNopLogger())
+	require.Error(t, err)
+}
+
+func TestSourcePoll(t *testing.T) {
+	var mu sync.Mutex
+	content := []byte("db version 1")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		sum := sha256.Sum256(content)
+		if r.URL.Path == "/map.db.sha256" {
+			fmt.Fprintf(w, "%s  map.db\n", hex.EncodeToString(sum[:]))
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(sum[:4])))
+		http.ServeContent(w, r, "map.db", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "map.db")
+	src := &Source{
+		URL:       ts.URL + "/map.db",
+		SHA256URL: ts.URL + "/map.db.sha256",
+		Verify: func(path string) error {
+			b, err := ioutil.ReadFile(path)
+			if err == nil && bytes.Contains(b, []byte("corrupted")) {
+				return errors.New("corrupted")
+			}
+			return err
+		},
+	}
+	_, err := src.Fetch(context.Background(), path, log.NewNopLogger())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan string)
+	go src.Poll(ctx, path, 10*time.Millisecond, func(path string) error {
+		b, err := ioutil.ReadFile(path)
+		updates <- string(b)
+		return err
+	}, log.NewNopLogger())
+
+	// an invalid DB does not replace the local DB
+	mu.Lock()
+	content = []byte("db corrupted")
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "db version 1", string(b))
+
+	mu.Lock()
+	content = []byte("db version 2")
+	mu.Unlock()
+	select {
+	case u := <-updates:
+		require.Equal(t, "db version 2", u)
+	case <-time.After(time.Second):
+		t.Fatal("no update")
+	}
+}
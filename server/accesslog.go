@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+type requestInfoKey struct{}
+
+// requestInfo is filled along the request handling for the access log
+type requestInfo struct {
+	cache string
+	// country and region of the client, set by GeoIPHandler
+	country string
+	region  string
+}
+
+// infoFromContext returns the request info stored in ctx, returns a throw away info if none
+func infoFromContext(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// AccessLogHandler is a middleware logging one line per request,
+// successful requests are sampled according to the access log sampling rate.
+func (s *Server) AccessLogHandler(next http.Handler) http.Handler {
+	if s.accessLogger == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		info := &requestInfo{}
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, req.WithContext(context.WithValue(req.Context(), requestInfoKey{}, info)))
+
+		status := sw.Status()
+		// errors are always logged
+		if status < http.StatusInternalServerError && s.accessLogSampling < 1 && rand.Float64() >= s.accessLogSampling {
+			return
+		}
+
+		kv := []interface{}{
+			"method", req.Method,
+			"path", req.URL.Path,
+			"status", status,
+			"bytes", sw.bytes,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"ip", s.proxies.ClientIP(req),
+			"user_agent", req.UserAgent(),
+		}
+
+		if id := RequestID(req.Context()); id != "" {
+			kv = append(kv, "request_id", id)
+		}
+		if vars := mux.Vars(req); vars["z"] != "" {
+			kv = append(kv, "z", vars["z"], "x", vars["x"], "y", vars["y"])
+		}
+		if info.cache != "" {
+			kv = append(kv, "cache", info.cache)
+		}
+		if info.country != "" {
+			kv = append(kv, "country", info.country)
+			if info.region != "" {
+				kv = append(kv, "region", info.region)
+			}
+		}
+
+		s.accessLogger.Log(kv...)
+	})
+}
+
+// WithAccessLog logs requests to logger, sampling is the ratio of successful requests logged
+func WithAccessLog(logger log.Logger, sampling float64) Option {
+	return func(s *Server) {
+		s.accessLogger = logger
+		s.accessLogSampling = sampling
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// MapInfosHandler returns the map infos as currently stored in the DB
+func (s *Server) MapInfosHandler(w http.ResponseWriter, req *http.Request) {
+	mapInfos, ok, err := s.tileStorage.LoadMapInfos()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		level.Error(s.requestLogger(req)).Log("msg", "error reading db", "error", err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "no map in DB")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mapInfos)
+}
+
+// Version returns the application version
+func (s *Server) Version() string {
+	return s.version
+}
+
+// VersionHandler returns the application version and the infos of the map served
+func (s *Server) VersionHandler(w http.ResponseWriter, req *http.Request) {
+	infos, _, err := s.tileStorage.LoadMapInfos()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	m := map[string]interface{}{"version": s.version, "infos": infos}
+	if s.canary != nil {
+		if canaryInfos, _, err := s.canary.store.LoadMapInfos(); err == nil {
+			m["canary_infos"] = canaryInfos
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// MetadataHandler returns the metadata of the map served as in the metadata table of an mbtiles,
+// with the vector layers captured at import time, for the inspect tools and the style editors
+func (s *Server) MetadataHandler(w http.ResponseWriter, req *http.Request) {
+	infos := s.loadedMapInfos()
+	if infos == nil {
+		writeError(w, http.StatusNotFound, errNoMap.Error())
+		return
+	}
+
+	format := infos.Format
+	if format == "" {
+		format = "pbf"
+	}
+	m := map[string]interface{}{
+		"name":    infos.Region,
+		"format":  format,
+		"scheme":  "xyz",
+		"minzoom": 0,
+		"maxzoom": infos.MaxZoom,
+		"center":  []float64{infos.CenterLng, infos.CenterLat, float64(infos.MaxZoom)},
+	}
+	if infos.Encoding != "" {
+		m["encoding"] = infos.Encoding
+	}
+	if infos.Format == "" {
+		layers := infos.VectorLayers
+		if layers == nil {
+			layers = []storage.VectorLayer{}
+		}
+		m["vector_layers"] = layers
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
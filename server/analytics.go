@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// defaultAnalyticsLimit is the count of tiles returned by /admin/analytics without limit param
+const defaultAnalyticsLimit = 1000
+
+// analyticsSlot counts the requests per tile during a period
+type analyticsSlot struct {
+	start  time.Time
+	counts map[tilemath.Tile]uint64
+	// dropped counts the requests of the tiles not counted once the slot is full
+	dropped uint64
+}
+
+// tileAnalytics counts the served tiles requests per tile, in a ring of slots covering a period each,
+// a slot counts up to maxTiles distinct tiles to bound the memory used
+type tileAnalytics struct {
+	period   time.Duration
+	maxTiles int
+
+	mu    sync.Mutex
+	slots []analyticsSlot
+	cur   int
+}
+
+// WithAnalytics counts the served tiles requests per tile over the last slots periods,
+// reported by /admin/analytics, each period counts up to maxTiles distinct tiles
+func WithAnalytics(period time.Duration, slots, maxTiles int) Option {
+	return func(s *Server) {
+		s.analytics = &tileAnalytics{
+			period:   period,
+			maxTiles: maxTiles,
+			slots:    make([]analyticsSlot, slots),
+		}
+	}
+}
+
+// add counts a request of t at now
+func (a *tileAnalytics) add(t tilemath.Tile, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	slot := &a.slots[a.cur]
+	if slot.counts == nil || now.Sub(slot.start) >= a.period {
+		if slot.counts != nil {
+			a.cur = (a.cur + 1) % len(a.slots)
+			slot = &a.slots[a.cur]
+		}
+		*slot = analyticsSlot{start: now.Truncate(a.period), counts: make(map[tilemath.Tile]uint64)}
+	}
+
+	if _, ok := slot.counts[t]; !ok && len(slot.counts) >= a.maxTiles {
+		slot.dropped++
+		return
+	}
+	slot.counts[t]++
+}
+
+// tileCount is the requests count of a tile, in the XYZ scheme
+type tileCount struct {
+	Z        uint8  `json:"z"`
+	X        uint64 `json:"x"`
+	Y        uint64 `json:"y"`
+	Requests uint64 `json:"requests"`
+}
+
+// analyticsReport sums the slots
+type analyticsReport struct {
+	Since    time.Time         `json:"since"`
+	Requests uint64            `json:"requests"`
+	Dropped  uint64            `json:"dropped"`
+	Zooms    map[string]uint64 `json:"zooms"`
+	Tiles    []tileCount       `json:"tiles"`
+}
+
+// report returns the requests of the slots covering the periods before now, the tiles above zoom
+// are counted as their parent at zoom and the tiles below are left out, unless zoom is negative, sorted by requests
+func (a *tileAnalytics) report(zoom int, now time.Time) analyticsReport {
+	r := analyticsReport{Zooms: make(map[string]uint64)}
+	counts := make(map[tilemath.Tile]uint64)
+
+	oldest := now.Add(-a.period * time.Duration(len(a.slots)))
+
+	a.mu.Lock()
+	for _, slot := range a.slots {
+		if slot.counts == nil || !slot.start.After(oldest) {
+			continue
+		}
+		if r.Since.IsZero() || slot.start.Before(r.Since) {
+			r.Since = slot.start
+		}
+		r.Dropped += slot.dropped
+		r.Requests += slot.dropped
+		for t, n := range slot.counts {
+			r.Requests += n
+			r.Zooms[zoomLabels[t.Z]] += n
+			if zoom >= 0 {
+				if int(t.Z) < zoom {
+					continue
+				}
+				for int(t.Z) > zoom {
+					t = t.Parent()
+				}
+			}
+			counts[t] += n
+		}
+	}
+	a.mu.Unlock()
+
+	r.Tiles = make([]tileCount, 0, len(counts))
+	for t, n := range counts {
+		r.Tiles = append(r.Tiles, tileCount{Z: t.Z, X: t.X, Y: t.Y, Requests: n})
+	}
+	sort.Slice(r.Tiles, func(i, j int) bool {
+		if r.Tiles[i].Requests != r.Tiles[j].Requests {
+			return r.Tiles[i].Requests > r.Tiles[j].Requests
+		}
+		ti, tj := r.Tiles[i], r.Tiles[j]
+		if ti.Z != tj.Z {
+			return ti.Z < tj.Z
+		}
+		if ti.X != tj.X {
+			return ti.X < tj.X
+		}
+		return ti.Y < tj.Y
+	})
+	return r
+}
+
+// AnalyticsHandler reports the most requested tiles, e.g. /admin/analytics?zoom=10&limit=500,
+// zoom counts the tiles as their parent at this zoom, format=geojson returns the tiles centers
+// as points weighted by their requests, for a heatmap
+func (s *Server) AnalyticsHandler(w http.ResponseWriter, req *http.Request) {
+	if s.analytics == nil {
+		writeError(w, http.StatusNotFound, "analytics not enabled")
+		return
+	}
+
+	q := req.URL.Query()
+	zoom, limit := -1, defaultAnalyticsLimit
+	var err error
+	if v := q.Get("zoom"); v != "" {
+		if zoom, err = strconv.Atoi(v); err != nil || zoom < 0 || zoom > maxTileZoom {
+			writeError(w, http.StatusBadRequest, "invalid zoom")
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	r := s.analytics.report(zoom, time.Now())
+	if len(r.Tiles) > limit {
+		r.Tiles = r.Tiles[:limit]
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	switch q.Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r)
+	case "geojson":
+		w.Header().Set("Content-Type", "application/geo+json")
+		json.NewEncoder(w).Encode(analyticsGeoJSON(r.Tiles))
+	default:
+		writeError(w, http.StatusBadRequest, "invalid format")
+	}
+}
+
+// analyticsGeoJSON returns the tiles centers as a GeoJSON FeatureCollection of points
+func analyticsGeoJSON(tiles []tileCount) map[string]interface{} {
+	features := make([]map[string]interface{}, len(tiles))
+	for i, tc := range tiles {
+		minLat, minLng, maxLat, maxLng := tilemath.Tile{Z: tc.Z, X: tc.X, Y: tc.Y}.Bounds()
+		features[i] = map[string]interface{}{
+			"type": "Feature",
+			"geometry": map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []float64{(minLng + maxLng) / 2, (minLat + maxLat) / 2},
+			},
+			"properties": tc,
+		}
+	}
+	return map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+func TestTileAnalytics(t *testing.T) {
+	a := &tileAnalytics{period: time.Hour, maxTiles: 3, slots: make([]analyticsSlot, 2)}
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	a.add(tilemath.Tile{Z: 10, X: 100, Y: 200}, start)
+	a.add(tilemath.Tile{Z: 10, X: 101, Y: 201}, start)
+	a.add(tilemath.Tile{Z: 10, X: 101, Y: 201}, start.Add(time.Minute))
+	a.add(tilemath.Tile{Z: 2, X: 1, Y: 1}, start.Add(time.Minute))
+	// the slot is full
+	a.add(tilemath.Tile{Z: 10, X: 0, Y: 0}, start.Add(time.Minute))
+
+	r := a.report(-1, start.Add(time.Minute))
+	require.Equal(t, uint64(5), r.Requests)
+	require.Equal(t, uint64(1), r.Dropped)
+	require.Equal(t, map[string]uint64{"2": 1, "10": 3}, r.Zooms)
+	require.Equal(t, tileCount{Z: 10, X: 101, Y: 201, Requests: 2}, r.Tiles[0])
+	require.Len(t, r.Tiles, 3)
+
+	// the zoom 10 tiles share their parent at zoom 9, zoom 2 is left out
+	r = a.report(9, start.Add(time.Minute))
+	require.Equal(t, []tileCount{{Z: 9, X: 50, Y: 100, Requests: 3}}, r.Tiles)
+
+	// a new slot, then the first one is out of the retention
+	a.add(tilemath.Tile{Z: 10, X: 0, Y: 0}, start.Add(time.Hour))
+	r = a.report(-1, start.Add(time.Hour))
+	require.Equal(t, uint64(6), r.Requests)
+	require.Equal(t, start, r.Since)
+
+	a.add(tilemath.Tile{Z: 10, X: 0, Y: 0}, start.Add(2*time.Hour))
+	r = a.report(-1, start.Add(2*time.Hour))
+	require.Equal(t, uint64(2), r.Requests)
+	require.Equal(t, start.Add(time.Hour), r.Since)
+
+	r = a.report(-1, start.Add(5*time.Hour))
+	require.Zero(t, r.Requests)
+}
+
+func TestServer_AnalyticsHandler(t *testing.T) {
+	s, err := New("analytics_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(), WithStaticDir(""))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.AnalyticsHandler(w, httptest.NewRequest("GET", "/admin/analytics", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	s, err = New("analytics_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""), WithAnalytics(time.Hour, 24, 1000))
+	require.NoError(t, err)
+	s.analytics.add(tilemath.Tile{Z: 1, X: 1, Y: 0}, time.Now())
+
+	w = httptest.NewRecorder()
+	s.AnalyticsHandler(w, httptest.NewRequest("GET", "/admin/analytics?format=geojson", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var fc struct {
+		Features []struct {
+			Geometry struct {
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties tileCount `json:"properties"`
+		} `json:"features"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&fc))
+	require.Len(t, fc.Features, 1)
+	require.Equal(t, uint64(1), fc.Features[0].Properties.Requests)
+	require.InDelta(t, 90, fc.Features[0].Geometry.Coordinates[0], 1e-9)
+	require.Greater(t, fc.Features[0].Geometry.Coordinates[1], 0.0)
+
+	for _, q := range []string{"zoom=x", "zoom=-1", "limit=0", "format=csv"} {
+		w = httptest.NewRecorder()
+		s.AnalyticsHandler(w, httptest.NewRequest("GET", "/admin/analytics?"+q, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code, q)
+	}
+}
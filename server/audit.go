@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+)
+
+// auditTile logs an authenticated tile request decision
+func (s *Server) auditTile(req *http.Request, a auth, code int, z uint8, x, y uint64) {
+	if s.auditLogger == nil {
+		return
+	}
+
+	s.auditLogger.Log(
+		"action", "tile.read",
+		"auth", a.method,
+		"key_id", a.keyID,
+		"ip", s.proxies.ClientIP(req),
+		"z", z, "x", x, "y", y,
+		"status", code,
+	)
+}
+
+// AuditHandler is a middleware logging admin operations to the audit log
+func (s *Server) AuditHandler(next http.Handler) http.Handler {
+	if s.auditLogger == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, req)
+
+		s.auditLogger.Log(
+			"action", "admin",
+			"subject", Subject(req.Context()),
+			"ip", s.proxies.ClientIP(req),
+			"method", req.Method,
+			"path", req.URL.Path,
+			"status", sw.Status(),
+		)
+	})
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+)
+
+// recordLogger records the logged records as maps of their string values
+type recordLogger struct {
+	mu      sync.Mutex
+	records []map[string]string
+}
+
+func (l *recordLogger) Log(keyvals ...interface{}) error {
+	r := make(map[string]string)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		r[fmt.Sprint(keyvals[i])] = fmt.Sprint(keyvals[i+1])
+	}
+	l.mu.Lock()
+	l.records = append(l.records, r)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *recordLogger) last(t *testing.T) map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	require.NotEmpty(t, l.records)
+	return l.records[len(l.records)-1]
+}
+
+func TestServer_auditTile(t *testing.T) {
+	audit := &recordLogger{}
+	s, err := New("audit_test", "secret", cachedStore{}, log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""), WithAuditLogger(audit))
+	require.NoError(t, err)
+	s.SetReady(true)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf}", s)
+
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{"tiles key", "secret", http.StatusOK},
+		{"wrong key", "other", http.StatusUnauthorized},
+		{"no key", "", http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/tiles/1/0/1.pbf?key="+tt.key, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, tt.want, w.Code, tt.name)
+
+		require.Len(t, audit.records, i+1, tt.name)
+		require.Equal(t, map[string]string{
+			"action": "tile.read",
+			"auth":   "tiles_key",
+			"key_id": "",
+			"ip":     "192.0.2.1",
+			"z":      "1",
+			"x":      "0",
+			"y":      "1",
+			"status": fmt.Sprint(tt.want),
+		}, audit.last(t), tt.name)
+	}
+}
+
+func TestServer_AuditHandler(t *testing.T) {
+	audit := &recordLogger{}
+	s, err := New("audit_handler_test", "", cachedStore{}, log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""), WithAuditLogger(audit))
+	require.NoError(t, err)
+
+	h := s.AuditHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+
+	req := httptest.NewRequest("POST", "/admin/actions/purge-caches?confirm=1", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req = req.WithContext(context.WithValue(req.Context(), subjectKey, "alice"))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	require.Equal(t, map[string]string{
+		"action":  "admin",
+		"subject": "alice",
+		"ip":      "192.0.2.1",
+		"method":  "POST",
+		"path":    "/admin/actions/purge-caches",
+		"status":  "202",
+	}, audit.last(t))
+
+	// the status defaults to 200, an anonymous subject is empty
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin/mapinfos", nil))
+	rec := audit.last(t)
+	require.Equal(t, "200", rec["status"])
+	require.Equal(t, "", rec["subject"])
+	require.Equal(t, "GET", rec["method"])
+
+}
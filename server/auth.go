@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/apikey"
+)
+
+// authentication methods
+const (
+	authNone      = ""
+	authTilesKey  = "tiles_key"
+	authAPIKey    = "api_key"
+	authSignature = "signature"
+)
+
+// auth describes how a request was authenticated
+type auth struct {
+	method string
+	keyID  string
+	// trusted API keys bypass the redaction
+	trusted bool
+}
+
+// authorize checks the tiles key, the API keys or the URL signature if required,
+// returns the HTTP status code and the authentication used
+func (s *Server) authorize(req *http.Request, z uint8) (int, auth) {
+	q := req.URL.Query()
+	key := q.Get("key")
+	if s.tilesKey != "" && key == s.tilesKey {
+		return http.StatusOK, auth{method: authTilesKey}
+	}
+
+	if s.keys != nil && key != "" {
+		k, err := s.keys.Authorize(key, z)
+		switch err {
+		case nil:
+			return http.StatusOK, auth{method: authAPIKey, keyID: k.ID, trusted: k.Trusted}
+		case apikey.ErrZoomNotAllowed:
+			return http.StatusForbidden, auth{method: authAPIKey, keyID: k.ID}
+		case apikey.ErrQuotaExceeded:
+			return http.StatusTooManyRequests, auth{method: authAPIKey, keyID: k.ID}
+		case apikey.ErrUnknownKey:
+		default:
+			level.Error(s.requestLogger(req)).Log("msg", "can't authorize API key", "error", err)
+			return http.StatusInternalServerError, auth{method: authAPIKey}
+		}
+	}
+
+	if s.signingKey != nil {
+		if validSignature(s.signingKey, req.URL.Path, q, time.Now()) {
+			return http.StatusOK, auth{method: authSignature}
+		}
+		return http.StatusUnauthorized, auth{method: authSignature}
+	}
+
+	if s.tilesKey == "" && s.keys == nil {
+		return http.StatusOK, auth{method: authNone}
+	}
+
+	return http.StatusUnauthorized, auth{method: authTilesKey}
+}
+
+// KeysUsageHandler returns the current month usage of the API keys
+func (s *Server) KeysUsageHandler(w http.ResponseWriter, req *http.Request) {
+	if s.keys == nil {
+		writeError(w, http.StatusNotFound, "no API keys configured")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.keys.Usage())
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// BackupHandler streams a consistent copy of the DB as a download, gzipped with the gzip URL param,
+// e.g. /admin/backup?gzip=true
+func (s *Server) BackupHandler(w http.ResponseWriter, req *http.Request) {
+	if s.backup == nil {
+		writeError(w, http.StatusNotFound, "backups not enabled")
+		return
+	}
+
+	compress := false
+	if v := req.URL.Query().Get("gzip"); v != "" {
+		var err error
+		if compress, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid gzip param")
+			return
+		}
+	}
+
+	name := "kvtiles"
+	if infos, ok, err := s.tileStorage.LoadMapInfos(); err == nil && ok && infos.Region != "" {
+		name = infos.Region
+	}
+	name = fmt.Sprintf("%s-%s.db", name, time.Now().UTC().Format("20060102T150405Z"))
+
+	var out io.Writer = w
+	var gz *gzip.Writer
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if compress {
+		name += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+		gz = gzip.NewWriter(w)
+		out = gz
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Header().Set("Cache-Control", "no-store")
+
+	// the copy of a large DB outlasts the server write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		level.Warn(s.requestLogger(req)).Log("msg", "can't lift the write deadline, the backup may be truncated", "error", err)
+	}
+
+	// the status is already sent, a failed copy is truncated, without the gzip trailer
+	n, err := s.backup.WriteSnapshot(out)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		level.Error(s.requestLogger(req)).Log("msg", "backup failed", "error", err, "written", n)
+		return
+	}
+	level.Info(s.requestLogger(req)).Log("msg", "backup sent", "bytes", n, "gzip", compress)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+)
+
+// snapshot writes its content as the DB copy
+type snapshot string
+
+func (s snapshot) WriteSnapshot(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, string(s))
+	return int64(n), err
+}
+
+// slowSnapshot writes its content in two halves, pausing in between
+type slowSnapshot struct {
+	content string
+	pause   time.Duration
+}
+
+func (s slowSnapshot) WriteSnapshot(w io.Writer) (int64, error) {
+	half := len(s.content) / 2
+	n, err := io.WriteString(w, s.content[:half])
+	if err != nil {
+		return int64(n), err
+	}
+	time.Sleep(s.pause)
+	m, err := io.WriteString(w, s.content[half:])
+	return int64(n + m), err
+}
+
+func TestServer_BackupHandler(t *testing.T) {
+	s, err := New("backup_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(), WithStaticDir(""))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.BackupHandler(w, httptest.NewRequest("GET", "/admin/backup", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	s, err = New("backup_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+		WithBackup(snapshot("bolt db")),
+	)
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	s.BackupHandler(w, httptest.NewRequest("GET", "/admin/backup", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "bolt db", w.Body.String())
+	require.Regexp(t, `^attachment; filename="kvtiles-\d{8}T\d{6}Z\.db"$`, w.Header().Get("Content-Disposition"))
+
+	w = httptest.NewRecorder()
+	s.BackupHandler(w, httptest.NewRequest("GET", "/admin/backup?gzip=true", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+	require.True(t, strings.HasSuffix(w.Header().Get("Content-Disposition"), `.db.gz"`))
+	gz, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	require.Equal(t, "bolt db", string(b))
+
+	w = httptest.NewRecorder()
+	s.BackupHandler(w, httptest.NewRequest("GET", "/admin/backup?gzip=maybe", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestServer_BackupHandlerWriteTimeout(t *testing.T) {
+	content := strings.Repeat("bolt db ", 1<<16)
+	s, err := New("backup_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+		WithBackup(slowSnapshot{content: content, pause: 300 * time.Millisecond}),
+		WithAccessLog(log.NewNopLogger(), 1),
+		WithAuditLogger(log.NewNopLogger()),
+	)
+	require.NoError(t, err)
+
+	// the wrapping writers must let the handler lift the write deadline
+	h := s.AccessLogHandler(SecurityHeadersHandler(SecurityHeaders{ContentSecurityPolicy: DefaultContentSecurityPolicy})(
+		s.AuditHandler(http.HandlerFunc(s.BackupHandler))))
+	ts := httptest.NewUnstartedServer(h)
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/admin/backup")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, len(content), len(b))
+}
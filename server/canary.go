@@ -0,0 +1,105 @@
+package server
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// versions of the served data in metrics and in the X-Tiles-Version header
+const (
+	versionStable = "stable"
+	versionCanary = "canary"
+	// versionSnapshot prefixes the date of the snapshot served
+	versionSnapshot = "snapshot/"
+)
+
+var (
+	versionRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tiles_version_requests_total",
+		Help:      "Tiles requests per served data version (stable or canary) and status code.",
+	}, []string{"version", "code"})
+
+	versionLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "tiles_version_request_duration_seconds",
+		Help:      "Tiles requests latency per served data version.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"version"})
+)
+
+// canary is a second version of the data served to a share of the clients
+type canary struct {
+	store storage.TileStore
+	ratio float64
+	keys  map[string]bool
+	// maxZoom of the canary map, -1 if unknown
+	maxZoom int
+}
+
+// WithCanary serves the tiles from store to the API keys keyIDs and to a ratio of the clients,
+// a client is routed by its IP so it always gets the same version
+func WithCanary(store storage.TileStore, ratio float64, keyIDs []string) Option {
+	return func(s *Server) {
+		c := &canary{
+			store:   store,
+			ratio:   ratio,
+			keys:    make(map[string]bool),
+			maxZoom: -1,
+		}
+		for _, id := range keyIDs {
+			c.keys[id] = true
+		}
+		if infos, ok, err := store.LoadMapInfos(); err == nil && ok {
+			c.maxZoom = infos.MaxZoom
+		}
+		s.canary = c
+	}
+}
+
+// routed returns true if the request is served by the canary
+func (c *canary) routed(clientIP string, a auth) bool {
+	if a.keyID != "" && c.keys[a.keyID] {
+		return true
+	}
+	if c.ratio <= 0 {
+		return false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(clientIP))
+	return float64(h.Sum32()%10000) < c.ratio*10000
+}
+
+// tilesMaxZoom returns the max zoom of the stable map, -1 if unknown
+func (s *Server) tilesMaxZoom() int {
+	return int(atomic.LoadInt32(&s.maxZoom))
+}
+
+// versionMaxZoom returns the max zoom of the map serving version, -1 if unknown
+func (s *Server) versionMaxZoom(version string) int {
+	switch {
+	case version == versionCanary:
+		return s.canary.maxZoom
+	case strings.HasPrefix(version, versionSnapshot):
+		sn, ok := s.snapshotAt(strings.TrimPrefix(version, versionSnapshot))
+		if !ok {
+			return -1
+		}
+		return sn.maxZoom
+	}
+	return s.tilesMaxZoom()
+}
+
+func observeVersion(version string, status int, elapsed time.Duration) {
+	versionRequests.WithLabelValues(version, strconv.Itoa(status)).Inc()
+	versionLatency.WithLabelValues(version).Observe(elapsed.Seconds())
+}
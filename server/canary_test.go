@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+)
+
+func TestCanary_routed(t *testing.T) {
+	tests := []struct {
+		name  string
+		ratio float64
+		ip    string
+		a     auth
+		want  bool
+	}{
+		{"disabled", 0, "10.0.0.1", auth{}, false},
+		{"all", 1, "10.0.0.1", auth{}, true},
+		{"canary key", 0, "10.0.0.1", auth{method: authAPIKey, keyID: "beta"}, true},
+		{"other key", 0, "10.0.0.1", auth{method: authAPIKey, keyID: "prod"}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			c := &canary{ratio: tt.ratio, keys: map[string]bool{"beta": true}}
+			require.Equal(t, tt.want, c.routed(tt.ip, tt.a))
+		})
+	}
+
+	c := &canary{ratio: 0.2}
+	var routed int
+	for i := 0; i < 10000; i++ {
+		ip := fmt.Sprintf("10.%d.%d.1", i/256, i%256)
+		r := c.routed(ip, auth{})
+		// a client always gets the same version
+		require.Equal(t, r, c.routed(ip, auth{}))
+		if r {
+			routed++
+		}
+	}
+	require.InDelta(t, 2000, routed, 300)
+}
+
+func TestServer_CanaryMaxZoom(t *testing.T) {
+	get := func(ratio float64, path string) *httptest.ResponseRecorder {
+		s, err := New("canary_test", "", tileStore("stable"), log.NewNopLogger(), health.NewServer(),
+			WithStaticDir(""), WithCanary(zoomStore{tileStore: tileStore("canary"), zoom: 16}, ratio, nil))
+		require.NoError(t, err)
+		r := mux.NewRouter()
+		r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf}", s)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	// the max zoom is the one of the version served
+	w := get(1, "/tiles/16/0/0.pbf")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "canary", w.Body.String())
+	require.Equal(t, http.StatusNotFound, get(0, "/tiles/16/0/0.pbf").Code)
+	require.Equal(t, http.StatusOK, get(0, "/tiles/14/0/0.pbf").Code)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// Capabilities describes the features enabled on the server, for the clients and the tools adapting to it
+type Capabilities struct {
+	Version string `json:"version"`
+	// Formats are the tiles URL extensions served, e.g. pbf
+	Formats []string `json:"formats"`
+	// Conversions are the content types the raster tiles are converted to when accepted by the client
+	Conversions []string `json:"conversions,omitempty"`
+	// Auth are the authentication methods of the data requests, none when open
+	Auth []string `json:"auth"`
+	// Features are the optional features enabled, e.g. search
+	Features []string `json:"features"`
+	// Endpoints are the paths of the routes, filled by the handler registering them
+	Endpoints []string    `json:"endpoints,omitempty"`
+	Maps      []MapLayout `json:"maps"`
+}
+
+// MapLayout describes a map served
+type MapLayout struct {
+	// Name is stable, or canary for the map served to a share of the clients
+	Name string `json:"name"`
+	// Format is pbf for vector tiles, or the raster format, e.g. png
+	Format   string `json:"format"`
+	Encoding string `json:"encoding,omitempty"`
+	Region   string `json:"region,omitempty"`
+	MinZoom  int    `json:"minzoom"`
+	MaxZoom  int    `json:"maxzoom"`
+	// Center is lng, lat
+	Center [2]float64 `json:"center"`
+}
+
+// Capabilities returns the features enabled on the server
+func (s *Server) Capabilities() Capabilities {
+	c := Capabilities{Version: s.version, Auth: []string{}, Features: []string{}, Maps: []MapLayout{}}
+
+	format := s.mapFormat()
+	if format.format == "" {
+		c.Formats = []string{"pbf", "grid.json"}
+		c.Features = append(c.Features, "layers", "lang", "query")
+		if s.redaction != nil {
+			c.Features = append(c.Features, "redaction")
+		}
+	} else {
+		c.Formats = []string{format.format, format.format + "@2x"}
+		for _, e := range s.rasterEncoders {
+			c.Conversions = append(c.Conversions, e.ContentType())
+		}
+		if format.encoding != "" {
+			c.Features = append(c.Features, "elevation")
+		}
+	}
+	if s.search != nil {
+		c.Features = append(c.Features, "search")
+	}
+	if s.canary != nil {
+		c.Features = append(c.Features, "canary")
+	}
+	if len(s.snapshots) > 0 {
+		c.Features = append(c.Features, "snapshots")
+	}
+	if s.digest {
+		c.Features = append(c.Features, "digest")
+	}
+
+	if s.tilesKey != "" {
+		c.Auth = append(c.Auth, authTilesKey)
+	}
+	if s.keys != nil {
+		c.Auth = append(c.Auth, authAPIKey)
+	}
+	if s.signingKey != nil {
+		c.Auth = append(c.Auth, authSignature)
+	}
+	if len(c.Auth) == 0 {
+		c.Auth = append(c.Auth, "none")
+	}
+
+	if infos := s.loadedMapInfos(); infos != nil {
+		c.Maps = append(c.Maps, mapLayout(versionStable, infos))
+	}
+	if s.canary != nil {
+		if infos, ok, err := s.canary.store.LoadMapInfos(); err == nil && ok {
+			c.Maps = append(c.Maps, mapLayout(versionCanary, infos))
+		}
+	}
+	return c
+}
+
+func mapLayout(name string, infos *storage.MapInfos) MapLayout {
+	format := strings.ToLower(infos.Format)
+	if format == "" {
+		format = "pbf"
+	}
+	return MapLayout{
+		Name:     name,
+		Format:   format,
+		Encoding: infos.Encoding,
+		Region:   infos.Region,
+		MaxZoom:  infos.MaxZoom,
+		Center:   [2]float64{infos.CenterLng, infos.CenterLat},
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var rasterConversions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "raster_conversions_total",
+	Help:      "Raster tiles served converted per content type, result is hit when served from the cache, converted or failed.",
+}, []string{"type", "result"})
+
+// RasterEncoder converts the PNG and JPEG raster tiles to another image format, e.g. WebP or AVIF,
+// kvtiles ships no encoder, they usually wrap a C library such as libwebp or libavif
+type RasterEncoder interface {
+	// ContentType is the content type of the converted tiles, requested in the Accept header, e.g. image/webp
+	ContentType() string
+	// Encode converts a PNG or JPEG tile
+	Encode(tile []byte) ([]byte, error)
+}
+
+// WithRasterEncoders converts the PNG and JPEG raster tiles for the clients explicitly accepting the content type
+// of one of encoders, by order of preference, the converted tiles are cached up to maxBytes, 0 to disable the cache
+func WithRasterEncoders(maxBytes int64, encoders ...RasterEncoder) Option {
+	return func(s *Server) {
+		s.rasterEncoders = encoders
+		if maxBytes > 0 && len(encoders) > 0 {
+			s.convertCache = newLayersCache(maxBytes)
+		}
+	}
+}
+
+// rasterEncoder returns the preferred encoder accepted by req, nil if none
+func (s *Server) rasterEncoder(req *http.Request) RasterEncoder {
+	var best RasterEncoder
+	var bestQ float64
+	for _, e := range s.rasterEncoders {
+		if q := acceptQ(req, "Accept", e.ContentType()); q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// convertRaster returns the tile data converted by e, tileKey identifies the tile served, e.g. stable/3/1/2@2x,
+// cache is false when the tile is not the stored one, e.g. a fallback tile
+func (s *Server) convertRaster(e RasterEncoder, tileKey string, data []byte, cache bool) ([]byte, error) {
+	typ := e.ContentType()
+	var key string
+	if cache && s.convertCache != nil {
+		key = tileKey + "/" + typ
+		if converted, ok := s.convertCache.get(key); ok {
+			rasterConversions.WithLabelValues(typ, "hit").Inc()
+			return converted, nil
+		}
+	}
+
+	converted, err := e.Encode(data)
+	if err != nil {
+		rasterConversions.WithLabelValues(typ, "failed").Inc()
+		return nil, err
+	}
+	rasterConversions.WithLabelValues(typ, "converted").Inc()
+
+	if key != "" {
+		s.convertCache.add(key, converted)
+	}
+	return converted, nil
+}
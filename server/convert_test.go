@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// pngStore serves the same PNG tile for every request
+type pngStore struct {
+	tileStore
+}
+
+func (pngStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 14, Format: "png"}, true, nil
+}
+
+// fakeEncoder prefixes the tiles with its content type
+type fakeEncoder struct {
+	ctype string
+	calls int
+	err   error
+}
+
+func (e *fakeEncoder) ContentType() string {
+	return e.ctype
+}
+
+func (e *fakeEncoder) Encode(tile []byte) ([]byte, error) {
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	return append([]byte(e.ctype+":"), tile...), nil
+}
+
+func TestServer_convertRaster(t *testing.T) {
+	webp := &fakeEncoder{ctype: "image/webp"}
+	avif := &fakeEncoder{ctype: "image/avif"}
+	s, err := New("convert_test", "", pngStore{tileStore("png")}, log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""), WithRasterEncoders(1<<20, avif, webp))
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:png}", s)
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/tiles/3/1/2.png", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "Accept", w.Header().Get("Vary"))
+		return w
+	}
+
+	// */* does not accept the converted formats
+	w := get("image/png,image/*;q=0.8,*/*;q=0.5")
+	require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	require.Equal(t, "png", w.Body.String())
+
+	w = get("image/webp,*/*")
+	require.Equal(t, "image/webp", w.Header().Get("Content-Type"))
+	require.Equal(t, "image/webp:png", w.Body.String())
+
+	// the first encoder is preferred unless the client prefers another one
+	w = get("image/avif,image/webp,*/*")
+	require.Equal(t, "image/avif", w.Header().Get("Content-Type"))
+	w = get("image/avif;q=0.5,image/webp,*/*")
+	require.Equal(t, "image/webp", w.Header().Get("Content-Type"))
+
+	// converted once then served from the cache
+	require.Equal(t, 1, webp.calls)
+	require.Equal(t, 1, avif.calls)
+
+	// a failed conversion serves the stored tile
+	s.convertCache.purge()
+	webp.err = errors.New("encoder failed")
+	w = get("image/webp")
+	require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	require.Equal(t, "png", w.Body.String())
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxRecentErrors bounds the errors listed by the admin dashboard
+const maxRecentErrors = 20
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// AdminAction is an operation run from the admin dashboard, e.g. reloading the configuration
+type AdminAction struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	run func(ctx context.Context) error
+}
+
+// recentError is a 5xx response or a panic listed by the admin dashboard
+type recentError struct {
+	Time      time.Time `json:"time"`
+	Status    int       `json:"status"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// errorLog keeps the last errors
+type errorLog struct {
+	mu     sync.Mutex
+	errors []recentError
+}
+
+func (l *errorLog) add(e recentError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.errors) == maxRecentErrors {
+		l.errors = append(l.errors[:0], l.errors[1:]...)
+	}
+	l.errors = append(l.errors, e)
+}
+
+// list returns the errors, the most recent first
+func (l *errorLog) list() []recentError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	errs := make([]recentError, len(l.errors))
+	for i, e := range l.errors {
+		errs[len(errs)-1-i] = e
+	}
+	return errs
+}
+
+// AddAdminAction adds an operation run by POST /admin/actions/{name} and offered by the dashboard,
+// to call before serving
+func (s *Server) AddAdminAction(name, description string, run func(ctx context.Context) error) {
+	s.actions = append(s.actions, AdminAction{Name: name, Description: description, run: run})
+}
+
+// AddAdminStatus adds the value returned by fn to /admin/status under name, e.g. the DB size,
+// to call before serving
+func (s *Server) AddAdminStatus(name string, fn func() interface{}) {
+	if s.adminStatus == nil {
+		s.adminStatus = make(map[string]func() interface{})
+	}
+	s.adminStatus[name] = fn
+}
+
+// DashboardHandler serves the admin dashboard page, polling /admin/status
+func (s *Server) DashboardHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(dashboardHTML)
+}
+
+// AdminStatusHandler reports the Status as JSON
+func (s *Server) AdminStatusHandler(w http.ResponseWriter, req *http.Request) {
+	status, err := s.Status()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(status)
+}
+
+// Status returns the map infos, the requests and caches counters, the recent errors,
+// the actions and the statuses added with AddAdminStatus
+func (s *Server) Status() (map[string]interface{}, error) {
+	infos, _, err := s.tileStorage.LoadMapInfos()
+	if err != nil {
+		return nil, err
+	}
+
+	requests, caches, err := gatherCounters(prometheus.DefaultGatherer)
+	if err != nil {
+		level.Warn(s.logger).Log("msg", "can't gather metrics", "error", err)
+	}
+
+	status := map[string]interface{}{
+		"version":  s.version,
+		"infos":    infos,
+		"started":  s.started,
+		"ready":    s.isReady() && !s.isStandby(),
+		"requests": requests,
+		"caches":   caches,
+		"errors":   s.recentErrors.list(),
+		"actions":  s.actions,
+	}
+	for name, fn := range s.adminStatus {
+		status[name] = fn()
+	}
+	return status, nil
+}
+
+// AdminActionHandler runs the action of the URL /admin/actions/{name}, the X-Requested-With header is required
+// so a cross site form can't trigger it with the browser credentials
+func (s *Server) AdminActionHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("X-Requested-With") == "" {
+		writeError(w, http.StatusForbidden, "X-Requested-With header required")
+		return
+	}
+
+	name := mux.Vars(req)["name"]
+	for _, a := range s.actions {
+		if a.Name != name {
+			continue
+		}
+		if err := a.run(req.Context()); err != nil {
+			level.Error(s.requestLogger(req)).Log("msg", "admin action failed", "action", name, "error", err)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		level.Info(s.requestLogger(req)).Log("msg", "admin action done", "action", name)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": "ok"}`))
+		return
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("unknown action %s", name))
+}
+
+// requestsCounters are the tiles requests counted by status class, e.g. 2xx
+type requestsCounters struct {
+	Total   float64            `json:"total"`
+	Bytes   float64            `json:"bytes"`
+	ByClass map[string]float64 `json:"by_class"`
+}
+
+// cacheCounters are the counters of a cache tier
+type cacheCounters struct {
+	Lookups map[string]float64 `json:"lookups"`
+	Entries float64            `json:"entries"`
+	Bytes   float64            `json:"bytes"`
+}
+
+// gatherCounters reads the tiles requests and the caches counters from the registered metrics
+func gatherCounters(g prometheus.Gatherer) (requestsCounters, map[string]*cacheCounters, error) {
+	requests := requestsCounters{ByClass: make(map[string]float64)}
+	caches := make(map[string]*cacheCounters)
+	tier := func(m *dto.Metric) *cacheCounters {
+		name := labelValue(m, "tier")
+		c, ok := caches[name]
+		if !ok {
+			c = &cacheCounters{Lookups: make(map[string]float64)}
+			caches[name] = c
+		}
+		return c
+	}
+
+	families, err := g.Gather()
+	if err != nil {
+		return requests, caches, err
+	}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			switch f.GetName() {
+			case metricsNamespace + "_tiles_requests_total":
+				v := m.GetCounter().GetValue()
+				requests.Total += v
+				if code := labelValue(m, "code"); code != "" {
+					requests.ByClass[code[:1]+"xx"] += v
+				}
+			case metricsNamespace + "_tiles_bytes_total":
+				requests.Bytes += m.GetCounter().GetValue()
+			case metricsNamespace + "_cache_lookups_total":
+				tier(m).Lookups[labelValue(m, "result")] += m.GetCounter().GetValue()
+			case metricsNamespace + "_cache_entries":
+				tier(m).Entries = m.GetGauge().GetValue()
+			case metricsNamespace + "_cache_bytes":
+				tier(m).Bytes = m.GetGauge().GetValue()
+			}
+		}
+	}
+	return requests, caches, nil
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
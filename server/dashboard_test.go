@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+)
+
+func TestServer_AdminActionHandler(t *testing.T) {
+	s, err := New("dashboard_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(), WithStaticDir(""))
+	require.NoError(t, err)
+
+	var purged int
+	s.AddAdminAction("purge-caches", "Drop the cached tiles", func(context.Context) error {
+		purged++
+		return nil
+	})
+	s.AddAdminAction("reload", "Reload the config", func(context.Context) error {
+		return errors.New("invalid config")
+	})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/actions/{name}", s.AdminActionHandler).Methods("POST")
+	post := func(name string, xhr bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/admin/actions/"+name, nil)
+		if xhr {
+			req.Header.Set("X-Requested-With", "kvtiles")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// a cross site form can't set the header
+	require.Equal(t, http.StatusForbidden, post("purge-caches", false).Code)
+	require.Zero(t, purged)
+
+	require.Equal(t, http.StatusOK, post("purge-caches", true).Code)
+	require.Equal(t, 1, purged)
+	require.Equal(t, http.StatusInternalServerError, post("reload", true).Code)
+	require.Equal(t, http.StatusNotFound, post("unknown", true).Code)
+}
+
+func TestServer_AdminStatusHandler(t *testing.T) {
+	s, err := New("dashboard_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(), WithStaticDir(""))
+	require.NoError(t, err)
+	s.AddAdminAction("reload", "Reload the config", func(context.Context) error { return nil })
+	s.AddAdminStatus("db", func() interface{} { return map[string]int{"size": 42} })
+
+	// the oldest errors are dropped
+	for i := 0; i < maxRecentErrors+5; i++ {
+		s.reportError(httptest.NewRequest("GET", "/tiles/1/0/0.pbf", nil), "error", fmt.Sprintf("error %d", i), 500, "")
+	}
+
+	w := httptest.NewRecorder()
+	s.AdminStatusHandler(w, httptest.NewRequest("GET", "/admin/status", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var status struct {
+		Ready   bool           `json:"ready"`
+		Actions []AdminAction  `json:"actions"`
+		Errors  []recentError  `json:"errors"`
+		DB      map[string]int `json:"db"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	require.False(t, status.Ready)
+	require.Equal(t, []AdminAction{{Name: "reload", Description: "Reload the config"}}, status.Actions)
+	require.Len(t, status.Errors, maxRecentErrors)
+	require.Equal(t, fmt.Sprintf("error %d", maxRecentErrors+4), status.Errors[0].Message)
+	require.Equal(t, 42, status.DB["size"])
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/errreport"
+	"github.com/akhenakh/kvtiles/events"
+)
+
+// SetDegraded marks the storage as degraded, e.g. by a cache.Breaker: the tiles are served from the caches only
+// and flagged as stale, the change is logged, sent to the error reporter and emitted as an event
+func (s *Server) SetDegraded(degraded bool) {
+	var v int32
+	if degraded {
+		v = 1
+	}
+	if atomic.SwapInt32(&s.degraded, v) == v {
+		return
+	}
+
+	typ := events.StorageRecovered
+	if degraded {
+		typ = events.StorageDegraded
+		level.Error(s.logger).Log("msg", "storage degraded, tiles served from the caches only")
+		if s.reporter != nil {
+			s.reporter.Report(errreport.Event{Level: "error", Message: "storage degraded, tiles served from the caches only"})
+		}
+	} else {
+		level.Info(s.logger).Log("msg", "storage recovered")
+	}
+	if s.events != nil {
+		s.events.Emit(events.Event{Type: typ})
+	}
+}
+
+func (s *Server) isDegraded() bool {
+	return atomic.LoadInt32(&s.degraded) == 1
+}
+
+// setStaleHeaders flags a response served from the caches while the storage is degraded
+func setStaleHeaders(w http.ResponseWriter) {
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	w.Header().Set("X-Tiles-Degraded", "cache-only")
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// cachedStore serves 1/0/0 only, the other tiles are unavailable
+type cachedStore struct{}
+
+func (cachedStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	if z == 1 && x == 0 && y == 0 {
+		return []byte("tile"), nil
+	}
+	return nil, storage.ErrUnavailable
+}
+
+func (cachedStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 14}, true, nil
+}
+
+func (cachedStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func TestServer_Degraded(t *testing.T) {
+	s, err := New("degraded_test", "", cachedStore{}, log.NewNopLogger(), health.NewServer(), WithStaticDir(""))
+	require.NoError(t, err)
+	s.SetReady(true)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf}", s)
+
+	s.SetDegraded(true)
+	// in the TMS scheme 1/0/0 is 1/0/1
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/1/0/1.pbf", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "cache-only", w.Header().Get("X-Tiles-Degraded"))
+	require.Contains(t, w.Header().Get("Warning"), "110")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/1/1/1.pbf", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// still ready, serving from the caches
+	w = httptest.NewRecorder()
+	s.ReadyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"storage":"degraded"`)
+
+	s.SetDegraded(false)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/1/0/1.pbf", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get("X-Tiles-Degraded"))
+}
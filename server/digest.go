@@ -0,0 +1,45 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+)
+
+// WithTileDigest adds to the tiles responses the X-Tile-Digest header, the SHA-256 of the body as sent,
+// and when key is set the X-Tile-Signature header, the HMAC of the tile path and its digest
+func WithTileDigest(key []byte) Option {
+	return func(s *Server) {
+		s.digest = true
+		s.digestKey = key
+	}
+}
+
+// TileDigest returns the X-Tile-Digest of a tile response body, e.g. sha-256=<base64>
+func TileDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// TileSignature returns the X-Tile-Signature of the tile at path with digest, e.g. /tiles/11/618/722.pbf,
+// binding the content to the tile so a valid tile can't be served in place of another
+func TileSignature(key []byte, path, digest string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(digest))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setTileDigest sets the digest headers of the tile at path served with body
+func (s *Server) setTileDigest(h http.Header, path string, body []byte) {
+	if !s.digest {
+		return
+	}
+	digest := TileDigest(body)
+	h.Set("X-Tile-Digest", digest)
+	if s.digestKey != nil {
+		h.Set("X-Tile-Signature", TileSignature(s.digestKey, path, digest))
+	}
+}
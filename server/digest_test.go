@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+)
+
+func TestServer_tileDigest(t *testing.T) {
+	get := func(opts ...Option) *httptest.ResponseRecorder {
+		s, err := New("digest_test", "", tileStore(testTile(t)), log.NewNopLogger(), health.NewServer(),
+			append([]Option{WithStaticDir("")}, opts...)...)
+		require.NoError(t, err)
+
+		r := mux.NewRouter()
+		r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf}", s)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/3/1/2.pbf", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	w := get()
+	require.Empty(t, w.Header().Get("X-Tile-Digest"))
+
+	w = get(WithTileDigest(nil))
+	require.Equal(t, TileDigest(w.Body.Bytes()), w.Header().Get("X-Tile-Digest"))
+	require.Empty(t, w.Header().Get("X-Tile-Signature"))
+
+	key := []byte("secret")
+	w = get(WithTileDigest(key))
+	digest := w.Header().Get("X-Tile-Digest")
+	require.Equal(t, TileDigest(w.Body.Bytes()), digest)
+	require.Equal(t, TileSignature(key, "/tiles/3/1/2.pbf", digest), w.Header().Get("X-Tile-Signature"))
+	require.NotEqual(t, TileSignature(key, "/tiles/3/1/3.pbf", digest), w.Header().Get("X-Tile-Signature"))
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// InFlightHandler is a middleware counting the requests being served, to report the requests cut off on shutdown
+func (s *Server) InFlightHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&s.inFlight, 1)
+		defer atomic.AddInt64(&s.inFlight, -1)
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// InFlight returns the count of requests being served through InFlightHandler
+func (s *Server) InFlight() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
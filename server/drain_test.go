@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_InFlightHandler(t *testing.T) {
+	s := &Server{}
+	started, release := make(chan struct{}), make(chan struct{})
+	h := s.InFlightHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tiles/1/0/0.pbf", nil))
+		close(done)
+	}()
+
+	<-started
+	require.Equal(t, int64(1), s.InFlight())
+	close(release)
+	<-done
+	require.Equal(t, int64(0), s.InFlight())
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/terrain"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// elevationResponse is the JSON body of ElevationHandler
+type elevationResponse struct {
+	Lat       float64 `json:"lat"`
+	Lng       float64 `json:"lng"`
+	Zoom      uint8   `json:"zoom"`
+	Elevation float64 `json:"elevation"`
+}
+
+// ElevationHandler returns the elevation in meters at the lat lng param, decoded from the terrain-RGB tile
+// covering the point at zoom, zoom defaults to the max zoom of the map
+func (s *Server) ElevationHandler(w http.ResponseWriter, req *http.Request) {
+	if s.isStandby() {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "standby")
+		return
+	}
+
+	format := s.mapFormat()
+	if format.encoding == "" {
+		writeError(w, http.StatusNotFound, "no terrain tiles")
+		return
+	}
+
+	lat, lng, z, err := s.parsePoint(req.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if code, _ := s.authorize(req, z); code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
+
+	if s.mask != nil && !s.mask.Contains(lat, lng) {
+		writeError(w, http.StatusNotFound, "outside of the map")
+		return
+	}
+
+	ctx := req.Context()
+	if s.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+
+	t := tilemath.FromLatLng(lat, lng, z)
+	data, err := s.tileStorage.ReadTileData(ctx, z, t.X, t.TMSY())
+	if err != nil {
+		level.Error(s.requestLogger(req)).Log("msg", "error reading tile", "error", err, "z", t.Z, "x", t.X, "y", t.Y)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(data) == 0 {
+		writeError(w, http.StatusNotFound, "tile not found")
+		return
+	}
+
+	grid, err := terrain.Decode(format.encoding, data)
+	if err != nil {
+		level.Error(s.requestLogger(req)).Log("msg", "error decoding terrain tile", "error", err, "z", t.Z, "x", t.X, "y", t.Y)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	elevation := grid.At(t.Position(lat, lng))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(elevationResponse{
+		Lat:       lat,
+		Lng:       lng,
+		Zoom:      z,
+		Elevation: math.Round(elevation*10) / 10,
+	})
+}
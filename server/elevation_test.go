@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// terrainStore serves the same terrain-RGB tile for every coordinates
+type terrainStore struct {
+	tileStore
+}
+
+func (terrainStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 12, Format: "png", Encoding: "mapbox"}, true, nil
+}
+
+func TestServer_ElevationHandler(t *testing.T) {
+	// 100 m everywhere
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, color.RGBA{1, 138, 136, 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	s, err := New("elevation_test", "", terrainStore{tileStore(buf.Bytes())}, log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+	)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.ElevationHandler(w, httptest.NewRequest("GET", "/elevation?lat=19.82&lng=-155.47&zoom=10", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp elevationResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.EqualValues(t, 10, resp.Zoom)
+	require.InDelta(t, 100, resp.Elevation, 1e-9)
+
+	w = httptest.NewRecorder()
+	s.ElevationHandler(w, httptest.NewRequest("GET", "/elevation?lat=19.82&lng=-155.47&zoom=13", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// raster tiles are served as stored, the vector params are ignored
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.png", s)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/1/0/0.png?layers=water", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	require.Empty(t, w.Header().Get("Content-Encoding"))
+	require.Equal(t, buf.Bytes(), w.Body.Bytes())
+
+	s, err = New("elevation_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(), WithStaticDir(""))
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	s.ElevationHandler(w, httptest.NewRequest("GET", "/elevation?lat=19.82&lng=-155.47", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isGzip reports whether data starts with the gzip magic bytes
+func isGzip(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// gunzip returns the uncompressed data, data not gzipped is returned as is since a DB can mix
+// gzipped and raw vector tiles
+func gunzip(data []byte) ([]byte, error) {
+	if !isGzip(data) {
+		return data, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of req allows gzip
+func acceptsGzip(req *http.Request) bool {
+	return acceptQ(req, "Accept-Encoding", "gzip", "*") > 0
+}
+
+// acceptQ returns the q-value of the first entry of the header of req matching one of values,
+// e.g. the Accept-Encoding or Accept headers, 0 when none matches
+func acceptQ(req *http.Request, header string, values ...string) float64 {
+	for _, h := range req.Header.Values(header) {
+		for _, part := range strings.Split(h, ",") {
+			v, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			v = strings.ToLower(strings.TrimSpace(v))
+			match := false
+			for _, want := range values {
+				match = match || v == want
+			}
+			if !match {
+				continue
+			}
+			q := 1.0
+			if qv, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				q, _ = strconv.ParseFloat(qv, 64)
+			}
+			return q
+		}
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=1.0, *;q=0.5", true},
+		{"br, GZIP", true},
+		{"gzip;q=0", false},
+		{"identity", false},
+		{"*", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/tiles/1/0/0.pbf", nil)
+		if tt.header != "" {
+			req.Header.Set("Accept-Encoding", tt.header)
+		}
+		require.Equal(t, tt.want, acceptsGzip(req), tt.header)
+	}
+}
+
+func TestServer_encoding(t *testing.T) {
+	raw := testTile(t)
+	for _, tt := range []struct {
+		name     string
+		stored   []byte
+		accept   string
+		encoding string
+	}{
+		{"gzipped", gzipped(t, string(raw), gzip.DefaultCompression), "gzip", "gzip"},
+		{"gzipped without gzip support", gzipped(t, string(raw), gzip.DefaultCompression), "", ""},
+		{"raw", raw, "gzip", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New("encoding_test", "", tileStore(tt.stored), log.NewNopLogger(), health.NewServer(), WithStaticDir(""))
+			require.NoError(t, err)
+
+			r := mux.NewRouter()
+			r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf}", s)
+			req := httptest.NewRequest("GET", "/tiles/3/1/2.pbf", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, "application/x-protobuf", w.Header().Get("Content-Type"))
+			require.Equal(t, tt.encoding, w.Header().Get("Content-Encoding"))
+			require.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			if tt.encoding == "" {
+				require.Equal(t, raw, w.Body.Bytes())
+			} else {
+				require.Equal(t, tt.stored, w.Body.Bytes())
+			}
+		})
+	}
+}
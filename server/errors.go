@@ -0,0 +1,35 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// statusClientClosedRequest is the non standard status, as used by nginx,
+// recorded when the client disconnected before the response
+const statusClientClosedRequest = 499
+
+// errorResponse is the JSON body of an error
+type errorResponse struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError replies to the request with a JSON error, with the request ID set by RequestIDHandler if any
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorResponse{Code: code, Message: msg, RequestID: w.Header().Get(RequestIDHeader)})
+}
+
+// NotFoundHandler replies with a JSON 404 error, for the requests not matching any route
+func NotFoundHandler(w http.ResponseWriter, req *http.Request) {
+	writeError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+}
+
+// MethodNotAllowedHandler replies with a JSON 405 error, for the requests matching a route but not its methods
+func MethodNotAllowedHandler(w http.ResponseWriter, req *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/akhenakh/kvtiles/geoip"
+)
+
+var countryRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "requests_by_country_total",
+	Help:      "Requests per client country ISO code, unknown when the IP is not in the GeoIP DB.",
+}, []string{"country"})
+
+// GeoResolver resolves an IP to its location, e.g. a geoip.Reader
+type GeoResolver interface {
+	Lookup(ip net.IP) (geoip.Location, bool, error)
+}
+
+// WithGeoIP resolves the clients IPs against db, adding their country and region to the access log
+// and counting the requests per country
+func WithGeoIP(db GeoResolver) Option {
+	return func(s *Server) {
+		s.geoip = db
+	}
+}
+
+// GeoIPHandler is a middleware resolving the client location when a GeoIP DB is set
+func (s *Server) GeoIPHandler(next http.Handler) http.Handler {
+	if s.geoip == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		country := "unknown"
+		loc, ok, err := s.geoip.Lookup(s.proxies.ClientIP(req))
+		if err != nil {
+			level.Debug(s.requestLogger(req)).Log("msg", "can't resolve the client location", "error", err)
+		}
+		if ok && loc.Country != "" {
+			country = loc.Country
+			info := infoFromContext(req.Context())
+			info.country, info.region = loc.Country, loc.Region
+		}
+		countryRequests.WithLabelValues(country).Inc()
+
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/geoip"
+)
+
+type geoStub map[string]geoip.Location
+
+func (g geoStub) Lookup(ip net.IP) (geoip.Location, bool, error) {
+	loc, ok := g[ip.String()]
+	return loc, ok, nil
+}
+
+func TestServer_GeoIPHandler(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{
+		logger:            log.NewNopLogger(),
+		accessLogger:      log.NewLogfmtLogger(&buf),
+		accessLogSampling: 1,
+		geoip:             geoStub{"81.2.69.160": {Country: "GB", Region: "ENG"}},
+	}
+	h := s.AccessLogHandler(s.GeoIPHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})))
+
+	gb := testutil.ToFloat64(countryRequests.WithLabelValues("GB"))
+	unknown := testutil.ToFloat64(countryRequests.WithLabelValues("unknown"))
+
+	req := httptest.NewRequest("GET", "/tiles/1/0/0.pbf", nil)
+	req.RemoteAddr = "81.2.69.160:4242"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	require.Contains(t, buf.String(), "country=GB region=ENG")
+
+	buf.Reset()
+	req.RemoteAddr = "10.0.0.1:4242"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	require.NotContains(t, buf.String(), "country=")
+
+	require.Equal(t, gb+1, testutil.ToFloat64(countryRequests.WithLabelValues("GB")))
+	require.Equal(t, unknown+1, testutil.ToFloat64(countryRequests.WithLabelValues("unknown")))
+}
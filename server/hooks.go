@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// TileRequest describes the tile request passed to the hooks
+type TileRequest struct {
+	// Z, X, Y is the requested tile in the XYZ scheme
+	Z    uint8
+	X, Y uint64
+	// KeyID is the API key authenticating the request, empty if none
+	KeyID   string
+	Request *http.Request
+}
+
+// Hooks are called while serving a tile, they may set headers on w but not write the body,
+// any of them can be nil
+type Hooks struct {
+	// PreRead is called once the request is authorized, before reading the tile,
+	// a non nil error stops the request, e.g. a per tenant check
+	PreRead func(w http.ResponseWriter, tr *TileRequest) error
+	// PostRead is called with the gzipped tile before it is served, it returns the tile to serve
+	PostRead func(w http.ResponseWriter, tr *TileRequest, data []byte) ([]byte, error)
+	// OnMiss is called when the tile is not in the DB, it returns the gzipped tile to serve instead,
+	// nil to answer not found
+	OnMiss func(w http.ResponseWriter, tr *TileRequest) ([]byte, error)
+	// OnError is called when the tile can't be read, before answering with an error
+	OnError func(tr *TileRequest, err error)
+}
+
+// HookError is returned by hooks to answer with a specific status, other errors answer with a 500
+type HookError struct {
+	Code    int
+	Message string
+}
+
+func (e *HookError) Error() string {
+	return e.Message
+}
+
+// WithHooks calls hooks while serving the tiles
+func WithHooks(hooks Hooks) Option {
+	return func(s *Server) {
+		s.hooks = &hooks
+	}
+}
+
+// writeHookError answers with the error returned by a hook
+func (s *Server) writeHookError(w http.ResponseWriter, tr *TileRequest, err error) {
+	var he *HookError
+	if errors.As(err, &he) {
+		writeError(w, he.Code, he.Message)
+		return
+	}
+	level.Error(s.requestLogger(tr.Request)).Log("msg", "tile hook failed", "error", err, "z", tr.Z, "x", tr.X, "y", tr.Y)
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// hooksStore holds the tile 1/0/0, fails reading 1/1/1
+type hooksStore struct{}
+
+func (hooksStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	switch {
+	case z == 1 && x == 0 && y == 1:
+		return []byte("tile"), nil
+	case z == 1 && x == 1 && y == 0:
+		return nil, errors.New("broken")
+	}
+	return nil, nil
+}
+
+func (hooksStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 1}, true, nil
+}
+
+func (hooksStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func TestServer_hooks(t *testing.T) {
+	var failed []string
+	hooks := Hooks{
+		PreRead: func(w http.ResponseWriter, tr *TileRequest) error {
+			if tr.Request.URL.Query().Get("tenant") == "blocked" {
+				return &HookError{Code: http.StatusForbidden, Message: "tenant blocked"}
+			}
+			w.Header().Set("X-Tenant", tr.Request.URL.Query().Get("tenant"))
+			return nil
+		},
+		PostRead: func(w http.ResponseWriter, tr *TileRequest, data []byte) ([]byte, error) {
+			return append(data, " rewritten"...), nil
+		},
+		OnMiss: func(w http.ResponseWriter, tr *TileRequest) ([]byte, error) {
+			if tr.X == 1 && tr.Y == 0 {
+				return []byte("fallback"), nil
+			}
+			return nil, nil
+		},
+		OnError: func(tr *TileRequest, err error) {
+			failed = append(failed, err.Error())
+		},
+	}
+
+	s, err := New("hooks_test", "", hooksStore{}, log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""), WithHooks(hooks))
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.pbf", s)
+
+	tests := []struct {
+		path   string
+		code   int
+		body   string
+		tenant string
+	}{
+		{"/tiles/1/0/0.pbf?tenant=a", http.StatusOK, "tile rewritten", "a"},
+		{"/tiles/1/0/0.pbf?tenant=blocked", http.StatusForbidden, "", ""},
+		{"/tiles/1/1/0.pbf", http.StatusOK, "fallback rewritten", ""},
+		{"/tiles/1/0/1.pbf", http.StatusNotFound, "", ""},
+		{"/tiles/1/1/1.pbf", http.StatusInternalServerError, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			require.Equal(t, tt.code, w.Code)
+			if tt.code != http.StatusOK {
+				return
+			}
+			require.Equal(t, tt.body, w.Body.String())
+			require.Equal(t, tt.tenant, w.Header().Get("X-Tenant"))
+		})
+	}
+	require.Equal(t, []string{"broken"}, failed)
+}
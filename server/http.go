@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"mime"
 	"net/http"
@@ -13,41 +15,355 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/gorilla/mux"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/akhenakh/kvtiles/events"
+	"github.com/akhenakh/kvtiles/mask"
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/tilemath"
 )
 
 var (
 	templatesNames = []string{"osm-liberty-gl.style", "planet.json", "index.html", "openlayers.html"}
 )
 
-// ServeHTTP serves the mbtiles for URL such as /tiles/11/618/722.pbf
+// ServeHTTP serves the mbtiles for URL such as /tiles/11/618/722.pbf, or /tiles/11/618/722.png for raster tiles,
+// /tiles/11/618/722@2x.png for high-DPI raster tiles, /tiles/11/618/722.grid.json serves the UTFGrid of a vector tile,
+// /tiles/2024-01-01/11/618/722.pbf serves the tile of the map as of a date from the snapshots
 func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 
-	z, _ := strconv.Atoi(vars["z"])
-	x, _ := strconv.Atoi(vars["x"])
-	y, _ := strconv.Atoi(vars["y"])
+	// the max zoom is checked once the map serving the request is known
+	z, x, y, err := parseTileCoords(vars["z"], vars["x"], vars["y"], -1)
+	if err != nil {
+		writeError(w, err.(*tileCoordsError).code, err.Error())
+		return
+	}
+
+	start := time.Now()
+	zoom := zoomLabels[z]
+	sw := &statusWriter{ResponseWriter: w}
+	w = sw
+	var storageTime time.Duration
+	var served []byte
+	version := versionStable
+	defer func() {
+		elapsed := time.Since(start)
+		tilesRequests.WithLabelValues(zoom, strconv.Itoa(sw.Status())).Inc()
+		tilesLatency.WithLabelValues(zoom).Observe(elapsed.Seconds())
+		tilesBytes.WithLabelValues(zoom).Add(float64(sw.bytes))
+		if s.canary != nil && !strings.HasPrefix(version, versionSnapshot) {
+			observeVersion(version, sw.Status(), elapsed)
+		}
+		if s.analytics != nil && sw.Status() < http.StatusBadRequest {
+			s.analytics.add(tilemath.Tile{Z: z, X: x, Y: y}, start)
+		}
+
+		if s.events != nil {
+			switch sw.Status() {
+			case http.StatusOK:
+				s.events.Emit(events.Event{Type: events.TileServed, Tile: fmt.Sprintf("%d/%d/%d", z, x, y)})
+			case http.StatusNotFound:
+				s.events.Emit(events.Event{Type: events.TileMissing, Tile: fmt.Sprintf("%d/%d/%d", z, x, y)})
+			}
+		}
+
+		if s.shadow != nil && sw.Status() != statusClientClosedRequest && s.shadow.sample() {
+			s.shadow.mirror(req, sw.Status(), served)
+		}
+
+		if s.slowThreshold > 0 && elapsed >= s.slowThreshold {
+			level.Warn(s.requestLogger(req)).Log(
+				"msg", "slow tile request",
+				"z", z, "x", x, "y", y,
+				"status", sw.Status(),
+				"bytes", sw.bytes,
+				"duration", elapsed,
+				"storage_duration", storageTime,
+				"ip", s.proxies.ClientIP(req),
+				"user_agent", req.UserAgent(),
+				"url", req.URL.String(),
+			)
+		}
+	}()
+
+	if s.isStandby() {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "standby")
+		return
+	}
+
+	code, a := s.authorize(req, z)
+	if a.method != authNone {
+		s.auditTile(req, a, code, z, x, y)
+	}
+	if code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
 
-	q := req.URL.Query()
-	if s.tilesKey != "" {
-		k := q.Get("key")
-		if k != s.tilesKey {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	var layers []string
+	if param := req.URL.Query().Get("layers"); param != "" {
+		if layers, err = parseLayers(param); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 	}
 
-	data, err := s.tileStorage.ReadTileData(uint8(z), uint64(x), uint64(1<<uint(z)-y-1))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	redactor, ok := s.redactor(req.URL.Query().Get("redact"), a)
+	if !ok {
+		writeError(w, http.StatusForbidden, "redact requires a trusted key")
+		return
+	}
+
+	lang := req.URL.Query().Get("lang")
+	if lang != "" && !validLang(lang) {
+		writeError(w, http.StatusBadRequest, "invalid lang")
+		return
+	}
+
+	grid := vars["format"] == "grid.json"
+	// the /tiles/{z}/{x}/{y}@2x.png high-DPI raster tiles
+	retina := strings.HasSuffix(strings.TrimSuffix(req.URL.Path, "."+vars["format"]), "@2x")
+	if retina && s.mapFormat().format == "" {
+		writeError(w, http.StatusNotFound, "no raster tiles")
+		return
+	}
+	callback := req.URL.Query().Get("callback")
+	if grid && callback != "" && !validCallback.MatchString(callback) {
+		writeError(w, http.StatusBadRequest, "invalid callback")
+		return
+	}
+
+	relation := mask.Inside
+	if s.mask != nil {
+		relation = s.mask.Relation(tilemath.Tile{Z: z, X: x, Y: y})
+		if relation == mask.Outside {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	store := s.tileStorage
+	if date, ok := vars["date"]; ok {
+		// the /tiles/{date}/{z}/{x}/{y} map as of date
+		if err := ParseSnapshotDate(date); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sn, ok := s.snapshotAt(date)
+		if !ok {
+			writeError(w, http.StatusNotFound, "no snapshot at this date")
+			return
+		}
+		// the caches are keyed by version
+		store, version = sn.store, versionSnapshot+sn.date
+		w.Header().Set("X-Tiles-Snapshot", sn.date)
+	} else if s.canary != nil {
+		if s.canary.routed(s.proxies.ClientIP(req).String(), a) {
+			store, version = s.canary.store, versionCanary
+		}
+		w.Header().Set("X-Tiles-Version", version)
+	}
+	if err := checkMaxZoom(z, s.versionMaxZoom(version)); err != nil {
+		writeError(w, err.(*tileCoordsError).code, err.Error())
+		return
+	}
+
+	var tr *TileRequest
+	if s.hooks != nil || len(s.transformers) > 0 {
+		tr = &TileRequest{Z: z, X: x, Y: y, KeyID: a.keyID, Request: req}
+	}
+	if s.hooks != nil && s.hooks.PreRead != nil {
+		if err := s.hooks.PreRead(w, tr); err != nil {
+			s.writeHookError(w, tr, err)
+			return
+		}
+	}
+
+	ctx := req.Context()
+	if s.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+
+	if l := s.mapLimiter(version); l != nil {
+		if err := l.acquire(ctx); err != nil {
+			writeOverloaded(w, err)
+			return
+		}
+		defer l.release()
+	}
+
+	readStart := time.Now()
+	data, err := store.ReadTileData(ctx, z, x, 1<<z-y-1)
+	storageTime = time.Since(readStart)
+	switch {
+	case errors.Is(err, context.Canceled) && req.Context().Err() != nil:
+		// the client is gone, nobody is reading the response
+		level.Debug(s.requestLogger(req)).Log("msg", "client disconnected", "z", z, "x", x, "y", y)
+		writeError(w, statusClientClosedRequest, "client closed request")
+		return
+	case errors.Is(err, storage.ErrUnavailable):
+		// degraded storage, the tile is not in the caches
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, "storage unavailable")
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		level.Warn(s.requestLogger(req)).Log("msg", "tile read timed out", "z", z, "x", x, "y", y, "duration", storageTime)
+		if s.hooks != nil && s.hooks.OnError != nil {
+			s.hooks.OnError(tr, err)
+		}
+		writeError(w, http.StatusServiceUnavailable, "tile read timed out")
+		return
+	case err != nil:
+		level.Error(s.requestLogger(req)).Log("msg", "error reading tile", "error", err, "z", z, "x", x, "y", y)
+		if s.hooks != nil && s.hooks.OnError != nil {
+			s.hooks.OnError(tr, err)
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if s.isDegraded() {
+		setStaleHeaders(w)
+	}
+	fallback := len(data) == 0
 	if len(data) == 0 {
-		http.NotFound(w, req)
+		tilesLookups.WithLabelValues(zoom, "miss").Inc()
+		if s.hooks != nil && s.hooks.OnMiss != nil {
+			if data, err = s.hooks.OnMiss(w, tr); err != nil {
+				s.writeHookError(w, tr, err)
+				return
+			}
+		}
+		if len(data) == 0 {
+			writeError(w, http.StatusNotFound, "tile not found")
+			return
+		}
+	} else {
+		tilesLookups.WithLabelValues(zoom, "hit").Inc()
+	}
+
+	// raster tiles are served as stored, or as their @2x variant
+	vector := s.mapFormat().format == ""
+
+	if retina {
+		if data, err = s.retinaTile(ctx, store, version, z, x, y, data, !fallback); err != nil {
+			level.Error(s.requestLogger(req)).Log("msg", "error rendering @2x tile", "error", err, "z", z, "x", x, "y", y)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if vector && relation == mask.Partial {
+		if data, err = s.clip(tr, z, x, y, data); err != nil {
+			level.Error(s.requestLogger(req)).Log("msg", "error clipping tile", "error", err, "z", z, "x", x, "y", y)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if vector && len(layers) > 0 {
+		if data, err = s.filterLayers(version, z, x, y, layers, data, !fallback); err != nil {
+			level.Error(s.requestLogger(req)).Log("msg", "error filtering tile layers", "error", err, "z", z, "x", x, "y", y)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if vector && redactor != nil {
+		if data, err = applyTransformers([]TileTransformer{redactor}, tr, data); err != nil {
+			level.Error(s.requestLogger(req)).Log("msg", "error redacting tile", "error", err, "z", z, "x", x, "y", y)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// after the redaction, a redacted translation is not copied
+	if vector && lang != "" {
+		if data, err = applyTransformers([]TileTransformer{LocalizeNames(lang)}, tr, data); err != nil {
+			level.Error(s.requestLogger(req)).Log("msg", "error localizing tile", "error", err, "z", z, "x", x, "y", y)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if vector && len(s.transformers) > 0 {
+		if data, err = s.transform(tr, data); err != nil {
+			level.Error(s.requestLogger(req)).Log("msg", "error transforming tile", "error", err, "z", z, "x", x, "y", y)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if s.hooks != nil && s.hooks.PostRead != nil {
+		if data, err = s.hooks.PostRead(w, tr, data); err != nil {
+			s.writeHookError(w, tr, err)
+			return
+		}
+	}
+
+	if grid {
+		if !vector {
+			writeError(w, http.StatusNotFound, "no vector tiles")
+			return
+		}
+		if served, err = writeGrid(w, data, callback); err != nil {
+			level.Error(s.requestLogger(req)).Log("msg", "error rendering grid", "error", err, "z", z, "x", x, "y", y)
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
-	w.Header().Set("Content-Type", "application/x-protobuf")
-	w.Header().Set("Content-Encoding", "gzip")
+
+	if vector {
+		// stored tiles are usually gzipped, raw tiles and clients not accepting gzip get them uncompressed
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Header().Add("Vary", "Accept-Encoding")
+		if isGzip(data) {
+			if acceptsGzip(req) {
+				w.Header().Set("Content-Encoding", "gzip")
+			} else if data, err = gunzip(data); err != nil {
+				level.Error(s.requestLogger(req)).Log("msg", "error uncompressing tile", "error", err, "z", z, "x", x, "y", y)
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+	} else {
+		ctype := rasterContentType(s.mapFormat().format)
+		if len(s.rasterEncoders) > 0 && (ctype == "image/png" || ctype == "image/jpeg") {
+			w.Header().Add("Vary", "Accept")
+			// a failed conversion serves the stored tile
+			if e := s.rasterEncoder(req); e != nil {
+				tileKey := fmt.Sprintf("%s/%d/%d/%d", version, z, x, y)
+				if retina {
+					tileKey += "@2x"
+				}
+				if converted, err := s.convertRaster(e, tileKey, data, !fallback); err != nil {
+					level.Warn(s.requestLogger(req)).Log("msg", "error converting tile", "error", err, "type", e.ContentType(), "z", z, "x", x, "y", y)
+				} else {
+					data, ctype = converted, e.ContentType()
+				}
+			}
+		}
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("Surrogate-Key", "tiles")
+	s.setTileDigest(w.Header(), req.URL.Path, data)
 	_, _ = w.Write(data)
+	served = data
+}
+
+// rasterContentType returns the content type of the raster tiles of format
+func rasterContentType(format string) string {
+	switch format {
+	case "png":
+		return "image/png"
+	case "jpg", "jpeg":
+		return "image/jpeg"
+	case "webp":
+		return "image/webp"
+	}
+	return "application/octet-stream"
 }
 
 // TilesHandler serves the mbtiles at /tiles/11/618/722.pbf
@@ -79,7 +395,13 @@ func (s *Server) HealthHandler(w http.ResponseWriter, request *http.Request) {
 
 // StaticHandler serves templates and other static files
 func (s *Server) StaticHandler(w http.ResponseWriter, req *http.Request) {
+	if s.templates == nil {
+		writeError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
 	path := strings.TrimPrefix(req.URL.Path, "/static/")
+	w.Header().Set("Surrogate-Key", "static")
 	if path == "" {
 		path = "index.html"
 	}
@@ -87,56 +409,75 @@ func (s *Server) StaticHandler(w http.ResponseWriter, req *http.Request) {
 	// serve file normally
 	if !isTpl(path) {
 		req.URL.Path = path
-		s.fileHandler.ServeHTTP(w, req)
+		s.serveFile(w, req)
 		return
 	}
 
 	// check for key if needed
-	q := req.URL.Query()
-	if s.tilesKey != "" {
-		k := q.Get("key")
-		if k != s.tilesKey {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
-		}
+	if s.tilesKey != "" && req.URL.Query().Get("key") != s.tilesKey {
+		writeError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		return
 	}
 
-	mapInfos, ok, err := s.tileStorage.LoadMapInfos()
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		level.Error(s.logger).Log("msg", "error reading db", "error", err)
+	b, err := s.render(path, s.templates.Lookup(path), req)
+	switch {
+	case errors.Is(err, errNoMap):
+		writeError(w, http.StatusNotFound, err.Error())
+		level.Error(s.requestLogger(req)).Log("msg", "db does not contain a map")
 		return
-	}
-	if !ok {
-		http.Error(w, "no map in DB", 404)
-		level.Error(s.logger).Log("msg", "db does not contain a map")
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		level.Error(s.requestLogger(req)).Log("msg", "can't execute template", "error", err, "path", path)
 		return
 	}
 
-	// Templates variables
-	proto := "http"
-	if req.Header.Get("X-Forwarded-Proto") == "https" {
-		proto = "https"
+	// change header base on content-type
+	ctype := mime.TypeByExtension(filepath.Ext(path))
+	w.Header().Set("Content-Type", ctype)
+	w.Write(b)
+}
+
+var errNoMap = errors.New("no map in DB")
+
+// templateParams returns the variables of the debug map and styles templates
+func (s *Server) templateParams(req *http.Request) (map[string]interface{}, error) {
+	mapInfos := s.loadedMapInfos()
+	if mapInfos == nil {
+		return nil, errNoMap
 	}
+	return TemplateParams(mapInfos, baseURL(req), s.tilesKey)
+}
 
-	p := map[string]interface{}{
-		"TilesBaseURL": fmt.Sprintf("%s://%s", proto, req.Host),
-		"MaxZoom":      mapInfos.MaxZoom,
-		"CenterLat":    mapInfos.CenterLat,
-		"CenterLng":    mapInfos.CenterLng,
-		"TilesKey":     s.tilesKey,
+// TemplateParams returns the variables of the debug map and styles templates of the map,
+// whose files and tiles are served from baseURL and protected by tilesKey if not empty
+func TemplateParams(infos *storage.MapInfos, baseURL, tilesKey string) (map[string]interface{}, error) {
+	// the TileJSON lists the layers of the DB, the OpenMapTiles ones when it has none
+	var vectorLayers string
+	if len(infos.VectorLayers) > 0 {
+		b, err := json.Marshal(infos.VectorLayers)
+		if err != nil {
+			return nil, err
+		}
+		vectorLayers = string(b)
 	}
 
-	// change header base on content-type
-	ctype := mime.TypeByExtension(filepath.Ext(path))
-	w.Header().Set("Content-Type", ctype)
+	return map[string]interface{}{
+		"TilesBaseURL": baseURL,
+		"MaxZoom":      infos.MaxZoom,
+		"CenterLat":    infos.CenterLat,
+		"CenterLng":    infos.CenterLng,
+		"TilesKey":     tilesKey,
+		"VectorLayers": vectorLayers,
+	}, nil
+}
 
-	err = s.templates.ExecuteTemplate(w, path, p)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		level.Error(s.logger).Log("msg", "can't execute template", "error", err, "path", path)
-		return
+// baseURL returns the URL of the server as requested by the client
+func baseURL(req *http.Request) string {
+	proto := "http"
+	if req.Header.Get("X-Forwarded-Proto") == "https" {
+		proto = "https"
 	}
+	return fmt.Sprintf("%s://%s", proto, req.Host)
 }
 
 func isTpl(path string) bool {
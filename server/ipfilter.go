@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the networks of the proxies allowed to set X-Forwarded-For
+type TrustedProxies []*net.IPNet
+
+// IPFilter allows or denies requests based on the client IP
+type IPFilter struct {
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	proxies TrustedProxies
+}
+
+// ParseCIDRs parses a list of CIDR, a single IP is treated as a /32 or /128
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %s", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s: %w", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ParseTrustedProxies parses a list of proxies CIDR
+func ParseTrustedProxies(cidrs []string) (TrustedProxies, error) {
+	nets, err := ParseCIDRs(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	return TrustedProxies(nets), nil
+}
+
+// ClientIP returns the client IP of the request,
+// X-Forwarded-For is only honored when the request comes from a trusted proxy
+func (t TrustedProxies) ClientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(t, ip) {
+		return ip
+	}
+
+	// walking the chain from the closest proxy, the first untrusted address is the client
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hip == nil {
+			break
+		}
+		ip = hip
+		if !contains(t, hip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// NewIPFilter returns an IPFilter, deny takes precedence over allow,
+// an empty allow list allows every IP not denied
+func NewIPFilter(allow, deny []string, proxies TrustedProxies) (*IPFilter, error) {
+	a, err := ParseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	d, err := ParseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &IPFilter{allow: a, deny: d, proxies: proxies}, nil
+}
+
+// Allowed returns true if the ip is allowed by the filter
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if contains(f.deny, ip) {
+		return false
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	return contains(f.allow, ip)
+}
+
+// Handler is a middleware rejecting requests from non allowed IPs
+func (f *IPFilter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !f.Allowed(f.proxies.ClientIP(req)) {
+			writeError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
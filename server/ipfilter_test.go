@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedProxies_ClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"direct", "1.2.3.4:1234", "", "1.2.3.4"},
+		{"untrusted xff ignored", "1.2.3.4:1234", "5.6.7.8", "1.2.3.4"},
+		{"trusted proxy", "10.0.0.1:1234", "5.6.7.8", "5.6.7.8"},
+		{"chained proxies", "10.0.0.1:1234", "9.9.9.9, 5.6.7.8, 192.168.1.1", "5.6.7.8"},
+		{"only proxies", "10.0.0.1:1234", "10.0.0.2", "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/tiles/1/1/1.pbf", nil)
+			req.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			require.Equal(t, tt.want, proxies.ClientIP(req).String())
+		})
+	}
+}
+
+func TestIPFilter_Allowed(t *testing.T) {
+	f, err := NewIPFilter([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.1.0.0/16"}, nil)
+	require.NoError(t, err)
+
+	require.True(t, f.Allowed(net.ParseIP("10.0.0.1")))
+	require.True(t, f.Allowed(net.ParseIP("2001:db8::1")))
+	require.False(t, f.Allowed(net.ParseIP("10.1.2.3")))
+	require.False(t, f.Allowed(net.ParseIP("1.2.3.4")))
+
+	_, err = NewIPFilter([]string{"not an ip"}, nil, nil)
+	require.Error(t, err)
+}
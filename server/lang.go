@@ -0,0 +1,61 @@
+package server
+
+import (
+	"github.com/akhenakh/kvtiles/mvt"
+)
+
+// validLang returns true for language codes such as en, fr or zh-Hant
+func validLang(lang string) bool {
+	if len(lang) < 2 || len(lang) > 16 {
+		return false
+	}
+	for _, c := range lang {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalizeNames is a TileTransformer copying the name:lang attribute of the features into name,
+// or name_lang as used by OpenMapTiles for a few languages, features without a translation are unchanged
+func LocalizeNames(lang string) TileTransformer {
+	keys := []string{"name:" + lang, "name_" + lang}
+
+	return TransformerFunc(func(tr *TileRequest, tile []byte) ([]byte, error) {
+		layers, err := mvt.SplitLayers(tile)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, raw := range layers {
+			l, err := mvt.DecodeLayer(raw)
+			if err != nil {
+				return nil, err
+			}
+
+			var localized bool
+			for _, f := range l.Features {
+				for _, k := range keys {
+					v, ok := f.Properties[k]
+					if !ok {
+						continue
+					}
+					if f.Properties["name"] != v {
+						f.Properties["name"] = v
+						localized = true
+					}
+					break
+				}
+			}
+			if !localized {
+				continue
+			}
+			if layers[i], err = mvt.EncodeLayer(l); err != nil {
+				return nil, err
+			}
+		}
+
+		return mvt.JoinLayers(layers), nil
+	})
+}
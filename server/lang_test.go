@@ -0,0 +1,53 @@
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/mvt"
+)
+
+func TestServer_lang(t *testing.T) {
+	point := []uint32{9, 20, 20}
+	tile, err := mvt.Encode([]mvt.Layer{
+		{Name: "place", Extent: 4096, Features: []mvt.Feature{
+			{Type: mvt.Point, Geometry: point, Properties: map[string]interface{}{"name": "München", "name:en": "Munich"}},
+			{Type: mvt.Point, Geometry: point, Properties: map[string]interface{}{"name": "Köln", "name_en": "Cologne"}},
+			{Type: mvt.Point, Geometry: point, Properties: map[string]interface{}{"name": "Berlin", "name:fr": "Berlin"}},
+		}},
+	})
+	require.NoError(t, err)
+
+	s, err := New("lang_test", "", tileStore(gzipped(t, string(tile), gzip.DefaultCompression)), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+	)
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.pbf", s)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/3/1/2.pbf?lang=en", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	data, err := gunzip(w.Body.Bytes())
+	require.NoError(t, err)
+	layers, err := mvt.Decode(data)
+	require.NoError(t, err)
+
+	var names []interface{}
+	for _, f := range layers[0].Features {
+		names = append(names, f.Properties["name"])
+	}
+	require.Equal(t, []interface{}{"Munich", "Cologne", "Berlin"}, names)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/3/1/2.pbf?lang=e:n", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
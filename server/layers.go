@@ -0,0 +1,168 @@
+package server
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxLayersParam is the max count of layers accepted by the layers query parameter
+const maxLayersParam = 64
+
+var layersCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "layers_cache_lookups_total",
+	Help:      "Lookups of the tiles filtered by the layers query parameter, result is hit or miss.",
+}, []string{"result"})
+
+// WithLayersCache caches up to maxBytes of the tiles filtered by the layers query parameter,
+// 0 to disable the cache
+func WithLayersCache(maxBytes int64) Option {
+	return func(s *Server) {
+		if maxBytes > 0 {
+			s.layersCache = newLayersCache(maxBytes)
+		}
+	}
+}
+
+// SetLayersCacheSize resizes the cache of the tiles filtered by the layers query parameter,
+// a cache disabled by WithLayersCache stays disabled
+func (s *Server) SetLayersCacheSize(maxBytes int64) {
+	if s.layersCache != nil {
+		s.layersCache.setMaxBytes(maxBytes)
+	}
+}
+
+// parseLayers returns the sorted unique layer names of a layers query parameter, e.g. roads,water
+func parseLayers(param string) ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, n := range strings.Split(param, ",") {
+		if n = strings.TrimSpace(n); n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		names = append(names, n)
+	}
+	if len(names) > maxLayersParam {
+		return nil, fmt.Errorf("too many layers, max %d", maxLayersParam)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// filterLayers returns the gzipped tile data with only the layers names,
+// cache is false when the tile is not the stored one, e.g. a fallback tile
+func (s *Server) filterLayers(version string, z uint8, x, y uint64, names []string, data []byte, cache bool) ([]byte, error) {
+	var key string
+	if cache && s.layersCache != nil {
+		key = fmt.Sprintf("%s/%d/%d/%d/%s", version, z, x, y, strings.Join(names, ","))
+		if filtered, ok := s.layersCache.get(key); ok {
+			layersCacheLookups.WithLabelValues("hit").Inc()
+			return filtered, nil
+		}
+		layersCacheLookups.WithLabelValues("miss").Inc()
+	}
+
+	filtered, err := applyTransformers([]TileTransformer{KeepLayers(names...)}, nil, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != "" {
+		s.layersCache.add(key, filtered)
+	}
+	return filtered, nil
+}
+
+type layersEntry struct {
+	key  string
+	data []byte
+}
+
+// layersCache is a least recently used cache of filtered tiles, bounded in bytes
+type layersCache struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newLayersCache(maxBytes int64) *layersCache {
+	return &layersCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *layersCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*layersEntry).data, true
+}
+
+// entrySize is the size accounted for an entry, the key can be as long as the filtered tile
+func entrySize(key string, data []byte) int64 {
+	return int64(len(key) + len(data))
+}
+
+func (c *layersCache) add(key string, data []byte) {
+	size := entrySize(key, data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if size > c.maxBytes {
+		return
+	}
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&layersEntry{key: key, data: data})
+	c.bytes += size
+	c.evict()
+}
+
+// setMaxBytes resizes the cache
+func (c *layersCache) setMaxBytes(maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// evict must be called with the lock held
+func (c *layersCache) evict() {
+	for c.bytes > c.maxBytes {
+		en := c.ll.Remove(c.ll.Back()).(*layersEntry)
+		delete(c.items, en.key)
+		c.bytes -= entrySize(en.key, en.data)
+	}
+}
+
+// purge empties the cache
+func (c *layersCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
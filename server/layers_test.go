@@ -0,0 +1,94 @@
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/mvt"
+)
+
+// manyLayers returns a layers parameter with more than maxLayersParam names
+func manyLayers() string {
+	names := make([]string, maxLayersParam+1)
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestParseLayers(t *testing.T) {
+	names, err := parseLayers(" water,roads,,water")
+	require.NoError(t, err)
+	require.Equal(t, []string{"roads", "water"}, names)
+
+	_, err = parseLayers(manyLayers())
+	require.Error(t, err)
+}
+
+func TestServer_layers(t *testing.T) {
+	s, err := New("layers_test", "", tileStore(gzipped(t, string(testTile(t)), gzip.DefaultCompression)), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+		WithLayersCache(1<<20),
+	)
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.pbf", s)
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	for _, url := range []string{"/tiles/3/1/2.pbf?layers=water", "/tiles/3/1/2.pbf?layers=water,unknown,water"} {
+		w := get(url)
+		require.Equal(t, http.StatusOK, w.Code)
+		data, err := gunzip(w.Body.Bytes())
+		require.NoError(t, err)
+		layers, err := mvt.Decode(data)
+		require.NoError(t, err)
+		require.Len(t, layers, 1)
+		require.Equal(t, "water", layers[0].Name)
+	}
+	require.Len(t, s.layersCache.items, 2)
+
+	s.SetLayersCacheSize(s.layersCache.bytes - 1)
+	require.Len(t, s.layersCache.items, 1)
+
+	// without the parameter the tile is served as stored
+	w := get("/tiles/3/1/2.pbf")
+	require.Equal(t, http.StatusOK, w.Code)
+	data, err := gunzip(w.Body.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, testTile(t), data)
+
+	w = get("/tiles/3/1/2.pbf?layers=" + manyLayers())
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	require.NoError(t, s.RefreshMapInfos())
+	require.Empty(t, s.layersCache.items)
+}
+
+func TestLayersCache_KeySize(t *testing.T) {
+	c := newLayersCache(100)
+
+	// a long layers list weighs as much as the tile
+	c.add(strings.Repeat("k", 90), make([]byte, 20))
+	require.Empty(t, c.items)
+
+	c.add("3/1/2:water", make([]byte, 20))
+	require.Equal(t, int64(len("3/1/2:water")+20), c.bytes)
+
+	c.add(strings.Repeat("k", 60), make([]byte, 20))
+	require.Len(t, c.items, 1)
+	require.Equal(t, int64(80), c.bytes)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// limit scopes
+const (
+	limitGlobal = "global"
+	limitMap    = "map"
+)
+
+var (
+	requestsShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_shed_total",
+		Help:      "Data requests replied with a 503 by the concurrency limits, per scope (global, map).",
+	}, []string{"scope"})
+
+	requestsQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_queued",
+		Help:      "Data requests waiting for a concurrency slot, per scope (global, map).",
+	}, []string{"scope"})
+)
+
+// errOverloaded is returned when no slot is available within the queue limits
+var errOverloaded = errors.New("too many requests in flight")
+
+// limiter bounds the concurrent requests to max, up to queue requests wait at most wait for a slot
+type limiter struct {
+	scope string
+	slots chan struct{}
+	queue int64
+	wait  time.Duration
+	// queued is the count of requests waiting for a slot, accessed atomically
+	queued int64
+}
+
+func newLimiter(scope string, max, queue int, wait time.Duration) *limiter {
+	return &limiter{scope: scope, slots: make(chan struct{}, max), queue: int64(queue), wait: wait}
+}
+
+// acquire takes a slot, waiting in the queue if there is room, release must be called once done
+func (l *limiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if atomic.AddInt64(&l.queued, 1) > l.queue {
+		atomic.AddInt64(&l.queued, -1)
+		requestsShed.WithLabelValues(l.scope).Inc()
+		return errOverloaded
+	}
+	requestsQueued.WithLabelValues(l.scope).Inc()
+	defer func() {
+		atomic.AddInt64(&l.queued, -1)
+		requestsQueued.WithLabelValues(l.scope).Dec()
+	}()
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		requestsShed.WithLabelValues(l.scope).Inc()
+		return errOverloaded
+	}
+}
+
+func (l *limiter) release() {
+	<-l.slots
+}
+
+// concurrencyLimits are the global limiter and the limiters of each map by version
+type concurrencyLimits struct {
+	global *limiter
+	perMap int
+	queue  int
+	wait   time.Duration
+
+	mu   sync.Mutex
+	maps map[string]*limiter
+}
+
+// WithConcurrencyLimits bounds the data requests in flight to global, and the tiles requests in flight
+// per map (the stable, canary and each snapshot) to perMap, 0 for no limit,
+// up to queue requests per limit wait at most wait for a slot, the others are replied with a 503,
+// so a slow backend can't hold all the goroutines and memory
+func WithConcurrencyLimits(global, perMap, queue int, wait time.Duration) Option {
+	return func(s *Server) {
+		if global <= 0 && perMap <= 0 {
+			s.limits = nil
+			return
+		}
+		s.limits = &concurrencyLimits{perMap: perMap, queue: queue, wait: wait, maps: make(map[string]*limiter)}
+		if global > 0 {
+			s.limits.global = newLimiter(limitGlobal, global, queue, wait)
+		}
+	}
+}
+
+// mapLimiter returns the limiter of the map version, nil when there is no per map limit
+func (s *Server) mapLimiter(version string) *limiter {
+	if s.limits == nil || s.limits.perMap <= 0 {
+		return nil
+	}
+	s.limits.mu.Lock()
+	defer s.limits.mu.Unlock()
+	l, ok := s.limits.maps[version]
+	if !ok {
+		l = newLimiter(limitMap, s.limits.perMap, s.limits.queue, s.limits.wait)
+		s.limits.maps[version] = l
+	}
+	return l
+}
+
+// LimitHandler is a middleware applying the global concurrency limit, the requests over the limit
+// are replied with a 503
+func (s *Server) LimitHandler(next http.Handler) http.Handler {
+	if s.limits == nil || s.limits.global == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := s.limits.global.acquire(req.Context()); err != nil {
+			writeOverloaded(w, err)
+			return
+		}
+		defer s.limits.global.release()
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// writeOverloaded replies to a request that didn't get a slot
+func writeOverloaded(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.Canceled) {
+		// the client is gone while queued
+		writeError(w, statusClientClosedRequest, "client closed request")
+		return
+	}
+	w.Header().Set("Retry-After", "1")
+	writeError(w, http.StatusServiceUnavailable, err.Error())
+}
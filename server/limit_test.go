@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// blockingStore blocks the tiles reads until release is closed
+type blockingStore struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s blockingStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return []byte("tile"), nil
+}
+
+func (blockingStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 14}, true, nil
+}
+
+func (blockingStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(limitGlobal, 1, 1, 50*time.Millisecond)
+	require.NoError(t, l.acquire(context.Background()))
+
+	// queued until the slot is released
+	done := make(chan error)
+	go func() { done <- l.acquire(context.Background()) }()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&l.queued) == 1 }, time.Second, time.Millisecond)
+
+	// the queue is full
+	require.ErrorIs(t, l.acquire(context.Background()), errOverloaded)
+
+	l.release()
+	require.NoError(t, <-done)
+
+	// waited too long
+	require.ErrorIs(t, l.acquire(context.Background()), errOverloaded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, l.acquire(ctx), context.Canceled)
+	l.release()
+	require.Zero(t, atomic.LoadInt64(&l.queued))
+}
+
+func TestServer_ConcurrencyLimits(t *testing.T) {
+	stable := blockingStore{started: make(chan struct{}), release: make(chan struct{})}
+	s, err := New("limit_test", "", stable, log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""), WithConcurrencyLimits(0, 1, 0, time.Second),
+		WithSnapshots(map[string]storage.TileStore{"2024-01-01": tileStore("jan")}))
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf}", s.LimitHandler(s))
+	r.Handle("/tiles/{date:[0-9]+-[0-9]+-[0-9]+}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf}", s.LimitHandler(s))
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/1/0/0.pbf", nil))
+		done <- w.Code
+	}()
+	<-stable.started
+
+	// the stable map is busy, without a queue
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/1/0/1.pbf", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// the snapshot has its own limit
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/2024-01-01/1/0/1.pbf", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	close(stable.release)
+	require.Equal(t, http.StatusOK, <-done)
+}
+
+func TestServer_LimitHandler(t *testing.T) {
+	s := &Server{}
+	WithConcurrencyLimits(1, 0, 0, time.Second)(s)
+	started, release := make(chan struct{}), make(chan struct{})
+	h := s.LimitHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/query", nil))
+		close(done)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/query", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	close(release)
+	<-done
+	require.Nil(t, s.mapLimiter(versionStable))
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/mask"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+func TestServer_mask(t *testing.T) {
+	m, err := mask.Parse([]byte(`{"type": "Polygon", "coordinates": [[[-10, -10], [10, -10], [10, 10], [-10, 10], [-10, -10]]]}`))
+	require.NoError(t, err)
+
+	s, err := New("mask_test", "", tileStore(gzipped(t, string(testTile(t)), gzip.DefaultCompression)), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+		WithMask(m),
+	)
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.pbf", s)
+	get := func(tile tilemath.Tile) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/tiles/%d/%d/%d.pbf", tile.Z, tile.X, tile.Y), nil))
+		return w
+	}
+
+	w := get(tilemath.FromLatLng(48.8, 2.3, 8))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Zero(t, w.Body.Len())
+
+	w = get(tilemath.FromLatLng(5, 5, 8))
+	require.Equal(t, http.StatusOK, w.Code)
+	data, err := gunzip(w.Body.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, testTile(t), data)
+
+	// the test tile features are in the top left corner, outside the mask
+	w = get(tilemath.Tile{Z: 0})
+	require.Equal(t, http.StatusOK, w.Code)
+	data, err = gunzip(w.Body.Bytes())
+	require.NoError(t, err)
+	require.Empty(t, data)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "kvtiles"
+
+var (
+	tilesRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tiles_requests_total",
+		Help:      "Tiles requests per zoom level and status code.",
+	}, []string{"zoom", "code"})
+
+	tilesLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "tiles_request_duration_seconds",
+		Help:      "Tiles requests latency per zoom level.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"zoom"})
+
+	tilesBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tiles_bytes_total",
+		Help:      "Tiles bytes served per zoom level.",
+	}, []string{"zoom"})
+
+	tilesLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tiles_lookups_total",
+		Help:      "Tiles storage lookups per zoom level, result is hit when the tile exists or miss.",
+	}, []string{"zoom", "result"})
+)
+
+// zoomLabels are the zoom metrics labels, precomputed to avoid allocations per request
+var zoomLabels = func() []string {
+	l := make([]string, maxTileZoom+1)
+	for i := range l {
+		l[i] = strconv.Itoa(i)
+	}
+	return l
+}()
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maximum duration an introspected token is trusted without asking again
+	introspectionCacheTTL = time.Minute
+	// maximum count of introspected tokens cached, the least recently used are dropped
+	introspectionCacheSize = 10000
+)
+
+type ctxKey int
+
+const subjectKey ctxKey = iota
+
+// TokenIntrospector validates OAuth2 bearer tokens against an introspection endpoint (RFC 7662)
+type TokenIntrospector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	scope        string
+	client       *http.Client
+
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	cache      map[string]*list.Element
+}
+
+type cachedToken struct {
+	token string
+	in    introspection
+}
+
+type introspection struct {
+	Active   bool   `json:"active"`
+	Scope    string `json:"scope"`
+	Subject  string `json:"sub"`
+	Username string `json:"username"`
+	Exp      int64  `json:"exp"`
+
+	validUntil time.Time
+}
+
+// NewTokenIntrospector returns a TokenIntrospector, if scope is not empty, tokens must be granted this scope
+func NewTokenIntrospector(endpoint, clientID, clientSecret, scope string) *TokenIntrospector {
+	return &TokenIntrospector{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scope:        scope,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		maxEntries:   introspectionCacheSize,
+		ll:           list.New(),
+		cache:        make(map[string]*list.Element),
+	}
+}
+
+// DiscoverIntrospectionEndpoint reads the introspection endpoint from the OIDC issuer discovery document
+func DiscoverIntrospectionEndpoint(ctx context.Context, issuer string) (string, error) {
+	u := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("can't fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("OIDC discovery returned status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		IntrospectionEndpoint string `json:"introspection_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", fmt.Errorf("can't decode OIDC discovery document: %w", err)
+	}
+	if doc.IntrospectionEndpoint == "" {
+		return "", errors.New("OIDC issuer does not provide an introspection endpoint")
+	}
+
+	return doc.IntrospectionEndpoint, nil
+}
+
+// Handler is a middleware rejecting requests without an active bearer token
+func (t *TokenIntrospector) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == req.Header.Get("Authorization") {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
+
+		in, err := t.introspect(req.Context(), token)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, http.StatusText(http.StatusBadGateway))
+			return
+		}
+		if !in.Active {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
+		if t.scope != "" && !hasScope(in.Scope, t.scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			writeError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		sub := in.Subject
+		if sub == "" {
+			sub = in.Username
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), subjectKey, sub)))
+	})
+}
+
+func (t *TokenIntrospector) introspect(ctx context.Context, token string) (introspection, error) {
+	now := time.Now()
+
+	if in, ok := t.cached(token, now); ok {
+		return in, nil
+	}
+
+	var in introspection
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return in, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.clientID, t.clientSecret)
+
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return in, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return in, fmt.Errorf("introspection returned status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&in); err != nil {
+		return in, err
+	}
+
+	in.validUntil = now.Add(introspectionCacheTTL)
+	if exp := time.Unix(in.Exp, 0); in.Exp > 0 && exp.Before(in.validUntil) {
+		in.validUntil = exp
+		// an expired token is not active, whatever the server says
+		if !exp.After(now) {
+			in.Active = false
+		}
+	}
+
+	t.store(token, in)
+
+	return in, nil
+}
+
+// cached returns the introspection of token if cached and still valid
+func (t *TokenIntrospector) cached(token string, now time.Time) (introspection, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.cache[token]
+	if !ok {
+		return introspection{}, false
+	}
+	ct := e.Value.(*cachedToken)
+	if !now.Before(ct.in.validUntil) {
+		t.ll.Remove(e)
+		delete(t.cache, token)
+		return introspection{}, false
+	}
+	t.ll.MoveToFront(e)
+	return ct.in, true
+}
+
+// store caches the introspection of token, dropping the least recently used tokens above maxEntries
+func (t *TokenIntrospector) store(token string, in introspection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.cache[token]; ok {
+		e.Value.(*cachedToken).in = in
+		t.ll.MoveToFront(e)
+		return
+	}
+	t.cache[token] = t.ll.PushFront(&cachedToken{token: token, in: in})
+	for t.ll.Len() > t.maxEntries {
+		e := t.ll.Back()
+		t.ll.Remove(e)
+		delete(t.cache, e.Value.(*cachedToken).token)
+	}
+}
+
+// Subject returns the authenticated subject stored in ctx if any
+func Subject(ctx context.Context) string {
+	sub, _ := ctx.Value(subjectKey).(string)
+	return sub
+}
+
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
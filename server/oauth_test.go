@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// introspectionServer answers the introspection of the tokens valid, expired, reader and any other as invalid
+func introspectionServer(t *testing.T, calls *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(calls, 1)
+		user, pass, ok := req.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "client", user)
+		require.Equal(t, "secret", pass)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch req.PostFormValue("token") {
+		case "valid":
+			fmt.Fprintf(w, `{"active": true, "scope": "openid admin", "sub": "alice", "exp": %d}`, time.Now().Add(time.Hour).Unix())
+		case "expired":
+			// some servers don't check the expiration
+			fmt.Fprintf(w, `{"active": true, "scope": "admin", "sub": "bob", "exp": %d}`, time.Now().Add(-time.Minute).Unix())
+		case "reader":
+			fmt.Fprint(w, `{"active": true, "scope": "read", "username": "carol"}`)
+		default:
+			fmt.Fprint(w, `{"active": false}`)
+		}
+	}))
+}
+
+func TestTokenIntrospector_Handler(t *testing.T) {
+	var calls int64
+	ts := introspectionServer(t, &calls)
+	defer ts.Close()
+
+	ti := NewTokenIntrospector(ts.URL, "client", "secret", "admin")
+	h := ti.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, Subject(req.Context()))
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+		wantSubject   string
+		wantChallenge string
+	}{
+		{"valid", "Bearer valid", http.StatusOK, "alice", ""},
+		{"expired", "Bearer expired", http.StatusUnauthorized, "", `Bearer error="invalid_token"`},
+		{"invalid", "Bearer invalid", http.StatusUnauthorized, "", `Bearer error="invalid_token"`},
+		{"insufficient scope", "Bearer reader", http.StatusForbidden, "", `Bearer error="insufficient_scope"`},
+		{"missing", "", http.StatusUnauthorized, "", "Bearer"},
+		{"not bearer", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, "", "Bearer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/mapinfos", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			require.Equal(t, tt.want, w.Code)
+			require.Equal(t, tt.wantChallenge, w.Header().Get("WWW-Authenticate"))
+			if tt.wantSubject != "" {
+				require.Equal(t, tt.wantSubject, w.Body.String())
+			}
+		})
+	}
+
+	// the valid token is cached, the expired one is not
+	atomic.StoreInt64(&calls, 0)
+	for _, token := range []string{"valid", "expired"} {
+		req := httptest.NewRequest("GET", "/admin/mapinfos", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+
+	// the introspection endpoint is down
+	ts.Close()
+	req := httptest.NewRequest("GET", "/admin/mapinfos", nil)
+	req.Header.Set("Authorization", "Bearer other")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadGateway, w.Code)
+}
+
+func TestTokenIntrospector_CacheBound(t *testing.T) {
+	var calls int64
+	ts := introspectionServer(t, &calls)
+	defer ts.Close()
+
+	ti := NewTokenIntrospector(ts.URL, "client", "secret", "")
+	ti.maxEntries = 2
+
+	for _, token := range []string{"a", "b", "a", "c"} {
+		_, err := ti.introspect(context.Background(), token)
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(3), atomic.LoadInt64(&calls))
+	require.Equal(t, 2, ti.ll.Len())
+	require.Len(t, ti.cache, 2)
+
+	// b was the least recently used
+	_, err := ti.introspect(context.Background(), "a")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), atomic.LoadInt64(&calls))
+	_, err = ti.introspect(context.Background(), "b")
+	require.NoError(t, err)
+	require.Equal(t, int64(4), atomic.LoadInt64(&calls))
+}
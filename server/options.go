@@ -0,0 +1,110 @@
+package server
+
+import (
+	"time"
+
+	log "github.com/go-kit/kit/log"
+
+	"github.com/akhenakh/kvtiles/apikey"
+	"github.com/akhenakh/kvtiles/events"
+	"github.com/akhenakh/kvtiles/mask"
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// Option configures optional features of the Server
+type Option func(*Server)
+
+// WithURLSigningKey requires tiles URLs to be signed by key using SignPath,
+// the tiles key, if any, is still accepted in place of a signature.
+func WithURLSigningKey(key []byte) Option {
+	return func(s *Server) {
+		s.signingKey = key
+	}
+}
+
+// WithAPIKeys enables the API keys from the store in addition to the tiles key,
+// enforcing their quotas and zoom restrictions
+func WithAPIKeys(keys *apikey.Store) Option {
+	return func(s *Server) {
+		s.keys = keys
+	}
+}
+
+// WithTrustedProxies sets the proxies allowed to report the client IP via X-Forwarded-For
+func WithTrustedProxies(proxies TrustedProxies) Option {
+	return func(s *Server) {
+		s.proxies = proxies
+	}
+}
+
+// WithAuditLogger logs authenticated tiles requests and admin operations to logger
+func WithAuditLogger(logger log.Logger) Option {
+	return func(s *Server) {
+		s.auditLogger = logger
+	}
+}
+
+// WithSlowRequestThreshold logs the details of tiles requests taking longer than d
+func WithSlowRequestThreshold(d time.Duration) Option {
+	return func(s *Server) {
+		s.slowThreshold = d
+	}
+}
+
+// WithRequestTimeout sets the overall deadline of the tiles storage reads, 0 for no deadline
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = d
+	}
+}
+
+// WithStaticDir serves the debug map and the templates from dir instead of ./static, the files missing from dir
+// are served from the embedded defaults, empty to disable them
+func WithStaticDir(dir string) Option {
+	return func(s *Server) {
+		s.staticDir = dir
+	}
+}
+
+// WithVersion sets the application version reported by the version handler
+func WithVersion(version string) Option {
+	return func(s *Server) {
+		s.version = version
+	}
+}
+
+// WithEvents emits a tile served or tile missing event to bus for every tiles request
+func WithEvents(bus *events.Bus) Option {
+	return func(s *Server) {
+		s.events = bus
+	}
+}
+
+// WithMask restricts the served tiles to the mask m, tiles outside are served as empty
+// with a 204 status, features outside are removed from the tiles crossing the mask border
+func WithMask(m *mask.Mask) Option {
+	return func(s *Server) {
+		s.mask = m
+	}
+}
+
+// WithBackup serves the snapshots of snap on BackupHandler
+func WithBackup(snap storage.Snapshotter) Option {
+	return func(s *Server) {
+		s.backup = snap
+	}
+}
+
+// WithWriter accepts the tiles and map infos writes of TileWriteHandler and MapInfosWriteHandler, stored by writer
+func WithWriter(writer storage.Writer) Option {
+	return func(s *Server) {
+		s.writer = writer
+	}
+}
+
+// WithSearch serves the features search of searcher on SearchHandler
+func WithSearch(searcher storage.Searcher) Option {
+	return func(s *Server) {
+		s.search = searcher
+	}
+}
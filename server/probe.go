@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+var (
+	probeLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "storage_probe_duration_seconds",
+		Help:      "Duration of the tiles reads of the storage health probe.",
+		Buckets:   []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	})
+
+	probeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "storage_probe_errors_total",
+		Help:      "Failed tiles reads of the storage health probe.",
+	})
+
+	probeHealthy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "storage_probe_healthy",
+		Help:      "1 while the storage health probe is within its latency and error rate thresholds.",
+	})
+)
+
+// probeResult is the result of a probe read
+type probeResult struct {
+	latency time.Duration
+	err     error
+}
+
+// storageProbe reads a random tile of the storage every interval, the storage is unhealthy
+// when the mean latency or the error rate of the last window reads exceeds the thresholds
+type storageProbe struct {
+	store        storage.TileStore
+	interval     time.Duration
+	maxLatency   time.Duration
+	maxErrorRate float64
+	window       int
+
+	results []probeResult
+	next    int
+}
+
+// WithStorageProbe reads a random tile of store every interval, the storage behind the caches or the served store if nil,
+// the server is reported unhealthy while the mean latency of the last window reads exceeds maxLatency
+// or their error rate exceeds maxErrorRate, see RunStorageProbe
+func WithStorageProbe(store storage.TileStore, interval, maxLatency time.Duration, maxErrorRate float64, window int) Option {
+	return func(s *Server) {
+		if interval <= 0 {
+			s.probe = nil
+			return
+		}
+		if window < 1 {
+			window = 1
+		}
+		s.probe = &storageProbe{
+			store:        store,
+			interval:     interval,
+			maxLatency:   maxLatency,
+			maxErrorRate: maxErrorRate,
+			window:       window,
+		}
+	}
+}
+
+// RunStorageProbe probes the storage until ctx is done, onChange is called when the storage
+// becomes unhealthy or recovers, it returns at once without WithStorageProbe
+func (s *Server) RunStorageProbe(ctx context.Context, onChange func(healthy bool)) error {
+	p := s.probe
+	if p == nil {
+		return nil
+	}
+	if p.store == nil {
+		p.store = s.tileStorage
+	}
+	s.probeStatus.Store("ok")
+	probeHealthy.Set(1)
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		r := p.read(ctx, rnd, s.maxZoomOrZero())
+		if ctx.Err() != nil {
+			return nil
+		}
+		msg := p.record(r)
+		s.probeStatus.Store(msg)
+		if ok := msg == "ok"; ok != healthy {
+			healthy = ok
+			if healthy {
+				probeHealthy.Set(1)
+				level.Info(s.logger).Log("msg", "storage probe recovered")
+			} else {
+				probeHealthy.Set(0)
+				level.Error(s.logger).Log("msg", "storage probe failing", "reason", msg)
+			}
+			onChange(healthy)
+		}
+	}
+}
+
+// maxZoomOrZero returns the max zoom of the map, 0 if unknown
+func (s *Server) maxZoomOrZero() uint8 {
+	if z := atomic.LoadInt32(&s.maxZoom); z > 0 {
+		return uint8(z)
+	}
+	return 0
+}
+
+// read reads a random tile up to maxZoom, a missing tile is a successful read
+func (p *storageProbe) read(ctx context.Context, rnd *rand.Rand, maxZoom uint8) probeResult {
+	z := uint8(rnd.Intn(int(maxZoom) + 1))
+	n := int64(1) << z
+	x, y := uint64(rnd.Int63n(n)), uint64(rnd.Int63n(n))
+
+	ctx, cancel := context.WithTimeout(ctx, p.interval)
+	defer cancel()
+	start := time.Now()
+	_, err := p.store.ReadTileData(ctx, z, x, y)
+	r := probeResult{latency: time.Since(start), err: err}
+	probeLatency.Observe(r.latency.Seconds())
+	if err != nil {
+		probeErrors.Inc()
+	}
+	return r
+}
+
+// record adds r to the window and returns "ok" or the exceeded threshold
+func (p *storageProbe) record(r probeResult) string {
+	if len(p.results) < p.window {
+		p.results = append(p.results, r)
+	} else {
+		p.results[p.next] = r
+		p.next = (p.next + 1) % p.window
+	}
+
+	var errs int
+	var total time.Duration
+	for _, r := range p.results {
+		if r.err != nil {
+			errs++
+		}
+		total += r.latency
+	}
+	if rate := float64(errs) / float64(len(p.results)); rate > p.maxErrorRate {
+		return fmt.Sprintf("error rate %.2f over %.2f", rate, p.maxErrorRate)
+	}
+	if mean := total / time.Duration(len(p.results)); p.maxLatency > 0 && mean > p.maxLatency {
+		return fmt.Sprintf("mean latency %s over %s", mean, p.maxLatency)
+	}
+	return "ok"
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// failingStore fails the tiles reads while failing is set
+type failingStore struct {
+	failing *int32
+}
+
+func (s failingStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	if atomic.LoadInt32(s.failing) == 1 {
+		return nil, errors.New("input/output error")
+	}
+	return nil, nil
+}
+
+func (failingStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 14}, true, nil
+}
+
+func (failingStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func TestStorageProbe_Record(t *testing.T) {
+	p := &storageProbe{maxLatency: 100 * time.Millisecond, maxErrorRate: 0.5, window: 4}
+
+	require.Equal(t, "ok", p.record(probeResult{latency: time.Millisecond}))
+	require.Equal(t, "ok", p.record(probeResult{latency: time.Millisecond, err: errors.New("failed")}))
+	require.Equal(t, "error rate 0.67 over 0.50", p.record(probeResult{latency: time.Millisecond, err: errors.New("failed")}))
+	require.Equal(t, "ok", p.record(probeResult{latency: time.Millisecond}))
+	// the first read is out of the window
+	require.Equal(t, "ok", p.record(probeResult{latency: time.Millisecond}))
+	require.Len(t, p.results, 4)
+
+	require.Equal(t, "mean latency 250.75ms over 100ms", p.record(probeResult{latency: time.Second}))
+}
+
+func TestServer_RunStorageProbe(t *testing.T) {
+	var failing int32
+	store := failingStore{failing: &failing}
+	s, err := New("probe_test", "", tileStore("tile"), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""), WithStorageProbe(store, time.Millisecond, time.Second, 0, 2))
+	require.NoError(t, err)
+	s.SetReady(true)
+
+	changes := make(chan bool, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.RunStorageProbe(ctx, func(healthy bool) { changes <- healthy }) }()
+
+	atomic.StoreInt32(&failing, 1)
+	require.False(t, <-changes)
+	require.False(t, s.Serving())
+
+	w := httptest.NewRecorder()
+	s.ReadyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Contains(t, w.Body.String(), "error rate")
+
+	atomic.StoreInt32(&failing, 0)
+	require.True(t, <-changes)
+	require.True(t, s.Serving())
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// disabled
+	s, err = New("probe_test", "", tileStore("tile"), log.NewNopLogger(), health.NewServer(), WithStaticDir(""))
+	require.NoError(t, err)
+	require.NoError(t, s.RunStorageProbe(context.Background(), func(bool) { t.Fatal("unexpected change") }))
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// QueryHandler returns, for each layer of the tile covering the lat lng param at zoom,
+// the features containing the point or else the nearest one, as a GeoJSON feature collection,
+// zoom defaults to the max zoom of the map
+func (s *Server) QueryHandler(w http.ResponseWriter, req *http.Request) {
+	if s.isStandby() {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "standby")
+		return
+	}
+
+	q := req.URL.Query()
+	lat, lng, z, err := s.parsePoint(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	code, a := s.authorize(req, z)
+	if code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
+
+	var layers []string
+	if param := q.Get("layers"); param != "" {
+		if layers, err = parseLayers(param); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	redactor, ok := s.redactor(q.Get("redact"), a)
+	if !ok {
+		writeError(w, http.StatusForbidden, "redact requires a trusted key")
+		return
+	}
+
+	lang := q.Get("lang")
+	if lang != "" && !validLang(lang) {
+		writeError(w, http.StatusBadRequest, "invalid lang")
+		return
+	}
+
+	fc := &mvt.FeatureCollection{Type: "FeatureCollection", Features: []mvt.GeoJSONFeature{}}
+	t := tilemath.FromLatLng(lat, lng, z)
+	if s.mask == nil || s.mask.Contains(lat, lng) {
+		ctx := req.Context()
+		if s.requestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+			defer cancel()
+		}
+
+		data, err := s.tileStorage.ReadTileData(ctx, z, t.X, t.TMSY())
+		if err != nil {
+			level.Error(s.requestLogger(req)).Log("msg", "error reading tile", "error", err, "z", t.Z, "x", t.X, "y", t.Y)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(data) > 0 {
+			tr := &TileRequest{Z: t.Z, X: t.X, Y: t.Y, KeyID: a.keyID, Request: req}
+			if fc, err = s.queryTile(tr, data, layers, redactor, lang, lat, lng); err != nil {
+				level.Error(s.requestLogger(req)).Log("msg", "error querying tile", "error", err, "z", t.Z, "x", t.X, "y", t.Y)
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(fc)
+}
+
+// queryTile returns the features of the gzipped tile data selected by QueryHandler
+func (s *Server) queryTile(tr *TileRequest, data []byte, names []string, redactor TileTransformer, lang string,
+	lat, lng float64) (*mvt.FeatureCollection, error) {
+	tile, err := gunzip(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		if tile, err = KeepLayers(names...).Transform(tr, tile); err != nil {
+			return nil, err
+		}
+	}
+	if redactor != nil {
+		if tile, err = redactor.Transform(tr, tile); err != nil {
+			return nil, err
+		}
+	}
+	if lang != "" {
+		if tile, err = LocalizeNames(lang).Transform(tr, tile); err != nil {
+			return nil, err
+		}
+	}
+	layers, err := mvt.Decode(tile)
+	if err != nil {
+		return nil, err
+	}
+
+	t := tilemath.Tile{Z: tr.Z, X: tr.X, Y: tr.Y}
+	fx, fy := t.Position(lat, lng)
+
+	selected := layers[:0]
+	for _, l := range layers {
+		var containing []mvt.Feature
+		var nearest []mvt.Feature
+		minDist := math.Inf(1)
+		for _, f := range l.Features {
+			d, err := mvt.Distance(f, fx*float64(l.Extent), fy*float64(l.Extent))
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case d == 0:
+				containing = append(containing, f)
+			case d < minDist:
+				minDist = d
+				nearest = []mvt.Feature{f}
+			}
+		}
+		if len(containing) > 0 {
+			l.Features = containing
+		} else {
+			l.Features = nearest
+		}
+		if len(l.Features) > 0 {
+			selected = append(selected, l)
+		}
+	}
+
+	return mvt.GeoJSON(selected, t)
+}
+
+// parsePoint returns the lat lng and zoom query parameters, zoom defaults to the max zoom of the map
+func (s *Server) parsePoint(q url.Values) (lat, lng float64, z uint8, err error) {
+	lat, err = strconv.ParseFloat(q.Get("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, 0, errors.New("invalid lat")
+	}
+	lng, err = strconv.ParseFloat(q.Get("lng"), 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return 0, 0, 0, errors.New("invalid lng")
+	}
+
+	maxZoom := s.tilesMaxZoom()
+	zoom := maxZoom
+	if param := q.Get("zoom"); param != "" {
+		if zoom, err = strconv.Atoi(param); err != nil || zoom < 0 || zoom > 30 || (maxZoom >= 0 && zoom > maxZoom) {
+			return 0, 0, 0, errors.New("invalid zoom")
+		}
+	}
+	if zoom < 0 {
+		return 0, 0, 0, errors.New("zoom is required")
+	}
+	return lat, lng, uint8(zoom), nil
+}
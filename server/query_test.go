@@ -0,0 +1,69 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/mvt"
+)
+
+func TestServer_QueryHandler(t *testing.T) {
+	square := func(x0, y0, x1, y1 int64) []uint32 {
+		return mvt.EncodeGeometry(mvt.Polygon, [][][2]int64{{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}, {x0, y0}}})
+	}
+	point := func(x, y int64) []uint32 {
+		return mvt.EncodeGeometry(mvt.Point, [][][2]int64{{{x, y}}})
+	}
+	tile, err := mvt.Encode([]mvt.Layer{
+		{Name: "landuse", Extent: 4096, Features: []mvt.Feature{
+			{ID: 1, Type: mvt.Polygon, Geometry: square(1000, 1000, 3000, 3000)},
+			{ID: 2, Type: mvt.Polygon, Geometry: square(0, 0, 100, 100)},
+		}},
+		{Name: "poi", Extent: 4096, Features: []mvt.Feature{
+			{ID: 3, Type: mvt.Point, Geometry: point(100, 100)},
+			{ID: 4, Type: mvt.Point, Geometry: point(2060, 2048), Properties: map[string]interface{}{"name": "Null Island", "name:fr": "Île Nulle"}},
+		}},
+	})
+	require.NoError(t, err)
+
+	s, err := New("query_test", "", tileStore(gzipped(t, string(tile), gzip.DefaultCompression)), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+	)
+	require.NoError(t, err)
+
+	get := func(url string) (*httptest.ResponseRecorder, mvt.FeatureCollection) {
+		w := httptest.NewRecorder()
+		s.QueryHandler(w, httptest.NewRequest("GET", url, nil))
+		var fc mvt.FeatureCollection
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fc))
+		}
+		return w, fc
+	}
+
+	w, _ := get("/query?lat=100&lng=0")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = get("/query?lat=0&lng=0&zoom=15")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the polygon containing the point and the nearest poi
+	w, fc := get("/query?lat=0&lng=0&zoom=0")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, fc.Features, 2)
+	require.EqualValues(t, 1, fc.Features[0].ID)
+	require.Equal(t, "landuse", fc.Features[0].Layer)
+	require.EqualValues(t, 4, fc.Features[1].ID)
+	require.Equal(t, "Null Island", fc.Features[1].Properties["name"])
+
+	w, fc = get("/query?lat=0&lng=0&zoom=0&layers=poi&lang=fr")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, fc.Features, 1)
+	require.Equal(t, "Île Nulle", fc.Features[0].Properties["name"])
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// SetReady marks the server as ready or not to receive traffic, as reported by /readyz
+func (s *Server) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+// SetStandby marks the server as a standby: tiles requests are refused with a 503
+// and /readyz fails, until the server is made active
+func (s *Server) SetStandby(standby bool) {
+	var v int32
+	if standby {
+		v = 1
+	}
+	atomic.StoreInt32(&s.standby, v)
+}
+
+// SetSelfCheck records the result of the DB self-check, the server is not ready while it failed
+func (s *Server) SetSelfCheck(err error) {
+	msg := "ok"
+	if err != nil {
+		msg = err.Error()
+	}
+	s.selfCheck.Store(msg)
+}
+
+// Serving reports whether the health status can be SERVING: not a standby,
+// the DB self-check and the storage probe are not failing
+func (s *Server) Serving() bool {
+	selfCheck, _ := s.selfCheck.Load().(string)
+	probe, _ := s.probeStatus.Load().(string)
+	return !s.isStandby() && (selfCheck == "" || selfCheck == "ok") && (probe == "" || probe == "ok")
+}
+
+func (s *Server) isReady() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+func (s *Server) isStandby() bool {
+	return atomic.LoadInt32(&s.standby) == 1
+}
+
+// LivezHandler reports the process is up
+func (s *Server) LivezHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status": "ok"}`))
+}
+
+// ReadyzHandler reports the server is ready to serve tiles:
+// startup completed, DB open, map infos loaded, a storage read succeeded, the DB self-check passed
+// and the storage probe is healthy
+func (s *Server) ReadyzHandler(w http.ResponseWriter, req *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	fail := func(check, msg string) {
+		checks[check] = msg
+		ready = false
+	}
+
+	if s.isReady() {
+		checks["startup"] = "ok"
+	} else {
+		fail("startup", "starting")
+	}
+
+	if s.isStandby() {
+		fail("role", "standby")
+	}
+
+	_, ok, err := s.tileStorage.LoadMapInfos()
+	switch {
+	case err != nil:
+		fail("mapinfos", err.Error())
+	case !ok:
+		fail("mapinfos", "no map in DB")
+	default:
+		checks["mapinfos"] = "ok"
+	}
+
+	_, err = s.tileStorage.ReadTileData(req.Context(), 0, 0, 0)
+	switch {
+	case errors.Is(err, storage.ErrUnavailable):
+		// still serving from the caches
+		checks["storage"] = "degraded"
+	case err != nil:
+		fail("storage", err.Error())
+	default:
+		checks["storage"] = "ok"
+	}
+
+	switch msg, _ := s.selfCheck.Load().(string); msg {
+	case "":
+	case "ok":
+		checks["selfcheck"] = "ok"
+	default:
+		fail("selfcheck", msg)
+	}
+
+	switch msg, _ := s.probeStatus.Load().(string); msg {
+	case "":
+	case "ok":
+		checks["probe"] = "ok"
+	default:
+		fail("probe", msg)
+	}
+
+	status := "ready"
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		status = "not_ready"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"strings"
+)
+
+// WithRedaction removes the attributes of the rules from every served tile,
+// requests authenticated with a trusted API key are not redacted
+func WithRedaction(rules ...RedactRule) Option {
+	return func(s *Server) {
+		if len(rules) > 0 {
+			s.redaction = Redact(rules...)
+		}
+	}
+}
+
+// ParseRedactRules parses attribute or layer.attribute rules, e.g. housenumber,poi.osm_id
+func ParseRedactRules(rules []string) []RedactRule {
+	var parsed []RedactRule
+	for _, r := range rules {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		var layer string
+		if i := strings.Index(r, "."); i > 0 && i < len(r)-1 {
+			layer, r = r[:i], r[i+1:]
+		}
+		parsed = append(parsed, RedactRule{Layer: layer, Attributes: []string{r}})
+	}
+	return parsed
+}
+
+// redactor returns the TileTransformer redacting the tile of a request, nil for none,
+// trusted keys choose the redacted attributes with the redact query parameter,
+// ok is false when the parameter is used without a trusted key
+func (s *Server) redactor(param string, a auth) (t TileTransformer, ok bool) {
+	if param != "" {
+		if !a.trusted {
+			return nil, false
+		}
+		return Redact(ParseRedactRules(strings.Split(param, ","))...), true
+	}
+	if a.trusted {
+		return nil, true
+	}
+	return s.redaction, true
+}
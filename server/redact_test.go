@@ -0,0 +1,75 @@
+package server
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/apikey"
+	"github.com/akhenakh/kvtiles/mvt"
+)
+
+func TestParseRedactRules(t *testing.T) {
+	require.Equal(t, []RedactRule{
+		{Attributes: []string{"housenumber"}},
+		{Layer: "poi", Attributes: []string{"osm_id"}},
+		{Attributes: []string{".x"}},
+	}, ParseRedactRules([]string{"housenumber", " poi.osm_id", "", ".x"}))
+}
+
+func TestServer_redaction(t *testing.T) {
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.json")
+	err := ioutil.WriteFile(keysPath, []byte(`[{"id": "public", "key": "pub"}, {"id": "internal", "key": "int", "trusted": true}]`), 0600)
+	require.NoError(t, err)
+	keys, closeKeys, err := apikey.Open(keysPath, filepath.Join(dir, "usage.db"))
+	require.NoError(t, err)
+	defer closeKeys()
+
+	s, err := New("redact_test", "", tileStore(gzipped(t, string(testTile(t)), gzip.DefaultCompression)), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+		WithAPIKeys(keys),
+		WithRedaction(ParseRedactRules([]string{"housenumber.osm_id", "class"})...),
+	)
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.pbf", s)
+	get := func(url string, code int) []mvt.Layer {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		require.Equal(t, code, w.Code)
+		if code != http.StatusOK {
+			return nil
+		}
+		data, err := gunzip(w.Body.Bytes())
+		require.NoError(t, err)
+		layers, err := mvt.Decode(data)
+		require.NoError(t, err)
+		require.Len(t, layers, 2)
+		return layers
+	}
+
+	layers := get("/tiles/3/1/2.pbf?key=pub", http.StatusOK)
+	require.Empty(t, layers[0].Features[0].Properties)
+	require.Equal(t, map[string]interface{}{"housenumber": "12"}, layers[1].Features[0].Properties)
+
+	// trusted keys get the full tiles or choose the redacted attributes
+	layers = get("/tiles/3/1/2.pbf?key=int", http.StatusOK)
+	require.Equal(t, map[string]interface{}{"class": "ocean"}, layers[0].Features[0].Properties)
+	require.Len(t, layers[1].Features[0].Properties, 2)
+
+	layers = get("/tiles/3/1/2.pbf?key=int&redact=housenumber", http.StatusOK)
+	require.Equal(t, map[string]interface{}{"class": "ocean"}, layers[0].Features[0].Properties)
+	require.Equal(t, map[string]interface{}{"osm_id": uint64(42)}, layers[1].Features[0].Properties)
+
+	get("/tiles/3/1/2.pbf?key=pub&redact=housenumber", http.StatusForbidden)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// NewRefererFilter returns a middleware rejecting requests whose Origin or Referer host
+// is not part of the allowed hosts, a host can be prefixed by "*." to match any subdomain.
+// Requests without Origin nor Referer are accepted only if allowEmpty is true.
+func NewRefererFilter(allowed []string, allowEmpty bool) func(http.Handler) http.Handler {
+	hosts := make([]string, 0, len(allowed))
+	for _, h := range allowed {
+		hosts = append(hosts, strings.ToLower(h))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			src := req.Header.Get("Origin")
+			if src == "" {
+				src = req.Header.Get("Referer")
+			}
+
+			if src == "" {
+				if !allowEmpty {
+					writeError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+					return
+				}
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			u, err := url.Parse(src)
+			if err != nil || !hostAllowed(hosts, strings.ToLower(u.Hostname())) {
+				writeError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func hostAllowed(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+		if strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefererFilter(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	tests := []struct {
+		name       string
+		allowEmpty bool
+		origin     string
+		referer    string
+		want       int
+	}{
+		{"allowed referer", false, "", "https://maps.example.com/index.html", http.StatusOK},
+		{"allowed origin", false, "https://maps.example.com", "", http.StatusOK},
+		{"case insensitive", false, "", "https://MAPS.Example.com/", http.StatusOK},
+		{"wildcard subdomain", false, "", "https://a.b.example.org/", http.StatusOK},
+		{"wildcard not the domain", false, "", "https://example.org/", http.StatusForbidden},
+		{"wildcard not a suffix", false, "", "https://evilexample.org/", http.StatusForbidden},
+		{"denied referer", false, "", "https://evil.com/maps.example.com", http.StatusForbidden},
+		{"origin over referer", false, "https://evil.com", "https://maps.example.com/", http.StatusForbidden},
+		{"invalid referer", false, "", "http://[::1", http.StatusForbidden},
+		{"empty denied", false, "", "", http.StatusForbidden},
+		{"empty allowed", true, "", "", http.StatusOK},
+		{"denied when empty allowed", true, "", "https://evil.com/", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewRefererFilter([]string{"maps.example.com", "*.example.org"}, tt.allowEmpty)(ok)
+			req := httptest.NewRequest("GET", "/tiles/1/1/1.pbf", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			require.Equal(t, tt.want, w.Code)
+		})
+	}
+}
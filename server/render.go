@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// maxRenders bounds the cached renders, the base URL comes from the client Host header
+const maxRenders = 256
+
+var (
+	templateRenders = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "template_renders_total",
+		Help:      "Debug map and styles templates served per template, cache is hit when served from the renders cache or miss.",
+	}, []string{"template", "cache"})
+
+	templateLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "template_render_duration_seconds",
+		Help:      "Debug map and styles templates execution latency per template, on cache misses.",
+		Buckets:   []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025},
+	}, []string{"template"})
+
+	staticRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "static_requests_total",
+		Help:      "Static files requests per file extension and status code, the extension is empty for errors.",
+	}, []string{"type", "code"})
+)
+
+// renderKey identifies a render, the other template variables only change with the map infos
+type renderKey struct {
+	template string
+	baseURL  string
+}
+
+// renderCache holds the executed templates, purged when the map infos change
+type renderCache struct {
+	mu      sync.Mutex
+	renders map[renderKey][]byte
+}
+
+func (c *renderCache) get(k renderKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.renders[k]
+	return b, ok
+}
+
+func (c *renderCache) add(k renderKey, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.renders == nil {
+		c.renders = make(map[renderKey][]byte)
+	}
+	if len(c.renders) < maxRenders {
+		c.renders[k] = b
+	}
+}
+
+func (c *renderCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.renders = nil
+}
+
+// render returns tpl executed with the template params of req, cached per template name and base URL
+func (s *Server) render(name string, tpl *template.Template, req *http.Request) ([]byte, error) {
+	p, err := s.templateParams(req)
+	if err != nil {
+		return nil, err
+	}
+
+	k := renderKey{template: name, baseURL: p["TilesBaseURL"].(string)}
+	if b, ok := s.renders.get(k); ok {
+		templateRenders.WithLabelValues(name, "hit").Inc()
+		return b, nil
+	}
+
+	start := time.Now()
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	templateLatency.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	templateRenders.WithLabelValues(name, "miss").Inc()
+
+	s.renders.add(k, buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// loadedMapInfos returns the map infos read by RefreshMapInfos, nil when the DB has no map
+func (s *Server) loadedMapInfos() *storage.MapInfos {
+	infos, _ := s.mapInfos.Load().(*storage.MapInfos)
+	return infos
+}
+
+// serveFile serves a static file, counting the requests per file extension
+func (s *Server) serveFile(w http.ResponseWriter, req *http.Request) {
+	sw := &statusWriter{ResponseWriter: w}
+	s.fileHandler.ServeHTTP(sw, req)
+
+	var typ string
+	if sw.Status() < http.StatusBadRequest {
+		typ = strings.TrimPrefix(strings.ToLower(filepath.Ext(req.URL.Path)), ".")
+	}
+	staticRequests.WithLabelValues(typ, strconv.Itoa(sw.Status())).Inc()
+}
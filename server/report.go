@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/errreport"
+)
+
+// RecoverHandler is a middleware recovering from panics,
+// panics and 5xx responses are sent to the error reporter if any
+func (s *Server) RecoverHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+
+		defer func() {
+			if r := recover(); r != nil {
+				stack := string(debug.Stack())
+				level.Error(s.requestLogger(req)).Log("msg", "panic serving request", "error", r, "stack", stack)
+				s.reportError(req, "fatal", fmt.Sprintf("panic: %v", r), http.StatusInternalServerError, stack)
+				if sw.status == 0 {
+					writeError(sw, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				}
+				return
+			}
+
+			if sw.Status() >= http.StatusInternalServerError {
+				s.reportError(req, "error", fmt.Sprintf("%s %s returned %d", req.Method, req.URL.Path, sw.Status()), sw.Status(), "")
+			}
+		}()
+
+		next.ServeHTTP(sw, req)
+	})
+}
+
+func (s *Server) reportError(req *http.Request, lvl, msg string, status int, stack string) {
+	s.recentErrors.add(recentError{Time: time.Now(), Status: status, Message: msg, RequestID: RequestID(req.Context())})
+
+	if s.reporter == nil {
+		return
+	}
+
+	s.reporter.Report(errreport.Event{
+		Level:     lvl,
+		Message:   msg,
+		Status:    status,
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		RequestID: RequestID(req.Context()),
+		ClientIP:  s.proxies.ClientIP(req).String(),
+		UserAgent: req.UserAgent(),
+		Stack:     stack,
+	})
+}
+
+// WithErrorReporter reports panics and 5xx responses to r
+func WithErrorReporter(r *errreport.Reporter) Option {
+	return func(s *Server) {
+		s.reporter = r
+	}
+}
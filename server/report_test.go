@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/errreport"
+)
+
+// chanSender sends the reported events to a channel
+type chanSender chan errreport.Event
+
+func (c chanSender) Send(ctx context.Context, e errreport.Event) error {
+	c <- e
+	return nil
+}
+
+func TestServer_RecoverHandler(t *testing.T) {
+	sent := make(chanSender, 1)
+	reporter := errreport.NewReporter(sent, "report_test", "1.2.3", log.NewNopLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reporter.Run(ctx)
+
+	s, err := New("report_test", "", cachedStore{}, log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""), WithErrorReporter(reporter))
+	require.NoError(t, err)
+
+	h := s.RecoverHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	req := httptest.NewRequest("GET", "/panic?key=1", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("User-Agent", "test")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	e := <-sent
+	require.Equal(t, "fatal", e.Level)
+	require.Equal(t, "panic: boom", e.Message)
+	require.Equal(t, http.StatusInternalServerError, e.Status)
+	require.Equal(t, "GET", e.Method)
+	require.Equal(t, "/panic?key=1", e.URL)
+	require.Equal(t, "192.0.2.1", e.ClientIP)
+	require.Equal(t, "test", e.UserAgent)
+	require.Contains(t, e.Stack, "TestServer_RecoverHandler")
+	require.Equal(t, "report_test", e.App)
+	require.Equal(t, "1.2.3", e.Version)
+
+	// the client errors are not reported
+	for _, path := range []string{"/", "/missing", "/unavailable"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	e = <-sent
+	require.Equal(t, "error", e.Level)
+	require.Equal(t, "GET /unavailable returned 503", e.Message)
+	require.Equal(t, http.StatusServiceUnavailable, e.Status)
+	require.Empty(t, e.Stack)
+
+	require.Len(t, s.recentErrors.list(), 2)
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// retinaSize is the width of the @2x tiles, stored tiles at least this wide are served as is
+const retinaSize = 512
+
+var retinaTiles = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "retina_tiles_total",
+	Help:      "@2x tiles served, source is stored, children when stitched from the next zoom, upscaled or cache.",
+}, []string{"source"})
+
+// WithRetinaCache caches the stitched and upscaled @2x raster tiles up to maxBytes
+func WithRetinaCache(maxBytes int64) Option {
+	return func(s *Server) {
+		s.retinaCache = nil
+		if maxBytes > 0 {
+			s.retinaCache = newLayersCache(maxBytes)
+		}
+	}
+}
+
+// retinaTile returns the @2x variant of the raster tile data at z x y in the XYZ scheme, data itself
+// when it is already a high-DPI tile, else the 4 tiles of the next zoom stitched, or data upscaled
+// at the max zoom, cache is false when the tile is not the stored one, e.g. a fallback tile
+func (s *Server) retinaTile(ctx context.Context, store storage.TileStore, version string,
+	z uint8, x, y uint64, data []byte, cache bool) ([]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// e.g. WebP, there is no decoder to rework it
+		retinaTiles.WithLabelValues("stored").Inc()
+		return data, nil
+	}
+	if cfg.Width >= retinaSize {
+		retinaTiles.WithLabelValues("stored").Inc()
+		return data, nil
+	}
+
+	var key string
+	if cache && s.retinaCache != nil {
+		key = fmt.Sprintf("%s/%d/%d/%d", version, z, x, y)
+		if b, ok := s.retinaCache.get(key); ok {
+			retinaTiles.WithLabelValues("cache").Inc()
+			return b, nil
+		}
+	}
+
+	img, source, err := s.stitchChildren(ctx, store, s.versionMaxZoom(version), z, x, y, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if img == nil {
+		src, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("can't decode tile: %w", err)
+		}
+		img, source = upscale2x(src), "upscaled"
+	}
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't encode @2x tile: %w", err)
+	}
+	retinaTiles.WithLabelValues(source).Inc()
+
+	if key != "" {
+		s.retinaCache.add(key, buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// stitchChildren returns the 4 tiles of the next zoom covering z x y drawn in one image,
+// nil when one of them is missing or not of the size of cfg, e.g. at maxZoom, the max zoom of store
+func (s *Server) stitchChildren(ctx context.Context, store storage.TileStore, maxZoom int, z uint8, x, y uint64, cfg image.Config) (image.Image, string, error) {
+	if z >= maxTileZoom || (maxZoom >= 0 && int(z) >= maxZoom) {
+		return nil, "", nil
+	}
+
+	cz := z + 1
+	dst := image.NewRGBA(image.Rect(0, 0, 2*cfg.Width, 2*cfg.Height))
+	for i := uint64(0); i < 4; i++ {
+		dx, dy := i%2, i/2
+		cx, cy := 2*x+dx, 2*y+dy
+		// stored in the TMS scheme
+		b, err := store.ReadTileData(ctx, cz, cx, 1<<cz-cy-1)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(b) == 0 {
+			return nil, "", nil
+		}
+		child, _, err := image.Decode(bytes.NewReader(b))
+		if err != nil || child.Bounds().Dx() != cfg.Width || child.Bounds().Dy() != cfg.Height {
+			return nil, "", nil
+		}
+		at := image.Pt(int(dx)*cfg.Width, int(dy)*cfg.Height)
+		draw.Draw(dst, child.Bounds().Sub(child.Bounds().Min).Add(at), child, child.Bounds().Min, draw.Src)
+	}
+	return dst, "children", nil
+}
+
+// upscale2x returns src twice as large, bilinearly interpolated
+func upscale2x(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, 2*w, 2*h))
+	for dy := 0; dy < 2*h; dy++ {
+		// the center of the destination pixel in the source
+		sy := (float64(dy)+0.5)/2 - 0.5
+		y0, fy := clampFloor(sy, h)
+		y1 := min(y0+1, h-1)
+		for dx := 0; dx < 2*w; dx++ {
+			sx := (float64(dx)+0.5)/2 - 0.5
+			x0, fx := clampFloor(sx, w)
+			x1 := min(x0+1, w-1)
+
+			p00, p10 := rgba.PixOffset(x0, y0), rgba.PixOffset(x1, y0)
+			p01, p11 := rgba.PixOffset(x0, y1), rgba.PixOffset(x1, y1)
+			d := dst.PixOffset(dx, dy)
+			for c := 0; c < 4; c++ {
+				top := float64(rgba.Pix[p00+c])*(1-fx) + float64(rgba.Pix[p10+c])*fx
+				bottom := float64(rgba.Pix[p01+c])*(1-fx) + float64(rgba.Pix[p11+c])*fx
+				dst.Pix[d+c] = uint8(top*(1-fy) + bottom*fy + 0.5)
+			}
+		}
+	}
+	return dst
+}
+
+// clampFloor returns the integer part of v within [0, n) and its fraction
+func clampFloor(v float64, n int) (int, float64) {
+	if v <= 0 {
+		return 0, 0
+	}
+	i := int(v)
+	if i >= n-1 {
+		return n - 1, 0
+	}
+	return i, v - float64(i)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// colorStore serves 256px PNG tiles up to zoom 2, filled with a color of their position in the TMS scheme
+type colorStore struct {
+	tileStore
+}
+
+func tileColor(z uint8, x, y uint64) color.RGBA {
+	return color.RGBA{R: uint8(z * 50), G: uint8(x * 60), B: uint8(y * 60), A: 255}
+}
+
+func (colorStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	if z > 2 {
+		return nil, nil
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	c := tileColor(z, x, y)
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (colorStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 2, Format: "png"}, true, nil
+}
+
+func TestServer_retinaTile(t *testing.T) {
+	s, err := New("retina_test", "", colorStore{}, log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""), WithRetinaCache(1<<20))
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}@2x.{format:png}", s)
+	get := func(path string) image.Image {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+		img, err := png.Decode(w.Body)
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 512, 512), img.Bounds())
+		return img
+	}
+
+	// the 4 children of 1/0/0 at zoom 2, y 0 in the XYZ scheme is y 3 in the TMS scheme
+	children := testutil.ToFloat64(retinaTiles.WithLabelValues("children"))
+	img := get("/tiles/1/0/0@2x.png")
+	require.Equal(t, tileColor(2, 0, 3), img.At(10, 10))
+	require.Equal(t, tileColor(2, 1, 3), img.At(300, 10))
+	require.Equal(t, tileColor(2, 0, 2), img.At(10, 300))
+	require.Equal(t, tileColor(2, 1, 2), img.At(300, 300))
+	require.Equal(t, children+1, testutil.ToFloat64(retinaTiles.WithLabelValues("children")))
+
+	cached := testutil.ToFloat64(retinaTiles.WithLabelValues("cache"))
+	get("/tiles/1/0/0@2x.png")
+	require.Equal(t, cached+1, testutil.ToFloat64(retinaTiles.WithLabelValues("cache")))
+
+	// upscaled at the max zoom
+	upscaled := testutil.ToFloat64(retinaTiles.WithLabelValues("upscaled"))
+	img = get("/tiles/2/1/1@2x.png")
+	require.Equal(t, tileColor(2, 1, 2), img.At(0, 0))
+	require.Equal(t, tileColor(2, 1, 2), img.At(511, 511))
+	require.Equal(t, upscaled+1, testutil.ToFloat64(retinaTiles.WithLabelValues("upscaled")))
+}
+
+func TestServer_retinaVector(t *testing.T) {
+	s, err := New("retina_vector_test", "", tileStore(testTile(t)), log.NewNopLogger(), health.NewServer(), WithStaticDir(""))
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}@2x.{format:png}", s)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/1/0/0@2x.png", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUpscale2x(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{A: 255})
+	src.Set(1, 0, color.RGBA{R: 200, A: 255})
+
+	dst := upscale2x(src)
+	require.Equal(t, image.Rect(0, 0, 4, 2), dst.Bounds())
+	// the edges keep the source colors, the inner pixels are interpolated
+	require.Equal(t, uint8(0), dst.RGBAAt(0, 0).R)
+	require.Equal(t, uint8(50), dst.RGBAAt(1, 0).R)
+	require.Equal(t, uint8(150), dst.RGBAAt(2, 1).R)
+	require.Equal(t, uint8(200), dst.RGBAAt(3, 1).R)
+}
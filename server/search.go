@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/akhenakh/kvtiles/mvt"
+)
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 100
+)
+
+// SearchHandler returns the features whose name matches the q parameter as a GeoJSON feature collection of points,
+// with the tile they come from
+func (s *Server) SearchHandler(w http.ResponseWriter, req *http.Request) {
+	if s.search == nil {
+		writeError(w, http.StatusNotFound, "no search index")
+		return
+	}
+
+	if code, _ := s.authorize(req, 0); code != http.StatusOK {
+		writeError(w, code, http.StatusText(code))
+		return
+	}
+
+	q := req.URL.Query().Get("q")
+	if q == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit := defaultSearchLimit
+	if param := req.URL.Query().Get("limit"); param != "" {
+		l, err := strconv.Atoi(param)
+		if err != nil || l < 1 || l > maxSearchLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+			return
+		}
+		limit = l
+	}
+
+	found, err := s.search.Search(q, limit)
+	if err != nil {
+		level.Error(s.requestLogger(req)).Log("msg", "can't search features", "error", err)
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	fc := mvt.FeatureCollection{Type: "FeatureCollection", Features: []mvt.GeoJSONFeature{}}
+	for _, f := range found {
+		if s.mask != nil && !s.mask.Contains(f.Lat, f.Lng) {
+			continue
+		}
+		props := map[string]interface{}{
+			"name": f.Name,
+			"tile": fmt.Sprintf("%d/%d/%d", f.Z, f.X, f.Y),
+		}
+		if f.Class != "" {
+			props["class"] = f.Class
+		}
+		fc.Features = append(fc.Features, mvt.GeoJSONFeature{
+			Type:       "Feature",
+			Layer:      f.Layer,
+			Geometry:   mvt.Geometry{Type: "Point", Coordinates: []float64{f.Lng, f.Lat}},
+			Properties: props,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(fc)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/mask"
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// searcher returns its features whatever the query
+type searcher []storage.SearchFeature
+
+func (s searcher) Search(q string, limit int) ([]storage.SearchFeature, error) {
+	if len(s) > limit {
+		return s[:limit], nil
+	}
+	return s, nil
+}
+
+func TestServer_SearchHandler(t *testing.T) {
+	m, err := mask.Parse([]byte(`{"type": "Polygon", "coordinates": [[[-10, -10], [10, -10], [10, 10], [-10, 10], [-10, -10]]]}`))
+	require.NoError(t, err)
+
+	features := searcher{
+		{Name: "Inside", Layer: "place", Class: "city", Lat: 5, Lng: 5, Z: 14, X: 8419, Y: 7963},
+		{Name: "Outside", Layer: "place", Lat: 48.8, Lng: 2.3, Z: 14, X: 8296, Y: 5639},
+	}
+	s, err := New("search_test", "secret", tileStore(nil), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+		WithSearch(features),
+		WithMask(m),
+	)
+	require.NoError(t, err)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.SearchHandler(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	require.Equal(t, http.StatusUnauthorized, get("/search?q=in").Code)
+	require.Equal(t, http.StatusBadRequest, get("/search?key=secret").Code)
+	require.Equal(t, http.StatusBadRequest, get("/search?key=secret&q=in&limit=1000").Code)
+
+	w := get("/search?key=secret&q=in")
+	require.Equal(t, http.StatusOK, w.Code)
+	var fc mvt.FeatureCollection
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fc))
+	// features outside the mask are not returned
+	require.Len(t, fc.Features, 1)
+	require.Equal(t, "place", fc.Features[0].Layer)
+	require.Equal(t, "Inside", fc.Features[0].Properties["name"])
+	require.Equal(t, "city", fc.Features[0].Properties["class"])
+	require.Equal(t, "14/8419/7963", fc.Features[0].Properties["tile"])
+	require.Equal(t, []interface{}{5.0, 5.0}, fc.Features[0].Geometry.Coordinates)
+
+	s, err = New("search_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(), WithStaticDir(""))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, get("/search?q=in").Code)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultContentSecurityPolicy allows the debug maps and the admin dashboard to load their inline scripts,
+// the map libraries from their CDNs and the tiles, styles and glyphs from any HTTPS origin
+const DefaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' https://api.mapbox.com https://cdn.jsdelivr.net https://unpkg.com; " +
+	"style-src 'self' 'unsafe-inline' https://api.mapbox.com https://cdn.jsdelivr.net https://unpkg.com; " +
+	"img-src 'self' data: blob: https:; connect-src 'self' https:; worker-src 'self' blob:; child-src blob:; " +
+	"frame-ancestors 'self'"
+
+// SecurityHeaders are the headers set by SecurityHeadersHandler, X-Content-Type-Options: nosniff is always set
+type SecurityHeaders struct {
+	// ContentSecurityPolicy is set on the HTML pages, omitted when empty
+	ContentSecurityPolicy string
+	// ReferrerPolicy is omitted when empty
+	ReferrerPolicy string
+	// HSTSMaxAge sets Strict-Transport-Security on the TLS requests, omitted when 0
+	HSTSMaxAge time.Duration
+}
+
+// SecurityHeadersHandler returns a middleware setting the security headers h on the responses
+func SecurityHeadersHandler(h SecurityHeaders) func(http.Handler) http.Handler {
+	var hsts string
+	if h.HSTSMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", int64(h.HSTSMaxAge/time.Second))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			if h.ReferrerPolicy != "" {
+				w.Header().Set("Referrer-Policy", h.ReferrerPolicy)
+			}
+			if hsts != "" && req.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+			if h.ContentSecurityPolicy != "" {
+				// the content type is only known once the handler writes
+				w = &cspWriter{ResponseWriter: w, policy: h.ContentSecurityPolicy}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// cspWriter sets Content-Security-Policy on the HTML responses
+type cspWriter struct {
+	http.ResponseWriter
+	policy      string
+	wroteHeader bool
+}
+
+func (w *cspWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			w.Header().Set("Content-Security-Policy", w.policy)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cspWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		// as net/http does, sniffing the content type when unset
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController
+func (w *cspWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
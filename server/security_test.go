@@ -0,0 +1,42 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityHeadersHandler(t *testing.T) {
+	h := SecurityHeadersHandler(SecurityHeaders{
+		ContentSecurityPolicy: "default-src 'self'",
+		ReferrerPolicy:        "no-referrer",
+		HSTSMaxAge:            time.Hour,
+	})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/tile" {
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			w.Write([]byte{0x1a})
+			return
+		}
+		w.Write([]byte("<!DOCTYPE html><html></html>"))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/static/", nil))
+	require.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	require.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+	require.Equal(t, "default-src 'self'", w.Header().Get("Content-Security-Policy"))
+	// not a TLS request
+	require.Empty(t, w.Header().Get("Strict-Transport-Security"))
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/tile", nil)
+	req.TLS = &tls.ConnectionState{}
+	h.ServeHTTP(w, req)
+	require.Equal(t, "max-age=3600", w.Header().Get("Strict-Transport-Security"))
+	require.Empty(t, w.Header().Get("Content-Security-Policy"))
+	require.Equal(t, []byte{0x1a}, w.Body.Bytes())
+}
@@ -3,11 +3,18 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"text/template"
+	"time"
 
 	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"google.golang.org/grpc/health"
 
+	"github.com/akhenakh/kvtiles/apikey"
+	"github.com/akhenakh/kvtiles/errreport"
+	"github.com/akhenakh/kvtiles/events"
+	"github.com/akhenakh/kvtiles/mask"
 	"github.com/akhenakh/kvtiles/storage"
 )
 
@@ -17,38 +24,166 @@ type Server struct {
 	logger       log.Logger
 	appName      string
 	healthServer *health.Server
+	staticDir    string
 	fileHandler  http.Handler
 	templates    *template.Template
+	stylesDir    string
+	styles       map[string]*mapStyle
+	version      string
 	tilesKey     string
+	signingKey   []byte
+	digest       bool
+	digestKey    []byte
+	keys         *apikey.Store
+	proxies      TrustedProxies
+	geoip        GeoResolver
+	auditLogger  log.Logger
+
+	accessLogger      log.Logger
+	accessLogSampling float64
+	slowThreshold     time.Duration
+	requestTimeout    time.Duration
+	handlerTimeout    time.Duration
+	reporter          *errreport.Reporter
+	shadow            *shadow
+	canary            *canary
+	hooks             *Hooks
+	transformers      []TileTransformer
+	events            *events.Bus
+	layersCache       *layersCache
+	rasterEncoders    []RasterEncoder
+	convertCache      *layersCache
+	retinaCache       *layersCache
+	snapshots         []datedMap
+	limits            *concurrencyLimits
+	probe             *storageProbe
+	redaction         TileTransformer
+	mask              *mask.Mask
+	search            storage.Searcher
+	backup            storage.Snapshotter
+	writer            storage.Writer
+	analytics         *tileAnalytics
+	actions           []AdminAction
+	adminStatus       map[string]func() interface{}
+	recentErrors      errorLog
+	started           time.Time
+	// maxZoom of the map, -1 if unknown, accessed atomically
+	maxZoom int32
+	// format of the map tiles, a mapFormat
+	format atomic.Value
+	// mapInfos are the map infos of the templates, a *storage.MapInfos
+	mapInfos atomic.Value
+	// renders caches the executed templates
+	renders renderCache
+	// ready is set to 1 when startup is completed
+	ready int32
+	// standby is set to 1 while a primary is serving
+	standby int32
+	// selfCheck is the result of the DB self-check, "ok" or the error
+	selfCheck atomic.Value
+	// probeStatus is the state of the storage probe, "ok" or the exceeded threshold
+	probeStatus atomic.Value
+	// degraded is set to 1 while the storage is degraded and the tiles are served from the caches only
+	degraded int32
+	// inFlight is the count of data requests being served, accessed atomically
+	inFlight int64
 }
 
 // New returns a Server
-func New(appName, tilesKey string, storage storage.TileStore,
-	logger log.Logger, healthServer *health.Server) (*Server, error) {
+func New(appName, tilesKey string, store storage.TileStore,
+	logger log.Logger, healthServer *health.Server, opts ...Option) (*Server, error) {
 	logger = log.With(logger, "component", "server")
 
-	// static file handler
-	fileHandler := http.FileServer(http.Dir("./static"))
-
-	// computing templates
-	pathTpls := make([]string, len(templatesNames))
-	for i, name := range templatesNames {
-		pathTpls[i] = "./static/" + name
-	}
-	t, err := template.ParseFiles(pathTpls...)
-	if err != nil {
-		return nil, fmt.Errorf("can't parse templates: %w", err)
-	}
-
 	s := &Server{
-		tileStorage:  storage,
+		tileStorage:  store,
 		logger:       logger,
 		appName:      appName,
 		healthServer: healthServer,
-		fileHandler:  fileHandler,
 		tilesKey:     tilesKey,
-		templates:    t,
+		staticDir:    "./static",
+		started:      time.Now(),
+	}
+
+	if err := s.RefreshMapInfos(); err != nil {
+		return nil, err
+	}
+	// the cached map infos follow the swapped DBs
+	storage.OnChange(store, func() {
+		if err := s.RefreshMapInfos(); err != nil {
+			level.Error(s.logger).Log("msg", "can't refresh map infos after a change", "error", err)
+		}
+	})
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.staticDir != "" {
+		// static file handler, the files of staticDir override the embedded ones
+		files := StaticFS(s.staticDir)
+		s.fileHandler = http.FileServer(http.FS(files))
+
+		// computing templates
+		t, err := template.ParseFS(files, templatesNames...)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse templates: %w", err)
+		}
+		s.templates = t
+	}
+
+	if s.stylesDir != "" {
+		styles, err := loadStyles(s.stylesDir)
+		if err != nil {
+			return nil, fmt.Errorf("can't load styles: %w", err)
+		}
+		s.styles = styles
 	}
 
 	return s, nil
 }
+
+// mapFormat is the format of the map tiles, format is empty for vector tiles
+type mapFormat struct {
+	format   string
+	encoding string
+}
+
+// mapFormat returns the format of the map tiles
+func (s *Server) mapFormat() mapFormat {
+	return s.format.Load().(mapFormat)
+}
+
+// RefreshMapInfos reloads the map infos, after the DB has been replaced,
+// it is called on the changes of a storage.ChangeNotifier store, e.g. a storage.Swappable
+func (s *Server) RefreshMapInfos() error {
+	maxZoom := -1
+	mapInfos, ok, err := s.tileStorage.LoadMapInfos()
+	if err != nil {
+		return fmt.Errorf("can't read map infos: %w", err)
+	}
+	var format mapFormat
+	if ok {
+		maxZoom = mapInfos.MaxZoom
+		format = mapFormat{format: mapInfos.Format, encoding: mapInfos.Encoding}
+	} else {
+		mapInfos = nil
+	}
+	atomic.StoreInt32(&s.maxZoom, int32(maxZoom))
+	s.format.Store(format)
+	s.mapInfos.Store(mapInfos)
+	// the templates render the map infos
+	s.renders.purge()
+
+	// the filtered tiles are from the previous dataset
+	if s.layersCache != nil {
+		s.layersCache.purge()
+	}
+	if s.convertCache != nil {
+		s.convertCache.purge()
+	}
+	if s.retinaCache != nil {
+		s.retinaCache.purge()
+	}
+
+	return nil
+}
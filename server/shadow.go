@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// shadowConcurrency bounds the in flight mirrored requests, requests above are dropped
+const shadowConcurrency = 64
+
+var shadowRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "shadow_requests_total",
+	Help:      "Tiles requests mirrored to the shadow backend, by comparison result: match, status_mismatch, body_mismatch, error or dropped.",
+}, []string{"result"})
+
+// shadow mirrors a share of the tiles requests to another backend and compares the responses
+type shadow struct {
+	baseURL string
+	ratio   float64
+	client  *http.Client
+	sem     chan struct{}
+	logger  log.Logger
+}
+
+// WithShadow mirrors asynchronously a ratio of the tiles requests to the server at baseURL,
+// e.g. http://new-kvtilesd:8080, and compares the status and the tile with the response served
+func WithShadow(baseURL string, ratio float64) Option {
+	return func(s *Server) {
+		s.shadow = &shadow{
+			baseURL: strings.TrimSuffix(baseURL, "/"),
+			ratio:   ratio,
+			client: &http.Client{
+				Timeout: 10 * time.Second,
+				// the tiles are compared as served
+				Transport: &http.Transport{
+					Proxy:              http.ProxyFromEnvironment,
+					DisableCompression: true,
+					IdleConnTimeout:    90 * time.Second,
+				},
+			},
+			sem:    make(chan struct{}, shadowConcurrency),
+			logger: log.With(s.logger, "component", "shadow"),
+		}
+	}
+}
+
+func (sh *shadow) sample() bool {
+	return sh.ratio >= 1 || rand.Float64() < sh.ratio
+}
+
+// mirror sends req to the shadow backend in the background, status and body were served to the client
+func (sh *shadow) mirror(req *http.Request, status int, body []byte) {
+	select {
+	case sh.sem <- struct{}{}:
+	default:
+		shadowRequests.WithLabelValues("dropped").Inc()
+		return
+	}
+
+	u := sh.baseURL + req.URL.RequestURI()
+	header := req.Header.Clone()
+	// the storage may return memory only valid during the request
+	body = append([]byte(nil), body...)
+	go func() {
+		defer func() { <-sh.sem }()
+		shadowRequests.WithLabelValues(sh.compare(u, header, status, body)).Inc()
+	}()
+}
+
+func (sh *shadow) compare(u string, header http.Header, status int, body []byte) string {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		level.Warn(sh.logger).Log("msg", "invalid shadow request", "error", err)
+		return "error"
+	}
+	for _, h := range []string{"Authorization", "Referer", "Origin", "User-Agent"} {
+		if v := header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	resp, err := sh.client.Do(req)
+	if err != nil {
+		level.Warn(sh.logger).Log("msg", "shadow request failed", "url", u, "error", err)
+		return "error"
+	}
+	defer resp.Body.Close()
+
+	shadowBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		level.Warn(sh.logger).Log("msg", "can't read shadow response", "url", u, "error", err)
+		return "error"
+	}
+
+	if resp.StatusCode != status {
+		level.Warn(sh.logger).Log("msg", "shadow status mismatch", "url", u,
+			"status", status, "shadow_status", resp.StatusCode)
+		return "status_mismatch"
+	}
+
+	if status == http.StatusOK && !sameTile(body, shadowBody) {
+		level.Warn(sh.logger).Log("msg", "shadow tile mismatch", "url", u,
+			"bytes", len(body), "shadow_bytes", len(shadowBody))
+		return "body_mismatch"
+	}
+
+	return "match"
+}
+
+// sameTile compares the tiles uncompressed, the same tile may be gzipped differently
+func sameTile(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+
+	ua, err := gunzip(a)
+	if err != nil {
+		return false
+	}
+	ub, err := gunzip(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ua, ub)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, data string, level int) []byte {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestShadow_compare(t *testing.T) {
+	tile := gzipped(t, "tile 1/0/0", gzip.BestSpeed)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tiles/1/0/0.pbf":
+			w.Write(gzipped(t, "tile 1/0/0", gzip.BestCompression))
+		case "/tiles/1/1/0.pbf":
+			w.Write(gzipped(t, "another tile", gzip.BestSpeed))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	s := &Server{logger: log.NewNopLogger()}
+	WithShadow(ts.URL+"/", 1)(s)
+
+	tests := []struct {
+		path   string
+		status int
+		body   []byte
+		want   string
+	}{
+		{"/tiles/1/0/0.pbf", http.StatusOK, tile, "match"},
+		{"/tiles/1/1/0.pbf", http.StatusOK, tile, "body_mismatch"},
+		{"/tiles/1/0/1.pbf", http.StatusOK, tile, "status_mismatch"},
+		{"/tiles/1/0/1.pbf", http.StatusNotFound, nil, "match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			require.Equal(t, tt.want, s.shadow.compare(s.shadow.baseURL+tt.path, http.Header{}, tt.status, tt.body))
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	expiresParam   = "expires"
+	signatureParam = "signature"
+)
+
+// SignPath returns the query string authorizing access to path until expires,
+// e.g. SignPath(key, "/tiles/11/618/722.pbf", time.Now().Add(time.Hour))
+func SignPath(key []byte, path string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	v := url.Values{}
+	v.Set(expiresParam, exp)
+	v.Set(signatureParam, signature(key, path, exp))
+	return v.Encode()
+}
+
+// validSignature checks the request path is signed and not expired
+func validSignature(key []byte, path string, q url.Values, now time.Time) bool {
+	exp := q.Get(expiresParam)
+	sig := q.Get(signatureParam)
+	if exp == "" || sig == "" {
+		return false
+	}
+
+	ts, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() > ts {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(signature(key, path, exp)))
+}
+
+func signature(key []byte, path, exp string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
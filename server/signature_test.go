@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignPath(t *testing.T) {
+	key := []byte("secret")
+	path := "/tiles/11/618/722.pbf"
+	now := time.Now()
+
+	q, err := url.ParseQuery(SignPath(key, path, now.Add(time.Minute)))
+	require.NoError(t, err)
+
+	require.True(t, validSignature(key, path, q, now))
+	require.False(t, validSignature(key, path, q, now.Add(2*time.Minute)), "expired")
+	require.False(t, validSignature([]byte("other"), path, q, now), "wrong key")
+	require.False(t, validSignature(key, "/tiles/11/618/723.pbf", q, now), "wrong path")
+
+	q.Set(expiresParam, "9999999999")
+	require.False(t, validSignature(key, path, q, now), "tampered expiry")
+	require.False(t, validSignature(key, path, url.Values{}, now), "unsigned")
+}
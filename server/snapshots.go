@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// snapshotLayout is the layout of the snapshots dates
+const snapshotLayout = "2006-01-02"
+
+// datedMap is a dated version of the map
+type datedMap struct {
+	date  string
+	store storage.TileStore
+	// maxZoom of the snapshot map, -1 if unknown
+	maxZoom int
+}
+
+// WithSnapshots serves the dated snapshots of the map at /tiles/{date}/{z}/{x}/{y}, by date as 2006-01-02,
+// a date is served from the last snapshot at or before it, invalid dates are ignored
+func WithSnapshots(snapshots map[string]storage.TileStore) Option {
+	return func(s *Server) {
+		s.snapshots = nil
+		for date, store := range snapshots {
+			if ParseSnapshotDate(date) != nil {
+				continue
+			}
+			sn := datedMap{date: date, store: store, maxZoom: -1}
+			if infos, ok, err := store.LoadMapInfos(); err == nil && ok {
+				sn.maxZoom = infos.MaxZoom
+			}
+			s.snapshots = append(s.snapshots, sn)
+		}
+		sort.Slice(s.snapshots, func(i, j int) bool { return s.snapshots[i].date < s.snapshots[j].date })
+	}
+}
+
+// ParseSnapshotDate validates a snapshot date, as 2006-01-02
+func ParseSnapshotDate(date string) error {
+	if _, err := time.Parse(snapshotLayout, date); err != nil {
+		return fmt.Errorf("invalid snapshot date %q, expected YYYY-MM-DD", date)
+	}
+	return nil
+}
+
+// snapshotAt returns the last snapshot at or before date, false if there is none
+func (s *Server) snapshotAt(date string) (datedMap, bool) {
+	// the dates are in lexical order
+	i := sort.Search(len(s.snapshots), func(i int) bool { return s.snapshots[i].date > date })
+	if i == 0 {
+		return datedMap{}, false
+	}
+	return s.snapshots[i-1], true
+}
+
+// SnapshotsHandler lists the dated snapshots of the map with their infos
+func (s *Server) SnapshotsHandler(w http.ResponseWriter, req *http.Request) {
+	type snapshotInfos struct {
+		Date      string    `json:"date"`
+		Region    string    `json:"region,omitempty"`
+		MaxZoom   int       `json:"maxzoom"`
+		IndexTime time.Time `json:"index_time"`
+	}
+
+	list := make([]snapshotInfos, 0, len(s.snapshots))
+	for _, sn := range s.snapshots {
+		infos, ok, err := sn.store.LoadMapInfos()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		si := snapshotInfos{Date: sn.date, MaxZoom: -1}
+		if ok {
+			si.Region, si.MaxZoom, si.IndexTime = infos.Region, infos.MaxZoom, infos.IndexTime
+		}
+		list = append(list, si)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"snapshots": list})
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+func TestServer_Snapshots(t *testing.T) {
+	s, err := New("snapshots_test", "", tileStore("now"), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""), WithSnapshots(map[string]storage.TileStore{
+			"2024-02-01": tileStore("feb"),
+			"2024-01-01": tileStore("jan"),
+			"2024-03-01": zoomStore{tileStore: tileStore("mar"), zoom: 16},
+			"january":    tileStore("invalid"),
+		}))
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf}", s)
+	r.Handle("/tiles/{date:[0-9]+-[0-9]+-[0-9]+}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf}", s)
+
+	tests := []struct {
+		path     string
+		code     int
+		body     string
+		snapshot string
+	}{
+		{"/tiles/1/0/0.pbf", http.StatusOK, "now", ""},
+		{"/tiles/2024-01-01/1/0/0.pbf", http.StatusOK, "jan", "2024-01-01"},
+		{"/tiles/2024-01-31/1/0/0.pbf", http.StatusOK, "jan", "2024-01-01"},
+		{"/tiles/2024-02-01/1/0/0.pbf", http.StatusOK, "feb", "2024-02-01"},
+		{"/tiles/2024-02-28/1/0/0.pbf", http.StatusOK, "feb", "2024-02-01"},
+		{"/tiles/2030-01-01/1/0/0.pbf", http.StatusOK, "mar", "2024-03-01"},
+		// the max zoom is the one of the snapshot served
+		{"/tiles/2024-03-01/16/0/0.pbf", http.StatusOK, "mar", "2024-03-01"},
+		{"/tiles/2024-02-01/16/0/0.pbf", http.StatusNotFound, "", "2024-02-01"},
+		{"/tiles/16/0/0.pbf", http.StatusNotFound, "", ""},
+		{"/tiles/2023-12-31/1/0/0.pbf", http.StatusNotFound, "", ""},
+		{"/tiles/2024-13-01/1/0/0.pbf", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			require.Equal(t, tt.code, w.Code)
+			require.Equal(t, tt.snapshot, w.Header().Get("X-Tiles-Snapshot"))
+			if tt.code == http.StatusOK {
+				require.Equal(t, tt.body, w.Body.String())
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	s.SnapshotsHandler(w, httptest.NewRequest("GET", "/snapshots", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Snapshots []struct {
+			Date    string `json:"date"`
+			MaxZoom int    `json:"maxzoom"`
+		} `json:"snapshots"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Snapshots, 3)
+	require.Equal(t, "2024-01-01", list.Snapshots[0].Date)
+	require.Equal(t, "2024-02-01", list.Snapshots[1].Date)
+	require.Equal(t, 14, list.Snapshots[1].MaxZoom)
+}
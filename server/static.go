@@ -0,0 +1,35 @@
+package server
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// embedded are the default debug map files, the glyphs are too large to be embedded
+//
+//go:embed static
+var embedded embed.FS
+
+// StaticFS returns the debug map files of dir, falling back to the embedded ones, the glyphs are only read from dir
+func StaticFS(dir string) fs.FS {
+	defaults, err := fs.Sub(embedded, "static")
+	if err != nil {
+		panic(err)
+	}
+	return overlayFS{os.DirFS(dir), defaults}
+}
+
+// overlayFS opens a file from the first file system holding it
+type overlayFS []fs.FS
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	for _, fsys := range o {
+		f, err := fsys.Open(name)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
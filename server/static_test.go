@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/storage/cache"
+)
+
+func TestServer_StaticHandler(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("zoom {{ .MaxZoom }}"), 0o600))
+
+	s, err := New("static_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(), WithStaticDir(dir))
+	require.NoError(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.StaticHandler(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	// the files of the static dir override the embedded ones
+	w := get("/static/")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "zoom 14", w.Body.String())
+
+	w = get("/static/osm-liberty-gl.style")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "http://example.com/static/planet.json")
+
+	w = get("/static/osm-liberty.png")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+
+	w = get("/static/glyphs/Roboto%20Regular/0-255.pbf")
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestServer_RenderCache(t *testing.T) {
+	s, err := New("render_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer())
+	require.NoError(t, err)
+
+	get := func(host string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/static/planet.json", nil)
+		req.Host = host
+		s.StaticHandler(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	hits := testutil.ToFloat64(templateRenders.WithLabelValues("planet.json", "hit"))
+	misses := testutil.ToFloat64(templateRenders.WithLabelValues("planet.json", "miss"))
+
+	require.Contains(t, get("a.example.com"), "http://a.example.com/tiles/")
+	require.Contains(t, get("a.example.com"), "http://a.example.com/tiles/")
+	// rendered per base URL
+	require.Contains(t, get("b.example.com"), "http://b.example.com/tiles/")
+	require.Equal(t, hits+1, testutil.ToFloat64(templateRenders.WithLabelValues("planet.json", "hit")))
+	require.Equal(t, misses+2, testutil.ToFloat64(templateRenders.WithLabelValues("planet.json", "miss")))
+
+	// new map infos are rendered again
+	require.NoError(t, s.RefreshMapInfos())
+	get("a.example.com")
+	require.Equal(t, misses+3, testutil.ToFloat64(templateRenders.WithLabelValues("planet.json", "miss")))
+}
+
+// zoomStore is a tileStore of a map up to zoom
+type zoomStore struct {
+	tileStore
+	zoom int
+}
+
+func (s zoomStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: s.zoom}, true, nil
+}
+
+func TestServer_MapInfosSwap(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("zoom {{ .MaxZoom }}"), 0o600))
+
+	store := storage.NewSwappable(zoomStore{zoom: 14})
+	s, err := New("swap_test", "", cache.NewLRU(store, 1<<20), log.NewNopLogger(), health.NewServer(), WithStaticDir(dir))
+	require.NoError(t, err)
+
+	get := func() string {
+		w := httptest.NewRecorder()
+		s.StaticHandler(w, httptest.NewRequest("GET", "/static/", nil))
+		return w.Body.String()
+	}
+
+	require.Equal(t, "zoom 14", get())
+	// the swap is notified through the cache
+	store.Swap(zoomStore{zoom: 16})
+	require.Equal(t, "zoom 16", get())
+	require.Equal(t, 16, s.tilesMaxZoom())
+}
+
+// layersStore is a tileStore of a map with vector layers
+type layersStore struct {
+	tileStore
+}
+
+func (layersStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 14, Region: "test", VectorLayers: []storage.VectorLayer{
+		{ID: "water", Fields: map[string]string{"class": "String"}, MaxZoom: 14},
+		{ID: "poi", MinZoom: 12, MaxZoom: 14},
+	}}, true, nil
+}
+
+func TestServer_VectorLayers(t *testing.T) {
+	type tileJSON struct {
+		MaxZoom      int                   `json:"maxzoom"`
+		VectorLayers []storage.VectorLayer `json:"vector_layers"`
+	}
+	want := []storage.VectorLayer{
+		{ID: "water", Fields: map[string]string{"class": "String"}, MaxZoom: 14},
+		{ID: "poi", Fields: map[string]string{}, MinZoom: 12, MaxZoom: 14},
+	}
+
+	s, err := New("layers_test", "", layersStore{}, log.NewNopLogger(), health.NewServer())
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.StaticHandler(w, httptest.NewRequest("GET", "/static/planet.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var tj tileJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tj))
+	require.Equal(t, want, tj.VectorLayers)
+
+	w = httptest.NewRecorder()
+	s.MetadataHandler(w, httptest.NewRequest("GET", "/metadata", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	tj = tileJSON{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tj))
+	require.Equal(t, 14, tj.MaxZoom)
+	require.Equal(t, want, tj.VectorLayers)
+
+	// a DB without layers keeps the OpenMapTiles ones
+	s, err = New("layers_default_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer())
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	s.StaticHandler(w, httptest.NewRequest("GET", "/static/planet.json", nil))
+	tj = tileJSON{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tj))
+	require.Equal(t, "water", tj.VectorLayers[0].ID)
+	require.Equal(t, 22, tj.VectorLayers[0].MaxZoom)
+}
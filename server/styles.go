@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+)
+
+// defaultStyle is the ID of the debug map style, served when the debug map is enabled
+const defaultStyle = "osm-liberty"
+
+// mapStyle is a style template, its ID is its file name without the .json extension
+type mapStyle struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	tpl *template.Template
+}
+
+// WithStylesDir serves the *.json map styles of dir under /styles/, the styles are templates
+// of the tiles URL, as the debug map style
+func WithStylesDir(dir string) Option {
+	return func(s *Server) {
+		s.stylesDir = dir
+	}
+}
+
+// loadStyles parses the styles of dir by ID
+func loadStyles(dir string) (map[string]*mapStyle, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	styles := make(map[string]*mapStyle, len(paths))
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(filepath.Base(p), ".json")
+		tpl, err := template.New(id).Parse(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("can't parse style %s: %w", p, err)
+		}
+
+		// the name is read from the style when the template is valid JSON
+		st := &mapStyle{ID: id, Name: id, tpl: tpl}
+		var meta struct {
+			Name string `json:"name"`
+		}
+		if json.Unmarshal(b, &meta) == nil && meta.Name != "" {
+			st.Name = meta.Name
+		}
+		styles[id] = st
+	}
+	return styles, nil
+}
+
+// style returns the style id, the default style is served from the debug map templates
+func (s *Server) style(id string) (*mapStyle, bool) {
+	if st, ok := s.styles[id]; ok {
+		return st, true
+	}
+	if id == defaultStyle && s.templates != nil {
+		return &mapStyle{ID: defaultStyle, Name: "OSM Liberty", tpl: s.templates.Lookup("osm-liberty-gl.style")}, true
+	}
+	return nil, false
+}
+
+// StylesHandler lists the styles served under /styles/
+func (s *Server) StylesHandler(w http.ResponseWriter, req *http.Request) {
+	ids := make([]string, 0, len(s.styles)+1)
+	for id := range s.styles {
+		ids = append(ids, id)
+	}
+	if _, ok := s.styles[defaultStyle]; !ok && s.templates != nil {
+		ids = append(ids, defaultStyle)
+	}
+	sort.Strings(ids)
+
+	base := baseURL(req)
+	styles := make([]mapStyle, len(ids))
+	for i, id := range ids {
+		st, _ := s.style(id)
+		styles[i] = mapStyle{ID: st.ID, Name: st.Name, URL: fmt.Sprintf("%s/styles/%s.json", base, st.ID)}
+		if s.tilesKey != "" {
+			styles[i].URL += "?key=" + s.tilesKey
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Surrogate-Key", "static")
+	_ = json.NewEncoder(w).Encode(styles)
+}
+
+// StyleHandler serves the style of the URL /styles/{style}.json
+func (s *Server) StyleHandler(w http.ResponseWriter, req *http.Request) {
+	st, ok := s.style(mux.Vars(req)["style"])
+	if !ok {
+		writeError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	if s.tilesKey != "" && req.URL.Query().Get("key") != s.tilesKey {
+		writeError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		return
+	}
+
+	b, err := s.render("styles/"+st.ID, st.tpl, req)
+	switch {
+	case errors.Is(err, errNoMap):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		level.Error(s.requestLogger(req)).Log("msg", "can't execute style template", "error", err, "style", st.ID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Surrogate-Key", "static")
+	w.Write(b)
+}
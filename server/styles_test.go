@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+)
+
+func TestServer_styles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "dark.json"),
+		[]byte(`{"version": 8, "name": "Dark", "sources": {"tiles": {"type": "vector", "url": "{{ .TilesBaseURL }}/static/planet.json"}}}`), 0o600))
+
+	s, err := New("styles_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(t.TempDir()), WithStylesDir(dir),
+	)
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/styles/", s.StylesHandler)
+	r.HandleFunc("/styles/{style}.json", s.StyleHandler)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/styles/")
+	require.Equal(t, http.StatusOK, w.Code)
+	var styles []mapStyle
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &styles))
+	require.Equal(t, []mapStyle{
+		{ID: "dark", Name: "Dark", URL: "http://example.com/styles/dark.json"},
+		{ID: "osm-liberty", Name: "OSM Liberty", URL: "http://example.com/styles/osm-liberty.json"},
+	}, styles)
+
+	w = get("/styles/dark.json")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"url": "http://example.com/static/planet.json"`)
+
+	w = get("/styles/osm-liberty.json")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"id": "osm-liberty"`)
+
+	w = get("/styles/light.json")
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
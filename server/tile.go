@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// maximum zoom level addressable by the tiles keys
+const maxTileZoom = 30
+
+// tileCoordsError is a tile coordinates validation error with its HTTP status
+type tileCoordsError struct {
+	code int
+	msg  string
+}
+
+func (e *tileCoordsError) Error() string {
+	return e.msg
+}
+
+// parseTileCoords validates z/x/y, x and y must be within 0..2^z-1
+// and z lower or equal maxZoom when maxZoom is not negative
+func parseTileCoords(zs, xs, ys string, maxZoom int) (uint8, uint64, uint64, error) {
+	z, err := strconv.ParseUint(zs, 10, 8)
+	if err != nil || z > maxTileZoom {
+		return 0, 0, 0, &tileCoordsError{http.StatusBadRequest, fmt.Sprintf("invalid zoom %q", zs)}
+	}
+
+	max := uint64(1) << z
+	x, err := strconv.ParseUint(xs, 10, 64)
+	if err != nil || x >= max {
+		return 0, 0, 0, &tileCoordsError{http.StatusBadRequest, fmt.Sprintf("invalid x %q for zoom %d", xs, z)}
+	}
+
+	y, err := strconv.ParseUint(ys, 10, 64)
+	if err != nil || y >= max {
+		return 0, 0, 0, &tileCoordsError{http.StatusBadRequest, fmt.Sprintf("invalid y %q for zoom %d", ys, z)}
+	}
+
+	if err := checkMaxZoom(uint8(z), maxZoom); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return uint8(z), x, y, nil
+}
+
+// checkMaxZoom returns a not found tileCoordsError if z is above maxZoom, -1 for no max zoom
+func checkMaxZoom(z uint8, maxZoom int) error {
+	if maxZoom >= 0 && int(z) > maxZoom {
+		return &tileCoordsError{http.StatusNotFound, fmt.Sprintf("zoom %d is above the map max zoom %d", z, maxZoom)}
+	}
+	return nil
+}
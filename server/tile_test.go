@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTileCoords(t *testing.T) {
+	tests := []struct {
+		name     string
+		z, x, y  string
+		maxZoom  int
+		wantCode int
+	}{
+		{"valid", "11", "618", "722", 14, 0},
+		{"zoom 0", "0", "0", "0", 14, 0},
+		{"unknown max zoom", "18", "1", "1", -1, 0},
+		{"x out of range", "1", "2", "0", 14, http.StatusBadRequest},
+		{"y out of range", "1", "0", "2", 14, http.StatusBadRequest},
+		{"zoom overflow", "300", "0", "0", 14, http.StatusBadRequest},
+		{"zoom too big", "31", "0", "0", -1, http.StatusBadRequest},
+		{"x overflow", "2", "99999999999999999999999", "0", 14, http.StatusBadRequest},
+		{"not a number", "a", "0", "0", 14, http.StatusBadRequest},
+		{"above max zoom", "15", "0", "0", 14, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := parseTileCoords(tt.z, tt.x, tt.y, tt.maxZoom)
+			if tt.wantCode == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Equal(t, tt.wantCode, err.(*tileCoordsError).code)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var requestTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "request_timeouts_total",
+	Help:      "Data requests replied with a 503 by the handler timeout.",
+})
+
+// WithHandlerTimeout cancels the context of the data requests after d and replies with a 503
+// if the handler has not replied by then, distinct from the storage read timeout
+func WithHandlerTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.handlerTimeout = d
+	}
+}
+
+// TimeoutHandler is a middleware applying the handler timeout, the response is buffered
+// until the handler returns so a late handler can't write after the 503.
+// It works as http.TimeoutHandler does, which can't be used as is: its 503 body is a fixed text
+// where the API errors are JSON carrying the request ID, it replies 503 rather than 499
+// when the client goes away, and it doesn't count nor log the timeouts.
+// The handler also sees the headers already set, e.g. the request ID, http.TimeoutHandler starts
+// from empty headers.
+func (s *Server) TimeoutHandler(next http.Handler) http.Handler {
+	if s.handlerTimeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), s.handlerTimeout)
+		defer cancel()
+
+		tw := &timeoutWriter{header: w.Header().Clone()}
+		done := make(chan struct{})
+		panics := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panics <- p
+				}
+			}()
+			next.ServeHTTP(tw, req.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panics:
+			// re-panicked in the request goroutine for RecoverHandler
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for k, v := range tw.header {
+				w.Header()[k] = v
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			_, _ = w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			if req.Context().Err() != nil {
+				// the client is gone
+				writeError(w, statusClientClosedRequest, "client closed request")
+				return
+			}
+			requestTimeouts.Inc()
+			level.Warn(s.requestLogger(req)).Log("msg", "request timed out", "path", req.URL.Path, "timeout", s.handlerTimeout)
+			writeError(w, http.StatusServiceUnavailable, "request timed out")
+		}
+	})
+}
+
+// timeoutWriter buffers a response, discarding the writes once timed out
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 && !w.timedOut {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
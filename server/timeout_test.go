@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_TimeoutHandler(t *testing.T) {
+	s := &Server{logger: log.NewNopLogger(), handlerTimeout: 50 * time.Millisecond}
+	serve := func(h http.HandlerFunc) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		w.Header().Set(RequestIDHeader, "req-1")
+		s.TimeoutHandler(h).ServeHTTP(w, httptest.NewRequest("GET", "/tiles/1/0/0.pbf", nil))
+		return w
+	}
+
+	w := serve(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("tile"))
+	})
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Equal(t, "application/x-protobuf", w.Header().Get("Content-Type"))
+	require.Equal(t, "tile", w.Body.String())
+
+	release, late := make(chan struct{}), make(chan error)
+	w = serve(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+		<-release
+		_, err := w.Write([]byte("late"))
+		late <- err
+	})
+	close(release)
+	require.ErrorIs(t, <-late, http.ErrHandlerTimeout)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var body errorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Equal(t, "req-1", body.RequestID)
+
+	require.Panics(t, func() {
+		serve(func(w http.ResponseWriter, req *http.Request) { panic("boom") })
+	})
+
+	// disabled
+	s.handlerTimeout = 0
+	w = serve(func(w http.ResponseWriter, req *http.Request) {
+		_, ok := req.Context().Deadline()
+		require.False(t, ok)
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// TileTransformer rewrites the tiles between the storage read and the response
+type TileTransformer interface {
+	// Transform returns the tile to serve, tile is the uncompressed vector tile
+	Transform(tr *TileRequest, tile []byte) ([]byte, error)
+}
+
+// TransformerFunc adapts a function to a TileTransformer
+type TransformerFunc func(tr *TileRequest, tile []byte) ([]byte, error)
+
+// Transform calls f
+func (f TransformerFunc) Transform(tr *TileRequest, tile []byte) ([]byte, error) {
+	return f(tr, tile)
+}
+
+// WithTransformers applies the transformers in order to every served tile,
+// tiles are uncompressed then gzipped again, put a cache in front of the server for hot tiles
+func WithTransformers(transformers ...TileTransformer) Option {
+	return func(s *Server) {
+		s.transformers = append(s.transformers, transformers...)
+	}
+}
+
+// transform applies the transformers to the gzipped tile
+func (s *Server) transform(tr *TileRequest, data []byte) ([]byte, error) {
+	return applyTransformers(s.transformers, tr, data)
+}
+
+// clip removes the features outside the mask from the gzipped tile data
+func (s *Server) clip(tr *TileRequest, z uint8, x, y uint64, data []byte) ([]byte, error) {
+	t := tilemath.Tile{Z: z, X: x, Y: y}
+	return applyTransformers([]TileTransformer{TransformerFunc(func(_ *TileRequest, tile []byte) ([]byte, error) {
+		return s.mask.Clip(t, tile)
+	})}, tr, data)
+}
+
+// applyTransformers applies transformers in order to the gzipped tile data
+func applyTransformers(transformers []TileTransformer, tr *TileRequest, data []byte) ([]byte, error) {
+	tile, err := gunzip(data)
+	if err != nil {
+		return nil, fmt.Errorf("can't uncompress tile: %w", err)
+	}
+
+	for _, t := range transformers {
+		if tile, err = t.Transform(tr, tile); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(tile); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// KeepLayers is a TileTransformer removing the layers not listed
+func KeepLayers(names ...string) TileTransformer {
+	keep := make(map[string]bool, len(names))
+	for _, n := range names {
+		keep[n] = true
+	}
+
+	return TransformerFunc(func(tr *TileRequest, tile []byte) ([]byte, error) {
+		layers, err := mvt.SplitLayers(tile)
+		if err != nil {
+			return nil, err
+		}
+		kept := layers[:0]
+		for _, l := range layers {
+			if keep[l.Name] {
+				kept = append(kept, l)
+			}
+		}
+		return mvt.JoinLayers(kept), nil
+	})
+}
+
+// RedactAttributes is a TileTransformer removing the listed attributes from the features of all layers
+func RedactAttributes(keys ...string) TileTransformer {
+	return Redact(RedactRule{Attributes: keys})
+}
+
+// RedactRule removes Attributes from the features of Layer, of all layers if Layer is empty
+type RedactRule struct {
+	Layer      string
+	Attributes []string
+}
+
+// Redact is a TileTransformer removing the attributes of the rules from the features
+func Redact(rules ...RedactRule) TileTransformer {
+	return TransformerFunc(func(tr *TileRequest, tile []byte) ([]byte, error) {
+		layers, err := mvt.SplitLayers(tile)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, raw := range layers {
+			var keys []string
+			for _, r := range rules {
+				if r.Layer == "" || r.Layer == raw.Name {
+					keys = append(keys, r.Attributes...)
+				}
+			}
+			if len(keys) == 0 {
+				continue
+			}
+
+			l, err := mvt.DecodeLayer(raw)
+			if err != nil {
+				return nil, err
+			}
+
+			var redacted bool
+			for _, f := range l.Features {
+				for _, k := range keys {
+					if _, ok := f.Properties[k]; ok {
+						delete(f.Properties, k)
+						redacted = true
+					}
+				}
+			}
+			// layers without the attributes are kept as is
+			if !redacted {
+				continue
+			}
+			if layers[i], err = mvt.EncodeLayer(l); err != nil {
+				return nil, err
+			}
+		}
+
+		return mvt.JoinLayers(layers), nil
+	})
+}
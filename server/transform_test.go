@@ -0,0 +1,95 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// tileStore serves the same gzipped tile for every request
+type tileStore []byte
+
+func (s tileStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	return s, nil
+}
+
+func (tileStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 14}, true, nil
+}
+
+func (tileStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func testTile(t *testing.T) []byte {
+	point := []uint32{9, 20, 20}
+	tile, err := mvt.Encode([]mvt.Layer{
+		{Name: "water", Extent: 4096, Features: []mvt.Feature{
+			{Type: mvt.Point, Geometry: point, Properties: map[string]interface{}{"class": "ocean"}},
+		}},
+		{Name: "housenumber", Extent: 4096, Features: []mvt.Feature{
+			{Type: mvt.Point, Geometry: point, Properties: map[string]interface{}{"housenumber": "12", "osm_id": uint64(42)}},
+		}},
+	})
+	require.NoError(t, err)
+	return tile
+}
+
+func TestTransformers(t *testing.T) {
+	tile := testTile(t)
+
+	data, err := KeepLayers("water").Transform(nil, tile)
+	require.NoError(t, err)
+	layers, err := mvt.Decode(data)
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	require.Equal(t, "water", layers[0].Name)
+
+	data, err = RedactAttributes("osm_id").Transform(nil, tile)
+	require.NoError(t, err)
+	layers, err = mvt.Decode(data)
+	require.NoError(t, err)
+	require.Len(t, layers, 2)
+	require.Equal(t, map[string]interface{}{"class": "ocean"}, layers[0].Features[0].Properties)
+	require.Equal(t, map[string]interface{}{"housenumber": "12"}, layers[1].Features[0].Properties)
+}
+
+func TestServer_transformers(t *testing.T) {
+	var zooms []uint8
+	s, err := New("transform_test", "", tileStore(gzipped(t, string(testTile(t)), gzip.DefaultCompression)), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+		WithTransformers(
+			TransformerFunc(func(tr *TileRequest, tile []byte) ([]byte, error) {
+				zooms = append(zooms, tr.Z)
+				return tile, nil
+			}),
+			KeepLayers("housenumber"),
+			RedactAttributes("osm_id"),
+		))
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.pbf", s)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/3/1/2.pbf", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, []uint8{3}, zooms)
+
+	data, err := gunzip(w.Body.Bytes())
+	require.NoError(t, err)
+	layers, err := mvt.Decode(data)
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	require.Equal(t, map[string]interface{}{"housenumber": "12"}, layers[0].Features[0].Properties)
+}
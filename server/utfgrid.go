@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/utfgrid"
+)
+
+// validCallback matches the JSONP callbacks, a javascript identifier or a dotted path
+var validCallback = regexp.MustCompile(`^[A-Za-z_$][\w$.]*$`)
+
+// writeGrid writes the UTFGrid of the gzipped vector tile data, wrapped in callback if set,
+// returns the written body
+func writeGrid(w http.ResponseWriter, data []byte, callback string) ([]byte, error) {
+	tile, err := gunzip(data)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := mvt.Decode(tile)
+	if err != nil {
+		return nil, err
+	}
+	grid, err := utfgrid.Render(layers, utfgrid.DefaultSize)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(grid)
+	if err != nil {
+		return nil, err
+	}
+
+	if callback != "" {
+		body = []byte(fmt.Sprintf("%s(%s);", callback, body))
+		w.Header().Set("Content-Type", "application/javascript")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Surrogate-Key", "tiles")
+	_, _ = w.Write(body)
+	return body, nil
+}
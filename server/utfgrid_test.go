@@ -0,0 +1,57 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/utfgrid"
+)
+
+func TestServer_grid(t *testing.T) {
+	tile, err := mvt.Encode([]mvt.Layer{
+		{Name: "poi", Extent: 4096, Features: []mvt.Feature{
+			{ID: 1, Type: mvt.Point, Geometry: mvt.EncodeGeometry(mvt.Point, [][][2]int64{{{2048, 2048}}}),
+				Properties: map[string]interface{}{"name": "Berlin", "name:en": "Berlin"}},
+		}},
+	})
+	require.NoError(t, err)
+
+	s, err := New("grid_test", "", tileStore(gzipped(t, string(tile), gzip.DefaultCompression)), log.NewNopLogger(), health.NewServer(),
+		WithStaticDir(""),
+	)
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.{format:pbf|grid\\.json}", s)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/3/1/2.grid.json?lang=en", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var grid utfgrid.Grid
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &grid))
+	require.Len(t, grid.Grid, utfgrid.DefaultSize)
+	require.Equal(t, []string{"", "1"}, grid.Keys)
+	require.Equal(t, map[string]interface{}{"layer": "poi", "id": float64(1), "name": "Berlin", "name:en": "Berlin"}, grid.Data["1"])
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/3/1/2.grid.json?callback=grid.load", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/javascript", w.Header().Get("Content-Type"))
+	require.True(t, strings.HasPrefix(w.Body.String(), "grid.load({"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/tiles/3/1/2.grid.json?callback=alert(1)", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// maxWrittenTileSize limits the body of a written tile
+const maxWrittenTileSize = 16 << 20
+
+// TileWriteHandler stores the body as the tile at z/x/y in the XYZ scheme on PUT, as served, e.g. gzipped vector tiles,
+// and deletes the tile on DELETE
+func (s *Server) TileWriteHandler(w http.ResponseWriter, req *http.Request) {
+	if s.writer == nil {
+		writeError(w, http.StatusNotFound, "writes not enabled")
+		return
+	}
+
+	vars := mux.Vars(req)
+	z, x, y, err := parseTileCoords(vars["z"], vars["x"], vars["y"], -1)
+	if err != nil {
+		writeError(w, err.(*tileCoordsError).code, err.Error())
+		return
+	}
+	tile := storage.Tile{Z: z, X: x, Y: 1<<z - y - 1}
+
+	if req.Method == http.MethodPut {
+		tile.Data, err = io.ReadAll(http.MaxBytesReader(w, req.Body, maxWrittenTileSize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "can't read tile: "+err.Error())
+			return
+		}
+		if len(tile.Data) == 0 {
+			writeError(w, http.StatusBadRequest, "empty tile, use DELETE to remove it")
+			return
+		}
+	}
+
+	if err := s.writer.WriteTiles(req.Context(), []storage.Tile{tile}); err != nil {
+		s.writeFailed(w, req, err)
+		return
+	}
+	level.Info(s.requestLogger(req)).Log("msg", "tile written", "z", z, "x", x, "y", y, "bytes", len(tile.Data))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MapInfosWriteHandler replaces the map infos by the JSON body, as returned by MapInfosHandler
+func (s *Server) MapInfosWriteHandler(w http.ResponseWriter, req *http.Request) {
+	if s.writer == nil {
+		writeError(w, http.StatusNotFound, "writes not enabled")
+		return
+	}
+
+	var infos storage.MapInfos
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<20)).Decode(&infos); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid map infos: "+err.Error())
+		return
+	}
+
+	if err := s.writer.WriteMapInfos(req.Context(), infos); err != nil {
+		s.writeFailed(w, req, err)
+		return
+	}
+	level.Info(s.requestLogger(req)).Log("msg", "map infos written", "region", infos.Region)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeFailed replies to a failed write, with a 503 on the nodes not accepting the writes
+func (s *Server) writeFailed(w http.ResponseWriter, req *http.Request, err error) {
+	if errors.Is(err, storage.ErrNotLeader) {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	level.Error(s.requestLogger(req)).Log("msg", "write failed", "error", err)
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// recordWriter records the writes, failing them with err
+type recordWriter struct {
+	tiles []storage.Tile
+	infos []storage.MapInfos
+	err   error
+}
+
+func (w *recordWriter) WriteTiles(_ context.Context, tiles []storage.Tile) error {
+	w.tiles = append(w.tiles, tiles...)
+	return w.err
+}
+
+func (w *recordWriter) WriteMapInfos(_ context.Context, infos storage.MapInfos) error {
+	w.infos = append(w.infos, infos)
+	return w.err
+}
+
+func TestServer_WriteHandlers(t *testing.T) {
+	newRouter := func(opts ...Option) *mux.Router {
+		s, err := New("write_test", "", tileStore(nil), log.NewNopLogger(), health.NewServer(), append(opts, WithStaticDir(""))...)
+		require.NoError(t, err)
+		r := mux.NewRouter()
+		r.HandleFunc("/admin/mapinfos", s.MapInfosWriteHandler).Methods("PUT")
+		r.HandleFunc("/admin/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}", s.TileWriteHandler).Methods("PUT", "DELETE")
+		return r
+	}
+	do := func(r http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	r := newRouter()
+	require.Equal(t, http.StatusNotFound, do(r, "PUT", "/admin/tiles/1/0/0", "tile").Code)
+	require.Equal(t, http.StatusNotFound, do(r, "PUT", "/admin/mapinfos", "{}").Code)
+
+	writer := &recordWriter{}
+	r = newRouter(WithWriter(writer))
+
+	// y is flipped to the TMS scheme
+	require.Equal(t, http.StatusNoContent, do(r, "PUT", "/admin/tiles/2/1/0", "tile").Code)
+	require.Equal(t, http.StatusNoContent, do(r, "DELETE", "/admin/tiles/2/1/3", "").Code)
+	require.Equal(t, []storage.Tile{{Z: 2, X: 1, Y: 3, Data: []byte("tile")}, {Z: 2, X: 1, Y: 0}}, writer.tiles)
+
+	require.Equal(t, http.StatusBadRequest, do(r, "PUT", "/admin/tiles/2/1/4", "tile").Code)
+	require.Equal(t, http.StatusBadRequest, do(r, "PUT", "/admin/tiles/2/1/0", "").Code)
+
+	require.Equal(t, http.StatusNoContent, do(r, "PUT", "/admin/mapinfos", `{"Region":"hawaii","MaxZoom":14}`).Code)
+	require.Equal(t, []storage.MapInfos{{Region: "hawaii", MaxZoom: 14}}, writer.infos)
+	require.Equal(t, http.StatusBadRequest, do(r, "PUT", "/admin/mapinfos", `{"Region":`).Code)
+
+	// the followers point to the leader
+	writer.err = fmt.Errorf("%w, the leader is a at 10.0.0.1:7000", storage.ErrNotLeader)
+	w := do(r, "PUT", "/admin/tiles/2/1/0", "tile")
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Contains(t, w.Body.String(), "10.0.0.1:7000")
+
+	writer.err = fmt.Errorf("disk full")
+	require.Equal(t, http.StatusInternalServerError, do(r, "PUT", "/admin/mapinfos", "{}").Code)
+}
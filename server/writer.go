@@ -0,0 +1,39 @@
+package server
+
+import "net/http"
+
+// statusWriter records the status code and the bytes written to a ResponseWriter
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Status returns the status code sent, 200 if none was explicitly sent
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,226 @@
+// Package statsd pushes the Prometheus metrics to a StatsD or DogStatsD agent,
+// for the monitoring stacks which can't scrape the metrics endpoint
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/dogstatsd"
+	kitstatsd "github.com/go-kit/kit/metrics/statsd"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxPacketSize keeps the datagrams under the usual network MTU
+const maxPacketSize = 1432
+
+// Options configures an Exporter
+type Options struct {
+	// Addr is the UDP address of the agent, e.g. localhost:8125
+	Addr string
+	// Prefix is prepended to the metrics names, e.g. myapp.
+	Prefix string
+	// DogStatsD sends the labels as DogStatsD tags, they are appended to the metrics names otherwise
+	DogStatsD bool
+	// Tags are added to every metric, as DogStatsD key:value tags e.g. env:prod, ignored for StatsD
+	Tags []string
+	// Interval is the push interval
+	Interval time.Duration
+}
+
+// Exporter pushes the metrics of a Prometheus gatherer at every interval, counters are sent as
+// StatsD counters of their increase since the last push, gauges as gauges, histograms and summaries
+// as the counters of their count and sum, the lines are written by the go-kit StatsD or DogStatsD clients
+type Exporter struct {
+	gatherer prometheus.Gatherer
+	opts     Options
+	conn     net.Conn
+	logger   log.Logger
+
+	// the go-kit client buffering the metrics until written
+	newCounter func(name string) metrics.Counter
+	newGauge   func(name string) metrics.Gauge
+	writeTo    func(w io.Writer) (int64, error)
+
+	// last are the counters values at the last push, by metric line
+	last map[string]float64
+}
+
+// New returns an Exporter sending the metrics gathered from g to opts.Addr
+func New(g prometheus.Gatherer, opts Options, logger log.Logger) (*Exporter, error) {
+	conn, err := net.Dial("udp", opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("can't dial StatsD agent: %w", err)
+	}
+	e := &Exporter{
+		gatherer: g,
+		opts:     opts,
+		conn:     conn,
+		logger:   log.With(logger, "component", "statsd"),
+		last:     make(map[string]float64),
+	}
+
+	if opts.DogStatsD {
+		var tags []string
+		for _, t := range opts.Tags {
+			k, v, _ := strings.Cut(t, ":")
+			tags = append(tags, k, v)
+		}
+		d := dogstatsd.New(opts.Prefix, e.logger, tags...)
+		e.newCounter = func(name string) metrics.Counter { return d.NewCounter(name, 1) }
+		e.newGauge = func(name string) metrics.Gauge { return d.NewGauge(name) }
+		e.writeTo = d.WriteTo
+	} else {
+		s := kitstatsd.New(opts.Prefix, e.logger)
+		e.newCounter = func(name string) metrics.Counter { return s.NewCounter(name, 1) }
+		e.newGauge = func(name string) metrics.Gauge { return s.NewGauge(name) }
+		e.writeTo = s.WriteTo
+	}
+	return e, nil
+}
+
+// Run pushes the metrics until ctx is done, then pushes them a last time and closes the connection
+func (e *Exporter) Run(ctx context.Context) error {
+	defer e.conn.Close()
+
+	ticker := time.NewTicker(e.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err := e.Push(); err != nil {
+				level.Warn(e.logger).Log("msg", "can't push metrics", "error", err)
+			}
+			return nil
+		}
+		if err := e.Push(); err != nil {
+			level.Warn(e.logger).Log("msg", "can't push metrics", "error", err)
+		}
+	}
+}
+
+// Push sends the current metrics
+func (e *Exporter) Push() error {
+	mfs, err := e.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("can't gather metrics: %w", err)
+	}
+
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			name, lvs := e.name(mf.GetName(), m.GetLabel())
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				e.counter(name, lvs, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				e.newGauge(name).With(lvs...).Set(m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				e.newGauge(name).With(lvs...).Set(m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				e.counter(name+".count", lvs, float64(h.GetSampleCount()))
+				e.counter(name+".sum", lvs, h.GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				e.counter(name+".count", lvs, float64(s.GetSampleCount()))
+				e.counter(name+".sum", lvs, s.GetSampleSum())
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := e.writeTo(&buf); err != nil {
+		return fmt.Errorf("can't write metrics: %w", err)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return e.send(strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"))
+}
+
+// counter adds the increase of the counter since the last push, if any
+func (e *Exporter) counter(name string, lvs []string, v float64) {
+	key := name + "|" + strings.Join(lvs, ",")
+	last, ok := e.last[key]
+	e.last[key] = v
+	delta := v - last
+	if !ok || delta < 0 {
+		// the first push or a reset counter sends its whole value
+		delta = v
+	}
+	if delta == 0 {
+		return
+	}
+	e.newCounter(name).With(lvs...).Add(delta)
+}
+
+// name returns the StatsD name of a metric and its DogStatsD tags as label values pairs,
+// the labels values are appended to the name for StatsD, e.g. kvtiles_tiles_requests_total.12.200
+func (e *Exporter) name(name string, labels []*dto.LabelPair) (string, []string) {
+	if !e.opts.DogStatsD {
+		for _, l := range labels {
+			name += "." + sanitize(l.GetValue())
+		}
+		return name, nil
+	}
+
+	lvs := make([]string, 0, 2*len(labels))
+	for _, l := range labels {
+		lvs = append(lvs, l.GetName(), sanitize(l.GetValue()))
+	}
+	return name, lvs
+}
+
+// sanitize replaces the characters of the StatsD protocol in a label value
+func sanitize(v string) string {
+	if v == "" {
+		return "none"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '#', '@', '.', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+// send writes the lines in datagrams of up to maxPacketSize bytes
+func (e *Exporter) send(lines []string) error {
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		_, err := e.conn.Write(buf.Bytes())
+		buf.Reset()
+		return err
+	}
+
+	for _, l := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(l) > maxPacketSize {
+			if err := flush(); err != nil {
+				return fmt.Errorf("can't send metrics: %w", err)
+			}
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(l)
+	}
+	if err := flush(); err != nil {
+		return fmt.Errorf("can't send metrics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package statsd
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter_Push(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	read := func() []string {
+		var lines []string
+		buf := make([]byte, maxPacketSize)
+		for {
+			require.NoError(t, pc.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+		}
+		sort.Strings(lines)
+		return lines
+	}
+
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"}, []string{"code"})
+	size := prometheus.NewGauge(prometheus.GaugeOpts{Name: "cache_bytes"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds"})
+	reg.MustRegister(requests, size, latency)
+
+	requests.WithLabelValues("200").Add(3)
+	size.Set(1024)
+	latency.Observe(0.5)
+
+	e, err := New(reg, Options{Addr: pc.LocalAddr().String(), Prefix: "kv."}, log.NewNopLogger())
+	require.NoError(t, err)
+	defer e.conn.Close()
+
+	require.NoError(t, e.Push())
+	require.Equal(t, []string{
+		"kv.cache_bytes:1024.000000|g",
+		"kv.latency_seconds.count:1.000000|c",
+		"kv.latency_seconds.sum:0.500000|c",
+		"kv.requests_total.200:3.000000|c",
+	}, read())
+
+	// only the increase of the counters is sent
+	requests.WithLabelValues("200").Add(2)
+	require.NoError(t, e.Push())
+	require.Equal(t, []string{"kv.cache_bytes:1024.000000|g", "kv.requests_total.200:2.000000|c"}, read())
+
+	dog, err := New(reg, Options{Addr: pc.LocalAddr().String(), Prefix: "kv.", DogStatsD: true, Tags: []string{"env:test"}}, log.NewNopLogger())
+	require.NoError(t, err)
+	defer dog.conn.Close()
+	requests.WithLabelValues("404").Inc()
+	require.NoError(t, dog.Push())
+	lines := read()
+	require.Contains(t, lines, "kv.requests_total:1.000000|c|#env:test,code:404")
+	require.Contains(t, lines, "kv.requests_total:5.000000|c|#env:test,code:200")
+	require.Contains(t, lines, "kv.cache_bytes:1024.000000|g|#env:test")
+}
@@ -0,0 +1,246 @@
+package bbolt
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// Findings severities
+const (
+	// SeverityError is a problem breaking the served tiles
+	SeverityError = "error"
+	// SeverityWarning is a problem degrading the served map or the DB efficiency
+	SeverityWarning = "warning"
+)
+
+// Finding is a problem found by Diagnose and how to fix it
+type Finding struct {
+	Severity string `json:"severity"`
+	Problem  string `json:"problem"`
+	Fix      string `json:"fix"`
+}
+
+// DiagnoseOptions are the thresholds of Diagnose
+type DiagnoseOptions struct {
+	// MaxProblems bounds the corrupted tiles reported
+	MaxProblems int
+	// Samples is the count of tiles decoded as the map format
+	Samples int
+	// FreeRatio is the ratio of free pages in the file reported as bloat
+	FreeRatio float64
+}
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// maxCompressionSamples bounds the blobs whose encoding is compared to the map infos compression
+const maxCompressionSamples = 1000
+
+// Diagnose checks the DB for missing map infos, zoom gaps, corrupted tiles, free pages bloat,
+// compression flags not matching the stored tiles and a center outside of the tiles bounds
+func (s *Storage) Diagnose(opts DiagnoseOptions) ([]Finding, error) {
+	var findings []Finding
+	report := func(severity, fix, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Problem: fmt.Sprintf(format, args...), Fix: fix})
+	}
+
+	infos, ok, err := s.LoadMapInfos()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		report(SeverityError, "re-import the mbtiles with kvtiles import, the DB can't be served", "no map infos")
+		return findings, nil
+	}
+
+	st, err := s.Stats()
+	if err != nil {
+		return nil, err
+	}
+	if st.Tiles == 0 {
+		report(SeverityError, "re-import the mbtiles with kvtiles import", "no tiles in DB")
+		return findings, nil
+	}
+
+	s.diagnoseZooms(infos, st, report)
+	s.diagnoseCenter(infos, st, report)
+
+	if err := s.diagnoseCompression(infos, report); err != nil {
+		return nil, err
+	}
+
+	problems, _, err := s.Check(opts.MaxProblems)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range problems {
+		report(SeverityError, "re-import the mbtiles or restore a backup", "%v", p)
+	}
+	if opts.Samples > 0 {
+		if _, err := s.CheckSample(opts.Samples, rand.New(rand.NewSource(1))); err != nil {
+			report(SeverityError, "re-import the mbtiles, check its format metadata", "%v", err)
+		}
+	}
+
+	free, err := s.freePages()
+	if err != nil {
+		return nil, err
+	}
+	if ratio := float64(free) / float64(st.FileSize); opts.FreeRatio > 0 && ratio > opts.FreeRatio {
+		report(SeverityWarning, "rewrite the DB with kvtiles compact",
+			"%d bytes of free pages, %.1f%% of the file", free, ratio*100)
+	}
+
+	return findings, nil
+}
+
+// diagnoseZooms reports the zooms without tiles and a max zoom not matching the tiles
+func (s *Storage) diagnoseZooms(infos *storage.MapInfos, st *Stats, report func(severity, fix, format string, args ...interface{})) {
+	minZoom := -1
+	for z, zs := range st.Zooms {
+		if zs == nil {
+			continue
+		}
+		if minZoom < 0 {
+			minZoom = z
+		}
+	}
+	maxZoom := len(st.Zooms) - 1
+
+	if minZoom > 0 {
+		report(SeverityWarning, "re-import from an mbtiles starting at zoom 0 unless the map is not meant to be seen zoomed out",
+			"no tiles below zoom %d", minZoom)
+	}
+	for z := minZoom; z <= maxZoom; z++ {
+		if st.Zooms[z] == nil {
+			report(SeverityError, "re-import from an mbtiles generated for every zoom",
+				"no tiles at zoom %d, between zoom %d and %d", z, minZoom, maxZoom)
+		}
+	}
+	if infos.MaxZoom != maxZoom {
+		report(SeverityWarning, fmt.Sprintf("re-import with -maxZoom %d", maxZoom),
+			"map infos max zoom is %d but the tiles go up to zoom %d, clients overzoom from the wrong level", infos.MaxZoom, maxZoom)
+	}
+
+	// the tiles of a zoom are children of the tiles of the previous zoom
+	prev := st.Zooms[minZoom]
+	for z := minZoom + 1; z <= maxZoom; z++ {
+		zs := st.Zooms[z]
+		if zs == nil || prev == nil {
+			prev = zs
+			continue
+		}
+		if zs.MinX/2 < prev.MinX || zs.MaxX/2 > prev.MaxX || zs.MinY/2 < prev.MinY || zs.MaxY/2 > prev.MaxY {
+			report(SeverityWarning, "re-import from an mbtiles generated for a single area",
+				"tiles at zoom %d extend past the tiles at zoom %d", z, z-1)
+		}
+		prev = zs
+	}
+}
+
+// diagnoseCenter reports a center invalid or outside of the tiles at the max zoom
+func (s *Storage) diagnoseCenter(infos *storage.MapInfos, st *Stats, report func(severity, fix, format string, args ...interface{})) {
+	fix := "re-import with -centerLat and -centerLng inside the map"
+	switch {
+	case math.Abs(infos.CenterLat) > 85.0511 || math.Abs(infos.CenterLng) > 180:
+		report(SeverityError, fix, "center %f,%f is not a valid web mercator position", infos.CenterLat, infos.CenterLng)
+		return
+	case infos.CenterLat == 0 && infos.CenterLng == 0:
+		report(SeverityWarning, fix, "center is not set")
+		return
+	}
+
+	z := len(st.Zooms) - 1
+	zs := st.Zooms[z]
+	// the TMS max y is the XYZ min y
+	minLat, _, _, maxLng := tilemath.Tile{Z: uint8(z), X: zs.MaxX, Y: 1<<z - zs.MinY - 1}.Bounds()
+	_, minLng, maxLat, _ := tilemath.Tile{Z: uint8(z), X: zs.MinX, Y: 1<<z - zs.MaxY - 1}.Bounds()
+	if infos.CenterLat < minLat || infos.CenterLat > maxLat || infos.CenterLng < minLng || infos.CenterLng > maxLng {
+		report(SeverityWarning, fix, "center %f,%f is outside of the tiles bounds %.4f,%.4f,%.4f,%.4f at zoom %d",
+			infos.CenterLat, infos.CenterLng, minLng, minLat, maxLng, maxLat, z)
+	}
+}
+
+// diagnoseCompression compares the map infos compression with the dictionary and the stored blobs
+func (s *Storage) diagnoseCompression(infos *storage.MapInfos, report func(severity, fix, format string, args ...interface{})) error {
+	var hasDict bool
+	var zstdBlobs, gzipBlobs, otherBlobs int
+	err := s.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(storage.MapKey())
+		hasDict = b.Get(storage.DictKey()) != nil
+
+		c := b.Cursor()
+		count := 0
+		for k, v := c.Seek([]byte{storage.TilesPrefix}); k != nil && k[0] == storage.TilesPrefix && count < maxCompressionSamples; k, v = c.Next() {
+			count++
+			switch {
+			case bytes.HasPrefix(v, zstdMagic):
+				zstdBlobs++
+			case bytes.HasPrefix(v, gzipMagic):
+				gzipBlobs++
+			default:
+				otherBlobs++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fix := "re-import the mbtiles, the compression flag and the stored tiles must match"
+	switch infos.Compression {
+	case CompressionZstdDict:
+		if !hasDict {
+			report(SeverityError, fix, "compression is %s but the DB has no zstd dictionary", infos.Compression)
+		}
+		if gzipBlobs+otherBlobs > 0 {
+			report(SeverityError, fix, "compression is %s but %d sampled tiles are not zstd compressed",
+				infos.Compression, gzipBlobs+otherBlobs)
+		}
+	case "":
+		if hasDict {
+			report(SeverityError, fix, "the DB has a zstd dictionary but no compression set, tiles are decoded with it")
+		}
+		if zstdBlobs > 0 {
+			report(SeverityError, fix, "no compression set but %d sampled tiles are zstd compressed", zstdBlobs)
+		}
+		if infos.Format == "" && otherBlobs > 0 {
+			report(SeverityWarning, "re-import from an mbtiles storing gzipped vector tiles",
+				"%d sampled vector tiles are not gzipped, they are served uncompressed", otherBlobs)
+		}
+	default:
+		report(SeverityError, fix, "unknown compression %s", infos.Compression)
+	}
+	return nil
+}
+
+// freePages returns the bytes of the pages not used by any bucket, approximately since
+// read only DBs don't load the freelist, the meta and freelist pages are counted as free
+func (s *Storage) freePages() (int64, error) {
+	var free int64
+	err := s.View(func(tx *bbolt.Tx) error {
+		pageSize := int64(tx.DB().Info().PageSize)
+		used := int64(0)
+		err := tx.ForEach(func(_ []byte, b *bbolt.Bucket) error {
+			bs := b.Stats()
+			used += int64(bs.BranchPageN + bs.BranchOverflowN + bs.LeafPageN + bs.LeafOverflowN)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		free = tx.Size() - used*pageSize
+		return nil
+	})
+	return free, err
+}
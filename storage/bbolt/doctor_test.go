@@ -0,0 +1,53 @@
+//go:build cgo
+// +build cgo
+
+package bbolt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnose(t *testing.T) {
+	src, clean := setup(t)
+	defer clean()
+
+	opts := DiagnoseOptions{MaxProblems: 10, Samples: 100, FreeRatio: 0.5}
+	findings, err := src.Diagnose(opts)
+	require.NoError(t, err)
+	require.Empty(t, findings)
+
+	dir, err := ioutil.TempDir("", "kvtiles-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, sClose, err := NewStorage(filepath.Join(dir, "doctor.db"), log.NewNopLogger())
+	require.NoError(t, err)
+	defer sClose()
+	require.NoError(t, Migrate(src, s))
+
+	infos, _, err := s.LoadMapInfos()
+	require.NoError(t, err)
+	infos.MaxZoom = 5
+	infos.CenterLat, infos.CenterLng = 48.8, 2.2
+	infos.Compression = CompressionZstdDict
+	require.NoError(t, s.storeMapInfos(infos))
+
+	findings, err = s.Diagnose(opts)
+	require.NoError(t, err)
+	problems := make(map[string]string)
+	for _, f := range findings {
+		problems[f.Problem] = f.Severity
+	}
+	require.Equal(t, map[string]string{
+		"map infos max zoom is 5 but the tiles go up to zoom 11, clients overzoom from the wrong level":           SeverityWarning,
+		"center 48.800000,2.200000 is outside of the tiles bounds -180.0000,15.4537,-151.1719,31.8029 at zoom 11": SeverityWarning,
+		"compression is zstd-dict but the DB has no zstd dictionary":                                              SeverityError,
+		"compression is zstd-dict but 882 sampled tiles are not zstd compressed":                                  SeverityError,
+	}, problems)
+}
@@ -0,0 +1,306 @@
+package bbolt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// Tiles keys layouts, the layout defines the order of the tiles on disk
+const (
+	// LayoutZXY keys tiles by their "z/x/y" string, the default
+	LayoutZXY = ""
+	// LayoutQuadkey keys tiles by zoom then quadkey (Z-order curve)
+	LayoutQuadkey = "quadkey"
+	// LayoutHilbert keys tiles by zoom then Hilbert curve index, best locality
+	LayoutHilbert = "hilbert"
+)
+
+// UseKeyLayout sets the tiles keys layout used by StoreMap and Migrate
+func (s *Storage) UseKeyLayout(layout string) error {
+	switch layout {
+	case LayoutZXY, LayoutQuadkey, LayoutHilbert:
+	case "zxy":
+		layout = LayoutZXY
+	default:
+		return fmt.Errorf("unknown key layout %s", layout)
+	}
+	s.layout = layout
+	return nil
+}
+
+// appendTileKey appends the key of the tile index entry for layout
+func appendTileKey(buf []byte, layout string, z uint8, x, y uint64) []byte {
+	var d uint64
+	switch layout {
+	case LayoutHilbert:
+		d = hilbertIndex(z, x, y)
+	case LayoutQuadkey:
+		d = quadkeyIndex(z, x, y)
+	default:
+		return appendTileURLKey(buf, z, x, y)
+	}
+
+	buf = append(buf, storage.TilesURLPrefix, z)
+	return binary.BigEndian.AppendUint64(buf, d)
+}
+
+// parseTileKey returns the tile of an index entry key
+func parseTileKey(k []byte, layout string) (z uint8, x, y uint64, err error) {
+	switch layout {
+	case LayoutHilbert, LayoutQuadkey:
+		if len(k) != 10 {
+			return 0, 0, 0, fmt.Errorf("invalid tile key %x", k)
+		}
+		z = k[1]
+		d := binary.BigEndian.Uint64(k[2:])
+		if layout == LayoutHilbert {
+			x, y = hilbertTile(z, d)
+		} else {
+			x, y = quadkeyTile(z, d)
+		}
+		return z, x, y, nil
+	}
+
+	parts := strings.Split(string(k[1:]), "/")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("invalid tile key %q", k)
+	}
+	zz, errz := strconv.ParseUint(parts[0], 10, 8)
+	x, errx := strconv.ParseUint(parts[1], 10, 64)
+	y, erry := strconv.ParseUint(parts[2], 10, 64)
+	if errz != nil || errx != nil || erry != nil {
+		return 0, 0, 0, fmt.Errorf("invalid tile key %q", k)
+	}
+	return uint8(zz), x, y, nil
+}
+
+// quadkeyIndex interleaves the bits of x and y, as the digits of the tile quadkey
+func quadkeyIndex(z uint8, x, y uint64) uint64 {
+	var d uint64
+	for i := int(z) - 1; i >= 0; i-- {
+		d = d<<2 | (y>>uint(i)&1)<<1 | x>>uint(i)&1
+	}
+	return d
+}
+
+func quadkeyTile(z uint8, d uint64) (x, y uint64) {
+	for i := uint(0); i < uint(z); i++ {
+		x |= (d >> (2 * i) & 1) << i
+		y |= (d >> (2*i + 1) & 1) << i
+	}
+	return x, y
+}
+
+// hilbertIndex returns the distance of x y along the Hilbert curve covering the 2^z grid
+func hilbertIndex(z uint8, x, y uint64) uint64 {
+	n := uint64(1) << z
+	var d uint64
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint64
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+		x, y = hilbertRotate(n, x, y, rx, ry)
+	}
+	return d
+}
+
+func hilbertTile(z uint8, d uint64) (x, y uint64) {
+	n := uint64(1) << z
+	for s := uint64(1); s < n; s *= 2 {
+		rx := 1 & (d / 2)
+		ry := 1 & (d ^ rx)
+		x, y = hilbertRotate(s, x, y, rx, ry)
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+	return x, y
+}
+
+func hilbertRotate(n, x, y, rx, ry uint64) (uint64, uint64) {
+	if ry == 0 {
+		if rx == 1 {
+			x = n - 1 - x
+			y = n - 1 - y
+		}
+		return y, x
+	}
+	return x, y
+}
+
+// relayout numbers the blobs in the order of the tiles index, so adjacent tiles blobs
+// are written close to each other, index entries must point to getBlob IDs and are
+// rewritten to the new blobs
+func (s *Storage) relayout(getBlob func(id []byte) ([]byte, error)) error {
+	type entry struct {
+		k, id []byte
+	}
+
+	seqs := make(map[string]uint64)
+	var seq uint64
+	start := []byte{storage.TilesURLPrefix}
+
+	for {
+		var batch []entry
+		if err := s.View(func(tx *bbolt.Tx) error {
+			c := tx.Bucket(storage.MapKey()).Cursor()
+			for k, v := c.Seek(start); k != nil && k[0] == storage.TilesURLPrefix && len(batch) < transacMaxSize; k, v = c.Next() {
+				batch = append(batch, entry{
+					k:  append([]byte(nil), k...),
+					id: append([]byte(nil), v...),
+				})
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := s.Update(func(tx *bbolt.Tx) error {
+			b := tx.Bucket(storage.MapKey())
+			for _, e := range batch {
+				n, ok := seqs[string(e.id)]
+				if !ok {
+					seq++
+					n = seq
+					seqs[string(e.id)] = n
+
+					blob, err := getBlob(e.id)
+					if err != nil {
+						return err
+					}
+					if err := b.Put(blobKey(n), blob); err != nil {
+						return err
+					}
+				}
+				if err := b.Put(e.k, blobID(n)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed writing blobs: %w", err)
+		}
+
+		// smallest key after the last one
+		start = append(batch[len(batch)-1].k, 0)
+	}
+}
+
+func blobID(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+func blobKey(n uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{storage.TilesPrefix}, n)
+}
+
+// Migrate copies the map stored in src to dst, an empty DB, using dst key layout
+func Migrate(src, dst *Storage) error {
+	infos, ok, err := src.LoadMapInfos()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no map in source DB")
+	}
+
+	var dict []byte
+	if err := src.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket(storage.MapKey()).Get(storage.DictKey()); v != nil {
+			dict = append([]byte(nil), v...)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := dst.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(storage.MapKey())
+		if err != nil {
+			return err
+		}
+		if dict != nil {
+			return b.Put(storage.DictKey(), dict)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed writing to DB: %w", err)
+	}
+
+	// copy the index pointing to the source blobs
+	start := []byte{storage.TilesURLPrefix}
+	for {
+		var count int
+		var last []byte
+		err := src.View(func(stx *bbolt.Tx) error {
+			return dst.Update(func(dtx *bbolt.Tx) error {
+				db := dtx.Bucket(storage.MapKey())
+				c := stx.Bucket(storage.MapKey()).Cursor()
+				for k, v := c.Seek(start); k != nil && k[0] == storage.TilesURLPrefix && count < transacMaxSize; k, v = c.Next() {
+					z, x, y, err := parseTileKey(k, src.layout)
+					if err != nil {
+						return err
+					}
+					if err := db.Put(appendTileKey(nil, dst.layout, z, x, y), v); err != nil {
+						return err
+					}
+					last = append(last[:0], k...)
+					count++
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("failed copying index: %w", err)
+		}
+		if count == 0 {
+			break
+		}
+		start = append(last, 0)
+	}
+
+	err = dst.relayout(func(id []byte) ([]byte, error) {
+		var blob []byte
+		err := src.View(func(tx *bbolt.Tx) error {
+			v := tx.Bucket(storage.MapKey()).Get(append([]byte{storage.TilesPrefix}, id...))
+			if v == nil {
+				return fmt.Errorf("can't find blob %x", id)
+			}
+			blob = append([]byte(nil), v...)
+			return nil
+		})
+		return blob, err
+	})
+	if err != nil {
+		return err
+	}
+
+	infos.KeyLayout = dst.layout
+	return dst.storeMapInfos(infos)
+}
+
+// loadKeyLayout reads the key layout of the stored map
+func (s *Storage) loadKeyLayout() error {
+	infos, ok, err := s.LoadMapInfos()
+	if err != nil {
+		return err
+	}
+	if ok {
+		s.layout = infos.KeyLayout
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package bbolt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTileKey_RoundTrip(t *testing.T) {
+	tests := []struct {
+		z    uint8
+		x, y uint64
+	}{
+		{0, 0, 0},
+		{1, 1, 0},
+		{11, 124, 1147},
+		{14, 2763, 9421},
+		{30, 1<<30 - 1, 12345},
+	}
+
+	for _, layout := range []string{LayoutZXY, LayoutQuadkey, LayoutHilbert} {
+		for _, tt := range tests {
+			k := appendTileKey(nil, layout, tt.z, tt.x, tt.y)
+			z, x, y, err := parseTileKey(k, layout)
+			require.NoError(t, err)
+			require.Equal(t, []uint64{uint64(tt.z), tt.x, tt.y}, []uint64{uint64(z), x, y}, layout)
+		}
+	}
+}
+
+func TestHilbertIndex(t *testing.T) {
+	// every step along the curve moves to an adjacent tile
+	const z = 5
+	px, py := hilbertTile(z, 0)
+	for d := uint64(1); d < 1<<(2*z); d++ {
+		x, y := hilbertTile(z, d)
+		require.Equal(t, d, hilbertIndex(z, x, y))
+
+		dist := absDiff(x, px) + absDiff(y, py)
+		require.Equal(t, uint64(1), dist)
+		px, py = x, y
+	}
+}
+
+func absDiff(a, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
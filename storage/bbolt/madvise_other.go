@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package bbolt
+
+import (
+	"errors"
+
+	"go.etcd.io/bbolt"
+)
+
+// tuneMmap is not supported on this platform
+func tuneMmap(db *bbolt.DB, advice string, mlock bool) error {
+	return errors.New("madvise and mlock are not supported on this platform")
+}
@@ -0,0 +1,58 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package bbolt
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"go.etcd.io/bbolt"
+	"golang.org/x/sys/unix"
+)
+
+// tuneMmap applies the madvise hint and mlock to the DB mmap
+func tuneMmap(db *bbolt.DB, advice string, mlock bool) error {
+	var size int64
+	if err := db.View(func(tx *bbolt.Tx) error {
+		size = tx.Size()
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var data []byte
+	sh := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	sh.Data = db.Info().Data
+	sh.Len = int(size)
+	sh.Cap = int(size)
+
+	if advice != "" {
+		var a int
+		switch advice {
+		case "normal":
+			a = unix.MADV_NORMAL
+		case "random":
+			a = unix.MADV_RANDOM
+		case "sequential":
+			a = unix.MADV_SEQUENTIAL
+		case "willneed":
+			a = unix.MADV_WILLNEED
+		default:
+			return fmt.Errorf("unknown madvise advice %s", advice)
+		}
+
+		if err := unix.Madvise(data, a); err != nil {
+			return fmt.Errorf("madvise failed: %w", err)
+		}
+	}
+
+	if mlock {
+		if err := unix.Mlock(data); err != nil {
+			return fmt.Errorf("mlock failed: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+// +build !linux
+
+package bbolt
+
+const mapPopulate = 0
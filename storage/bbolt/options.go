@@ -0,0 +1,96 @@
+package bbolt
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.etcd.io/bbolt"
+)
+
+// Options tunes the DB opened for serving
+type Options struct {
+	// MmapPopulate pre-faults the whole mmap (MAP_POPULATE), linux only
+	MmapPopulate bool
+	// InitialMmapSize is the initial mmap size in bytes
+	InitialMmapSize int
+	// Advice is the madvise hint applied to the mmap: normal, random, sequential or willneed
+	Advice string
+	// Mlock locks the mmap in memory, preventing the DB pages to be swapped out
+	Mlock bool
+	// FreelistType is array or hashmap
+	FreelistType string
+	// PageSize is the expected DB page size, a warning is logged if it differs, 0 to skip the check
+	PageSize int
+}
+
+// NewROStorageWithOptions returns a read only storage using bboltdb tuned with opts
+func NewROStorageWithOptions(path string, logger log.Logger, opts Options) (*Storage, func() error, error) {
+	return openWithOptions(path, logger, opts, true)
+}
+
+// NewRWStorageWithOptions returns a storage using bboltdb tuned with opts, the map can be updated
+// with WriteTiles and WriteMapInfos while served
+func NewRWStorageWithOptions(path string, logger log.Logger, opts Options) (*Storage, func() error, error) {
+	return openWithOptions(path, logger, opts, false)
+}
+
+func openWithOptions(path string, logger log.Logger, opts Options, readOnly bool) (*Storage, func() error, error) {
+	bopts := &bbolt.Options{
+		ReadOnly:        readOnly,
+		InitialMmapSize: opts.InitialMmapSize,
+	}
+
+	if opts.MmapPopulate {
+		bopts.MmapFlags |= mapPopulate
+	}
+
+	switch strings.ToLower(opts.FreelistType) {
+	case "", "array":
+		bopts.FreelistType = bbolt.FreelistArrayType
+	case "hashmap", "map":
+		bopts.FreelistType = bbolt.FreelistMapType
+	default:
+		return nil, nil, fmt.Errorf("unknown freelist type %s", opts.FreelistType)
+	}
+
+	db, err := bbolt.Open(path, 0600, bopts)
+	if err != nil {
+		mode := "writing"
+		if readOnly {
+			mode = "reading"
+		}
+		return nil, nil, fmt.Errorf("failed to open DB for %s at %s: %w", mode, path, err)
+	}
+
+	if opts.PageSize != 0 && db.Info().PageSize != opts.PageSize {
+		level.Warn(logger).Log("msg", "unexpected DB page size",
+			"page_size", db.Info().PageSize, "expected", opts.PageSize, "os_page_size", os.Getpagesize())
+	}
+
+	if opts.Advice != "" || opts.Mlock {
+		if err := tuneMmap(db, opts.Advice, opts.Mlock); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+	}
+
+	s := &Storage{
+		DB:     db,
+		logger: logger,
+	}
+
+	if err := s.loadZstdDict(); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	if err := s.loadKeyLayout(); err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+
+	return s, s.Close, nil
+}
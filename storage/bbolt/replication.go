@@ -0,0 +1,104 @@
+package bbolt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// Version returns the version of the stored map, its index time in nanoseconds, 0 if none
+func (s *Storage) Version() (int64, error) {
+	infos, ok, err := s.LoadMapInfos()
+	if err != nil || !ok {
+		return 0, err
+	}
+	return infos.IndexTime.UnixNano(), nil
+}
+
+// WriteSnapshot writes a consistent copy of the whole DB file to w
+func (s *Storage) WriteSnapshot(w io.Writer) (int64, error) {
+	var n int64
+	err := s.View(func(tx *bbolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Diff calls fn for every map entry added or changed in to since from,
+// with a nil value for the removed entries
+func Diff(from, to *Storage, fn func(k, v []byte) error) error {
+	return from.View(func(ftx *bbolt.Tx) error {
+		return to.View(func(ttx *bbolt.Tx) error {
+			fb := ftx.Bucket(storage.MapKey())
+			tb := ttx.Bucket(storage.MapKey())
+			if fb == nil || tb == nil {
+				return fmt.Errorf("no map bucket to diff")
+			}
+
+			fc, tc := fb.Cursor(), tb.Cursor()
+			fk, fv := fc.First()
+			tk, tv := tc.First()
+			for fk != nil || tk != nil {
+				switch cmp := compareKeys(fk, tk); {
+				case cmp < 0:
+					if err := fn(fk, nil); err != nil {
+						return err
+					}
+					fk, fv = fc.Next()
+				case cmp > 0:
+					if err := fn(tk, tv); err != nil {
+						return err
+					}
+					tk, tv = tc.Next()
+				default:
+					if !bytes.Equal(fv, tv) {
+						if err := fn(tk, tv); err != nil {
+							return err
+						}
+					}
+					fk, fv = fc.Next()
+					tk, tv = tc.Next()
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// compareKeys compares cursor keys, nil being after any key
+func compareKeys(a, b []byte) int {
+	switch {
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return bytes.Compare(a, b)
+}
+
+// Apply writes the map entries, deleting the entries with a nil value
+func (s *Storage) Apply(keys, values [][]byte) error {
+	return s.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(storage.MapKey())
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			if values[i] == nil {
+				err = b.Delete(k)
+			} else {
+				err = b.Put(k, values[i])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,150 @@
+package bbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// maxSampleZoom is the highest zoom looked up for samples
+const maxSampleZoom = 24
+
+// SampleTiles calls fn with n tiles read at random positions of the DB, spread evenly across the zooms,
+// y is in the TMS scheme, data is gzipped as served and only valid during fn
+func (s *Storage) SampleTiles(n int, rnd *rand.Rand, fn func(z uint8, x, y uint64, data []byte) error) error {
+	return s.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(storage.MapKey())
+		if b == nil {
+			return fmt.Errorf("no map in DB")
+		}
+		c := b.Cursor()
+
+		var zooms []uint8
+		for z := uint8(0); z <= maxSampleZoom; z++ {
+			prefix := s.zoomPrefix(z)
+			if k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) {
+				zooms = append(zooms, z)
+			}
+		}
+		if len(zooms) == 0 {
+			return fmt.Errorf("no tiles in DB")
+		}
+
+		var bk []byte
+		for i := 0; i < n; i++ {
+			z := zooms[i%len(zooms)]
+			prefix := s.zoomPrefix(z)
+
+			// the first tile after a random position of the zoom, the first of the zoom past the last one
+			k, v := c.Seek(s.randomTileKey(rnd, z))
+			if k == nil || !bytes.HasPrefix(k, prefix) {
+				k, v = c.Seek(prefix)
+			}
+
+			z, x, y, err := parseTileKey(k, s.layout)
+			if err != nil {
+				return err
+			}
+
+			bk = append(append(bk[:0], storage.TilesPrefix), v...)
+			data := b.Get(bk)
+			if data == nil {
+				return fmt.Errorf("can't find blob of tile %d/%d/%d", z, x, y)
+			}
+			if s.dec != nil {
+				if data, err = s.regzip(data); err != nil {
+					return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+				}
+			}
+
+			if err := fn(z, x, y, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// zoomPrefix returns the prefix of the tile index keys at zoom z
+func (s *Storage) zoomPrefix(z uint8) []byte {
+	switch s.layout {
+	case LayoutHilbert, LayoutQuadkey:
+		return []byte{storage.TilesURLPrefix, z}
+	}
+	k := strconv.AppendUint([]byte{storage.TilesURLPrefix}, uint64(z), 10)
+	return append(k, '/')
+}
+
+// randomTileKey returns a key at a random position among the tile index keys at zoom z
+func (s *Storage) randomTileKey(rnd *rand.Rand, z uint8) []byte {
+	k := s.zoomPrefix(z)
+	switch s.layout {
+	case LayoutHilbert, LayoutQuadkey:
+		// the curves index the 4^z tiles of the zoom
+		return binary.BigEndian.AppendUint64(k, rnd.Uint64()>>(64-2*uint(z)))
+	}
+	return strconv.AppendUint(k, rnd.Uint64()>>(64-uint(z)), 10)
+}
+
+// CheckSample reads n tiles sampled across zooms and verifies they decode as the map format:
+// Mapbox vector tiles or png, jpeg, webp images, it returns the count of tiles checked
+func (s *Storage) CheckSample(n int, rnd *rand.Rand) (int, error) {
+	infos, ok, err := s.LoadMapInfos()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("no map infos")
+	}
+
+	var count int
+	err = s.SampleTiles(n, rnd, func(z uint8, x, y uint64, data []byte) error {
+		count++
+		if err := checkTile(infos.Format, data); err != nil {
+			return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+		}
+		return nil
+	})
+	return count, err
+}
+
+// imageMagics are the signatures starting the raster tiles by format
+var imageMagics = map[string][]byte{
+	"png":  []byte("\x89PNG\r\n\x1a\n"),
+	"jpg":  {0xff, 0xd8, 0xff},
+	"jpeg": {0xff, 0xd8, 0xff},
+}
+
+// checkTile verifies the tile data is in format, a vector tile when empty
+func checkTile(format string, data []byte) error {
+	switch format {
+	case "":
+		// raw vector tiles are served uncompressed
+		tile := data
+		if bytes.HasPrefix(data, gzipMagic) {
+			var err error
+			if tile, err = gunzip(data); err != nil {
+				return err
+			}
+		}
+		_, err := mvt.Decode(tile)
+		return err
+	case "webp":
+		if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+			return fmt.Errorf("not a webp image")
+		}
+		return nil
+	}
+
+	if magic, ok := imageMagics[format]; ok && !bytes.HasPrefix(data, magic) {
+		return fmt.Errorf("not a %s image", format)
+	}
+	return nil
+}
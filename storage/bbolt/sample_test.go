@@ -0,0 +1,58 @@
+//go:build cgo
+// +build cgo
+
+package bbolt
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleTiles(t *testing.T) {
+	src, clean := setup(t)
+	defer clean()
+
+	dir, err := ioutil.TempDir("", "kvtiles-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, layout := range []string{LayoutZXY, LayoutQuadkey, LayoutHilbert} {
+		s, sClose, err := NewStorage(filepath.Join(dir, "layout-"+layout+".db"), log.NewNopLogger())
+		require.NoError(t, err)
+		defer sClose()
+		require.NoError(t, s.UseKeyLayout(layout))
+		require.NoError(t, Migrate(src, s))
+
+		zooms := make(map[uint8]int)
+		err = s.SampleTiles(120, rand.New(rand.NewSource(1)), func(z uint8, x, y uint64, data []byte) error {
+			zooms[z]++
+			require.Less(t, x, uint64(1)<<z)
+			require.Less(t, y, uint64(1)<<z)
+			require.NotEmpty(t, data)
+			return nil
+		})
+		require.NoError(t, err, layout)
+		// hawaii holds zooms 0 to 11
+		require.Len(t, zooms, 12, layout)
+		require.Equal(t, 10, zooms[11], layout)
+
+		n, err := s.CheckSample(50, rand.New(rand.NewSource(2)))
+		require.NoError(t, err, layout)
+		require.Equal(t, 50, n, layout)
+	}
+}
+
+func TestCheckTile(t *testing.T) {
+	require.Error(t, checkTile("", []byte("not gzipped")))
+	require.NoError(t, checkTile("png", []byte("\x89PNG\r\n\x1a\nIHDR")))
+	require.Error(t, checkTile("png", []byte{0xff, 0xd8, 0xff, 0xe0}))
+	require.NoError(t, checkTile("jpg", []byte{0xff, 0xd8, 0xff, 0xe0}))
+	require.NoError(t, checkTile("webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")))
+	require.Error(t, checkTile("webp", []byte("RIFF")))
+}
@@ -0,0 +1,320 @@
+package bbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/fxamacker/cbor/v2"
+	"go.etcd.io/bbolt"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// maxSearchCandidates bounds the features read by a search
+const maxSearchCandidates = 10000
+
+// BuildSearchIndex indexes the names of the features of the tiles at zoom, replacing the existing index,
+// a feature spanning several tiles is indexed once, returns the count of indexed features
+func (s *Storage) BuildSearchIndex(zoom uint8) (int, error) {
+	infos, ok, err := s.LoadMapInfos()
+	if err != nil {
+		return 0, err
+	}
+	if ok && infos.Format != "" {
+		return 0, fmt.Errorf("search index requires vector tiles, not %s", infos.Format)
+	}
+	if err := s.loadKeyLayout(); err != nil {
+		return 0, err
+	}
+	if s.dec == nil {
+		if err := s.loadZstdDict(); err != nil {
+			return 0, err
+		}
+	}
+
+	// features are collected first, bbolt can't write while reading
+	var features []storage.SearchFeature
+	seen := make(map[string]bool)
+	err = s.ForEachTile(func(z uint8, x, y uint64, data []byte) error {
+		if z != zoom {
+			return nil
+		}
+		tile, err := gunzip(data)
+		if err != nil {
+			return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+		}
+		layers, err := mvt.Decode(tile)
+		if err != nil {
+			return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+		}
+
+		// in the XYZ scheme
+		y = 1<<z - y - 1
+		for _, l := range layers {
+			for _, f := range l.Features {
+				sf, ok := searchFeature(l, f, z, x, y)
+				if !ok {
+					continue
+				}
+
+				// the parts of a feature in several tiles share their ID or are close to each other
+				key := fmt.Sprintf("%s\x00%s\x00%s\x00%d", sf.Layer, sf.Name, sf.Class, f.ID)
+				if f.ID == 0 {
+					key = fmt.Sprintf("%s\x00%s\x00%s\x00%.1f,%.1f", sf.Layer, sf.Name, sf.Class, sf.Lat, sf.Lng)
+				}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				features = append(features, sf)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.deletePrefixes(storage.SearchPrefix, storage.SearchIndexPrefix); err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(features); start += transacMaxSize {
+		end := start + transacMaxSize
+		if end > len(features) {
+			end = len(features)
+		}
+		err := s.Update(func(tx *bbolt.Tx) error {
+			b := tx.Bucket(storage.MapKey())
+			for i := start; i < end; i++ {
+				id := binary.BigEndian.AppendUint32(nil, uint32(i))
+				v, err := cbor.Marshal(features[i])
+				if err != nil {
+					return err
+				}
+				if err := b.Put(append([]byte{storage.SearchPrefix}, id...), v); err != nil {
+					return err
+				}
+				for _, t := range searchTokens(features[i].Name) {
+					if err := b.Put(searchIndexKey(t, id), []byte{}); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("can't write search index: %w", err)
+		}
+	}
+
+	return len(features), nil
+}
+
+// Search returns up to limit features whose name contains the words of q, the last word may be a prefix,
+// names equal to q come first then the shortest names
+func (s *Storage) Search(q string, limit int) ([]storage.SearchFeature, error) {
+	tokens := searchTokens(q)
+	if len(tokens) == 0 || limit <= 0 {
+		return nil, nil
+	}
+	last := tokens[len(tokens)-1]
+
+	// candidates come from the longest token, the most selective
+	scan := len(tokens) - 1
+	for i, t := range tokens {
+		if len(t) > len(tokens[scan]) {
+			scan = i
+		}
+	}
+
+	var found []storage.SearchFeature
+	err := s.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(storage.MapKey())
+		if b == nil {
+			return nil
+		}
+
+		prefix := append([]byte{storage.SearchIndexPrefix}, tokens[scan]...)
+		if scan != len(tokens)-1 {
+			prefix = append(prefix, 0)
+		}
+
+		// a prefix may match several words of a name
+		seen := make(map[string]bool)
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && len(seen) < maxSearchCandidates; k, _ = c.Next() {
+			id := k[len(k)-4:]
+			if seen[string(id)] {
+				continue
+			}
+			seen[string(id)] = true
+			v := b.Get(append([]byte{storage.SearchPrefix}, id...))
+			if v == nil {
+				continue
+			}
+			var sf storage.SearchFeature
+			if err := cbor.Unmarshal(v, &sf); err != nil {
+				return err
+			}
+			if matchTokens(searchTokens(sf.Name), tokens[:len(tokens)-1], last) {
+				found = append(found, sf)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := strings.Join(tokens, " ")
+	sort.SliceStable(found, func(i, j int) bool {
+		ei := strings.Join(searchTokens(found[i].Name), " ") == normalized
+		ej := strings.Join(searchTokens(found[j].Name), " ") == normalized
+		if ei != ej {
+			return ei
+		}
+		return len(found[i].Name) < len(found[j].Name)
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+// matchTokens returns true if name holds all the words and a word starting with last
+func matchTokens(name, words []string, last string) bool {
+	has := func(match func(string) bool) bool {
+		for _, t := range name {
+			if match(t) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, w := range words {
+		if !has(func(t string) bool { return t == w }) {
+			return false
+		}
+	}
+	return has(func(t string) bool { return strings.HasPrefix(t, last) })
+}
+
+// searchFeature returns the search entry of a named feature, located at a point of its geometry
+func searchFeature(l mvt.Layer, f mvt.Feature, z uint8, x, y uint64) (storage.SearchFeature, bool) {
+	name, ok := f.Properties["name"].(string)
+	if !ok || name == "" {
+		return storage.SearchFeature{}, false
+	}
+	lines, err := mvt.DecodeGeometry(f)
+	if err != nil || len(lines) == 0 || len(lines[0]) == 0 {
+		return storage.SearchFeature{}, false
+	}
+
+	var px, py float64
+	switch f.Type {
+	case mvt.Polygon:
+		// the center of the exterior ring bounds
+		minX, minY, maxX, maxY := lines[0][0][0], lines[0][0][1], lines[0][0][0], lines[0][0][1]
+		for _, p := range lines[0] {
+			if p[0] < minX {
+				minX = p[0]
+			}
+			if p[0] > maxX {
+				maxX = p[0]
+			}
+			if p[1] < minY {
+				minY = p[1]
+			}
+			if p[1] > maxY {
+				maxY = p[1]
+			}
+		}
+		px, py = float64(minX+maxX)/2, float64(minY+maxY)/2
+	default:
+		// the first point or the middle of a line
+		p := lines[0][len(lines[0])/2]
+		px, py = float64(p[0]), float64(p[1])
+	}
+
+	n := float64(uint64(1) << z)
+	e := float64(l.Extent)
+	sf := storage.SearchFeature{
+		Name:  name,
+		Layer: l.Name,
+		Lng:   (float64(x)+px/e)/n*360 - 180,
+		Lat:   math.Atan(math.Sinh(math.Pi*(1-2*(float64(y)+py/e)/n))) * 180 / math.Pi,
+		Z:     z,
+		X:     x,
+		Y:     y,
+	}
+	sf.Class, _ = f.Properties["class"].(string)
+	return sf, true
+}
+
+// searchTokens returns the lower case words of s without diacritics
+func searchTokens(s string) []string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	words := strings.FieldsFunc(b.String(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	seen := make(map[string]bool, len(words))
+	tokens := words[:0]
+	for _, w := range words {
+		if !seen[w] {
+			seen[w] = true
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+func searchIndexKey(token string, id []byte) []byte {
+	k := append([]byte{storage.SearchIndexPrefix}, token...)
+	k = append(k, 0)
+	return append(k, id...)
+}
+
+// deletePrefixes deletes the keys starting with the prefixes
+func (s *Storage) deletePrefixes(prefixes ...byte) error {
+	for _, p := range prefixes {
+		for {
+			var deleted int
+			err := s.Update(func(tx *bbolt.Tx) error {
+				b := tx.Bucket(storage.MapKey())
+				if b == nil {
+					return nil
+				}
+				c := b.Cursor()
+				for k, _ := c.Seek([]byte{p}); k != nil && k[0] == p && deleted < transacMaxSize; k, _ = c.Seek([]byte{p}) {
+					if err := c.Delete(); err != nil {
+						return err
+					}
+					deleted++
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if deleted < transacMaxSize {
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package bbolt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+func TestStorage_Search(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvtiles-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, clean, err := NewStorage(filepath.Join(dir, "search.db"), log.NewNopLogger())
+	require.NoError(t, err)
+	defer clean()
+
+	named := func(id uint64, typ mvt.GeomType, name, class string, geom [][][2]int64) mvt.Feature {
+		return mvt.Feature{ID: id, Type: typ, Geometry: mvt.EncodeGeometry(typ, geom),
+			Properties: map[string]interface{}{"name": name, "class": class}}
+	}
+	tile := func(features ...mvt.Feature) []byte {
+		data, err := mvt.Encode([]mvt.Layer{{Name: "place", Extent: 4096, Features: features}})
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		_, err = gw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		return buf.Bytes()
+	}
+	etienne := named(1, mvt.Point, "Saint-Étienne", "city", [][][2]int64{{{2048, 2048}}})
+
+	w, err := s.NewTileWriter()
+	require.NoError(t, err)
+	require.NoError(t, w.Put(2, 1, 1, tile(
+		etienne,
+		named(2, mvt.LineString, "Rue Saint Denis", "street", [][][2]int64{{{0, 0}, {100, 100}, {200, 200}}}),
+		named(3, mvt.Polygon, "Paris", "city", [][][2]int64{{{0, 0}, {4096, 0}, {4096, 4096}, {0, 4096}, {0, 0}}}),
+		named(4, mvt.Point, "Paris Nord", "station", [][][2]int64{{{10, 10}}}),
+	)))
+	// the same feature in another tile is indexed once
+	require.NoError(t, w.Put(2, 2, 1, tile(etienne)))
+	require.NoError(t, w.Put(3, 1, 1, tile(named(5, mvt.Point, "Lyon", "city", [][][2]int64{{{0, 0}}}))))
+	require.NoError(t, w.Close(storage.MapInfos{MaxZoom: 3}))
+
+	count, err := s.BuildSearchIndex(2)
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+
+	found, err := s.Search("etienne", 10)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, "Saint-Étienne", found[0].Name)
+	require.Equal(t, "city", found[0].Class)
+	require.Equal(t, "place", found[0].Layer)
+	// TMS row 1 at zoom 2 is XYZ row 2
+	require.EqualValues(t, 2, found[0].Y)
+	require.InDelta(t, -45, found[0].Lng, 0.001)
+
+	found, err = s.Search("sain", 10)
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+
+	found, err = s.Search("saint den", 10)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, "Rue Saint Denis", found[0].Name)
+
+	found, err = s.Search("paris", 1)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, "Paris", found[0].Name)
+
+	found, err = s.Search("lyon", 10)
+	require.NoError(t, err)
+	require.Empty(t, found)
+
+	// rebuilding replaces the index
+	count, err = s.BuildSearchIndex(3)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	found, err = s.Search("paris", 10)
+	require.NoError(t, err)
+	require.Empty(t, found)
+	found, err = s.Search("LYON", 10)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+}
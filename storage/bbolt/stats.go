@@ -0,0 +1,122 @@
+package bbolt
+
+import (
+	"fmt"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// ZoomStats are the statistics of the tiles of a zoom level, sizes are the stored sizes
+type ZoomStats struct {
+	Tiles   int
+	Bytes   int64
+	MinSize int
+	MaxSize int
+	// MinX, MaxX, MinY, MaxY is the range of the tiles, y in the TMS scheme
+	MinX, MaxX, MinY, MaxY uint64
+}
+
+// Stats are the statistics of the DB content
+type Stats struct {
+	// Zooms are indexed by zoom level, nil for the levels without tiles
+	Zooms []*ZoomStats
+	Tiles int
+	// Blobs counts the distinct stored tiles, identical tiles share a blob
+	Blobs int
+	// BlobsBytes is the size of the blobs and their keys, IndexBytes the size of the tiles keys and values
+	BlobsBytes int64
+	IndexBytes int64
+	// DictBytes is the size of the zstd dictionary if any
+	DictBytes int
+	// FileSize is the size of the DB file
+	FileSize int64
+}
+
+// DuplicateRatio returns the ratio of tiles sharing their blob with another tile
+func (st *Stats) DuplicateRatio() float64 {
+	if st.Tiles == 0 {
+		return 0
+	}
+	return 1 - float64(st.Blobs)/float64(st.Tiles)
+}
+
+// Overhead returns the size of the DB file not used by the tiles, the index and the dictionary:
+// the pages headers, the free pages and the unused space in pages
+func (st *Stats) Overhead() int64 {
+	return st.FileSize - st.BlobsBytes - st.IndexBytes - int64(st.DictBytes)
+}
+
+// Stats reads every tile to compute the statistics of the DB
+func (s *Storage) Stats() (*Stats, error) {
+	st := &Stats{}
+	err := s.View(func(tx *bbolt.Tx) error {
+		st.FileSize = tx.Size()
+
+		b := tx.Bucket(storage.MapKey())
+		if b == nil {
+			return fmt.Errorf("no map in DB")
+		}
+		st.DictBytes = len(b.Get(storage.DictKey()))
+
+		blobs := make(map[string]struct{})
+		c := b.Cursor()
+		var bk []byte
+		for k, v := c.Seek([]byte{storage.TilesURLPrefix}); k != nil && k[0] == storage.TilesURLPrefix; k, v = c.Next() {
+			z, x, y, err := parseTileKey(k, s.layout)
+			if err != nil {
+				return err
+			}
+
+			bk = append(append(bk[:0], storage.TilesPrefix), v...)
+			data := b.Get(bk)
+			if data == nil {
+				return fmt.Errorf("can't find blob of tile %d/%d/%d", z, x, y)
+			}
+			if _, ok := blobs[string(bk)]; !ok {
+				blobs[string(bk)] = struct{}{}
+				st.BlobsBytes += int64(len(bk) + len(data))
+			}
+
+			st.Tiles++
+			st.IndexBytes += int64(len(k) + len(v))
+
+			for int(z) >= len(st.Zooms) {
+				st.Zooms = append(st.Zooms, nil)
+			}
+			zs := st.Zooms[z]
+			if zs == nil {
+				zs = &ZoomStats{MinSize: len(data), MinX: x, MaxX: x, MinY: y, MaxY: y}
+				st.Zooms[z] = zs
+			}
+			zs.Tiles++
+			zs.Bytes += int64(len(data))
+			if len(data) < zs.MinSize {
+				zs.MinSize = len(data)
+			}
+			if len(data) > zs.MaxSize {
+				zs.MaxSize = len(data)
+			}
+			if x < zs.MinX {
+				zs.MinX = x
+			}
+			if x > zs.MaxX {
+				zs.MaxX = x
+			}
+			if y < zs.MinY {
+				zs.MinY = y
+			}
+			if y > zs.MaxY {
+				zs.MaxY = y
+			}
+		}
+		st.Blobs = len(blobs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
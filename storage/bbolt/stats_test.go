@@ -0,0 +1,35 @@
+//go:build cgo
+// +build cgo
+
+package bbolt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_Stats(t *testing.T) {
+	s, clean := setup(t)
+	defer clean()
+
+	st, err := s.Stats()
+	require.NoError(t, err)
+
+	require.Len(t, st.Zooms, 12)
+	require.Equal(t, 1, st.Zooms[0].Tiles)
+	require.Equal(t, uint64(0), st.Zooms[0].MaxX)
+
+	var tiles int
+	var bytes int64
+	for _, zs := range st.Zooms {
+		tiles += zs.Tiles
+		bytes += zs.Bytes
+		require.LessOrEqual(t, zs.MinSize, zs.MaxSize)
+	}
+	require.Equal(t, st.Tiles, tiles)
+	require.Less(t, st.Blobs, st.Tiles)
+	require.Greater(t, st.DuplicateRatio(), 0.0)
+	require.Less(t, st.BlobsBytes, bytes)
+	require.Greater(t, st.Overhead(), int64(0))
+}
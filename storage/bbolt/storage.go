@@ -3,12 +3,16 @@ package bbolt
 import (
 	"bytes"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/terrain"
 	"github.com/fxamacker/cbor/v2"
 	log "github.com/go-kit/kit/log"
+	"github.com/klauspost/compress/zstd"
 	"go.etcd.io/bbolt"
 )
 
@@ -18,6 +22,16 @@ const transacMaxSize = 10000
 type Storage struct {
 	*bbolt.DB
 	logger log.Logger
+
+	zstdSamples  int
+	zstdDictSize int
+	layout       string
+	// encoding of terrain-RGB tiles, overriding the mbtiles metadata
+	encoding string
+	// filter selects the tiles stored by StoreMap, all tiles when nil
+	filter func(z uint8, x, y uint64) bool
+	// dec is set when tiles are stored compressed with a dictionary
+	dec *zstd.Decoder
 }
 
 // NewStorage returns a cold storage using bboltdb
@@ -28,26 +42,40 @@ func NewStorage(path string, logger log.Logger) (*Storage, func() error, error)
 		return nil, nil, err
 	}
 
-	return &Storage{
+	s := &Storage{
 		DB:     db,
 		logger: logger,
-	}, db.Close, nil
+	}
+	return s, s.Close, nil
+}
+
+// Close releases the zstd decoder, if any, and closes the DB
+func (s *Storage) Close() error {
+	if s.dec != nil {
+		s.dec.Close()
+	}
+	return s.DB.Close()
 }
 
 // NewROStorage returns a read only storage using bboltdb
 func NewROStorage(path string, logger log.Logger) (*Storage, func() error, error) {
-	// Creating DB
-	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true})
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open DB for reading at %s: %w", path, err)
-	}
+	return NewROStorageWithOptions(path, logger, Options{})
+}
 
-	s := &Storage{
-		DB:     db,
-		logger: logger,
-	}
+// UseTileFilter restricts the tiles stored by StoreMap to the ones selected by filter,
+// y is in the TMS scheme
+func (s *Storage) UseTileFilter(filter func(z uint8, x, y uint64) bool) {
+	s.filter = filter
+}
 
-	return s, db.Close, nil
+// UseTerrainEncoding makes StoreMap record the tiles as terrain-RGB tiles with the elevation encoding enc:
+// mapbox|terrarium, when the mbtiles metadata has no encoding
+func (s *Storage) UseTerrainEncoding(enc string) error {
+	if !terrain.ValidEncoding(enc) {
+		return fmt.Errorf("unknown terrain encoding %q, mapbox or terrarium expected", enc)
+	}
+	s.encoding = enc
+	return nil
 }
 
 // LoadMapInfos loads map infos from the DB if any
@@ -81,6 +109,20 @@ func (s *Storage) LoadMapInfos() (*storage.MapInfos, bool, error) {
 }
 
 func (s *Storage) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	format, encoding, layers, err := readMetadata(database, maxZoom)
+	if err != nil {
+		return err
+	}
+	if s.encoding != "" {
+		if format == "" {
+			return fmt.Errorf("terrain encoding requires raster tiles")
+		}
+		encoding = s.encoding
+	}
+	if format != "" && s.zstdDictSize > 0 {
+		return fmt.Errorf("zstd dictionary compression requires vector tiles, not %s", format)
+	}
+
 	rows, err := database.Query("SELECT * FROM map where zoom_level <= ?", maxZoom)
 	if err != nil {
 		return fmt.Errorf("can't read data from mbtiles sqlite: %w", err)
@@ -108,8 +150,10 @@ func (s *Storage) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZo
 	var tileID, gridID, key string
 	for rows.Next() {
 		rows.Scan(&zoom, &column, &row, &tileID, &gridID)
-		key = fmt.Sprintf("%c%d/%d/%d", storage.TilesURLPrefix, zoom, column, row)
-		if err = b.Put([]byte(key), []byte(tileID)); err != nil {
+		if s.filter != nil && !s.filter(uint8(zoom), uint64(column), uint64(row)) {
+			continue
+		}
+		if err = b.Put(appendTileKey(nil, s.layout, uint8(zoom), uint64(column), uint64(row)), []byte(tileID)); err != nil {
 			return err
 		}
 		count++
@@ -128,15 +172,80 @@ func (s *Storage) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZo
 
 	count = 0
 
-	rows, err = database.Query("SELECT images.tile_data, images.tile_id from images JOIN  map ON images.tile_id = map.tile_id where zoom_level <= ?;", maxZoom)
+	var enc *zstd.Encoder
+	var compression string
+	if s.zstdDictSize > 0 {
+		d, err := s.trainZstdDict(database, maxZoom)
+		if err != nil {
+			return err
+		}
+		if err = b.Put(storage.DictKey(), d); err != nil {
+			return err
+		}
+		enc, err = zstd.NewWriter(nil, zstd.WithEncoderDict(d), zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+		if err != nil {
+			return fmt.Errorf("can't create zstd encoder: %w", err)
+		}
+		defer enc.Close()
+		compression = CompressionZstdDict
+	}
+
+	if s.layout != LayoutZXY {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		err := s.relayout(func(id []byte) ([]byte, error) {
+			var tileData []byte
+			if err := database.QueryRow("SELECT tile_data FROM images WHERE tile_id = ?", string(id)).Scan(&tileData); err != nil {
+				return nil, fmt.Errorf("can't read tile %s from mbtiles sqlite: %w", id, err)
+			}
+			if enc == nil {
+				return tileData, nil
+			}
+			raw, err := gunzip(tileData)
+			if err != nil {
+				return nil, err
+			}
+			return enc.EncodeAll(raw, nil), nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return s.storeMapInfos(&storage.MapInfos{
+			CenterLat:    centerLat,
+			CenterLng:    centerLng,
+			MaxZoom:      maxZoom,
+			Region:       region,
+			IndexTime:    time.Now(),
+			Compression:  compression,
+			KeyLayout:    s.layout,
+			Format:       format,
+			Encoding:     encoding,
+			VectorLayers: layers,
+		})
+	}
+
+	rows, err = database.Query("SELECT images.tile_data, images.tile_id, map.zoom_level, map.tile_column, map.tile_row from images JOIN  map ON images.tile_id = map.tile_id where zoom_level <= ?;", maxZoom)
 	if err != nil {
 		return err
 	}
 
 	var tileData []byte
 	for rows.Next() {
-		rows.Scan(&tileData, &tileID)
+		rows.Scan(&tileData, &tileID, &zoom, &column, &row)
+		if s.filter != nil && !s.filter(uint8(zoom), uint64(column), uint64(row)) {
+			continue
+		}
 		key = fmt.Sprintf("%c%s", storage.TilesPrefix, tileID)
+		if enc != nil {
+			raw, err := gunzip(tileData)
+			if err != nil {
+				return err
+			}
+			tileData = enc.EncodeAll(raw, nil)
+		}
 		if err = b.Put([]byte(key), tileData); err != nil {
 			return err
 		}
@@ -160,14 +269,74 @@ func (s *Storage) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZo
 		}
 	}
 
-	infos := &storage.MapInfos{
-		CenterLat: centerLat,
-		CenterLng: centerLng,
-		MaxZoom:   maxZoom,
-		Region:    region,
-		IndexTime: time.Now(),
+	return s.storeMapInfos(&storage.MapInfos{
+		CenterLat:    centerLat,
+		CenterLng:    centerLng,
+		MaxZoom:      maxZoom,
+		Region:       region,
+		IndexTime:    time.Now(),
+		Compression:  compression,
+		Format:       format,
+		Encoding:     encoding,
+		VectorLayers: layers,
+	})
+}
+
+// readMetadata returns the tiles format, the terrain encoding and the vector layers of the mbtiles metadata,
+// the format is empty for pbf vector tiles, the layers zooms are limited to maxZoom
+func readMetadata(database *sql.DB, maxZoom int) (format, encoding string, layers []storage.VectorLayer, err error) {
+	rows, err := database.Query("SELECT name, value FROM metadata WHERE name IN ('format', 'encoding', 'json')")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("can't read metadata from mbtiles sqlite: %w", err)
+	}
+	defer rows.Close()
+
+	var js string
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return "", "", nil, err
+		}
+		switch name {
+		case "format":
+			format = strings.ToLower(value)
+		case "encoding":
+			encoding = strings.ToLower(value)
+		case "json":
+			js = value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", "", nil, err
+	}
+
+	if format == "pbf" {
+		format = ""
+	}
+	if format == "" || !terrain.ValidEncoding(encoding) {
+		encoding = ""
 	}
 
+	if format == "" && js != "" {
+		var meta struct {
+			VectorLayers []storage.VectorLayer `json:"vector_layers"`
+		}
+		if err := json.Unmarshal([]byte(js), &meta); err != nil {
+			return "", "", nil, fmt.Errorf("invalid json metadata in mbtiles: %w", err)
+		}
+		for _, l := range meta.VectorLayers {
+			if l.ID == "" || l.MinZoom > maxZoom {
+				continue
+			}
+			l.MaxZoom = min(l.MaxZoom, maxZoom)
+			layers = append(layers, l)
+		}
+	}
+	return format, encoding, layers, nil
+}
+
+// storeMapInfos writes the map infos entry
+func (s *Storage) storeMapInfos(infos *storage.MapInfos) error {
 	infoBytes, err := cbor.Marshal(infos)
 	if err != nil {
 		return fmt.Errorf("failed encoding MapInfos: %w", err)
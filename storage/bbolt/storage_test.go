@@ -10,7 +10,7 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func setup(t *testing.T) (*Storage, func()) {
+func setup(t testing.TB) (*Storage, func()) {
 	logger := log.NewLogfmtLogger(os.Stdout)
 
 	tmpFile, err := ioutil.TempFile(os.TempDir(), "kvtiles-test-")
@@ -1,34 +1,90 @@
 package bbolt
 
 import (
+	"bytes"
+	"context"
 	"errors"
-	"fmt"
+	"strconv"
+	"sync"
 
 	"go.etcd.io/bbolt"
 
 	"github.com/akhenakh/kvtiles/storage"
 )
 
+// keyPool reuses the buffers used to build the lookup keys
+var keyPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 64)
+		return &b
+	},
+}
+
+// maxPooledSize is the capacity above which a buffer is not kept in its pool
+const maxPooledSize = 1 << 20
+
+// rawPool reuses the buffers the zstd tiles are decoded into before being gzipped
+var rawPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 64<<10)
+		return &b
+	},
+}
+
+// gzipBufPool reuses the buffers the decoded tiles are gzipped into
+var gzipBufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
 // ReadTileData returns []bytes from a tile
-func (s *Storage) ReadTileData(z uint8, x uint64, y uint64) ([]byte, error) {
+func (s *Storage) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	bp := keyPool.Get().(*[]byte)
+	defer keyPool.Put(bp)
+
 	var v []byte
 	err := s.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(storage.MapKey())
 
-		k := []byte(fmt.Sprintf("%c%d/%d/%d", storage.TilesURLPrefix, z, x, y))
+		k := appendTileKey((*bp)[:0], s.layout, z, x, y)
 		v = b.Get(k)
 		if v == nil {
 			return nil
 		}
 
-		tk := []byte{storage.TilesPrefix}
+		tk := append(k[:0], storage.TilesPrefix)
 		tk = append(tk, v...)
+		*bp = tk
 		v = b.Get(tk)
 		if v == nil {
 			return errors.New("can't find blob at existing entry")
 		}
+		// the writes may remap the DB and reuse the freed pages once the transaction is closed
+		if !s.IsReadOnly() {
+			v = append([]byte(nil), v...)
+		}
 		return nil
 	})
 
-	return v, err
+	if err != nil || v == nil || s.dec == nil {
+		return v, err
+	}
+
+	return s.regzip(v)
+}
+
+// appendTileURLKey appends the key of the tile entry pointing to the blob,
+// same as fmt.Sprintf("%c%d/%d/%d", storage.TilesURLPrefix, z, x, y) without allocating
+func appendTileURLKey(buf []byte, z uint8, x, y uint64) []byte {
+	buf = append(buf, storage.TilesURLPrefix)
+	buf = strconv.AppendUint(buf, uint64(z), 10)
+	buf = append(buf, '/')
+	buf = strconv.AppendUint(buf, x, 10)
+	buf = append(buf, '/')
+	return strconv.AppendUint(buf, y, 10)
 }
@@ -3,12 +3,21 @@
 package bbolt
 
 import (
+	"context"
+	"database/sql"
 	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 
+	log "github.com/go-kit/kit/log"
 	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/storage"
 )
 
 func TestStorage_ReadTileData(t *testing.T) {
@@ -40,7 +49,7 @@ func TestStorage_ReadTileData(t *testing.T) {
 		tt := tt
 
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := s.ReadTileData(tt.z, tt.x, 1<<uint(tt.z)-tt.y-1)
+			got, err := s.ReadTileData(context.Background(), tt.z, tt.x, 1<<uint(tt.z)-tt.y-1)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ReadTileData() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -62,3 +71,116 @@ func TestStorage_ReadTileData(t *testing.T) {
 		})
 	}
 }
+
+func TestAppendTileURLKey(t *testing.T) {
+	got := appendTileURLKey(nil, 11, 124, 1147)
+	require.Equal(t, fmt.Sprintf("%c%d/%d/%d", storage.TilesURLPrefix, 11, 124, 1147), string(got))
+}
+
+func BenchmarkStorage_ReadTileData(b *testing.B) {
+	s, clean := setup(b)
+	defer clean()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.ReadTileData(context.Background(), 11, 124, 1147); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// setupZstdDict returns a RO storage of the tiles compressed with a zstd dictionary
+func setupZstdDict(t testing.TB) (*Storage, func()) {
+	logger := log.NewNopLogger()
+	tmpFile, err := ioutil.TempFile(os.TempDir(), "kvtiles-test-")
+	require.NoError(t, err)
+
+	wstorage, wclose, err := NewStorage(tmpFile.Name(), logger)
+	require.NoError(t, err)
+	wstorage.UseZstdDict(1000, 16*1024)
+
+	database, err := sql.Open("sqlite3", "../../testdata/hawaii.mbtiles")
+	require.NoError(t, err)
+	require.NoError(t, wstorage.StoreMap(database, 21.315603, -157.858093, 11, "hawaii"))
+	require.NoError(t, wclose())
+
+	zs, zclose, err := NewROStorage(tmpFile.Name(), logger)
+	require.NoError(t, err)
+
+	return zs, func() {
+		zclose()
+		os.Remove(tmpFile.Name())
+	}
+}
+
+func BenchmarkStorage_ReadTileData_ZstdDict(b *testing.B) {
+	s, clean := setupZstdDict(b)
+	defer clean()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.ReadTileData(context.Background(), 11, 124, 1147); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStorage_ReadTileData_ZstdDict(t *testing.T) {
+	s, clean := setup(t)
+	defer clean()
+
+	zs, zclose := setupZstdDict(t)
+	defer zclose()
+
+	infos, ok, err := zs.LoadMapInfos()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, CompressionZstdDict, infos.Compression)
+
+	want, err := s.ReadTileData(context.Background(), 11, 124, 1147)
+	require.NoError(t, err)
+	got, err := zs.ReadTileData(context.Background(), 11, 124, 1147)
+	require.NoError(t, err)
+
+	wantRaw, err := gunzip(want)
+	require.NoError(t, err)
+	gotRaw, err := gunzip(got)
+	require.NoError(t, err)
+	require.Equal(t, wantRaw, gotRaw)
+
+	// the decoder is released with the DB
+	require.NoError(t, zs.Close())
+	_, err = zs.dec.DecodeAll(nil, nil)
+	require.ErrorIs(t, err, zstd.ErrDecoderClosed)
+}
+
+func TestMigrate(t *testing.T) {
+	s, clean := setup(t)
+	defer clean()
+
+	logger := log.NewNopLogger()
+	tmpFile, err := ioutil.TempFile(os.TempDir(), "kvtiles-test-")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	dst, dclose, err := NewStorage(tmpFile.Name(), logger)
+	require.NoError(t, err)
+	require.NoError(t, dst.UseKeyLayout(LayoutHilbert))
+	require.NoError(t, Migrate(s, dst))
+	require.NoError(t, dclose())
+
+	hs, hclose, err := NewROStorage(tmpFile.Name(), logger)
+	require.NoError(t, err)
+	defer hclose()
+	require.Equal(t, LayoutHilbert, hs.layout)
+
+	for _, y := range []uint64{1147, 1148, 2000} {
+		want, err := s.ReadTileData(context.Background(), 11, 124, y)
+		require.NoError(t, err)
+		got, err := hs.ReadTileData(context.Background(), 11, 124, y)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+}
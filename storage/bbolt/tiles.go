@@ -0,0 +1,283 @@
+package bbolt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/mvt"
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// ForEachTile calls fn with every tile in the DB in the key layout order, y is in the TMS scheme,
+// data is gzipped as served and only valid during fn
+func (s *Storage) ForEachTile(fn func(z uint8, x, y uint64, data []byte) error) error {
+	return s.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(storage.MapKey())
+		if b == nil {
+			return fmt.Errorf("no map in DB")
+		}
+
+		c := b.Cursor()
+		var bk []byte
+		for k, v := c.Seek([]byte{storage.TilesURLPrefix}); k != nil && k[0] == storage.TilesURLPrefix; k, v = c.Next() {
+			z, x, y, err := parseTileKey(k, s.layout)
+			if err != nil {
+				return err
+			}
+
+			bk = append(append(bk[:0], storage.TilesPrefix), v...)
+			data := b.Get(bk)
+			if data == nil {
+				return fmt.Errorf("can't find blob of tile %d/%d/%d", z, x, y)
+			}
+			if s.dec != nil {
+				if data, err = s.regzip(data); err != nil {
+					return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+				}
+			}
+
+			if err := fn(z, x, y, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// TileWriter writes tiles to a new DB in batches, identical tiles share the same blob
+type TileWriter struct {
+	s     *Storage
+	tx    *bbolt.Tx
+	count int
+	blobs map[[sha256.Size]byte]uint64
+	seq   uint64
+}
+
+// NewTileWriter returns a TileWriter adding tiles to s using its key layout,
+// the tiles are stored gzipped as given
+func (s *Storage) NewTileWriter() (*TileWriter, error) {
+	if err := s.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(storage.MapKey())
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed writing to DB: %w", err)
+	}
+
+	tx, err := s.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TileWriter{
+		s:     s,
+		tx:    tx,
+		blobs: make(map[[sha256.Size]byte]uint64),
+	}, nil
+}
+
+// Put stores the tile z/x/y, y in the TMS scheme, replacing any existing tile
+func (w *TileWriter) Put(z uint8, x, y uint64, data []byte) error {
+	b := w.tx.Bucket(storage.MapKey())
+
+	h := sha256.Sum256(data)
+	n, ok := w.blobs[h]
+	if !ok {
+		w.seq++
+		n = w.seq
+		w.blobs[h] = n
+		// values must stay valid until the transaction is committed, data may come from another DB
+		if err := b.Put(blobKey(n), append([]byte(nil), data...)); err != nil {
+			return err
+		}
+	}
+	if err := b.Put(appendTileKey(nil, w.s.layout, z, x, y), blobID(n)); err != nil {
+		return err
+	}
+
+	w.count++
+	if w.count < transacMaxSize {
+		return nil
+	}
+
+	if err := w.tx.Commit(); err != nil {
+		return err
+	}
+	w.count = 0
+	tx, err := w.s.Begin(true)
+	if err != nil {
+		return err
+	}
+	w.tx = tx
+	return nil
+}
+
+// Close commits the tiles and stores infos, the index time and the key layout are set
+func (w *TileWriter) Close(infos storage.MapInfos) error {
+	if err := w.tx.Commit(); err != nil {
+		return err
+	}
+
+	infos.IndexTime = time.Now()
+	infos.Compression = ""
+	infos.KeyLayout = w.s.layout
+	return w.s.storeMapInfos(&infos)
+}
+
+// Abort discards the tiles not yet committed
+func (w *TileWriter) Abort() error {
+	return w.tx.Rollback()
+}
+
+// Compact copies all the buckets of src to dst, an empty DB, leaving the free pages behind
+func Compact(dst, src *bbolt.DB) error {
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	c := &compactor{dst: dst, tx: tx}
+
+	err = src.View(func(stx *bbolt.Tx) error {
+		return stx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			return c.copy([][]byte{name}, b)
+		})
+	})
+	if err != nil {
+		c.tx.Rollback()
+		return err
+	}
+
+	return c.tx.Commit()
+}
+
+// compactor writes to dst, committing every transacMaxSize keys
+type compactor struct {
+	dst   *bbolt.DB
+	tx    *bbolt.Tx
+	count int
+}
+
+// bucket returns the bucket at path in the current transaction
+func (c *compactor) bucket(path [][]byte) (*bbolt.Bucket, error) {
+	b, err := c.tx.CreateBucketIfNotExists(path[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range path[1:] {
+		if b, err = b.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+	// keys are copied in order, pages can be filled
+	b.FillPercent = 1
+	return b, nil
+}
+
+func (c *compactor) copy(path [][]byte, src *bbolt.Bucket) error {
+	if _, err := c.bucket(path); err != nil {
+		return err
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		// nested bucket
+		if v == nil {
+			return c.copy(append(path[:len(path):len(path)], k), src.Bucket(k))
+		}
+
+		b, err := c.bucket(path)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(k, v); err != nil {
+			return err
+		}
+
+		c.count++
+		if c.count < transacMaxSize {
+			return nil
+		}
+		if err := c.tx.Commit(); err != nil {
+			return err
+		}
+		c.count = 0
+		c.tx, err = c.dst.Begin(true)
+		return err
+	})
+}
+
+// Check verifies the map infos are present, every tile points to a blob and every blob decodes,
+// it returns the problems found, at most max, and the count of tiles checked
+func (s *Storage) Check(max int) ([]error, int, error) {
+	var problems []error
+	report := func(err error) bool {
+		problems = append(problems, err)
+		return len(problems) < max
+	}
+
+	infos, ok, err := s.LoadMapInfos()
+	if err != nil {
+		return nil, 0, err
+	}
+	if !ok {
+		return []error{fmt.Errorf("no map infos")}, 0, nil
+	}
+
+	var count int
+	err = s.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(storage.MapKey())
+
+		// blobs are checked once, true when referenced
+		blobs := make(map[string]bool)
+		c := b.Cursor()
+		for k, v := c.Seek([]byte{storage.TilesURLPrefix}); k != nil && k[0] == storage.TilesURLPrefix; k, v = c.Next() {
+			count++
+			if _, _, _, err := parseTileKey(k, s.layout); err != nil {
+				if !report(err) {
+					return nil
+				}
+				continue
+			}
+			bk := string(append([]byte{storage.TilesPrefix}, v...))
+			if _, ok := blobs[bk]; ok {
+				continue
+			}
+			blobs[bk] = true
+			data := b.Get([]byte(bk))
+			if data == nil {
+				if !report(fmt.Errorf("tile key %q points to a missing blob %x", k, v)) {
+					return nil
+				}
+				continue
+			}
+			switch {
+			case s.dec != nil:
+				_, err = s.dec.DecodeAll(data, nil)
+			case infos.Format == "" && bytes.HasPrefix(data, gzipMagic):
+				_, err = gunzip(data)
+			case infos.Format == "":
+				_, err = mvt.Decode(data)
+			}
+			if err != nil {
+				if !report(fmt.Errorf("blob %x is corrupted: %w", v, err)) {
+					return nil
+				}
+			}
+		}
+
+		var orphans int
+		for k, _ := c.Seek([]byte{storage.TilesPrefix}); k != nil && k[0] == storage.TilesPrefix; k, _ = c.Next() {
+			if !blobs[string(k)] {
+				orphans++
+			}
+		}
+		if orphans > 0 {
+			report(fmt.Errorf("%d blobs are not referenced by any tile", orphans))
+		}
+		return nil
+	})
+
+	return problems, count, err
+}
@@ -0,0 +1,116 @@
+//go:build cgo
+// +build cgo
+
+package bbolt
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+func TestTileWriter(t *testing.T) {
+	src, clean := setup(t)
+	defer clean()
+
+	dir, err := ioutil.TempDir("", "kvtiles-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dst, dstClose, err := NewStorage(filepath.Join(dir, "dst.db"), log.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, dst.UseKeyLayout(LayoutHilbert))
+
+	w, err := dst.NewTileWriter()
+	require.NoError(t, err)
+
+	// copies the tiles up to zoom 5
+	var count int
+	err = src.ForEachTile(func(z uint8, x, y uint64, data []byte) error {
+		if z > 5 {
+			return nil
+		}
+		count++
+		return w.Put(z, x, y, data)
+	})
+	require.NoError(t, err)
+	require.NotZero(t, count)
+	require.NoError(t, w.Close(storage.MapInfos{MaxZoom: 5, Region: "hawaii"}))
+	require.NoError(t, dstClose())
+
+	ro, roClose, err := NewROStorage(filepath.Join(dir, "dst.db"), log.NewNopLogger())
+	require.NoError(t, err)
+	defer roClose()
+
+	infos, ok, err := ro.LoadMapInfos()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, LayoutHilbert, infos.KeyLayout)
+	require.Equal(t, 5, infos.MaxZoom)
+
+	var copied int
+	err = ro.ForEachTile(func(z uint8, x, y uint64, data []byte) error {
+		copied++
+		want, err := src.ReadTileData(context.Background(), z, x, y)
+		require.NoError(t, err)
+		require.Equal(t, want, data)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, count, copied)
+
+	problems, checked, err := ro.Check(10)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+	require.Equal(t, count, checked)
+
+	// compacting keeps every entry
+	cdb, err := bbolt.Open(filepath.Join(dir, "compact.db"), 0600, nil)
+	require.NoError(t, err)
+	defer cdb.Close()
+	require.NoError(t, Compact(cdb, ro.DB))
+
+	var srcKeys, dstKeys int
+	require.NoError(t, ro.View(func(tx *bbolt.Tx) error {
+		srcKeys = tx.Bucket(storage.MapKey()).Stats().KeyN
+		return nil
+	}))
+	require.NoError(t, cdb.View(func(tx *bbolt.Tx) error {
+		dstKeys = tx.Bucket(storage.MapKey()).Stats().KeyN
+		return nil
+	}))
+	require.Equal(t, srcKeys, dstKeys)
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvtiles-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, clean, err := NewStorage(filepath.Join(dir, "map.db"), log.NewNopLogger())
+	require.NoError(t, err)
+	defer clean()
+
+	problems, _, err := s.Check(10)
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+
+	w, err := s.NewTileWriter()
+	require.NoError(t, err)
+	require.NoError(t, w.Put(1, 0, 0, []byte("not gzipped")))
+	require.NoError(t, w.Close(storage.MapInfos{MaxZoom: 1}))
+
+	problems, checked, err := s.Check(10)
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0].Error(), "corrupted")
+	require.Equal(t, 1, checked)
+}
@@ -0,0 +1,186 @@
+package bbolt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+var (
+	// writesBucket holds the sequence number of the last write applied
+	writesBucket = []byte("writes")
+	seqKey       = []byte("seq")
+	// blobRefsBucket counts the tiles pointing to each written blob
+	blobRefsBucket = []byte("blobrefs")
+)
+
+// WriteTiles stores the tiles as served, deleting the ones with nil data, and sets the index
+// time of the map to t, in one transaction, the blobs are keyed by the tiles SHA-256 so identical tiles share them.
+// The written blobs are counted and deleted once no tile points to them, the imported blobs are kept.
+// The write is ignored if seq is not above the sequence number of the last write applied, 0 to always apply it
+func (s *Storage) WriteTiles(seq uint64, t time.Time, tiles []storage.Tile) error {
+	if s.dec != nil {
+		return errors.New("can't write tiles to a DB compressed with a dictionary")
+	}
+
+	return s.write(seq, func(b *bbolt.Bucket, infos *storage.MapInfos) error {
+		refs, err := b.Tx().CreateBucketIfNotExists(blobRefsBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, tile := range tiles {
+			k := appendTileKey(nil, s.layout, tile.Z, tile.X, tile.Y)
+			old := append([]byte(nil), b.Get(k)...)
+
+			if tile.Data == nil {
+				if len(old) == 0 {
+					continue
+				}
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+				if err := releaseBlob(b, refs, old); err != nil {
+					return err
+				}
+				continue
+			}
+
+			h := sha256.Sum256(tile.Data)
+			if bytes.Equal(old, h[:]) {
+				continue
+			}
+			if err := retainBlob(b, refs, h[:], tile.Data); err != nil {
+				return err
+			}
+			if err := b.Put(k, h[:]); err != nil {
+				return err
+			}
+			if len(old) > 0 {
+				if err := releaseBlob(b, refs, old); err != nil {
+					return err
+				}
+			}
+		}
+		infos.IndexTime = t
+		return nil
+	})
+}
+
+// retainBlob stores data as the blob id if missing and counts a tile pointing to it
+func retainBlob(b, refs *bbolt.Bucket, id, data []byte) error {
+	bk := append([]byte{storage.TilesPrefix}, id...)
+	if b.Get(bk) == nil {
+		if err := b.Put(bk, data); err != nil {
+			return err
+		}
+		return refs.Put(id, binary.BigEndian.AppendUint64(nil, 1))
+	}
+
+	// an imported blob is not counted
+	v := refs.Get(id)
+	if len(v) != 8 {
+		return nil
+	}
+	return refs.Put(id, binary.BigEndian.AppendUint64(nil, binary.BigEndian.Uint64(v)+1))
+}
+
+// releaseBlob uncounts a tile pointing to the blob id, deleting the blob once no tile points to it
+func releaseBlob(b, refs *bbolt.Bucket, id []byte) error {
+	v := refs.Get(id)
+	if len(v) != 8 {
+		return nil
+	}
+	if n := binary.BigEndian.Uint64(v); n > 1 {
+		return refs.Put(id, binary.BigEndian.AppendUint64(nil, n-1))
+	}
+	if err := refs.Delete(id); err != nil {
+		return err
+	}
+	return b.Delete(append([]byte{storage.TilesPrefix}, id...))
+}
+
+// WriteMapInfos replaces the map infos, keeping the compression and the key layout of the stored tiles,
+// the write is ignored if seq is not above the sequence number of the last write applied, 0 to always apply it
+func (s *Storage) WriteMapInfos(seq uint64, infos storage.MapInfos) error {
+	return s.write(seq, func(_ *bbolt.Bucket, stored *storage.MapInfos) error {
+		infos.Compression = stored.Compression
+		infos.KeyLayout = stored.KeyLayout
+		*stored = infos
+		return nil
+	})
+}
+
+// WriteSeq returns the sequence number of the last write applied, 0 if none
+func (s *Storage) WriteSeq() (uint64, error) {
+	var seq uint64
+	err := s.View(func(tx *bbolt.Tx) error {
+		seq = readSeq(tx)
+		return nil
+	})
+	return seq, err
+}
+
+func readSeq(tx *bbolt.Tx) uint64 {
+	b := tx.Bucket(writesBucket)
+	if b == nil {
+		return 0
+	}
+	v := b.Get(seqKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// write runs fn with the map bucket and the stored map infos, written back after fn,
+// and records seq, in one transaction
+func (s *Storage) write(seq uint64, fn func(b *bbolt.Bucket, infos *storage.MapInfos) error) error {
+	return s.Update(func(tx *bbolt.Tx) error {
+		if seq != 0 && seq <= readSeq(tx) {
+			return nil
+		}
+
+		b := tx.Bucket(storage.MapKey())
+		if b == nil {
+			return fmt.Errorf("no map in DB")
+		}
+		v := b.Get(storage.MapKey())
+		if v == nil {
+			return fmt.Errorf("no map infos in DB")
+		}
+		infos := &storage.MapInfos{}
+		if err := cbor.NewDecoder(bytes.NewReader(v)).Decode(infos); err != nil {
+			return err
+		}
+
+		if err := fn(b, infos); err != nil {
+			return err
+		}
+
+		infoBytes, err := cbor.Marshal(infos)
+		if err != nil {
+			return fmt.Errorf("failed encoding MapInfos: %w", err)
+		}
+		if err := b.Put(storage.MapKey(), infoBytes); err != nil {
+			return err
+		}
+
+		if seq == 0 {
+			return nil
+		}
+		wb, err := tx.CreateBucketIfNotExists(writesBucket)
+		if err != nil {
+			return err
+		}
+		return wb.Put(seqKey, binary.BigEndian.AppendUint64(nil, seq))
+	})
+}
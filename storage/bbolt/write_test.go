@@ -0,0 +1,155 @@
+package bbolt
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+func TestStorage_WriteTiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvtiles-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "map.db")
+
+	s, clean, err := NewStorage(path, log.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, s.UseKeyLayout(LayoutQuadkey))
+	w, err := s.NewTileWriter()
+	require.NoError(t, err)
+	require.NoError(t, w.Put(1, 0, 0, []byte("a")))
+	require.NoError(t, w.Put(1, 1, 0, []byte("b")))
+	require.NoError(t, w.Close(storage.MapInfos{MaxZoom: 1, Region: "test"}))
+	require.NoError(t, clean())
+
+	s, clean, err = NewRWStorageWithOptions(path, log.NewNopLogger(), Options{})
+	require.NoError(t, err)
+	defer clean()
+
+	ctx := context.Background()
+	at := time.Unix(1600000000, 0)
+	require.NoError(t, s.WriteTiles(1, at, []storage.Tile{
+		{Z: 1, X: 0, Y: 0, Data: []byte("c")},
+		{Z: 1, X: 1, Y: 0},
+		{Z: 1, X: 1, Y: 1, Data: []byte("c")},
+	}))
+
+	data, err := s.ReadTileData(ctx, 1, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), data)
+	data, err = s.ReadTileData(ctx, 1, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), data)
+	data, err = s.ReadTileData(ctx, 1, 1, 0)
+	require.NoError(t, err)
+	require.Nil(t, data)
+
+	infos, ok, err := s.LoadMapInfos()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, at.Equal(infos.IndexTime))
+	require.Equal(t, LayoutQuadkey, infos.KeyLayout)
+
+	seq, err := s.WriteSeq()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), seq)
+
+	// an already applied write is ignored
+	require.NoError(t, s.WriteTiles(1, at, []storage.Tile{{Z: 1, X: 0, Y: 0}}))
+	data, err = s.ReadTileData(ctx, 1, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), data)
+
+	// the layout and compression are kept
+	require.NoError(t, s.WriteMapInfos(2, storage.MapInfos{MaxZoom: 2, Region: "updated", IndexTime: at, KeyLayout: LayoutHilbert}))
+	infos, _, err = s.LoadMapInfos()
+	require.NoError(t, err)
+	require.Equal(t, "updated", infos.Region)
+	require.Equal(t, 2, infos.MaxZoom)
+	require.Equal(t, LayoutQuadkey, infos.KeyLayout)
+
+	seq, err = s.WriteSeq()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), seq)
+
+	// the written blobs are deleted once no tile points to them, the imported ones are kept
+	require.Equal(t, 3, countBlobs(t, s))
+	require.NoError(t, s.WriteTiles(3, at, []storage.Tile{{Z: 1, X: 0, Y: 0, Data: []byte("d")}}))
+	require.Equal(t, 4, countBlobs(t, s))
+	require.NoError(t, s.WriteTiles(4, at, []storage.Tile{{Z: 1, X: 1, Y: 1}, {Z: 1, X: 0, Y: 0, Data: []byte("d")}}))
+	require.Equal(t, 3, countBlobs(t, s))
+	require.NoError(t, s.WriteTiles(5, at, []storage.Tile{{Z: 1, X: 0, Y: 0}}))
+	require.Equal(t, 2, countBlobs(t, s))
+}
+
+func countBlobs(t *testing.T, s *Storage) int {
+	var n int
+	require.NoError(t, s.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(storage.MapKey()).Cursor()
+		for k, _ := c.Seek([]byte{storage.TilesPrefix}); k != nil && k[0] == storage.TilesPrefix; k, _ = c.Next() {
+			n++
+		}
+		return nil
+	}))
+	return n
+}
+
+func TestStorage_ReadWhileWriting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvtiles-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "map.db")
+
+	s, clean, err := NewStorage(path, log.NewNopLogger())
+	require.NoError(t, err)
+	w, err := s.NewTileWriter()
+	require.NoError(t, err)
+	require.NoError(t, w.Put(1, 0, 0, bytes.Repeat([]byte{0}, 64<<10)))
+	require.NoError(t, w.Close(storage.MapInfos{MaxZoom: 1, Region: "test"}))
+	require.NoError(t, clean())
+
+	s, clean, err = NewRWStorageWithOptions(path, log.NewNopLogger(), Options{})
+	require.NoError(t, err)
+	defer clean()
+
+	// the tiles read stay intact while the writes grow the DB and reuse the freed pages
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				data, err := s.ReadTileData(context.Background(), 1, 0, 0)
+				require.NoError(t, err)
+				require.Len(t, data, 64<<10)
+				require.Equal(t, bytes.Repeat(data[:1], len(data)), data)
+			}
+		}()
+	}
+
+	for i := 1; i <= 200; i++ {
+		require.NoError(t, s.WriteTiles(uint64(i), time.Now(), []storage.Tile{
+			{Z: 1, X: 0, Y: 0, Data: bytes.Repeat([]byte{byte(i)}, 64<<10)},
+			{Z: 2, X: uint64(i % 4), Y: uint64(i / 4 % 4), Data: bytes.Repeat([]byte{byte(i)}, 16<<10)},
+		}))
+	}
+	close(done)
+	wg.Wait()
+}
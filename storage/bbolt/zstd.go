@@ -0,0 +1,141 @@
+package bbolt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"go.etcd.io/bbolt"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// CompressionZstdDict is the MapInfos compression of DBs storing tiles
+// compressed with a zstd dictionary
+const CompressionZstdDict = "zstd-dict"
+
+var gzipPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return w
+	},
+}
+
+// UseZstdDict makes StoreMap train a zstd dictionary of up to dictSize bytes
+// on samples tiles, then store the tiles compressed with it
+func (s *Storage) UseZstdDict(samples, dictSize int) {
+	s.zstdSamples = samples
+	s.zstdDictSize = dictSize
+}
+
+// trainZstdDict trains a dictionary on a random sample of the tiles up to maxZoom
+func (s *Storage) trainZstdDict(database *sql.DB, maxZoom int) ([]byte, error) {
+	rows, err := database.Query("SELECT images.tile_data FROM images JOIN map ON images.tile_id = map.tile_id "+
+		"WHERE zoom_level <= ? ORDER BY RANDOM() LIMIT ?", maxZoom, s.zstdSamples)
+	if err != nil {
+		return nil, fmt.Errorf("can't read samples from mbtiles sqlite: %w", err)
+	}
+	defer rows.Close()
+
+	var samples [][]byte
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		raw, err := gunzip(data)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, raw)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	d, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: s.zstdDictSize,
+		HashBytes:   6,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't train zstd dictionary: %w", err)
+	}
+
+	return d, nil
+}
+
+// loadZstdDict prepares the decoder if the DB was stored with a dictionary
+func (s *Storage) loadZstdDict() error {
+	var d []byte
+	if err := s.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(storage.MapKey())
+		if b == nil {
+			return nil
+		}
+		if v := b.Get(storage.DictKey()); v != nil {
+			d = append([]byte(nil), v...)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if d == nil {
+		return nil
+	}
+
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderDicts(d))
+	if err != nil {
+		return fmt.Errorf("can't load zstd dictionary: %w", err)
+	}
+	s.dec = dec
+
+	return nil
+}
+
+// regzip decodes a dictionary compressed tile and returns it gzipped, as served
+func (s *Storage) regzip(v []byte) ([]byte, error) {
+	rp := rawPool.Get().(*[]byte)
+	raw, err := s.dec.DecodeAll(v, (*rp)[:0])
+	if cap(raw) <= maxPooledSize {
+		*rp = raw
+	}
+	defer rawPool.Put(rp)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode tile: %w", err)
+	}
+
+	buf := gzipBufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledSize {
+			gzipBufPool.Put(buf)
+		}
+	}()
+	w := gzipPool.Get().(*gzip.Writer)
+	defer gzipPool.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	// the tile is owned by the caller, the buffers go back to their pools
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("tiles are expected to be gzipped: %w", err)
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
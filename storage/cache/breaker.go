@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+var storageDegraded = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "storage_degraded",
+	Help:      "1 while the storage is degraded and the tiles are only served from the caches.",
+})
+
+// Breaker stops reading from the storage after threshold read errors within window, the tiers in front
+// of it then serve their cached tiles while the misses fail with storage.ErrUnavailable,
+// a read is let through every retry to detect the recovery of the storage
+type Breaker struct {
+	next      storage.TileStore
+	threshold int
+	window    time.Duration
+	retry     time.Duration
+	logger    log.Logger
+
+	mu sync.Mutex
+	// failures counted since windowStart
+	failures    int
+	windowStart time.Time
+	degraded    bool
+	// lastTry is the time of the last read let through while degraded
+	lastTry  time.Time
+	onChange []func(degraded bool)
+}
+
+// NewBreaker returns a Breaker in front of next, the storage, under the caches tiers
+func NewBreaker(next storage.TileStore, threshold int, window, retry time.Duration, logger log.Logger) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	storageDegraded.Set(0)
+	return &Breaker{
+		next:      next,
+		threshold: threshold,
+		window:    window,
+		retry:     retry,
+		logger:    log.With(logger, "component", "breaker"),
+	}
+}
+
+// ReadTileData reads the tile from the storage, or fails with storage.ErrUnavailable while degraded
+func (b *Breaker) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	b.mu.Lock()
+	if b.degraded {
+		if time.Since(b.lastTry) < b.retry {
+			b.mu.Unlock()
+			return nil, storage.ErrUnavailable
+		}
+		b.lastTry = time.Now()
+	}
+	b.mu.Unlock()
+
+	data, err := b.next.ReadTileData(ctx, z, x, y)
+	// abandoned reads say nothing of the storage
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return data, err
+	}
+	b.record(err)
+	return data, err
+}
+
+// record counts the read result and switches the degraded mode
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	var changed bool
+	switch {
+	case err == nil && b.degraded:
+		b.degraded, b.failures, changed = false, 0, true
+		level.Info(b.logger).Log("msg", "storage recovered, reading from the storage again")
+	case err != nil && !b.degraded:
+		now := time.Now()
+		if now.Sub(b.windowStart) > b.window {
+			b.failures, b.windowStart = 0, now
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.degraded, b.lastTry, changed = true, now, true
+			level.Error(b.logger).Log("msg", "storage degraded, serving from the caches only", "errors", b.failures, "window", b.window, "error", err)
+		}
+	}
+	degraded, fns := b.degraded, b.onChange
+	b.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if degraded {
+		storageDegraded.Set(1)
+	} else {
+		storageDegraded.Set(0)
+	}
+	for _, fn := range fns {
+		fn(degraded)
+	}
+}
+
+// Degraded reports whether the tiles are only served from the caches
+func (b *Breaker) Degraded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.degraded
+}
+
+// OnDegraded registers fn, called when the storage becomes degraded or recovers
+func (b *Breaker) OnDegraded(fn func(degraded bool)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onChange = append(b.onChange, fn)
+}
+
+// LoadMapInfos loads map infos from the next tier
+func (b *Breaker) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return b.next.LoadMapInfos()
+}
+
+// OnChange registers fn on the next tier, if its map can change
+func (b *Breaker) OnChange(fn func()) {
+	storage.OnChange(b.next, fn)
+}
+
+// StoreMap stores the map in the next tier
+func (b *Breaker) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return b.next.StoreMap(database, centerLat, centerLng, maxZoom, region)
+}
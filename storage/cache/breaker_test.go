@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// errStore fails the reads while err is set, counting reads
+type errStore struct {
+	err   error
+	reads int
+}
+
+func (m *errStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	m.reads++
+	if m.err != nil {
+		return nil, m.err
+	}
+	return make([]byte, 10), nil
+}
+
+func (m *errStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{}, true, nil
+}
+
+func (m *errStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func TestBreaker_ReadTileData(t *testing.T) {
+	m := &errStore{}
+	b := NewBreaker(m, 2, time.Minute, time.Hour, log.NewNopLogger())
+	var changes []bool
+	b.OnDegraded(func(degraded bool) { changes = append(changes, degraded) })
+
+	// cached before the storage fails
+	lru := NewLRU(b, 1<<20)
+	_, err := lru.ReadTileData(context.Background(), 1, 1, 1)
+	require.NoError(t, err)
+
+	m.err = errors.New("input/output error")
+	for i := 0; i < 2; i++ {
+		_, err = lru.ReadTileData(context.Background(), 1, 0, 0)
+		require.ErrorIs(t, err, m.err)
+	}
+	require.True(t, b.Degraded())
+	require.Equal(t, []bool{true}, changes)
+	require.Equal(t, 3, m.reads)
+
+	// the storage is no longer read, the cached tiles are still served
+	_, err = lru.ReadTileData(context.Background(), 1, 0, 0)
+	require.ErrorIs(t, err, storage.ErrUnavailable)
+	data, err := lru.ReadTileData(context.Background(), 1, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, data, 10)
+	require.Equal(t, 3, m.reads)
+
+	// a read is let through once retry has passed
+	m.err = nil
+	b.lastTry = time.Now().Add(-2 * time.Hour)
+	_, err = lru.ReadTileData(context.Background(), 1, 0, 0)
+	require.NoError(t, err)
+	require.False(t, b.Degraded())
+	require.Equal(t, []bool{true, false}, changes)
+
+	// abandoned reads are not counted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.err = context.Canceled
+	for i := 0; i < 3; i++ {
+		_, _ = b.ReadTileData(ctx, 1, 0, 0)
+	}
+	require.False(t, b.Degraded())
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/groupcache"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// GroupcacheTier is the tier name of the distributed groupcache in metrics
+const GroupcacheTier = "groupcache"
+
+type fillKey struct{}
+
+// Group is a distributed tiles cache shared by the peers using groupcache,
+// a tile is loaded once from the storage by the peer owning its key.
+type Group struct {
+	next  storage.TileStore
+	group *groupcache.Group
+	// fillTimeout bounds the fills, they outlive the requests waiting for them
+	fillTimeout time.Duration
+
+	// generation is part of the keys, incremented to invalidate the cache
+	generation uint64
+	evictions  int64
+}
+
+// NewGroup returns a distributed cache in front of next holding up to maxBytes per peer,
+// a tile is loaded within fillTimeout, whatever the requests waiting for it
+func NewGroup(next storage.TileStore, name string, maxBytes int64, fillTimeout time.Duration) *Group {
+	c := &Group{next: next, fillTimeout: fillTimeout}
+
+	c.group = groupcache.NewGroup(name, maxBytes, groupcache.GetterFunc(
+		func(ctx context.Context, key string, dest groupcache.Sink) error {
+			var gen uint64
+			var z uint8
+			var x, y uint64
+			if _, err := fmt.Sscanf(key, "%d/%d/%d/%d", &gen, &z, &x, &y); err != nil {
+				return fmt.Errorf("invalid cache key %s: %w", key, err)
+			}
+
+			if filled, ok := ctx.Value(fillKey{}).(*bool); ok {
+				*filled = true
+			}
+
+			start := time.Now()
+			data, err := c.next.ReadTileData(ctx, z, x, y)
+			if err != nil {
+				return err
+			}
+			cacheFillLatency.WithLabelValues(GroupcacheTier).Observe(time.Since(start).Seconds())
+
+			// missing tiles are cached as empty values
+			return dest.SetBytes(data)
+		}))
+
+	return c
+}
+
+// NewHTTPPool returns the handler serving the cache to the peers, self is the base URL of this peer
+// as seen by the others e.g. http://10.0.0.1:8090, it must be called once, peers can be replaced with Set
+func NewHTTPPool(self string, peers []string) *groupcache.HTTPPool {
+	pool := groupcache.NewHTTPPoolOpts(self, &groupcache.HTTPPoolOptions{BasePath: "/_groupcache/"})
+	pool.Set(peers...)
+	return pool
+}
+
+// ReadTileData returns the tile from the local cache, the owning peer or the next tier
+func (c *Group) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	var filled bool
+	// the fill is shared by the concurrent requests of the tile, and by the peers,
+	// it must not be canceled when the request starting it goes away
+	fillCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.fillTimeout)
+	fillCtx = context.WithValue(fillCtx, fillKey{}, &filled)
+
+	key := fmt.Sprintf("%d/%d/%d/%d", atomic.LoadUint64(&c.generation), z, x, y)
+
+	var data []byte
+	done := make(chan error, 1)
+	go func() {
+		defer cancel()
+		done <- c.group.Get(fillCtx, key, groupcache.AllocatingByteSliceSink(&data))
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if filled {
+		cacheLookups.WithLabelValues(GroupcacheTier, resultMiss).Inc()
+	} else {
+		cacheLookups.WithLabelValues(GroupcacheTier, resultHit).Inc()
+	}
+
+	c.updateStats()
+
+	return data, nil
+}
+
+func (c *Group) updateStats() {
+	main := c.group.CacheStats(groupcache.MainCache)
+	hot := c.group.CacheStats(groupcache.HotCache)
+
+	evictions := main.Evictions + hot.Evictions
+	if prev := atomic.SwapInt64(&c.evictions, evictions); evictions > prev {
+		cacheEvictions.WithLabelValues(GroupcacheTier).Add(float64(evictions - prev))
+	}
+	cacheEntries.WithLabelValues(GroupcacheTier).Set(float64(main.Items + hot.Items))
+	cacheBytes.WithLabelValues(GroupcacheTier).Set(float64(main.Bytes + hot.Bytes))
+}
+
+// Purge invalidates the local cache entries, by changing the keys generation
+func (c *Group) Purge() {
+	atomic.AddUint64(&c.generation, 1)
+}
+
+// LoadMapInfos loads map infos from the next tier
+func (c *Group) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return c.next.LoadMapInfos()
+}
+
+// OnChange registers fn on the next tier, if its map can change
+func (c *Group) OnChange(fn func()) {
+	storage.OnChange(c.next, fn)
+}
+
+// StoreMap stores the map in the next tier and purges the cache
+func (c *Group) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	defer c.Purge()
+	return c.next.StoreMap(database, centerLat, centerLng, maxZoom, region)
+}
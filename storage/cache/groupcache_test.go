@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/groupcache"
+	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// slowStore blocks the tiles reads until release is closed or their context is done
+type slowStore struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (m *slowStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	m.started <- struct{}{}
+	select {
+	case <-m.release:
+		return []byte("tile"), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *slowStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{}, true, nil
+}
+
+func (m *slowStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func TestGroup_FillTimeout(t *testing.T) {
+	m := &slowStore{started: make(chan struct{}, 1), release: make(chan struct{})}
+	c := NewGroup(m, "fill_timeout_test", 1<<20, 50*time.Millisecond)
+
+	_, err := c.ReadTileData(context.Background(), 1, 1, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestGroup_Disconnect(t *testing.T) {
+	m := &slowStore{started: make(chan struct{}, 1), release: make(chan struct{})}
+	c := NewGroup(m, "disconnect_test", 1<<20, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.ReadTileData(ctx, 1, 1, 1)
+		first <- err
+	}()
+	<-m.started
+
+	second := make(chan error)
+	var data []byte
+	go func() {
+		var err error
+		data, err = c.ReadTileData(context.Background(), 1, 1, 1)
+		second <- err
+	}()
+
+	// the first request goes away, the fill goes on for the second one
+	cancel()
+	require.ErrorIs(t, <-first, context.Canceled)
+	close(m.release)
+	require.NoError(t, <-second)
+	require.Equal(t, []byte("tile"), data)
+
+	// the fill is cached
+	data, err := c.ReadTileData(context.Background(), 1, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("tile"), data)
+	require.Empty(t, m.started)
+}
+
+// peerGetter fetches the keys from the group of another peer, failing with err if set
+type peerGetter struct {
+	group *groupcache.Group
+	err   error
+}
+
+func (p *peerGetter) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	if p.err != nil {
+		return p.err
+	}
+	var data []byte
+	// the context values don't cross the peers
+	if err := p.group.Get(context.Background(), in.GetKey(), groupcache.AllocatingByteSliceSink(&data)); err != nil {
+		return err
+	}
+	out.Value = data
+	return nil
+}
+
+// ownerPicker picks peer as the owner of every key, or no peer when nil
+type ownerPicker struct {
+	peer groupcache.ProtoGetter
+}
+
+func (p ownerPicker) PickPeer(key string) (groupcache.ProtoGetter, bool) {
+	return p.peer, p.peer != nil
+}
+
+func TestGroup_TwoPeers(t *testing.T) {
+	ma, mb := &memStore{}, &memStore{}
+	a := NewGroup(ma, "peer_a_test", 1<<20, time.Second)
+	b := NewGroup(mb, "peer_b_test", 1<<20, time.Second)
+
+	// b owns all the keys, the other groups of the tests have no peers,
+	// the peer picker can only be registered once per process
+	toB := &peerGetter{group: b.group}
+	groupcache.RegisterPerGroupPeerPicker(func(name string) groupcache.PeerPicker {
+		if name == "peer_a_test" {
+			return ownerPicker{peer: toB}
+		}
+		return nil
+	})
+
+	// a gets the tile from b, which loads it from its storage
+	data, err := a.ReadTileData(context.Background(), 1, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, data, 10)
+	require.Equal(t, 0, ma.reads)
+	require.Equal(t, 1, mb.reads)
+
+	// b has cached it
+	data, err = b.ReadTileData(context.Background(), 1, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, data, 10)
+	require.Equal(t, 1, mb.reads)
+
+	// a missing tile is cached empty by its owner
+	data, err = a.ReadTileData(context.Background(), 1, 100, 1)
+	require.NoError(t, err)
+	require.Empty(t, data)
+	_, err = a.ReadTileData(context.Background(), 1, 100, 1)
+	require.NoError(t, err)
+	require.Equal(t, 2, mb.reads)
+
+	// b is down, a loads the tile itself
+	toB.err = errors.New("connection refused")
+	data, err = a.ReadTileData(context.Background(), 1, 2, 1)
+	require.NoError(t, err)
+	require.Len(t, data, 10)
+	require.Equal(t, 1, ma.reads)
+	require.Equal(t, 2, mb.reads)
+}
@@ -0,0 +1,169 @@
+// Package cache provides tiles caches as storage.TileStore wrappers
+package cache
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// LRUTier is the tier name of the in memory LRU cache in metrics
+const LRUTier = "lru"
+
+// ContourLRUTier is the tier name of the in memory LRU cache of the generated contour lines in metrics
+const ContourLRUTier = "contour_lru"
+
+type tileKey struct {
+	z    uint8
+	x, y uint64
+}
+
+type entry struct {
+	key  tileKey
+	data []byte
+}
+
+// LRU is an in memory least recently used tiles cache, bounded in bytes
+type LRU struct {
+	next     storage.TileStore
+	maxBytes int64
+	tier     string
+
+	mu    sync.Mutex
+	bytes int64
+	ll    *list.List
+	items map[tileKey]*list.Element
+	// gen is incremented by Purge, the tiles read before are not added
+	gen uint64
+}
+
+// NewLRU returns an LRU cache in front of next holding up to maxBytes of tiles
+func NewLRU(next storage.TileStore, maxBytes int64) *LRU {
+	return NewLRUWithTier(next, maxBytes, LRUTier)
+}
+
+// NewLRUWithTier returns an LRU cache reporting its metrics as tier, e.g. ContourLRUTier,
+// so two LRU caches of a process are told apart
+func NewLRUWithTier(next storage.TileStore, maxBytes int64, tier string) *LRU {
+	return &LRU{
+		next:     next,
+		maxBytes: maxBytes,
+		tier:     tier,
+		ll:       list.New(),
+		items:    make(map[tileKey]*list.Element),
+	}
+}
+
+// ReadTileData returns the tile from cache or reads it from the next tier,
+// missing tiles are not cached
+func (c *LRU) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	k := tileKey{z, x, y}
+
+	c.mu.Lock()
+	if e, ok := c.items[k]; ok {
+		c.ll.MoveToFront(e)
+		data := e.Value.(*entry).data
+		c.mu.Unlock()
+		cacheLookups.WithLabelValues(c.tier, resultHit).Inc()
+		return data, nil
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	cacheLookups.WithLabelValues(c.tier, resultMiss).Inc()
+
+	start := time.Now()
+	data, err := c.next.ReadTileData(ctx, z, x, y)
+	if err != nil || len(data) == 0 {
+		return data, err
+	}
+	cacheFillLatency.WithLabelValues(c.tier).Observe(time.Since(start).Seconds())
+
+	// the next tier may return memory not owned by us (mmap)
+	owned := make([]byte, len(data))
+	copy(owned, data)
+	c.add(k, owned, gen)
+
+	return owned, nil
+}
+
+// add caches the tile read at generation gen, unless the cache was purged since
+func (c *LRU) add(k tileKey, data []byte, gen uint64) {
+	size := int64(len(data))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if size > c.maxBytes || gen != c.gen {
+		return
+	}
+	if e, ok := c.items[k]; ok {
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	c.items[k] = c.ll.PushFront(&entry{key: k, data: data})
+	c.bytes += size
+	c.evict()
+}
+
+// SetMaxBytes resizes the cache, evicting the least recently used tiles over maxBytes
+func (c *LRU) SetMaxBytes(maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// evict removes the least recently used tiles until the cache fits, must be called with the lock held
+func (c *LRU) evict() {
+	for c.bytes > c.maxBytes {
+		e := c.ll.Back()
+		c.removeElement(e)
+		cacheEvictions.WithLabelValues(c.tier).Inc()
+	}
+
+	cacheEntries.WithLabelValues(c.tier).Set(float64(len(c.items)))
+	cacheBytes.WithLabelValues(c.tier).Set(float64(c.bytes))
+}
+
+// removeElement must be called with the lock held
+func (c *LRU) removeElement(e *list.Element) {
+	en := c.ll.Remove(e).(*entry)
+	delete(c.items, en.key)
+	c.bytes -= int64(len(en.data))
+}
+
+// Purge empties the cache
+func (c *LRU) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[tileKey]*list.Element)
+	c.bytes = 0
+	c.gen++
+	cacheEntries.WithLabelValues(c.tier).Set(0)
+	cacheBytes.WithLabelValues(c.tier).Set(0)
+}
+
+// LoadMapInfos loads map infos from the next tier
+func (c *LRU) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return c.next.LoadMapInfos()
+}
+
+// OnChange registers fn on the next tier, if its map can change
+func (c *LRU) OnChange(fn func()) {
+	storage.OnChange(c.next, fn)
+}
+
+// StoreMap stores the map in the next tier and purges the cache
+func (c *LRU) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	defer c.Purge()
+	return c.next.StoreMap(database, centerLat, centerLng, maxZoom, region)
+}
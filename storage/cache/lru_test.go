@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// memStore is a TileStore returning 10 bytes tiles for x < 100 and counting reads
+type memStore struct {
+	reads int
+}
+
+func (m *memStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	m.reads++
+	if x >= 100 {
+		return nil, nil
+	}
+	return make([]byte, 10), nil
+}
+
+func (m *memStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{}, true, nil
+}
+
+func (m *memStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func TestLRU_ReadTileData(t *testing.T) {
+	m := &memStore{}
+	c := NewLRU(m, 25)
+
+	for i := 0; i < 2; i++ {
+		data, err := c.ReadTileData(context.Background(), 1, 1, 1)
+		require.NoError(t, err)
+		require.Len(t, data, 10)
+	}
+	require.Equal(t, 1, m.reads)
+
+	// missing tiles are not cached
+	for i := 0; i < 2; i++ {
+		data, err := c.ReadTileData(context.Background(), 1, 100, 1)
+		require.NoError(t, err)
+		require.Empty(t, data)
+	}
+	require.Equal(t, 3, m.reads)
+
+	// filling above 25 bytes evicts the least recently used
+	_, _ = c.ReadTileData(context.Background(), 1, 2, 1)
+	_, _ = c.ReadTileData(context.Background(), 1, 1, 1)
+	_, _ = c.ReadTileData(context.Background(), 1, 3, 1)
+	require.Equal(t, 5, m.reads)
+	require.Len(t, c.items, 2)
+
+	_, _ = c.ReadTileData(context.Background(), 1, 1, 1)
+	require.Equal(t, 5, m.reads, "1/1/1 was recently used")
+	_, _ = c.ReadTileData(context.Background(), 1, 2, 1)
+	require.Equal(t, 6, m.reads, "1/2/1 was evicted")
+
+	c.Purge()
+	_, _ = c.ReadTileData(context.Background(), 1, 1, 1)
+	require.Equal(t, 7, m.reads)
+
+	// shrinking evicts the least recently used
+	_, _ = c.ReadTileData(context.Background(), 1, 2, 1)
+	c.SetMaxBytes(15)
+	require.Len(t, c.items, 1)
+	_, _ = c.ReadTileData(context.Background(), 1, 2, 1)
+	require.Equal(t, 8, m.reads)
+}
+
+// purgingStore is a memStore running purge during the reads, as a DB swap landing between the miss and the add
+type purgingStore struct {
+	memStore
+	purge func()
+}
+
+func (p *purgingStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	p.purge()
+	return p.memStore.ReadTileData(ctx, z, x, y)
+}
+
+func TestLRU_PurgeDuringRead(t *testing.T) {
+	p := &purgingStore{}
+	c := NewLRU(p, 100)
+	p.purge = c.Purge
+
+	// the tile read from the replaced DB is not cached after the purge
+	_, err := c.ReadTileData(context.Background(), 1, 1, 1)
+	require.NoError(t, err)
+	require.Empty(t, c.items)
+
+	p.purge = func() {}
+	_, err = c.ReadTileData(context.Background(), 1, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, c.items, 1)
+}
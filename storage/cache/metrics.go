@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "kvtiles"
+
+// lookup results
+const (
+	resultHit         = "hit"
+	resultMiss        = "miss"
+	resultNegativeHit = "negative_hit"
+)
+
+var (
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_lookups_total",
+		Help:      "Cache lookups per tier and result (hit, miss, negative_hit).",
+	}, []string{"tier", "result"})
+
+	cacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_evictions_total",
+		Help:      "Cache evictions per tier.",
+	}, []string{"tier"})
+
+	cacheFillLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_fill_duration_seconds",
+		Help:      "Duration to fill a cache entry from the next tier.",
+		Buckets:   []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"tier"})
+
+	cacheEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_entries",
+		Help:      "Number of entries per cache tier.",
+	}, []string{"tier"})
+
+	cacheBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_bytes",
+		Help:      "Size in bytes of the cached tiles per cache tier.",
+	}, []string{"tier"})
+)
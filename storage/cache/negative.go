@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// NegativeTier is the tier name of the missing tiles cache in metrics
+const NegativeTier = "negative"
+
+// Negative caches missing tiles for a TTL, so repeated requests for non existing tiles
+// do not hit the next tiers
+type Negative struct {
+	next       storage.TileStore
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	missing map[tileKey]time.Time
+	// gen is incremented by Purge, the tiles read before are not added
+	gen uint64
+}
+
+// NewNegative returns a missing tiles cache in front of next, holding up to maxEntries for ttl
+func NewNegative(next storage.TileStore, ttl time.Duration, maxEntries int) *Negative {
+	return &Negative{
+		next:       next,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		missing:    make(map[tileKey]time.Time),
+	}
+}
+
+// ReadTileData returns an empty tile if known as missing, or reads it from the next tier
+func (c *Negative) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	k := tileKey{z, x, y}
+	now := time.Now()
+
+	c.mu.Lock()
+	exp, ok := c.missing[k]
+	if ok && now.Before(exp) {
+		c.mu.Unlock()
+		cacheLookups.WithLabelValues(NegativeTier, resultNegativeHit).Inc()
+		return nil, nil
+	}
+	if ok {
+		delete(c.missing, k)
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	data, err := c.next.ReadTileData(ctx, z, x, y)
+	if err != nil || len(data) > 0 {
+		return data, err
+	}
+
+	cacheLookups.WithLabelValues(NegativeTier, resultMiss).Inc()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return data, nil
+	}
+	if len(c.missing) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.missing[k] = now.Add(c.ttl)
+	cacheEntries.WithLabelValues(NegativeTier).Set(float64(len(c.missing)))
+
+	return data, nil
+}
+
+// evict removes expired entries, or a random 10% if none expired,
+// must be called with the lock held
+func (c *Negative) evict(now time.Time) {
+	before := len(c.missing)
+	for k, exp := range c.missing {
+		if now.After(exp) {
+			delete(c.missing, k)
+		}
+	}
+
+	if len(c.missing) == before {
+		n := before / 10
+		for k := range c.missing {
+			if n <= 0 {
+				break
+			}
+			delete(c.missing, k)
+			n--
+		}
+	}
+
+	cacheEvictions.WithLabelValues(NegativeTier).Add(float64(before - len(c.missing)))
+}
+
+// Purge empties the cache
+func (c *Negative) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.missing = make(map[tileKey]time.Time)
+	c.gen++
+	cacheEntries.WithLabelValues(NegativeTier).Set(0)
+}
+
+// LoadMapInfos loads map infos from the next tier
+func (c *Negative) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return c.next.LoadMapInfos()
+}
+
+// OnChange registers fn on the next tier, if its map can change
+func (c *Negative) OnChange(fn func()) {
+	storage.OnChange(c.next, fn)
+}
+
+// StoreMap stores the map in the next tier and purges the cache
+func (c *Negative) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	defer c.Purge()
+	return c.next.StoreMap(database, centerLat, centerLng, maxZoom, region)
+}
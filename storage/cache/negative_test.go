@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegative_ReadTileData(t *testing.T) {
+	m := &memStore{}
+	c := NewNegative(m, time.Minute, 10)
+
+	for i := 0; i < 2; i++ {
+		data, err := c.ReadTileData(context.Background(), 1, 100, 1)
+		require.NoError(t, err)
+		require.Empty(t, data)
+	}
+	require.Equal(t, 1, m.reads)
+
+	// existing tiles are always read
+	for i := 0; i < 2; i++ {
+		data, err := c.ReadTileData(context.Background(), 1, 1, 1)
+		require.NoError(t, err)
+		require.Len(t, data, 10)
+	}
+	require.Equal(t, 3, m.reads)
+
+	// expired entries are read again
+	c.missing[tileKey{1, 100, 1}] = time.Now().Add(-time.Second)
+	_, _ = c.ReadTileData(context.Background(), 1, 100, 1)
+	require.Equal(t, 4, m.reads)
+
+	// the number of entries is bounded
+	for x := uint64(100); x < 120; x++ {
+		_, _ = c.ReadTileData(context.Background(), 1, x, 1)
+	}
+	require.LessOrEqual(t, len(c.missing), 10)
+}
+
+func TestNegative_PurgeDuringRead(t *testing.T) {
+	p := &purgingStore{}
+	c := NewNegative(p, time.Minute, 10)
+	p.purge = c.Purge
+
+	// the tile missing from the replaced DB is not remembered after the purge
+	_, err := c.ReadTileData(context.Background(), 1, 100, 1)
+	require.NoError(t, err)
+	require.Empty(t, c.missing)
+
+	p.purge = func() {}
+	_, err = c.ReadTileData(context.Background(), 1, 100, 1)
+	require.NoError(t, err)
+	require.Len(t, c.missing, 1)
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// prefetch results
+const (
+	prefetchQueued  = "queued"
+	prefetchDropped = "dropped"
+	prefetchError   = "error"
+)
+
+var prefetches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "cache_prefetches_total",
+	Help:      "Neighboring tiles read ahead per result: queued, dropped when the queue is full or error.",
+}, []string{"result"})
+
+// prefetchTimeout bounds a read ahead
+const prefetchTimeout = 10 * time.Second
+
+// Prefetch reads ahead from next the 8 neighbors of the tiles read and their 4 children at the next zoom,
+// as a client panning and zooming would, so the caches of next hold them when requested,
+// e.g. in front of a high latency backend such as S3
+type Prefetch struct {
+	next  storage.TileStore
+	queue chan tileKey
+	// maxZoom of the map, no children are read ahead at the max zoom, accessed atomically
+	maxZoom int32
+
+	mu      sync.Mutex
+	pending map[tileKey]struct{}
+}
+
+// NewPrefetch returns a Prefetch in front of next, up to queueSize tiles wait to be read by workers
+// until ctx is done
+func NewPrefetch(ctx context.Context, next storage.TileStore, workers, queueSize int) *Prefetch {
+	p := &Prefetch{
+		next:    next,
+		queue:   make(chan tileKey, queueSize),
+		pending: make(map[tileKey]struct{}),
+	}
+	p.loadMaxZoom()
+	storage.OnChange(next, p.loadMaxZoom)
+
+	for i := 0; i < workers; i++ {
+		go p.work(ctx)
+	}
+	return p
+}
+
+func (p *Prefetch) loadMaxZoom() {
+	maxZoom := -1
+	if infos, ok, err := p.next.LoadMapInfos(); err == nil && ok {
+		maxZoom = infos.MaxZoom
+	}
+	atomic.StoreInt32(&p.maxZoom, int32(maxZoom))
+}
+
+// ReadTileData reads the tile from the next tier, and queues its neighbors when found
+func (p *Prefetch) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	data, err := p.next.ReadTileData(ctx, z, x, y)
+	if err == nil && len(data) > 0 {
+		p.readAhead(z, x, y)
+	}
+	return data, err
+}
+
+// readAhead queues the neighbors of z x y in the TMS scheme, dropping them when the queue is full
+func (p *Prefetch) readAhead(z uint8, x, y uint64) {
+	n := uint64(1) << z
+	var tiles []tileKey
+	for dy := -1; dy <= 1; dy++ {
+		ny := int64(y) + int64(dy)
+		if ny < 0 || ny >= int64(n) {
+			continue
+		}
+		for dx := -1; dx <= 1; dx++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+			// the x axis wraps around the antimeridian
+			nx := (x + n + uint64(dx)) % n
+			tiles = append(tiles, tileKey{z, nx, uint64(ny)})
+		}
+	}
+	if int(z) < int(atomic.LoadInt32(&p.maxZoom)) {
+		tiles = append(tiles,
+			tileKey{z + 1, 2 * x, 2 * y}, tileKey{z + 1, 2*x + 1, 2 * y},
+			tileKey{z + 1, 2 * x, 2*y + 1}, tileKey{z + 1, 2*x + 1, 2*y + 1})
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, k := range tiles {
+		if _, ok := p.pending[k]; ok {
+			continue
+		}
+		select {
+		case p.queue <- k:
+			p.pending[k] = struct{}{}
+			prefetches.WithLabelValues(prefetchQueued).Inc()
+		default:
+			prefetches.WithLabelValues(prefetchDropped).Inc()
+		}
+	}
+}
+
+// work reads the queued tiles from the next tier until ctx is done
+func (p *Prefetch) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case k := <-p.queue:
+			rctx, cancel := context.WithTimeout(ctx, prefetchTimeout)
+			if _, err := p.next.ReadTileData(rctx, k.z, k.x, k.y); err != nil && ctx.Err() == nil {
+				prefetches.WithLabelValues(prefetchError).Inc()
+			}
+			cancel()
+
+			p.mu.Lock()
+			delete(p.pending, k)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// LoadMapInfos loads map infos from the next tier
+func (p *Prefetch) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return p.next.LoadMapInfos()
+}
+
+// OnChange registers fn on the next tier, if its map can change
+func (p *Prefetch) OnChange(fn func()) {
+	storage.OnChange(p.next, fn)
+}
+
+// StoreMap stores the map in the next tier
+func (p *Prefetch) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return p.next.StoreMap(database, centerLat, centerLng, maxZoom, region)
+}
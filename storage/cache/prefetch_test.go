@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// recordStore is a TileStore up to zoom 2 recording the tiles read
+type recordStore struct {
+	mu    sync.Mutex
+	tiles map[tileKey]int
+}
+
+func (m *recordStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tiles[tileKey{z, x, y}]++
+	return make([]byte, 10), nil
+}
+
+func (m *recordStore) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return &storage.MapInfos{MaxZoom: 2}, true, nil
+}
+
+func (m *recordStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func (m *recordStore) read(k tileKey) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tiles[k]
+}
+
+func TestPrefetch_ReadTileData(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &recordStore{tiles: make(map[tileKey]int)}
+	p := NewPrefetch(ctx, m, 2, 100)
+
+	data, err := p.ReadTileData(ctx, 1, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, data, 10)
+
+	// the 3 other tiles at zoom 1, the x axis wrapping around, and the 4 children
+	want := []tileKey{{1, 1, 0}, {1, 0, 1}, {1, 1, 1}, {2, 0, 0}, {2, 1, 0}, {2, 0, 1}, {2, 1, 1}}
+	require.Eventually(t, func() bool {
+		for _, k := range want {
+			if m.read(k) == 0 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, m.read(tileKey{1, 0, 0}))
+
+	// no children at the max zoom, the neighbors outside the map are skipped
+	p.readAhead(2, 0, 3)
+	require.Eventually(t, func() bool { return m.read(tileKey{2, 3, 2}) > 0 }, time.Second, 10*time.Millisecond)
+	require.Zero(t, m.read(tileKey{3, 0, 6}))
+}
+
+func TestPrefetch_QueueFull(t *testing.T) {
+	m := &recordStore{tiles: make(map[tileKey]int)}
+	// without workers nothing is read from the queue
+	p := NewPrefetch(context.Background(), m, 0, 4)
+
+	p.readAhead(1, 0, 0)
+	require.Len(t, p.queue, 4)
+	require.Len(t, p.pending, 4)
+
+	// pending tiles are not queued twice
+	p.readAhead(1, 0, 0)
+	require.Len(t, p.pending, 4)
+}
@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/akhenakh/kvtiles/storage"
+)
+
+// tiers names of the remote caches in metrics
+const (
+	RedisTier     = "redis"
+	MemcachedTier = "memcached"
+)
+
+// remoteClient is a key value cache server client
+type remoteClient interface {
+	get(ctx context.Context, key string) ([]byte, bool, error)
+	set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// Remote is a cache tier shared by the nodes, stored in Redis or memcached,
+// missing tiles are not cached, remote errors fall back to the next tier.
+type Remote struct {
+	next   storage.TileStore
+	client remoteClient
+	tier   string
+	// prefix is a string, replaced when the dataset changes
+	prefix atomic.Value
+	ttl    time.Duration
+	logger log.Logger
+}
+
+// NewRedis returns a Redis cache tier in front of next, keys are prefixed by prefix
+// and expire after ttl (0 for no expiration)
+func NewRedis(next storage.TileStore, addr, prefix string, ttl time.Duration, logger log.Logger) *Remote {
+	pool := &redis.Pool{
+		MaxIdle:     16,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr,
+				redis.DialConnectTimeout(time.Second),
+				redis.DialReadTimeout(time.Second),
+				redis.DialWriteTimeout(time.Second),
+			)
+		},
+	}
+
+	c := &Remote{
+		next:   next,
+		client: &redisClient{pool: pool},
+		tier:   RedisTier,
+		ttl:    ttl,
+		logger: log.With(logger, "component", "cache", "tier", RedisTier),
+	}
+	c.SetPrefix(prefix)
+	return c
+}
+
+// NewMemcached returns a memcached cache tier in front of next, keys are prefixed by prefix
+// and expire after ttl (0 for no expiration) clamped to 30 days
+func NewMemcached(next storage.TileStore, servers []string, prefix string, ttl time.Duration, logger log.Logger) *Remote {
+	c := &Remote{
+		next:   next,
+		client: &memcachedClient{client: memcache.New(servers...)},
+		tier:   MemcachedTier,
+		ttl:    ttl,
+		logger: log.With(logger, "component", "cache", "tier", MemcachedTier),
+	}
+	c.SetPrefix(prefix)
+	return c
+}
+
+// SetPrefix replaces the keys prefix, e.g. when the dataset version changes
+func (c *Remote) SetPrefix(prefix string) {
+	c.prefix.Store(prefix)
+}
+
+// ReadTileData returns the tile from the remote cache or reads it from the next tier
+func (c *Remote) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	key := fmt.Sprintf("%s%d/%d/%d", c.prefix.Load().(string), z, x, y)
+
+	data, ok, err := c.client.get(ctx, key)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "can't read from cache", "error", err)
+	}
+	if ok {
+		cacheLookups.WithLabelValues(c.tier, resultHit).Inc()
+		return data, nil
+	}
+
+	cacheLookups.WithLabelValues(c.tier, resultMiss).Inc()
+
+	start := time.Now()
+	data, err = c.next.ReadTileData(ctx, z, x, y)
+	if err != nil || len(data) == 0 {
+		return data, err
+	}
+	cacheFillLatency.WithLabelValues(c.tier).Observe(time.Since(start).Seconds())
+
+	if err := c.client.set(ctx, key, data, c.ttl); err != nil {
+		level.Warn(c.logger).Log("msg", "can't write to cache", "error", err)
+	}
+
+	return data, nil
+}
+
+// LoadMapInfos loads map infos from the next tier
+func (c *Remote) LoadMapInfos() (*storage.MapInfos, bool, error) {
+	return c.next.LoadMapInfos()
+}
+
+// OnChange registers fn on the next tier, if its map can change
+func (c *Remote) OnChange(fn func()) {
+	storage.OnChange(c.next, fn)
+}
+
+// StoreMap stores the map in the next tier
+func (c *Remote) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return c.next.StoreMap(database, centerLat, centerLng, maxZoom, region)
+}
+
+type redisClient struct {
+	pool *redis.Pool
+}
+
+func (r *redisClient) get(ctx context.Context, key string) ([]byte, bool, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	data, err := redis.Bytes(redis.DoContext(conn, ctx, "GET", key))
+	if err == redis.ErrNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (r *redisClient) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if ttl > 0 {
+		_, err = redis.DoContext(conn, ctx, "SET", key, value, "PX", ttl.Milliseconds())
+	} else {
+		_, err = redis.DoContext(conn, ctx, "SET", key, value)
+	}
+	return err
+}
+
+// memcached limits
+const (
+	memcachedMaxKeyLen = 250
+	// longer expirations are read as Unix timestamps by memcached
+	memcachedMaxTTL = 30 * 24 * time.Hour
+)
+
+type memcachedClient struct {
+	client *memcache.Client
+}
+
+// memcache does not support contexts, the request is only checked before calling
+func (m *memcachedClient) get(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	it, err := m.client.Get(memcachedKey(key))
+	if err == memcache.ErrCacheMiss {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return it.Value, true, nil
+}
+
+func (m *memcachedClient) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.client.Set(&memcache.Item{Key: memcachedKey(key), Value: value, Expiration: memcachedExpiration(ttl)})
+}
+
+// memcachedKey returns key if memcached accepts it, its SHA-1 otherwise,
+// e.g. when the prefix holds a region name with spaces
+func memcachedKey(key string) string {
+	valid := len(key) <= memcachedMaxKeyLen
+	for i := 0; valid && i < len(key); i++ {
+		valid = key[i] > ' ' && key[i] != 0x7f
+	}
+	if valid {
+		return key
+	}
+	sum := sha1.Sum([]byte(key))
+	return "sha1:" + hex.EncodeToString(sum[:])
+}
+
+// memcachedExpiration returns the expiration in seconds of ttl, clamped to 30 days,
+// 0 for no expiration
+func memcachedExpiration(ttl time.Duration) int32 {
+	switch {
+	case ttl <= 0:
+		return 0
+	case ttl > memcachedMaxTTL:
+		ttl = memcachedMaxTTL
+	case ttl < time.Second:
+		// 0 would never expire
+		ttl = time.Second
+	}
+	return int32(ttl / time.Second)
+}
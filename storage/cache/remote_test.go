@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemcachedKey(t *testing.T) {
+	require.Equal(t, "v1/Hawaii/1/2/3", memcachedKey("v1/Hawaii/1/2/3"))
+
+	for _, key := range []string{
+		"v1/Big Island/1/2/3",
+		"v1/Hawaii\n/1/2/3",
+		"v1/Hawaii\x7f/1/2/3",
+		strings.Repeat("a", memcachedMaxKeyLen+1),
+	} {
+		got := memcachedKey(key)
+		require.Equal(t, got, memcachedKey(got), key)
+		require.Len(t, got, len("sha1:")+40)
+	}
+
+	// no collision between the replaced characters
+	require.NotEqual(t, memcachedKey("v1/a b/1/2/3"), memcachedKey("v1/a\tb/1/2/3"))
+}
+
+func TestMemcachedExpiration(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		want int32
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{time.Millisecond, 1},
+		{90 * time.Second, 90},
+		{30 * 24 * time.Hour, 2592000},
+		{365 * 24 * time.Hour, 2592000},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, memcachedExpiration(tt.ttl), tt.ttl.String())
+	}
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/akhenakh/kvtiles/mvt"
+)
+
+// CompositeSource is a store merged in a Composite
+type CompositeSource struct {
+	Store TileStore
+	// Layers are the layers taken from this source, all if empty
+	Layers []string
+}
+
+// Composite is a read only TileStore merging the layers of the same tile from several stores,
+// e.g. a basemap and an overlay of POIs, a layer present in several sources is taken from the last one
+type Composite struct {
+	sources []CompositeSource
+	keep    []map[string]bool
+}
+
+// NewComposite returns a Composite of sources, the first one is the base providing the map infos
+func NewComposite(sources ...CompositeSource) (*Composite, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("a composite requires at least one source")
+	}
+
+	c := &Composite{sources: sources, keep: make([]map[string]bool, len(sources))}
+	for i, s := range sources {
+		if len(s.Layers) == 0 {
+			continue
+		}
+		c.keep[i] = make(map[string]bool, len(s.Layers))
+		for _, l := range s.Layers {
+			c.keep[i][l] = true
+		}
+	}
+	return c, nil
+}
+
+// ReadTileData returns the gzipped tile merging the layers of the sources,
+// the tile of a single source is returned as is
+func (c *Composite) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	datas := make([][]byte, len(c.sources))
+	found, last := 0, 0
+	for i, s := range c.sources {
+		data, err := s.Store.ReadTileData(ctx, z, x, y)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 {
+			datas[i] = data
+			found, last = found+1, i
+		}
+	}
+
+	switch {
+	case found == 0:
+		return nil, nil
+	case found == 1 && c.keep[last] == nil:
+		return datas[last], nil
+	}
+
+	var layers []mvt.RawLayer
+	index := make(map[string]int)
+	for i, data := range datas {
+		if data == nil {
+			continue
+		}
+		tile, err := gunzip(data)
+		if err != nil {
+			return nil, fmt.Errorf("can't uncompress tile from source %d: %w", i, err)
+		}
+		raws, err := mvt.SplitLayers(tile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read tile from source %d: %w", i, err)
+		}
+		for _, l := range raws {
+			if c.keep[i] != nil && !c.keep[i][l.Name] {
+				continue
+			}
+			if j, ok := index[l.Name]; ok {
+				layers[j] = l
+				continue
+			}
+			index[l.Name] = len(layers)
+			layers = append(layers, l)
+		}
+	}
+	if len(layers) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(mvt.JoinLayers(layers)); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// LoadMapInfos returns the map infos of the first source, the max zoom is the max of all sources
+func (c *Composite) LoadMapInfos() (*MapInfos, bool, error) {
+	infos, ok, err := c.sources[0].Store.LoadMapInfos()
+	if err != nil || !ok {
+		return infos, ok, err
+	}
+
+	merged := *infos
+	for _, s := range c.sources[1:] {
+		si, ok, err := s.Store.LoadMapInfos()
+		if err != nil {
+			return nil, false, err
+		}
+		if ok && si.MaxZoom > merged.MaxZoom {
+			merged.MaxZoom = si.MaxZoom
+		}
+	}
+	return &merged, true, nil
+}
+
+// OnChange registers fn on the sources whose map can change
+func (c *Composite) OnChange(fn func()) {
+	for _, s := range c.sources {
+		OnChange(s.Store, fn)
+	}
+}
+
+// StoreMap is not supported, maps are imported in each source
+func (c *Composite) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return errors.New("can't store a map in a composite")
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/mvt"
+)
+
+// mapStore serves the tiles by zoom, gzipped
+type mapStore struct {
+	maxZoom int
+	tiles   map[uint8][]byte
+}
+
+func (s mapStore) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	return s.tiles[z], nil
+}
+
+func (s mapStore) LoadMapInfos() (*MapInfos, bool, error) {
+	return &MapInfos{MaxZoom: s.maxZoom, Region: "base"}, true, nil
+}
+
+func (mapStore) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return nil
+}
+
+func gzipLayers(t *testing.T, layers ...mvt.Layer) []byte {
+	tile, err := mvt.Encode(layers)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err = gw.Write(tile)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func layer(name, source string) mvt.Layer {
+	return mvt.Layer{Name: name, Extent: 4096, Features: []mvt.Feature{
+		{Type: mvt.Point, Geometry: []uint32{9, 20, 20}, Properties: map[string]interface{}{"source": source}},
+	}}
+}
+
+func TestComposite(t *testing.T) {
+	ctx := context.Background()
+	base := mapStore{maxZoom: 14, tiles: map[uint8][]byte{
+		1: gzipLayers(t, layer("water", "base"), layer("poi", "base")),
+		2: gzipLayers(t, layer("water", "base")),
+	}}
+	overlay := mapStore{maxZoom: 16, tiles: map[uint8][]byte{
+		1: gzipLayers(t, layer("poi", "overlay"), layer("events", "overlay")),
+		3: gzipLayers(t, layer("poi", "overlay")),
+	}}
+
+	c, err := NewComposite(CompositeSource{Store: base}, CompositeSource{Store: overlay})
+	require.NoError(t, err)
+
+	data, err := c.ReadTileData(ctx, 1, 0, 0)
+	require.NoError(t, err)
+	tile, err := gunzip(data)
+	require.NoError(t, err)
+	layers, err := mvt.Decode(tile)
+	require.NoError(t, err)
+	require.Len(t, layers, 3)
+	// the overlay takes precedence
+	require.Equal(t, "poi", layers[1].Name)
+	require.Equal(t, "overlay", layers[1].Features[0].Properties["source"])
+	require.Equal(t, "events", layers[2].Name)
+
+	// a tile from a single source is served as stored
+	data, err = c.ReadTileData(ctx, 2, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, base.tiles[2], data)
+
+	data, err = c.ReadTileData(ctx, 4, 0, 0)
+	require.NoError(t, err)
+	require.Nil(t, data)
+
+	infos, ok, err := c.LoadMapInfos()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 16, infos.MaxZoom)
+	require.Equal(t, "base", infos.Region)
+
+	// only the listed layers are taken from a source
+	c, err = NewComposite(CompositeSource{Store: base}, CompositeSource{Store: overlay, Layers: []string{"events"}})
+	require.NoError(t, err)
+	data, err = c.ReadTileData(ctx, 1, 0, 0)
+	require.NoError(t, err)
+	tile, err = gunzip(data)
+	require.NoError(t, err)
+	layers, err = mvt.Decode(tile)
+	require.NoError(t, err)
+	require.Len(t, layers, 3)
+	require.Equal(t, "base", layers[1].Features[0].Properties["source"])
+
+	data, err = c.ReadTileData(ctx, 3, 0, 0)
+	require.NoError(t, err)
+	require.Nil(t, data)
+}
@@ -1,23 +1,53 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
 	"time"
 )
 
 const (
-	mapKey byte = 'm'
+	mapKey  byte = 'm'
+	dictKey byte = 'd'
 	// reserved T & t for tiles
 	TilesURLPrefix byte = 't'
 	TilesPrefix    byte = 'T'
+	// reserved S & s for the search index, features and tokens
+	SearchPrefix      byte = 'S'
+	SearchIndexPrefix byte = 's'
 )
 
+// ErrUnavailable is returned by the stores refusing to read, e.g. a degraded storage only served from the caches
+var ErrUnavailable = errors.New("storage unavailable")
+
+// ErrNotLeader is returned by the Writers accepting the writes on another node only, the leader
+var ErrNotLeader = errors.New("not the leader")
+
 type TileStore interface {
 	LoadMapInfos() (*MapInfos, bool, error)
-	ReadTileData(z uint8, x uint64, y uint64) ([]byte, error)
+	// ReadTileData returns the tile at z x y in the TMS scheme, nil if not found,
+	// reads are abandoned when ctx is done
+	ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error)
 	StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error
 }
 
+// ChangeNotifier is implemented by the stores whose map can be replaced while serving, e.g. a Swappable,
+// and by the stores wrapping them
+type ChangeNotifier interface {
+	// OnChange registers fn, called after every change of the map
+	OnChange(fn func())
+}
+
+// OnChange registers fn on store if it is a ChangeNotifier, a no-op otherwise
+func OnChange(store TileStore, fn func()) {
+	if n, ok := store.(ChangeNotifier); ok {
+		n.OnChange(fn)
+	}
+}
+
 // MapInfos used to store information about the map if any in DB
 type MapInfos struct {
 	CenterLat float64   `cbor:"1,keyasint,omitempty"`
@@ -25,9 +55,85 @@ type MapInfos struct {
 	MaxZoom   int       `cbor:"3,keyasint,omitempty"`
 	Region    string    `cbor:"4,keyasint,omitempty"`
 	IndexTime time.Time `cbor:"5,keyasint,omitempty"`
+	// Compression of the stored tiles, empty for gzip as served
+	Compression string `cbor:"6,keyasint,omitempty"`
+	// KeyLayout of the tiles keys, empty for z/x/y strings
+	KeyLayout string `cbor:"7,keyasint,omitempty"`
+	// Format of the tiles as in the mbtiles metadata, empty for pbf vector tiles, e.g. png for raster tiles
+	Format string `cbor:"8,keyasint,omitempty"`
+	// Encoding of the elevations of terrain-RGB raster tiles: mapbox|terrarium, empty for other tiles
+	Encoding string `cbor:"9,keyasint,omitempty"`
+	// VectorLayers of the vector tiles as in the vector_layers of the mbtiles json metadata
+	VectorLayers []VectorLayer `cbor:"10,keyasint,omitempty"`
+}
+
+// VectorLayer describes a layer of the vector tiles, as in TileJSON
+type VectorLayer struct {
+	ID          string `cbor:"1,keyasint" json:"id"`
+	Description string `cbor:"2,keyasint,omitempty" json:"description"`
+	// Fields are the attributes types by name: String, Number or Boolean
+	Fields  map[string]string `cbor:"3,keyasint,omitempty" json:"fields"`
+	MinZoom int               `cbor:"4,keyasint,omitempty" json:"minzoom"`
+	MaxZoom int               `cbor:"5,keyasint,omitempty" json:"maxzoom"`
+}
+
+// MarshalJSON encodes the layer with an empty fields object when it has no fields, as TileJSON requires
+func (l VectorLayer) MarshalJSON() ([]byte, error) {
+	type layer VectorLayer
+	if l.Fields == nil {
+		l.Fields = map[string]string{}
+	}
+	return json.Marshal(layer(l))
+}
+
+// SearchFeature is a named feature found in the tiles at Z, X, Y, in the XYZ scheme
+type SearchFeature struct {
+	Name  string  `cbor:"1,keyasint" json:"name"`
+	Layer string  `cbor:"2,keyasint" json:"layer"`
+	Class string  `cbor:"3,keyasint,omitempty" json:"class,omitempty"`
+	Lat   float64 `cbor:"4,keyasint" json:"lat"`
+	Lng   float64 `cbor:"5,keyasint" json:"lng"`
+	Z     uint8   `cbor:"6,keyasint" json:"z"`
+	X     uint64  `cbor:"7,keyasint" json:"x"`
+	Y     uint64  `cbor:"8,keyasint" json:"y"`
+}
+
+// Searcher finds the features by name
+type Searcher interface {
+	// Search returns up to limit features whose name contains the words of q,
+	// the last word may be a prefix
+	Search(q string, limit int) ([]SearchFeature, error)
+}
+
+// Snapshotter writes a consistent copy of the whole DB
+type Snapshotter interface {
+	// WriteSnapshot writes the DB to w, returns the count of bytes written
+	WriteSnapshot(w io.Writer) (int64, error)
+}
+
+// Tile is a tile written to a Writer, Y is in the TMS scheme, Data is stored as served, nil to delete the tile
+type Tile struct {
+	Z    uint8  `cbor:"1,keyasint"`
+	X    uint64 `cbor:"2,keyasint"`
+	Y    uint64 `cbor:"3,keyasint"`
+	Data []byte `cbor:"4,keyasint"`
+}
+
+// Writer updates the map while it is served
+type Writer interface {
+	// WriteTiles stores the tiles in one write
+	WriteTiles(ctx context.Context, tiles []Tile) error
+	// WriteMapInfos replaces the map infos, the index time is set to the write time,
+	// the compression and the key layout of the stored tiles are kept
+	WriteMapInfos(ctx context.Context, infos MapInfos) error
 }
 
 // MapKey returns the key for the map entry
 func MapKey() []byte {
 	return []byte{mapKey}
 }
+
+// DictKey returns the key of the tiles compression dictionary entry
+func DictKey() []byte {
+	return []byte{dictKey}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Swappable is a TileStore whose underlying store can be replaced while serving
+type Swappable struct {
+	v atomic.Value
+
+	mu       sync.Mutex
+	onChange []func()
+}
+
+type storeHolder struct {
+	TileStore
+}
+
+// NewSwappable returns a Swappable serving s
+func NewSwappable(s TileStore) *Swappable {
+	sw := &Swappable{}
+	sw.Swap(s)
+	return sw
+}
+
+// Swap replaces the served store and calls the OnChange funcs, returns the previous one
+func (sw *Swappable) Swap(s TileStore) TileStore {
+	prev, _ := sw.v.Load().(storeHolder)
+	sw.v.Store(storeHolder{s})
+
+	sw.mu.Lock()
+	fns := sw.onChange
+	sw.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+	return prev.TileStore
+}
+
+// OnChange registers fn, called after every Swap
+func (sw *Swappable) OnChange(fn func()) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.onChange = append(sw.onChange, fn)
+}
+
+// Current returns the served store
+func (sw *Swappable) Current() TileStore {
+	return sw.v.Load().(storeHolder).TileStore
+}
+
+// ReadTileData reads from the current store
+func (sw *Swappable) ReadTileData(ctx context.Context, z uint8, x uint64, y uint64) ([]byte, error) {
+	return sw.Current().ReadTileData(ctx, z, x, y)
+}
+
+// LoadMapInfos loads map infos from the current store
+func (sw *Swappable) LoadMapInfos() (*MapInfos, bool, error) {
+	return sw.Current().LoadMapInfos()
+}
+
+// Search searches the current store, nothing is found if it is not a Searcher
+func (sw *Swappable) Search(q string, limit int) ([]SearchFeature, error) {
+	if s, ok := sw.Current().(Searcher); ok {
+		return s.Search(q, limit)
+	}
+	return nil, nil
+}
+
+// WriteSnapshot writes a copy of the current store, it fails if it is not a Snapshotter
+func (sw *Swappable) WriteSnapshot(w io.Writer) (int64, error) {
+	if s, ok := sw.Current().(Snapshotter); ok {
+		return s.WriteSnapshot(w)
+	}
+	return 0, errors.New("the current store can't be snapshotted")
+}
+
+// StoreMap stores the map in the current store
+func (sw *Swappable) StoreMap(database *sql.DB, centerLat, centerLng float64, maxZoom int, region string) error {
+	return sw.Current().StoreMap(database, centerLat, centerLng, maxZoom, region)
+}
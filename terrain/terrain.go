@@ -0,0 +1,90 @@
+// Package terrain decodes the elevations of terrain-RGB raster tiles
+package terrain
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	// tiles formats decoded
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Elevation encodings of the terrain-RGB tiles
+const (
+	// EncodingMapbox is -10000 + (R * 256 * 256 + G * 256 + B) * 0.1
+	EncodingMapbox = "mapbox"
+	// EncodingTerrarium is R * 256 + G + B / 256 - 32768
+	EncodingTerrarium = "terrarium"
+)
+
+// ValidEncoding returns true if enc is a supported encoding
+func ValidEncoding(enc string) bool {
+	return enc == EncodingMapbox || enc == EncodingTerrarium
+}
+
+// Elevation returns the elevation in meters of a pixel color
+func Elevation(enc string, r, g, b uint8) float64 {
+	if enc == EncodingTerrarium {
+		return float64(r)*256 + float64(g) + float64(b)/256 - 32768
+	}
+	return -10000 + float64(uint32(r)<<16|uint32(g)<<8|uint32(b))*0.1
+}
+
+// Grid is the elevations of a tile pixels, row by row from the north west corner
+type Grid struct {
+	Width, Height int
+	Values        []float64
+}
+
+// Decode returns the elevations of a PNG or JPEG terrain-RGB tile
+func Decode(enc string, data []byte) (*Grid, error) {
+	if !ValidEncoding(enc) {
+		return nil, fmt.Errorf("unsupported terrain encoding %q", enc)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("can't decode terrain tile: %w", err)
+	}
+
+	bounds := img.Bounds()
+	g := &Grid{Width: bounds.Dx(), Height: bounds.Dy()}
+	g.Values = make([]float64, 0, g.Width*g.Height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, gr, b, _ := img.At(x, y).RGBA()
+			g.Values = append(g.Values, Elevation(enc, uint8(r>>8), uint8(gr>>8), uint8(b>>8)))
+		}
+	}
+	return g, nil
+}
+
+// At returns the elevation at fx, fy, fractions of the tile width and height,
+// interpolated between the centers of the pixels around
+func (g *Grid) At(fx, fy float64) float64 {
+	clamp := func(v float64, n int) float64 {
+		return math.Max(0, math.Min(v, float64(n-1)))
+	}
+	x := clamp(fx*float64(g.Width)-0.5, g.Width)
+	y := clamp(fy*float64(g.Height)-0.5, g.Height)
+
+	x0, y0 := int(x), int(y)
+	x1, y1 := x0, y0
+	if x0+1 < g.Width {
+		x1 = x0 + 1
+	}
+	if y0+1 < g.Height {
+		y1 = y0 + 1
+	}
+	tx, ty := x-float64(x0), y-float64(y0)
+
+	top := g.value(x0, y0)*(1-tx) + g.value(x1, y0)*tx
+	bottom := g.value(x0, y1)*(1-tx) + g.value(x1, y1)*tx
+	return top*(1-ty) + bottom*ty
+}
+
+func (g *Grid) value(x, y int) float64 {
+	return g.Values[y*g.Width+x]
+}
@@ -0,0 +1,41 @@
+package terrain
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestElevation(t *testing.T) {
+	require.InDelta(t, 0, Elevation(EncodingMapbox, 1, 134, 160), 1e-9)
+	require.InDelta(t, 4205.2, Elevation(EncodingMapbox, 2, 42, 228), 1e-6)
+	require.InDelta(t, 0, Elevation(EncodingTerrarium, 128, 0, 0), 1e-9)
+	require.InDelta(t, 4205.5, Elevation(EncodingTerrarium, 144, 109, 128), 1e-9)
+}
+
+func TestDecode(t *testing.T) {
+	// 0 m on the left column, 100 m on the right one
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for y := 0; y < 2; y++ {
+		img.Set(0, y, color.RGBA{1, 134, 160, 255})
+		img.Set(1, y, color.RGBA{1, 138, 136, 255})
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	g, err := Decode(EncodingMapbox, buf.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, 2, g.Width)
+	require.InDelta(t, 0, g.At(0, 0), 1e-6)
+	require.InDelta(t, 100, g.At(1, 1), 1e-6)
+	require.InDelta(t, 50, g.At(0.5, 0.5), 1e-6)
+
+	_, err = Decode("unknown", buf.Bytes())
+	require.Error(t, err)
+	_, err = Decode(EncodingMapbox, []byte("not an image"))
+	require.Error(t, err)
+}
@@ -0,0 +1,121 @@
+// Package tilemath converts between WGS84 coordinates and web mercator tiles
+package tilemath
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Tile is a web mercator tile in the XYZ scheme, as requested by the clients
+type Tile struct {
+	Z uint8
+	X uint64
+	Y uint64
+}
+
+// FromLatLng returns the tile containing lat lng at zoom z
+func FromLatLng(lat, lng float64, z uint8) Tile {
+	n := float64(uint64(1) << z)
+	lat = math.Max(math.Min(lat, 85.05112878), -85.05112878)
+	latRad := lat * math.Pi / 180
+
+	x := math.Floor((lng + 180) / 360 * n)
+	y := math.Floor((1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * n)
+
+	return Tile{Z: z, X: clamp(x, n), Y: clamp(y, n)}
+}
+
+// LatLng returns the coordinates of the north west corner of t
+func (t Tile) LatLng() (float64, float64) {
+	n := float64(uint64(1) << t.Z)
+	lng := float64(t.X)/n*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*float64(t.Y)/n))) * 180 / math.Pi
+	return lat, lng
+}
+
+// Bounds returns the south west and north east coordinates of t
+func (t Tile) Bounds() (minLat, minLng, maxLat, maxLng float64) {
+	maxLat, minLng = t.LatLng()
+	minLat, maxLng = Tile{Z: t.Z, X: t.X + 1, Y: t.Y + 1}.LatLng()
+	return minLat, minLng, maxLat, maxLng
+}
+
+// Position returns the position of lat lng relative to the north west corner of t,
+// as fractions of the tile width and height
+func (t Tile) Position(lat, lng float64) (fx, fy float64) {
+	n := float64(uint64(1) << t.Z)
+	lat = math.Max(math.Min(lat, 85.05112878), -85.05112878)
+	latRad := lat * math.Pi / 180
+
+	fx = (lng+180)/360*n - float64(t.X)
+	fy = (1-math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi)/2*n - float64(t.Y)
+	return fx, fy
+}
+
+// TMSY returns the y coordinate in the TMS scheme used by MBTiles and the storage
+func (t Tile) TMSY() uint64 {
+	return (uint64(1) << t.Z) - t.Y - 1
+}
+
+// Parent returns the tile containing t at the previous zoom
+func (t Tile) Parent() Tile {
+	if t.Z == 0 {
+		return t
+	}
+	return Tile{Z: t.Z - 1, X: t.X / 2, Y: t.Y / 2}
+}
+
+// Children returns the 4 tiles covering t at the next zoom
+func (t Tile) Children() [4]Tile {
+	z, x, y := t.Z+1, t.X*2, t.Y*2
+	return [4]Tile{{z, x, y}, {z, x + 1, y}, {z, x, y + 1}, {z, x + 1, y + 1}}
+}
+
+// CoverBBox calls fn for every tile covering the bounding box for zooms minZoom to maxZoom,
+// stops if fn returns false
+func CoverBBox(minLat, minLng, maxLat, maxLng float64, minZoom, maxZoom uint8, fn func(Tile) bool) {
+	for z := minZoom; z <= maxZoom; z++ {
+		nw := FromLatLng(maxLat, minLng, z)
+		se := FromLatLng(minLat, maxLng, z)
+		for x := nw.X; x <= se.X; x++ {
+			for y := nw.Y; y <= se.Y; y++ {
+				if !fn(Tile{Z: z, X: x, Y: y}) {
+					return
+				}
+			}
+		}
+		if z == maxZoom {
+			return
+		}
+	}
+}
+
+func clamp(v, n float64) uint64 {
+	if v < 0 {
+		return 0
+	}
+	if v >= n {
+		return uint64(n) - 1
+	}
+	return uint64(v)
+}
+
+// ParseBBox parses a "minLng,minLat,maxLng,maxLat" bounding box
+func ParseBBox(s string) (minLat, minLng, maxLat, maxLng float64, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return 0, 0, 0, 0, fmt.Errorf("invalid bbox %q, expecting minLng,minLat,maxLng,maxLat", s)
+	}
+
+	var v [4]float64
+	for i, p := range parts {
+		v[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("invalid bbox %q: %w", s, err)
+		}
+	}
+
+	return v[1], v[0], v[3], v[2], nil
+}
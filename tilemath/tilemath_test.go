@@ -0,0 +1,38 @@
+package tilemath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromLatLng(t *testing.T) {
+	// Honolulu
+	require.Equal(t, Tile{Z: 11, X: 125, Y: 899}, FromLatLng(21.315603, -157.858093, 11))
+	require.Equal(t, Tile{Z: 0}, FromLatLng(21.315603, -157.858093, 0))
+	// out of the mercator bounds are clamped
+	require.Equal(t, Tile{Z: 2, X: 3, Y: 0}, FromLatLng(90, 180, 2))
+
+	tile := Tile{Z: 11, X: 125, Y: 899}
+	minLat, minLng, maxLat, maxLng := tile.Bounds()
+	require.True(t, minLat < 21.315603 && 21.315603 < maxLat)
+	require.True(t, minLng < -157.858093 && -157.858093 < maxLng)
+	require.Equal(t, uint64(1148), tile.TMSY())
+	require.Equal(t, tile, tile.Children()[3].Parent())
+
+	fx, fy := Tile{Z: 1}.Position(0, 0)
+	require.InDelta(t, 1, fx, 1e-9)
+	require.InDelta(t, 1, fy, 1e-9)
+	fx, fy = tile.Position(21.315603, -157.858093)
+	require.True(t, fx > 0 && fx < 1 && fy > 0 && fy < 1)
+}
+
+func TestCoverBBox(t *testing.T) {
+	var count int
+	CoverBBox(-10, -10, 10, 10, 0, 2, func(Tile) bool {
+		count++
+		return true
+	})
+	// 1 at z0, 4 at z1, 4 at z2
+	require.Equal(t, 9, count)
+}
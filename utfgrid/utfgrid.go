@@ -0,0 +1,129 @@
+// Package utfgrid renders the UTFGrid interactivity of vector tiles, for the legacy map clients
+// relying on it for hover and click data
+package utfgrid
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/akhenakh/kvtiles/mvt"
+)
+
+// DefaultSize is the count of rows and columns of a grid, 4 pixels per cell on a 256 pixels tile
+const DefaultSize = 64
+
+// Grid is a UTFGrid 1.3, the keys index the features attributes in data,
+// the first key is empty for the cells without feature
+type Grid struct {
+	Grid []string                          `json:"grid"`
+	Keys []string                          `json:"keys"`
+	Data map[string]map[string]interface{} `json:"data"`
+}
+
+// Render returns the grid of size by size cells of the features of the layers, a cell holds the last feature
+// drawn over its center, points and lines are hit from the nearby cells,
+// the data of a feature are its attributes with its layer and its id if any
+func Render(layers []mvt.Layer, size int) (*Grid, error) {
+	cells := make([]int, size*size)
+	var features []map[string]interface{}
+
+	for _, l := range layers {
+		cell := float64(l.Extent) / float64(size)
+		for _, f := range l.Features {
+			lines, err := mvt.DecodeGeometry(f)
+			if err != nil {
+				return nil, err
+			}
+
+			var tolerance float64
+			switch f.Type {
+			case mvt.Point:
+				tolerance = 2 * cell
+			case mvt.LineString:
+				tolerance = cell
+			case mvt.Polygon:
+			default:
+				continue
+			}
+
+			minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+			for _, line := range lines {
+				for _, p := range line {
+					minX, maxX = math.Min(minX, float64(p[0])), math.Max(maxX, float64(p[0]))
+					minY, maxY = math.Min(minY, float64(p[1])), math.Max(maxY, float64(p[1]))
+				}
+			}
+			if math.IsInf(minX, 1) {
+				continue
+			}
+
+			// the cells whose center may hit the feature
+			first := func(v float64) int { return int(math.Max(0, math.Ceil((v-tolerance)/cell-0.5))) }
+			last := func(v float64) int { return int(math.Min(float64(size-1), math.Floor((v+tolerance)/cell-0.5))) }
+			id := 0
+			for cy := first(minY); cy <= last(maxY); cy++ {
+				for cx := first(minX); cx <= last(maxX); cx++ {
+					x, y := (float64(cx)+0.5)*cell, (float64(cy)+0.5)*cell
+					if mvt.GeometryDistance(f.Type, lines, x, y) > tolerance {
+						continue
+					}
+					if id == 0 {
+						features = append(features, featureData(l.Name, f))
+						id = len(features)
+					}
+					cells[cy*size+cx] = id
+				}
+			}
+		}
+	}
+
+	// the keys of the features left visible, in drawing order
+	index := make([]int, len(features)+1)
+	g := &Grid{Keys: []string{""}, Data: make(map[string]map[string]interface{})}
+	for _, id := range cells {
+		if id != 0 && index[id] == 0 {
+			index[id] = len(g.Keys)
+			key := strconv.Itoa(index[id])
+			g.Keys = append(g.Keys, key)
+			g.Data[key] = features[id-1]
+		}
+	}
+
+	var b strings.Builder
+	for y := 0; y < size; y++ {
+		b.Reset()
+		for x := 0; x < size; x++ {
+			b.WriteRune(encodeID(index[cells[y*size+x]]))
+		}
+		g.Grid = append(g.Grid, b.String())
+	}
+	return g, nil
+}
+
+// featureData returns the attributes of f with its layer and id
+func featureData(layer string, f mvt.Feature) map[string]interface{} {
+	data := make(map[string]interface{}, len(f.Properties)+2)
+	for k, v := range f.Properties {
+		data[k] = v
+	}
+	if _, ok := data["layer"]; !ok {
+		data["layer"] = layer
+	}
+	if _, ok := data["id"]; !ok && f.ID != 0 {
+		data["id"] = f.ID
+	}
+	return data
+}
+
+// encodeID returns the character of the key index id, skipping " and \ not to be escaped in JSON
+func encodeID(id int) rune {
+	c := id + 32
+	if c >= 34 {
+		c++
+	}
+	if c >= 92 {
+		c++
+	}
+	return rune(c)
+}
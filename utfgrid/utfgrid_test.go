@@ -0,0 +1,51 @@
+package utfgrid
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/akhenakh/kvtiles/mvt"
+)
+
+func TestRender(t *testing.T) {
+	layers := []mvt.Layer{
+		{Name: "landuse", Extent: 4096, Features: []mvt.Feature{
+			{ID: 7, Type: mvt.Polygon, Properties: map[string]interface{}{"class": "park"},
+				Geometry: mvt.EncodeGeometry(mvt.Polygon, [][][2]int64{{{0, 0}, {2048, 0}, {2048, 4096}, {0, 4096}, {0, 0}}})},
+		}},
+		{Name: "poi", Extent: 4096, Features: []mvt.Feature{
+			{Type: mvt.Point, Properties: map[string]interface{}{"name": "Fountain"},
+				Geometry: mvt.EncodeGeometry(mvt.Point, [][][2]int64{{{1900, 1000}}})},
+			// hidden by the next one
+			{Type: mvt.Point, Properties: map[string]interface{}{"name": "Hidden"},
+				Geometry: mvt.EncodeGeometry(mvt.Point, [][][2]int64{{{3900, 3900}}})},
+			{Type: mvt.Polygon, Properties: map[string]interface{}{"name": "Square"},
+				Geometry: mvt.EncodeGeometry(mvt.Polygon, [][][2]int64{{{3072, 3072}, {4096, 3072}, {4096, 4096}, {3072, 4096}, {3072, 3072}}})},
+		}},
+	}
+
+	g, err := Render(layers, 8)
+	require.NoError(t, err)
+	require.Len(t, g.Grid, 8)
+	require.Equal(t, []string{"", "1", "2", "3"}, g.Keys)
+	require.Equal(t, map[string]interface{}{"class": "park", "layer": "landuse", "id": uint64(7)}, g.Data["1"])
+	require.Equal(t, "Fountain", g.Data["2"]["name"])
+	require.Equal(t, "Square", g.Data["3"]["name"])
+
+	// ! is the first key, # the second, $ the third, the point is hit from the cells around
+	require.Equal(t, []string{
+		"!!###   ",
+		"!!####  ",
+		"!!####  ",
+		"!!###   ",
+		"!!!!    ",
+		"!!!!    ",
+		"!!!!  $$",
+		"!!!!  $$",
+	}, g.Grid)
+
+	_, err = json.Marshal(g)
+	require.NoError(t, err)
+}
@@ -0,0 +1,133 @@
+// Package warmup pre-loads tiles through the storage and caches before serving
+package warmup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/akhenakh/kvtiles/storage"
+	"github.com/akhenakh/kvtiles/tilemath"
+)
+
+// Source calls fn for every tile to warm, stopping if fn returns false
+type Source func(fn func(tilemath.Tile) bool)
+
+// BBox returns a Source covering the bounding box from minZoom to maxZoom
+func BBox(minLat, minLng, maxLat, maxLng float64, minZoom, maxZoom uint8) Source {
+	return func(fn func(tilemath.Tile) bool) {
+		tilemath.CoverBBox(minLat, minLng, maxLat, maxLng, minZoom, maxZoom, fn)
+	}
+}
+
+// List returns a Source from a list of tiles
+func List(tiles []tilemath.Tile) Source {
+	return func(fn func(tilemath.Tile) bool) {
+		for _, t := range tiles {
+			if !fn(t) {
+				return
+			}
+		}
+	}
+}
+
+// ReadAccessLog calls fn in order for every successful tile request found in a JSON access log
+func ReadAccessLog(r io.Reader, fn func(tilemath.Tile)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var l struct {
+			Z      string `json:"z"`
+			X      string `json:"x"`
+			Y      string `json:"y"`
+			Status int    `json:"status"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil || l.Z == "" || l.Status != 200 {
+			continue
+		}
+
+		z, errz := strconv.ParseUint(l.Z, 10, 8)
+		x, errx := strconv.ParseUint(l.X, 10, 64)
+		y, erry := strconv.ParseUint(l.Y, 10, 64)
+		if errz != nil || errx != nil || erry != nil {
+			continue
+		}
+		fn(tilemath.Tile{Z: uint8(z), X: x, Y: y})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("can't read access log: %w", err)
+	}
+
+	return nil
+}
+
+// TopFromAccessLog returns the n most requested tiles found in a JSON access log
+func TopFromAccessLog(r io.Reader, n int) ([]tilemath.Tile, error) {
+	counts := make(map[tilemath.Tile]int)
+
+	if err := ReadAccessLog(r, func(t tilemath.Tile) { counts[t]++ }); err != nil {
+		return nil, err
+	}
+
+	tiles := make([]tilemath.Tile, 0, len(counts))
+	for t := range counts {
+		tiles = append(tiles, t)
+	}
+	sort.Slice(tiles, func(i, j int) bool { return counts[tiles[i]] > counts[tiles[j]] })
+	if len(tiles) > n {
+		tiles = tiles[:n]
+	}
+
+	return tiles, nil
+}
+
+// Run reads every tile from source through store using concurrency readers,
+// returns the count of tiles read
+func Run(ctx context.Context, store storage.TileStore, source Source, concurrency int) (int, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	tiles := make(chan tilemath.Tile)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var count int
+	var firstErr error
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tiles {
+				_, err := store.ReadTileData(ctx, t.Z, t.X, t.TMSY())
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				count++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	source(func(t tilemath.Tile) bool {
+		select {
+		case tiles <- t:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	close(tiles)
+	wg.Wait()
+
+	if firstErr != nil {
+		return count, firstErr
+	}
+	return count, ctx.Err()
+}